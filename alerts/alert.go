@@ -25,6 +25,8 @@ func NewAlert(ctx context.Context, model models.Heapster) (Alert, error) {
 		model: model,
 		done:  make(chan struct{}),
 		mute:  model.Mute,
+
+		notifiers: make(map[string]notifiers.Notifier),
 	}
 	al.ctx, al.cancel = context.WithCancel(ctx)
 	// 创建notifier
@@ -34,8 +36,16 @@ func NewAlert(ctx context.Context, model models.Heapster) (Alert, error) {
 			notifier, err := notifiers.NewNotifier(model)
 			if err != nil {
 				logger.Warnf("load notifier error %v, heapster %s", err, model.ID)
+				continue
 			}
-			al.notifiers = append(al.notifiers, notifier)
+			al.notifiers[string(model.ID)] = notifier
+		}
+	}
+	// 升级策略, 加载失败的时候直接通知所有notifier
+	if al.model.Escalation != "" {
+		al.escalator, err = newEscalator(ctx, al.model.Escalation)
+		if err != nil {
+			logger.Warnf("load escalation %s error %v, heapster %s", al.model.Escalation, err, al.model.ID)
 		}
 	}
 	return al, nil
@@ -46,7 +56,8 @@ type defaultAlert struct {
 	model     models.Heapster
 	ctx       context.Context
 	mute      bool
-	notifiers []notifiers.Notifier
+	notifiers map[string]notifiers.Notifier
+	escalator *escalator
 	mtx       sync.RWMutex
 	cancel    func()
 	done      chan struct{}
@@ -97,44 +108,72 @@ func (al *defaultAlert) TurnOn() error {
 				logger.Warnf("no report data for %s, %v", string(al.model.ID), err)
 				continue
 			}
-			curStatus := models.HealthyStatusGreen
-			var rp models.Report
-			// 处理报告
-			for _, rp = range rps {
-				if rp.Faileds >= al.model.Threshold {
-					// RED
-					curStatus = models.HealthyStatusRed
-					break
-				} else if rp.Success >= al.model.Threshold {
-					// GREEN
-				} else {
-					// YELLOW
-					curStatus = models.HealthyStatusYellow
-				}
-			}
+			curStatus, stat, abnormal := evaluate(al.model, rps)
+			// 依赖的heapster是红色的时候抑制通知
 			if curStatus == models.HealthyStatusRed {
-				logger.Warnf("heapster %v turn to red", al.model.ID)
-				if err := al.model.SetStatus(al.ctx, models.HealthyStatusRed); err != nil {
-					logger.Warnf("healthy status change pass. %v", err)
+				stat.SuppressedBy = al.model.GetRedDepend(al.ctx)
+			}
+			if err := al.model.SetStatusWithStat(al.ctx, curStatus, stat); err != nil {
+				logger.Warnf("healthy status %v change pass. %v", curStatus, err)
+			}
+			// 事故跟踪, 恢复绿色之后结束
+			incident, err := models.FetchCurrentIncident(al.ctx, al.model.ID)
+			if err != nil {
+				logger.Warnf("load incident error %v, heapster %s", err, al.model.ID)
+			}
+			if curStatus == models.HealthyStatusGreen && incident != nil {
+				if err := incident.Resolve(al.ctx); err != nil {
+					logger.Warnf("resolve incident %s error %v", incident.ID, err)
 				}
-				// 发通知
-				if !al.mute {
-					for _, nt := range al.notifiers {
-						if err := nt.Send(al.ctx, rp); err != nil {
-							logger.Warnf("send report error %v", err)
-						}
-					}
+			}
+			if curStatus != models.HealthyStatusRed {
+				continue
+			}
+			if incident == nil {
+				if incident, err = models.OpenIncident(al.ctx, al.model.ID); err != nil {
+					logger.Warnf("open incident error %v, heapster %s", err, al.model.ID)
 				}
-			} else {
-				if err := al.model.SetStatus(al.ctx, curStatus); err != nil {
-					logger.Warnf("healthy status %v change pass. %v", curStatus, err)
+			}
+			logger.Warnf("heapster %v turn to red, %d/%d targets failed",
+				al.model.ID, stat.Faileds, stat.Total)
+			if stat.SuppressedBy != "" {
+				logger.Infof("heapster %v notification suppressed by %v", al.model.ID, stat.SuppressedBy)
+				continue
+			}
+			// 发通知
+			if !al.mute && len(abnormal) > 0 {
+				rp := abnormal[0]
+				if suppressed, err := al.model.CountSuppressed(al.ctx, stat.RedTargets); err == nil {
+					rp.Suppressed = suppressed
 				}
+				al.notify(models.DigestItem{
+					Heapster: al.model,
+					Report:   rp,
+					Incident: incident,
+					Faileds:  stat.Faileds,
+				})
 			}
 		}
 	}()
 	return nil
 }
 
+// notify 发送通知, 有升级策略的时候按照事故逐级通知
+func (al *defaultAlert) notify(item models.DigestItem) {
+	logger := middlewares.GetLogger(al.ctx)
+	if al.escalator != nil && item.Incident != nil {
+		if err := al.escalator.escalate(al.ctx, item); err != nil {
+			logger.Warnf("escalate incident %s error %v", item.Incident.ID, err)
+		}
+		return
+	}
+	for nid, nt := range al.notifiers {
+		if err := dispatch(al.ctx, nid, nt, item); err != nil {
+			logger.Warnf("send report error %v", err)
+		}
+	}
+}
+
 // TurnOff 关闭警报器
 func (al *defaultAlert) TurnOff() {
 	al.cancel()
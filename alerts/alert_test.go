@@ -16,7 +16,7 @@ func TestDefaultAlert(t *testing.T) {
 	hp := models.Heapster{
 		ID:        "testreport1",
 		Name:      "test_manager",
-		Type:      models.CheckType("test"),
+		Type:      models.CheckTypeTCP,
 		Port:      5200,
 		Timeout:   2 * time.Second,
 		Interval:  3 * time.Second,
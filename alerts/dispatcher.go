@@ -0,0 +1,155 @@
+package alerts
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+	"zonst/qipai/gamehealthysrv/notifiers"
+)
+
+type dispatcherKey struct{}
+
+// WithDispatcher 设置通知分发器, 所有警报器共用
+func WithDispatcher(ctx context.Context, d *Dispatcher) context.Context {
+	return context.WithValue(ctx, dispatcherKey{}, d)
+}
+
+// getDispatcher 获取通知分发器, 没有设置返回nil
+func getDispatcher(ctx context.Context) *Dispatcher {
+	d, _ := ctx.Value(dispatcherKey{}).(*Dispatcher)
+	return d
+}
+
+// dispatchGroup 等待合并的一组通知
+type dispatchGroup struct {
+	notifier notifiers.Notifier
+	labels   map[string]string
+	items    []models.DigestItem
+	timer    *time.Timer
+}
+
+// Dispatcher 通知分发器, 按照notifier和标签合并等待期间的通知
+type Dispatcher struct {
+	ctx       context.Context
+	groupWait time.Duration
+	groupBy   []string
+
+	mtx    sync.Mutex
+	groups map[string]*dispatchGroup
+	wg     sync.WaitGroup
+}
+
+// NewDispatcher 新建分发器, groupBy是合并时需要相同的标签名称
+func NewDispatcher(ctx context.Context, groupWait time.Duration, groupBy []string) *Dispatcher {
+	return &Dispatcher{
+		ctx:       ctx,
+		groupWait: groupWait,
+		groupBy:   groupBy,
+		groups:    make(map[string]*dispatchGroup),
+	}
+}
+
+// groupKey 分组主键以及分组的标签
+func (d *Dispatcher) groupKey(nid string, item models.DigestItem) (string, map[string]string) {
+	var (
+		all    = models.NewTemplateData(item.Heapster, item.Report, item.Incident).Labels
+		labels = make(map[string]string)
+		parts  = []string{nid}
+	)
+	names := append([]string{}, d.groupBy...)
+	sort.Strings(names)
+	for _, name := range names {
+		labels[name] = all[name]
+		parts = append(parts, name+"="+all[name])
+	}
+	return strings.Join(parts, ","), labels
+}
+
+// Dispatch 加入等待合并的分组, 分组的第一个通知开始计时
+func (d *Dispatcher) Dispatch(nid string, nt notifiers.Notifier, item models.DigestItem) {
+	key, labels := d.groupKey(nid, item)
+
+	d.mtx.Lock()
+	defer d.mtx.Unlock()
+	group, ok := d.groups[key]
+	if !ok {
+		group = &dispatchGroup{
+			notifier: nt,
+			labels:   labels,
+		}
+		d.groups[key] = group
+		d.wg.Add(1)
+		group.timer = time.AfterFunc(d.groupWait, func() {
+			defer d.wg.Done()
+			d.flush(key)
+		})
+	}
+	// 同一个heapster只保留最新的
+	for i := range group.items {
+		if group.items[i].Heapster.ID == item.Heapster.ID {
+			group.items[i] = item
+			return
+		}
+	}
+	group.items = append(group.items, item)
+}
+
+// flush 发送分组, 多个通知时优先合并发送
+func (d *Dispatcher) flush(key string) {
+	logger := middlewares.GetLogger(d.ctx)
+
+	d.mtx.Lock()
+	group, ok := d.groups[key]
+	delete(d.groups, key)
+	d.mtx.Unlock()
+	if !ok || len(group.items) == 0 {
+		return
+	}
+	if dn, ok := group.notifier.(notifiers.DigestNotifier); ok && len(group.items) > 1 {
+		digest := models.Digest{
+			Labels: group.labels,
+			Items:  group.items,
+		}
+		if err := dn.SendDigest(d.ctx, digest); err != nil {
+			logger.Warnf("send digest error %v, %s", err, digest.Summary())
+		}
+		return
+	}
+	for _, item := range group.items {
+		if err := group.notifier.Send(d.ctx, item.Report); err != nil {
+			logger.Warnf("send report error %v", err)
+		}
+	}
+}
+
+// Close 立即发送所有等待中的分组
+func (d *Dispatcher) Close() {
+	d.mtx.Lock()
+	var keys []string
+	for key, group := range d.groups {
+		// 计时器已经触发的分组由计时器发送
+		if group.timer.Stop() {
+			keys = append(keys, key)
+		}
+	}
+	d.mtx.Unlock()
+	for _, key := range keys {
+		d.flush(key)
+		d.wg.Done()
+	}
+	d.wg.Wait()
+}
+
+// dispatch 有分发器的时候交给分发器合并, 否则直接发送
+func dispatch(ctx context.Context, nid string, nt notifiers.Notifier, item models.DigestItem) error {
+	if d := getDispatcher(ctx); d != nil {
+		d.Dispatch(nid, nt, item)
+		return nil
+	}
+	return nt.Send(ctx, item.Report)
+}
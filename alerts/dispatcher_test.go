@@ -0,0 +1,94 @@
+package alerts
+
+import (
+	"context"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// recordNotifier 记录收到的通知
+type recordNotifier struct {
+	mtx     sync.Mutex
+	reports models.Reports
+	digests []models.Digest
+}
+
+func (rn *recordNotifier) Send(ctx context.Context, report models.Report) error {
+	rn.mtx.Lock()
+	defer rn.mtx.Unlock()
+	rn.reports = append(rn.reports, report)
+	return nil
+}
+
+// digestNotifier 支持合并发送
+type digestNotifier struct {
+	recordNotifier
+}
+
+func (dn *digestNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	dn.mtx.Lock()
+	defer dn.mtx.Unlock()
+	dn.digests = append(dn.digests, digest)
+	return nil
+}
+
+func testDigestItem(id string, group string, faileds int) models.DigestItem {
+	return models.DigestItem{
+		Heapster: models.Heapster{ID: models.SerialNumber(id), Name: id},
+		Report:   models.Report{Heapster: id, Target: "10.0.10.46:10000", Group: group},
+		Faileds:  faileds,
+	}
+}
+
+func TestDispatcherDigest(t *testing.T) {
+	ctx := middlewares.WithLogger(context.Background(), 5, os.Stdout)
+	d := NewDispatcher(ctx, 50*time.Millisecond, nil)
+	dn := &digestNotifier{}
+
+	d.Dispatch("sms", dn, testDigestItem("hp1", "a", 5))
+	d.Dispatch("sms", dn, testDigestItem("hp2", "a", 4))
+	d.Dispatch("sms", dn, testDigestItem("hp3", "b", 3))
+	// 同一个heapster只保留最新的
+	d.Dispatch("sms", dn, testDigestItem("hp1", "a", 5))
+
+	// 等待计时器发送
+	time.Sleep(100 * time.Millisecond)
+	d.Close()
+	assert.Len(t, dn.digests, 1)
+	assert.Len(t, dn.reports, 0)
+	assert.Equal(t, "3个监控中的12个目标异常(hp1、hp2、hp3)", dn.digests[0].Summary())
+
+	// 只有一个通知的时候正常发送
+	d.Dispatch("sms", dn, testDigestItem("hp1", "a", 5))
+	d.Close()
+	assert.Len(t, dn.digests, 1)
+	assert.Len(t, dn.reports, 1)
+}
+
+func TestDispatcherGroupBy(t *testing.T) {
+	ctx := middlewares.WithLogger(context.Background(), 5, os.Stdout)
+	d := NewDispatcher(ctx, time.Hour, []string{"group"})
+	dn := &digestNotifier{}
+	rn := &recordNotifier{}
+
+	d.Dispatch("sms", dn, testDigestItem("hp1", "a", 1))
+	d.Dispatch("sms", dn, testDigestItem("hp2", "a", 1))
+	d.Dispatch("sms", dn, testDigestItem("hp3", "b", 1))
+	d.Dispatch("webhook", rn, testDigestItem("hp1", "a", 1))
+	d.Dispatch("webhook", rn, testDigestItem("hp2", "a", 1))
+
+	// 关闭的时候立即发送等待中的分组
+	d.Close()
+	assert.Len(t, dn.digests, 1)
+	assert.Equal(t, map[string]string{"group": "a"}, dn.digests[0].Labels)
+	assert.Len(t, dn.reports, 1)
+	// 不支持合并的逐个发送
+	assert.Len(t, rn.reports, 2)
+}
@@ -0,0 +1,95 @@
+package alerts
+
+import (
+	"context"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+	"zonst/qipai/gamehealthysrv/notifiers"
+)
+
+// escalator 按照升级策略逐级发送通知
+type escalator struct {
+	policy    models.EscalationPolicy
+	notifiers map[string]notifiers.Notifier
+}
+
+// newEscalator 加载升级策略以及策略里面的所有通知器
+func newEscalator(ctx context.Context, id string) (*escalator, error) {
+	logger := middlewares.GetLogger(ctx)
+	esc := &escalator{
+		policy: models.EscalationPolicy{
+			ID: models.SerialNumber(id),
+		},
+		notifiers: make(map[string]notifiers.Notifier),
+	}
+	if err := esc.policy.Fill(ctx); err != nil {
+		return nil, err
+	}
+	for _, level := range esc.policy.Levels {
+		for _, nid := range level.Notifiers {
+			if _, ok := esc.notifiers[nid]; ok {
+				continue
+			}
+			model := models.HeapsterNotifier{
+				ID: models.SerialNumber(nid),
+			}
+			if err := model.Fill(ctx); err != nil {
+				logger.Warnf("load notifier %s error %v, escalation %s", nid, err, id)
+				continue
+			}
+			notifier, err := notifiers.NewNotifier(model)
+			if err != nil {
+				logger.Warnf("create notifier %s error %v, escalation %s", nid, err, id)
+				continue
+			}
+			esc.notifiers[nid] = notifier
+		}
+	}
+	return esc, nil
+}
+
+// escalate 发送已经到期但是还没有发送过的级别, 事故确认或者恢复之后不再升级
+func (esc *escalator) escalate(ctx context.Context, item models.DigestItem) error {
+	var (
+		logger = middlewares.GetLogger(ctx)
+		inc    = item.Incident
+	)
+	if inc.Status != models.IncidentStatusOpen {
+		return nil
+	}
+	var (
+		elapsed = time.Since(inc.StartedAt)
+		level   = inc.Level
+	)
+	for level < len(esc.policy.Levels) && elapsed >= esc.policy.Levels[level].Delay {
+		for _, nid := range esc.policy.Levels[level].Notifiers {
+			nt, ok := esc.notifiers[nid]
+			if !ok {
+				continue
+			}
+			if err := dispatch(ctx, nid, nt, item); err != nil {
+				logger.Warnf("escalate level %d notifier %s error %v", level, nid, err)
+			}
+		}
+		level++
+	}
+	if level == inc.Level {
+		return nil
+	}
+	inc.Level = level
+	err := inc.Save(ctx)
+	if err != models.ErrConflict {
+		return err
+	}
+	// 升级期间事故被确认或者恢复, 重新读取, 不能覆盖确认的状态
+	if err := inc.Fill(ctx); err != nil {
+		return err
+	}
+	if inc.Status != models.IncidentStatusOpen || inc.Level >= level {
+		return nil
+	}
+	inc.Level = level
+	return inc.Save(ctx)
+}
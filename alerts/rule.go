@@ -0,0 +1,129 @@
+package alerts
+
+import (
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// reportStatus 计算单个目标的健康状态, 多个探测点时需要足够的探测点都失败才是红色
+func reportStatus(model models.Heapster, rp models.Report) models.HealthyStatus {
+	if len(rp.Locations) == 0 {
+		if rp.Faileds >= model.Threshold {
+			return models.HealthyStatusRed
+		} else if rp.Success >= model.Threshold {
+			return models.HealthyStatusGreen
+		}
+		return models.HealthyStatusYellow
+	}
+	minLocations := model.MinLocations
+	if minLocations < 1 {
+		minLocations = 1
+	}
+	failedLocations := 0
+	for _, lrp := range rp.Locations {
+		if lrp.Faileds >= model.Threshold {
+			failedLocations++
+		}
+	}
+	if failedLocations >= minLocations {
+		return models.HealthyStatusRed
+	} else if failedLocations == 0 && rp.Success >= model.Threshold {
+		return models.HealthyStatusGreen
+	}
+	return models.HealthyStatusYellow
+}
+
+// latencyStatus 按延迟规则计算单个目标的状态, 返回状态和超出的延迟描述
+func latencyStatus(model models.Heapster, rp models.Report) (models.HealthyStatus, string) {
+	if model.Latency == nil || rp.Success == 0 {
+		return models.HealthyStatusGreen, ""
+	}
+	if reason := model.Latency.Red.Exceeded(rp); reason != "" {
+		return models.HealthyStatusRed, reason
+	}
+	if reason := model.Latency.Yellow.Exceeded(rp); reason != "" {
+		return models.HealthyStatusYellow, reason
+	}
+	return models.HealthyStatusGreen, ""
+}
+
+// evaluate 按照聚合规则计算整体状态, 返回统计数据和异常的报告(红色在前)
+func evaluate(model models.Heapster, rps models.Reports) (models.HealthyStatus, models.HeapsterStat, models.Reports) {
+	var (
+		stat = models.HeapsterStat{
+			Total:     len(rps),
+			Groups:    make(map[string]models.GroupStat),
+			Locations: make(map[string]models.LocationStat),
+			UpdatedAt: time.Now(),
+		}
+		reds, yellows models.Reports
+	)
+	for _, rp := range rps {
+		rp.Status = reportStatus(model, rp)
+		for _, lrp := range rp.Locations {
+			ls := stat.Locations[lrp.Location]
+			ls.Total++
+			if lrp.Faileds >= model.Threshold {
+				ls.Faileds++
+			}
+			stat.Locations[lrp.Location] = ls
+		}
+		// 能连通但是响应慢
+		if rp.Status != models.HealthyStatusRed {
+			if status, reason := latencyStatus(model, rp); status != models.HealthyStatusGreen {
+				stat.Slow++
+				rp.Reason = reason
+				if status == models.HealthyStatusRed || rp.Status == models.HealthyStatusGreen {
+					rp.Status = status
+				}
+			}
+		}
+		gs := stat.Groups[rp.Group]
+		gs.Total++
+		switch rp.Status {
+		case models.HealthyStatusGreen:
+			stat.Healthy++
+			gs.Healthy++
+		case models.HealthyStatusRed:
+			stat.Faileds++
+			stat.RedTargets = append(stat.RedTargets, rp.Target)
+			reds = append(reds, rp)
+		default:
+			stat.Unstable++
+			yellows = append(yellows, rp)
+		}
+		stat.Groups[rp.Group] = gs
+	}
+	if stat.Total > 0 {
+		stat.HealthyPercent = float64(stat.Healthy) * 100 / float64(stat.Total)
+		stat.FailedPercent = float64(stat.Faileds) * 100 / float64(stat.Total)
+	}
+	abnormal := append(reds, yellows...)
+
+	// 没有配置规则的时候任意目标失败都是红色
+	rule := model.Rule
+	if rule == nil {
+		if len(reds) > 0 {
+			return models.HealthyStatusRed, stat, abnormal
+		} else if len(yellows) > 0 {
+			return models.HealthyStatusYellow, stat, abnormal
+		}
+		return models.HealthyStatusGreen, stat, abnormal
+	}
+	if rule.RedPercent > 0 && stat.FailedPercent >= rule.RedPercent {
+		return models.HealthyStatusRed, stat, abnormal
+	}
+	if rule.MinHealthy > 0 && stat.Healthy < rule.MinHealthy {
+		return models.HealthyStatusRed, stat, abnormal
+	}
+	for gid, quorum := range rule.GroupQuorums {
+		if stat.Groups[gid].Healthy < quorum {
+			return models.HealthyStatusRed, stat, abnormal
+		}
+	}
+	if stat.Faileds > 0 && stat.FailedPercent >= rule.YellowPercent {
+		return models.HealthyStatusYellow, stat, abnormal
+	}
+	return models.HealthyStatusGreen, stat, abnormal
+}
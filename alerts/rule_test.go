@@ -0,0 +1,125 @@
+package alerts
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testRuleReports(total int, faileds int) models.Reports {
+	rps := make(models.Reports, 0, total)
+	for i := 0; i < total; i++ {
+		rp := models.Report{
+			Heapster: "testrule",
+			Target:   fmt.Sprintf("10.0.%d.%d:10000", i/250, i%250+1),
+			Group:    "room",
+			Success:  3,
+		}
+		if i < faileds {
+			rp.Success = 0
+			rp.Faileds = 3
+		}
+		rps = append(rps, rp)
+	}
+	return rps
+}
+
+func TestEvaluateDefault(t *testing.T) {
+	hp := models.Heapster{
+		ID:        "testrule",
+		Interval:  3 * time.Second,
+		Threshold: 3,
+	}
+	status, stat, abnormal := evaluate(hp, testRuleReports(200, 0))
+	assert.Equal(t, models.HealthyStatusGreen, status)
+	assert.Len(t, abnormal, 0)
+	assert.Equal(t, 200, stat.Healthy)
+
+	status, stat, abnormal = evaluate(hp, testRuleReports(200, 1))
+	assert.Equal(t, models.HealthyStatusRed, status)
+	assert.Len(t, abnormal, 1)
+	assert.Equal(t, models.HealthyStatusRed, abnormal[0].Status)
+	assert.Equal(t, 0.5, stat.FailedPercent)
+}
+
+func TestEvaluateRule(t *testing.T) {
+	hp := models.Heapster{
+		ID:        "testrule",
+		Interval:  3 * time.Second,
+		Threshold: 3,
+		Groups:    []string{"room"},
+		Rule: &models.HeapsterRule{
+			RedPercent:    20,
+			YellowPercent: 5,
+		},
+	}
+	status, _, _ := evaluate(hp, testRuleReports(200, 1))
+	assert.Equal(t, models.HealthyStatusGreen, status)
+	status, _, _ = evaluate(hp, testRuleReports(200, 10))
+	assert.Equal(t, models.HealthyStatusYellow, status)
+	status, stat, _ := evaluate(hp, testRuleReports(200, 40))
+	assert.Equal(t, models.HealthyStatusRed, status)
+	assert.Equal(t, float64(20), stat.FailedPercent)
+	assert.Equal(t, 160, stat.Groups["room"].Healthy)
+
+	hp.Rule.MinHealthy = 195
+	status, _, _ = evaluate(hp, testRuleReports(200, 10))
+	assert.Equal(t, models.HealthyStatusRed, status)
+
+	hp.Rule.MinHealthy = 0
+	hp.Rule.GroupQuorums = map[string]int{"room": 199}
+	status, _, _ = evaluate(hp, testRuleReports(200, 2))
+	assert.Equal(t, models.HealthyStatusRed, status)
+}
+
+func TestEvaluateLatency(t *testing.T) {
+	hp := models.Heapster{
+		ID:        "testrule",
+		Interval:  3 * time.Second,
+		Threshold: 3,
+		Latency: &models.LatencyRule{
+			Yellow: models.LatencyThreshold{P95: 200 * time.Millisecond},
+			Red:    models.LatencyThreshold{Max: time.Second},
+		},
+	}
+	rps := testRuleReports(10, 0)
+	rps[0].P95Delay = 300 * time.Millisecond
+	status, stat, abnormal := evaluate(hp, rps)
+	assert.Equal(t, models.HealthyStatusYellow, status)
+	assert.Equal(t, 1, stat.Slow)
+	assert.Contains(t, abnormal[0].Reason, "p95")
+
+	rps[1].MaxDelay = 2 * time.Second
+	status, stat, abnormal = evaluate(hp, rps)
+	assert.Equal(t, models.HealthyStatusRed, status)
+	assert.Equal(t, 2, stat.Slow)
+	assert.Equal(t, rps[1].Target, abnormal[0].Target)
+	assert.Contains(t, abnormal[0].Reason, "2s")
+}
+
+func TestEvaluateLocations(t *testing.T) {
+	hp := models.Heapster{
+		ID:           "testrule",
+		Interval:     3 * time.Second,
+		Threshold:    3,
+		MinLocations: 2,
+	}
+	rps := testRuleReports(2, 0)
+	rps[0].Success, rps[0].Faileds = 3, 3
+	rps[0].Locations = []models.LocationReport{
+		{Location: "shenzhen", Faileds: 3},
+		{Location: "shanghai", Success: 3},
+	}
+	status, stat, _ := evaluate(hp, rps)
+	assert.Equal(t, models.HealthyStatusYellow, status)
+	assert.Equal(t, 1, stat.Locations["shenzhen"].Faileds)
+
+	rps[0].Locations[1] = models.LocationReport{Location: "shanghai", Faileds: 3}
+	status, stat, _ = evaluate(hp, rps)
+	assert.Equal(t, models.HealthyStatusRed, status)
+	assert.Equal(t, 1, stat.Locations["shanghai"].Faileds)
+}
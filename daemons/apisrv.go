@@ -2,6 +2,7 @@ package daemons
 
 import (
 	"context"
+	"expvar"
 	"net/http"
 	"os"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"zonst/qipai-golang-libs/httputil"
 	"zonst/qipai/gamehealthysrv/handlers"
 	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
 	"zonst/qipai/logagent/utils"
 
 	"github.com/gorilla/mux"
@@ -21,7 +23,7 @@ type HealthyAPISrv struct {
 	// http配置
 	Host string `json:"host"`
 
-	// Redis配置
+	// Redis配置, 只有redis存储需要
 	RedisHost     string `json:"redis_host"`
 	RedisPassword string `json:"redis_password"`
 	RedisDB       int    `json:"redis_db"`
@@ -29,6 +31,23 @@ type HealthyAPISrv struct {
 	// Elastic配置
 	ElasticURLs []string `json:"elastic_urls"`
 
+	// 模型存储, redis(默认)或者bolt, bolt需要配置文件路径
+	StoreType string `json:"store"`
+	StorePath string `json:"store_path"`
+	// 从目录里的json或者yaml文件加载全部配置, 文件修改之后自动重新加载, 不能通过接口修改; 状态还是保存在模型存储里
+	ConfigDir string `json:"config_dir"`
+
+	// 探测结果存储, elastic(默认), influxdb或者local, local需要配置文件路径, 保留时间单位小时
+	ProbeStoreType      string `json:"probe_store"`
+	ProbeStorePath      string `json:"probe_store_path"`
+	ProbeStoreRetention int    `json:"probe_retention"`
+
+	// InfluxDB配置
+	InfluxHost     string `json:"influx_host"`
+	InfluxUser     string `json:"influx_user"`
+	InfluxPassword string `json:"influx_password"`
+	InfluxDB       string `json:"influx_db"`
+
 	// 通用配置
 	LogLevel   int      `json:"log_level"`
 	AccessKeys []string `json:"accesskeys"`
@@ -59,7 +78,9 @@ func HealthyAPISrvInit(part *utils.ConfigPart) (srv *HealthyAPISrv, err error) {
 	if err = utils.ReflectConfigPart(part, &config); err != nil {
 		return
 	}
-	config.init()
+	if err = config.init(); err != nil {
+		return
+	}
 	srv = &config
 	return
 }
@@ -67,6 +88,13 @@ func HealthyAPISrvInit(part *utils.ConfigPart) (srv *HealthyAPISrv, err error) {
 // Start 组件启动
 func (srv *HealthyAPISrv) Start() {
 	logger := middlewares.GetLogger(srv.ctx)
+	// 旧版本的数据没有索引, 第一次启动时建立
+	if srv.StoreType == "" || srv.StoreType == models.StoreTypeRedis {
+		ctx := middlewares.WithRedisConn(srv.ctx, srv.RedisHost, srv.RedisPassword, srv.RedisDB)
+		if err := models.MigrateIndexes(ctx); err != nil {
+			logger.Warnf("migrate indexes error %v", err)
+		}
+	}
 	logger.Infof("api server listen at %s", srv.Host)
 	if err := srv.server.ListenAndServe(); err != nil {
 		logger.Fatalf("api server error %s", err)
@@ -80,7 +108,21 @@ func (srv *HealthyAPISrv) Stop() {
 }
 
 // 服务内部初始化
-func (srv *HealthyAPISrv) init() {
+func (srv *HealthyAPISrv) init() error {
+	store, err := models.NewStore(srv.StoreType, srv.StorePath)
+	if err != nil {
+		return err
+	}
+	if srv.ConfigDir != "" {
+		if store, err = models.NewDirStore(srv.ConfigDir, store); err != nil {
+			return err
+		}
+	}
+	retention := time.Duration(srv.ProbeStoreRetention) * time.Hour
+	probeStore, err := models.NewProbeStore(srv.ProbeStoreType, srv.ProbeStorePath, retention)
+	if err != nil {
+		return err
+	}
 	r := mux.NewRouter()
 	srv.server = &http.Server{
 		Handler:      r,
@@ -91,13 +133,22 @@ func (srv *HealthyAPISrv) init() {
 	srv.ctx = httputil.WithHTTPContext(nil)
 	srv.ctx = middlewares.WithLogger(srv.ctx, srv.LogLevel, os.Stdout)
 	httputil.Use(srv.ctx, middlewares.LoggerHandler(srv.LogLevel, os.Stdout))
-	httputil.Use(srv.ctx, middlewares.RedisConnHandler(srv.RedisHost, srv.RedisPassword, srv.RedisDB))
+	if srv.StoreType == "" || srv.StoreType == models.StoreTypeRedis {
+		httputil.Use(srv.ctx, middlewares.RedisConnHandler(srv.RedisHost, srv.RedisPassword, srv.RedisDB))
+	}
 	httputil.Use(srv.ctx, middlewares.ElasticConnHandler(srv.ElasticURLs, "", ""))
+	if srv.ProbeStoreType == models.ProbeStoreTypeInflux {
+		httputil.Use(srv.ctx, middlewares.InfluxDBHandler(srv.InfluxHost, srv.InfluxUser, srv.InfluxPassword, srv.InfluxDB))
+	}
+	httputil.Use(srv.ctx, models.StoreHandler(store))
+	httputil.Use(srv.ctx, models.ProbeStoreHandler(probeStore))
 
 	// api 版本
 	v1 := r.PathPrefix("/v1").Subrouter()
 
 	r.NotFoundHandler = middlewares.ErrorNotFoundHandler()
+	// 运行统计, 同一个进程里探测日志写入队列的长度和丢弃数量也在这里
+	r.Handle("/debug/vars", expvar.Handler()).Methods("GET")
 	// group
 	v1.HandleFunc("/gamehealthy/group",
 		httputil.HandleFunc(srv.ctx,
@@ -133,6 +184,10 @@ func (srv *HealthyAPISrv) init() {
 		httputil.HandleFunc(srv.ctx,
 			middlewares.BindBody(&handlers.FetchNotifierReq{}),
 			handlers.FetchNotifierHandler)).Methods("GET")
+	v1.HandleFunc("/gamehealthy/notifier/test",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.TestNotifierReq{}),
+			handlers.TestNotifierHandler)).Methods("POST")
 
 	// heapster
 	v1.HandleFunc("/gamehealthy/heapster",
@@ -160,9 +215,86 @@ func (srv *HealthyAPISrv) init() {
 			middlewares.BindBody(&handlers.MuteHeapsterReq{}),
 			handlers.MuteHeapsterHandler)).Methods("POST")
 
+	// escalation
+	v1.HandleFunc("/gamehealthy/escalation",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.CreateEscalationReq{}),
+			handlers.CreateEscalationHandler)).Methods("POST")
+	v1.HandleFunc("/gamehealthy/escalation",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.UpdateEscalationReq{}),
+			handlers.UpdateEscalationHandler)).Methods("PATCH", "PUT")
+	v1.HandleFunc("/gamehealthy/escalation",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.DeleteEscalationReq{}),
+			handlers.DeleteEscalationHandler)).Methods("DELETE")
+	v1.HandleFunc("/gamehealthy/escalation",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchEscalationReq{}),
+			handlers.FetchEscalationHandler)).Methods("GET")
+
+	// template
+	v1.HandleFunc("/gamehealthy/template",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.CreateTemplateReq{}),
+			handlers.CreateTemplateHandler)).Methods("POST")
+	v1.HandleFunc("/gamehealthy/template",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.UpdateTemplateReq{}),
+			handlers.UpdateTemplateHandler)).Methods("PATCH", "PUT")
+	v1.HandleFunc("/gamehealthy/template",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.DeleteTemplateReq{}),
+			handlers.DeleteTemplateHandler)).Methods("DELETE")
+	v1.HandleFunc("/gamehealthy/template",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchTemplateReq{}),
+			handlers.FetchTemplateHandler)).Methods("GET")
+	v1.HandleFunc("/gamehealthy/template/preview",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.PreviewTemplateReq{}),
+			handlers.PreviewTemplateHandler)).Methods("POST")
+
+	// revision
+	v1.HandleFunc("/gamehealthy/revision",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchRevisionReq{}),
+			handlers.FetchRevisionHandler)).Methods("GET")
+	v1.HandleFunc("/gamehealthy/revision/rollback",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.RollbackRevisionReq{}),
+			handlers.RollbackRevisionHandler)).Methods("POST")
+
+	// usage
+	v1.HandleFunc("/gamehealthy/usage",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchUsageReq{}),
+			handlers.FetchUsageHandler)).Methods("GET")
+
+	// incident
+	v1.HandleFunc("/gamehealthy/incident",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchIncidentReq{}),
+			handlers.FetchIncidentHandler)).Methods("GET")
+	v1.HandleFunc("/gamehealthy/incident/ack",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.AckIncidentReq{}),
+			handlers.AckIncidentHandler)).Methods("POST")
+
+	// notification
+	v1.HandleFunc("/gamehealthy/notification",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchNotificationReq{}),
+			handlers.FetchNotificationHandler)).Methods("GET")
+
 	// report
 	v1.HandleFunc("/gamehealthy/report",
 		httputil.HandleFunc(srv.ctx,
 			middlewares.BindBody(&handlers.FetchReportReq{}),
 			handlers.FetchReportHandler)).Methods("GET")
+	v1.HandleFunc("/gamehealthy/sla",
+		httputil.HandleFunc(srv.ctx,
+			middlewares.BindBody(&handlers.FetchSLAReq{}),
+			handlers.FetchSLAHandler)).Methods("GET")
+	return nil
 }
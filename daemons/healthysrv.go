@@ -2,6 +2,9 @@ package daemons
 
 import (
 	"context"
+	"expvar"
+	"fmt"
+	"net/http"
 	"os"
 
 	"sync"
@@ -10,6 +13,7 @@ import (
 	"zonst/qipai/gamehealthysrv/detectors"
 	"zonst/qipai/gamehealthysrv/middlewares"
 	"zonst/qipai/gamehealthysrv/models"
+	"zonst/qipai/gamehealthysrv/notifiers"
 	"zonst/qipai/logagent/utils"
 )
 
@@ -17,7 +21,7 @@ import (
 type HealthySrv struct {
 	utils.InputPluginConfig
 
-	// Redis配置
+	// Redis配置, 只有redis存储需要, bolt存储的事故, 通知记录和流量控制不使用Redis
 	RedisHost     string `json:"redis_host"`
 	RedisPassword string `json:"redis_password"`
 	RedisDB       int    `json:"redis_db"`
@@ -25,20 +29,65 @@ type HealthySrv struct {
 	// Elastic配置
 	ElasticURLs []string `json:"elastic_urls"`
 
+	// 模型存储, redis(默认)或者bolt, bolt需要配置文件路径
+	StoreType string `json:"store"`
+	StorePath string `json:"store_path"`
+	// 从目录里的json或者yaml文件加载全部配置, 文件修改之后自动重新加载, 不能通过接口修改; 状态还是保存在模型存储里
+	ConfigDir string `json:"config_dir"`
+
+	// 探测结果存储, elastic(默认), influxdb或者local, local需要配置文件路径, 保留时间单位小时
+	ProbeStoreType      string `json:"probe_store"`
+	ProbeStorePath      string `json:"probe_store_path"`
+	ProbeStoreRetention int    `json:"probe_retention"`
+	// elastic存储: 超过保留时间的日志索引删除(delete, 默认)或者关闭(close), 之前先降采样为小时统计, 小时统计保留天数默认365;
+	// 每次重新统计最近downsample_window小时(默认24), 包括晚到的日志
+	ProbeRetentionAction string `json:"probe_retention_action"`
+	SLARetention         int    `json:"sla_retention"`
+	DownsampleWindow     int    `json:"downsample_window"`
+
+	// 探测日志批量写入, 满batch条或者每隔flush秒写一次; 写入失败的暂存到spool文件, 为空时丢弃
+	ProbeBatchSize     int    `json:"probe_batch_size"`
+	ProbeFlushInterval int    `json:"probe_flush_interval"`
+	ProbeSpoolPath     string `json:"probe_spool_path"`
+	ProbeSpoolSize     int    `json:"probe_spool_size"`
+
+	// InfluxDB配置
+	InfluxHost     string `json:"influx_host"`
+	InfluxUser     string `json:"influx_user"`
+	InfluxPassword string `json:"influx_password"`
+	InfluxDB       string `json:"influx_db"`
+
 	// 联通短信配置
 	UnicomSP       string `json:"unicom_sp"`
 	UnicomUsername string `json:"unicom_username"`
 	UnicomPassword string `json:"unicom_password"`
 
+	// 探测点配置, 多个探测点时只需要一个负责警报
+	ProbeLocation string `json:"probe_location"`
+	ProbeOnly     bool   `json:"probe_only"`
+
+	// 短信回执拉取间隔, 单位秒
+	ReceiptInterval int `json:"receipt_interval"`
+
+	// 通知合并等待时间, 单位秒, 0表示不合并; 合并时需要相同的标签
+	GroupWait int      `json:"group_wait"`
+	GroupBy   []string `json:"group_by"`
+
+	// 运行统计的监听地址, 比如 127.0.0.1:6060, 提供 /debug/vars 查看探测日志写入队列的长度和丢弃数量; 为空不启动
+	DebugAddr string `json:"debug_addr"`
+
 	LogLevel   int      `json:"log_level"`
 	AccessKeys []string `json:"accesskeys"`
 
 	// 私有配置
-	ctx     context.Context
-	cancel  func()
-	done    chan struct{}
-	loopers map[models.SerialNumber]detectors.DetectLooper
-	alerts  map[models.SerialNumber]alerts.Alert
+	ctx        context.Context
+	cancel     func()
+	dispatcher *alerts.Dispatcher
+	deliveries *notifiers.Deliveries
+	probes     *models.BufferedProbeStore
+	done       chan struct{}
+	loopers    map[models.SerialNumber]detectors.DetectLooper
+	alerts     map[models.SerialNumber]alerts.Alert
 }
 
 // 服务名称
@@ -66,7 +115,9 @@ func HealthySrvInit(part *utils.ConfigPart) (srv *HealthySrv, err error) {
 	if err = utils.ReflectConfigPart(part, &config); err != nil {
 		return
 	}
-	config.init()
+	if err = config.init(); err != nil {
+		return
+	}
 	srv = &config
 	return
 }
@@ -75,6 +126,31 @@ func HealthySrvInit(part *utils.ConfigPart) (srv *HealthySrv, err error) {
 func (srv *HealthySrv) Start() {
 	defer close(srv.done)
 
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	// 只探测的时候不发通知, 也不需要拉取回执
+	if !srv.ProbeOnly {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.pollReceipts()
+		}()
+	}
+	if srv.DebugAddr != "" {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.serveDebug()
+		}()
+	}
+	if srv.ProbeStoreType == "" || srv.ProbeStoreType == models.ProbeStoreTypeElastic {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			srv.maintainElastic()
+		}()
+	}
+
 	var (
 		logger      = middlewares.GetLogger(srv.ctx)
 		ratelimiter = middlewares.GetRateLimiter(srv.ctx)
@@ -84,6 +160,13 @@ func (srv *HealthySrv) Start() {
 		err            error
 	)
 
+	// 旧版本的数据没有索引, 第一次启动时建立
+	if srv.StoreType == "" || srv.StoreType == models.StoreTypeRedis {
+		if err := models.MigrateIndexes(srv.ctx); err != nil {
+			logger.Warnf("migrate indexes error %v", err)
+		}
+	}
+
 	logger.Info("start main looper")
 	for {
 		select {
@@ -114,11 +197,107 @@ func (srv *HealthySrv) Start() {
 	}
 }
 
+// pollReceipts 定时拉取短信回执, 更新通知的送达状态
+func (srv *HealthySrv) pollReceipts() {
+	logger := middlewares.GetLogger(srv.ctx)
+	interval := time.Duration(srv.ReceiptInterval) * time.Second
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-srv.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := notifiers.PollReceipts(srv.ctx); err != nil {
+			logger.Warnf("poll sms receipts error %v", err)
+		}
+	}
+}
+
+// serveDebug 提供运行统计, 服务停止时关闭
+func (srv *HealthySrv) serveDebug() {
+	logger := middlewares.GetLogger(srv.ctx)
+	mux := http.NewServeMux()
+	mux.Handle("/debug/vars", expvar.Handler())
+	server := &http.Server{Addr: srv.DebugAddr, Handler: mux}
+	go func() {
+		<-srv.ctx.Done()
+		server.Close()
+	}()
+	logger.Infof("serve debug vars on %s", srv.DebugAddr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Warnf("serve debug vars error %v", err)
+	}
+}
+
+// maintainElastic 启动时安装索引模版; 多个探测点时只由负责警报的服务每小时降采样和清理过期索引
+func (srv *HealthySrv) maintainElastic() {
+	logger := middlewares.GetLogger(srv.ctx)
+	if err := models.InstallElasticTemplates(srv.ctx); err != nil {
+		logger.Warnf("install elastic templates error %v", err)
+	}
+	if srv.ProbeOnly {
+		return
+	}
+	retention := time.Duration(srv.ProbeStoreRetention) * time.Hour
+	if retention <= 0 {
+		retention = models.DefaultProbeRetention
+	}
+	slaRetention := time.Duration(srv.SLARetention) * 24 * time.Hour
+	if slaRetention <= 0 {
+		slaRetention = models.DefaultSLARetention
+	}
+	window := time.Duration(srv.DownsampleWindow) * time.Hour
+	if window <= 0 {
+		window = models.DefaultDownsampleWindow
+	}
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		now := time.Now()
+		// 没有降采样的日志不清理
+		watermark, err := models.DownsampleProbeLogs(srv.ctx, window, now)
+		if err != nil {
+			logger.Warnf("downsample probe logs error %v", err)
+		}
+		before := now.Add(-retention)
+		if watermark.Before(before) {
+			before = watermark
+		}
+		expired, err := models.ExpireProbeIndices(srv.ctx, srv.ProbeRetentionAction, before, now.Add(-slaRetention))
+		if err != nil {
+			logger.Warnf("expire probe indices error %v", err)
+		}
+		if len(expired) > 0 {
+			logger.Infof("expired indices %v", expired)
+		}
+		select {
+		case <-srv.ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 func (srv *HealthySrv) installHeapster(looper detectors.DetectLooper, alert alerts.Alert, model models.Heapster) {
 	looper.Run()
-	alert.TurnOn()
-	srv.alerts[model.ID] = alert
 	srv.loopers[model.ID] = looper
+	if alert != nil {
+		alert.TurnOn()
+		srv.alerts[model.ID] = alert
+	}
+}
+
+// 创建警报器, 只探测的时候不创建
+func (srv *HealthySrv) newAlert(model models.Heapster) (alerts.Alert, error) {
+	if srv.ProbeOnly {
+		return nil, nil
+	}
+	return alerts.NewAlert(srv.ctx, model)
 }
 
 func (srv *HealthySrv) uninstallHeapster(model models.Heapster) {
@@ -148,13 +327,19 @@ func (srv *HealthySrv) loadAdded(keys models.HeapsterSetKeys) {
 			entry.Warnf("load heapster %s error: %v", k, err)
 			continue
 		}
+		// 旧版本保存的配置可能缺少阈值和超时, 补全默认值; 只跳过无法运行的配置
+		model.FillDefaults()
+		if err := model.Runnable(); err != nil {
+			entry.Warnf("heapster %s invalid: %v", k, err)
+			continue
+		}
 		// 创建新的
 		looper, err := detectors.NewDetectLooper(srv.ctx, model)
 		if err != nil {
 			entry.Warnf("create looper for heapster %s error: %v", model.ID, err)
 			continue
 		}
-		alert, err := alerts.NewAlert(srv.ctx, model)
+		alert, err := srv.newAlert(model)
 		if err != nil {
 			entry.Warnf("create alert for heapster %s error: %v", model.ID, err)
 			continue
@@ -177,13 +362,19 @@ func (srv *HealthySrv) loadModified(keys models.HeapsterSetKeys) {
 			entry.Warnf("load heapster %s error: %v", k, err)
 			continue
 		}
+		// 旧版本保存的配置可能缺少阈值和超时, 补全默认值; 只跳过无法运行的配置
+		model.FillDefaults()
+		if err := model.Runnable(); err != nil {
+			entry.Warnf("heapster %s invalid: %v", k, err)
+			continue
+		}
 		// 创建新的
 		looper, err := detectors.NewDetectLooper(srv.ctx, model)
 		if err != nil {
 			entry.Warnf("create looper for heapster %s error: %v", model.ID, err)
 			continue
 		}
-		alert, err := alerts.NewAlert(srv.ctx, model)
+		alert, err := srv.newAlert(model)
 		if err != nil {
 			entry.Warnf("create alert for heapster %s error: %v", model.ID, err)
 			continue
@@ -235,14 +426,78 @@ func (srv *HealthySrv) Stop() {
 	}
 
 	wg.Wait()
+	if srv.dispatcher != nil {
+		logger.Infof("flushing pending notifications")
+		srv.dispatcher.Close()
+	}
+	logger.Infof("waiting background deliveries")
+	srv.deliveries.Wait()
+	logger.Infof("flushing buffered probe logs")
+	if err := srv.probes.Close(); err != nil {
+		logger.Warnf("close probe spool error %v", err)
+	}
 	logger.Infof("healthy server stop")
 }
 
 // 服务内部初始化
-func (srv *HealthySrv) init() {
+func (srv *HealthySrv) init() error {
+	switch srv.ProbeRetentionAction {
+	case "", models.ElasticRetentionDelete, models.ElasticRetentionClose:
+	default:
+		return fmt.Errorf("probe retention action %s not support", srv.ProbeRetentionAction)
+	}
+	store, err := models.NewStore(srv.StoreType, srv.StorePath)
+	if err != nil {
+		return err
+	}
+	if srv.ConfigDir != "" {
+		if store, err = models.NewDirStore(srv.ConfigDir, store); err != nil {
+			return err
+		}
+	}
+	retention := time.Duration(srv.ProbeStoreRetention) * time.Hour
+	probeStore, err := models.NewProbeStore(srv.ProbeStoreType, srv.ProbeStorePath, retention)
+	if err != nil {
+		return err
+	}
 	srv.ctx = middlewares.WithLogger(context.Background(), srv.LogLevel, os.Stdout)
-	srv.ctx = middlewares.WithRedisConn(srv.ctx, srv.RedisHost, srv.RedisPassword, srv.RedisDB)
-	srv.ctx = middlewares.WithRateLimiter(srv.ctx, srv.RedisHost, srv.RedisPassword, srv.RedisDB)
+	srv.ctx = models.WithStore(srv.ctx, store)
+	// 本地存储不需要Redis, 事故和通知记录也保存在文件里, 流量控制在进程内
+	if srv.StoreType == "" || srv.StoreType == models.StoreTypeRedis {
+		srv.ctx = middlewares.WithRedisConn(srv.ctx, srv.RedisHost, srv.RedisPassword, srv.RedisDB)
+		srv.ctx = middlewares.WithRateLimiter(srv.ctx, srv.RedisHost, srv.RedisPassword, srv.RedisDB)
+	} else {
+		srv.ctx = middlewares.WithMemoryRateLimiter(srv.ctx)
+	}
 	srv.ctx = middlewares.WithElasticConn(srv.ctx, srv.ElasticURLs, "", "")
+	if srv.ProbeStoreType == models.ProbeStoreTypeInflux {
+		srv.ctx, err = middlewares.WithInfluxDB(srv.ctx, srv.InfluxHost, srv.InfluxUser, srv.InfluxPassword, srv.InfluxDB)
+		if err != nil {
+			return err
+		}
+	}
+	if srv.ProbeLocation != "" {
+		srv.ctx = middlewares.WithProbeLocation(srv.ctx, srv.ProbeLocation)
+	}
+	// 批量写入和分发器一样在服务停止之后还需要写入剩余的日志, 不使用可以取消的context
+	srv.probes, err = models.NewBufferedProbeStore(srv.ctx, probeStore, models.BufferedProbeConfig{
+		BatchSize:     srv.ProbeBatchSize,
+		FlushInterval: time.Duration(srv.ProbeFlushInterval) * time.Second,
+		SpoolPath:     srv.ProbeSpoolPath,
+		SpoolSize:     srv.ProbeSpoolSize,
+	})
+	if err != nil {
+		return err
+	}
+	srv.ctx = models.WithProbeStore(srv.ctx, srv.probes)
+	// 电话等后台发送不随警报停止, 服务停止时等待结束
+	srv.deliveries = notifiers.NewDeliveries()
+	srv.ctx = notifiers.WithDeliveries(srv.ctx, srv.deliveries)
+	// 分发器在服务停止之后还需要发送等待中的通知, 不使用可以取消的context
+	if srv.GroupWait > 0 {
+		srv.dispatcher = alerts.NewDispatcher(srv.ctx, time.Duration(srv.GroupWait)*time.Second, srv.GroupBy)
+		srv.ctx = alerts.WithDispatcher(srv.ctx, srv.dispatcher)
+	}
 	srv.ctx, srv.cancel = context.WithCancel(srv.ctx)
+	return nil
 }
@@ -1,8 +1,18 @@
 package daemons
 
 import (
+	"context"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
+
+	"zonst/qipai/gamehealthysrv/alerts"
+	"zonst/qipai/gamehealthysrv/detectors"
+	"zonst/qipai/gamehealthysrv/models"
 	"zonst/qipai/logagent/utils"
 
 	"github.com/stretchr/testify/assert"
@@ -35,3 +45,115 @@ func TestHealthySrv(t *testing.T) {
 	srv.StopInputs()
 
 }
+
+// newTestHealthySrv 使用本地存储的服务, 同一个进程里的服务共用存储文件
+func newTestHealthySrv(t *testing.T, dir string, location string, probeOnly bool) *HealthySrv {
+	srv := &HealthySrv{
+		StoreType:          models.StoreTypeBolt,
+		StorePath:          filepath.Join(dir, "store.db"),
+		ProbeStoreType:     models.ProbeStoreTypeLocal,
+		ProbeStorePath:     filepath.Join(dir, "probes.db"),
+		ProbeBatchSize:     1,
+		ProbeFlushInterval: 1,
+		ProbeLocation:      location,
+		ProbeOnly:          probeOnly,
+
+		loopers: make(map[models.SerialNumber]detectors.DetectLooper),
+		alerts:  make(map[models.SerialNumber]alerts.Alert),
+		done:    make(chan struct{}),
+	}
+	assert.NoError(t, srv.init())
+	return srv
+}
+
+// waitStatus 等待heapster变成指定状态, 超时返回最后的状态
+func waitStatus(ctx context.Context, id models.SerialNumber, want models.HealthyStatus, timeout time.Duration) models.HealthyStatus {
+	hst := &models.Heapster{ID: id}
+	deadline := time.Now().Add(timeout)
+	for {
+		status := hst.GetStatus(ctx)
+		if status == want || time.Now().After(deadline) {
+			return status
+		}
+		time.Sleep(200 * time.Millisecond)
+	}
+}
+
+func TestMultiLocationHealthySrv(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gamehealthy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 关闭的端口, 所有探测点都连接失败
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	shenzhen := newTestHealthySrv(t, dir, "shenzhen", false)
+	ctx := shenzhen.ctx
+	g := &models.Group{
+		ID:        "testlocationgroup",
+		Name:      "room",
+		Endpoints: models.Endpoints{"127.0.0.1"},
+	}
+	assert.NoError(t, g.Save(ctx))
+	hst := &models.Heapster{
+		ID:           "testlocation",
+		Name:         "room",
+		Type:         models.CheckTypeTCP,
+		Port:         port,
+		Timeout:      200 * time.Millisecond,
+		Interval:     500 * time.Millisecond,
+		Threshold:    2,
+		Groups:       []string{string(g.ID)},
+		MinLocations: 2,
+	}
+	assert.NoError(t, hst.Save(ctx))
+
+	// 只有一个探测点失败不够判定为红色
+	go shenzhen.Start()
+	defer shenzhen.Stop()
+	assert.Equal(t, models.HealthyStatusYellow, waitStatus(ctx, hst.ID, models.HealthyStatusYellow, 10*time.Second))
+	time.Sleep(2 * time.Second)
+	assert.Equal(t, models.HealthyStatusYellow, hst.GetStatus(ctx))
+
+	// 另一个探测点也失败之后是红色, 统计里有两个探测点
+	shanghai := newTestHealthySrv(t, dir, "shanghai", true)
+	go shanghai.Start()
+	defer shanghai.Stop()
+	assert.Equal(t, models.HealthyStatusRed, waitStatus(ctx, hst.ID, models.HealthyStatusRed, 10*time.Second))
+	stat, err := hst.GetStat(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, stat.Locations["shenzhen"].Faileds)
+	assert.Equal(t, 1, stat.Locations["shanghai"].Faileds)
+}
+
+func TestHealthySrvDebugVars(t *testing.T) {
+	dir, err := ioutil.TempDir("", "healthysrvdebug")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	addr := l.Addr().String()
+	l.Close()
+
+	srv := newTestHealthySrv(t, dir, "", true)
+	srv.DebugAddr = addr
+	go srv.Start()
+	defer srv.Stop()
+
+	// 探测服务单独运行时也能查看写入队列的统计
+	var body []byte
+	for i := 0; i < 50; i++ {
+		resp, err := http.Get("http://" + addr + "/debug/vars")
+		if err == nil {
+			body, _ = ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	assert.Contains(t, string(body), "gamehealthy_probe")
+}
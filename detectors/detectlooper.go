@@ -11,12 +11,12 @@ import (
 )
 
 var (
-	namedDetectors = make(map[string]detectorCreator)
+	namedDetectors = make(map[models.CheckType]detectorCreator)
 )
 
-// 注册detector类型
-func registCreator(name string, creator detectorCreator) {
-	namedDetectors[name] = creator
+// 注册detector类型, 支持的检查类型在 models.CheckTypes 里定义, 每个类型都要注册
+func registCreator(typ models.CheckType, creator detectorCreator) {
+	namedDetectors[typ] = creator
 }
 
 // detector 探测器接口
@@ -35,7 +35,7 @@ type DetectLooper interface {
 
 // NewDetectLooper 创建一个looper
 func NewDetectLooper(ctx context.Context, model models.Heapster) (DetectLooper, error) {
-	creator, ok := namedDetectors[string(model.Type)]
+	creator, ok := namedDetectors[model.Type]
 	if !ok {
 		return nil, fmt.Errorf("detector type not found")
 	}
@@ -95,6 +95,7 @@ func (dl *defaultLooper) Run() error {
 
 		ticker := time.NewTicker(dl.model.Interval)
 		defer ticker.Stop()
+		location := middlewares.GetProbeLocation(dl.ctx)
 
 		for {
 			var (
@@ -102,6 +103,12 @@ func (dl *defaultLooper) Run() error {
 			)
 			pls := dl.worker.probe(timeoutCtx)
 			cancel()
+			// 标记探测点, heapster名称和检查类型
+			for i := range pls {
+				pls[i].Location = location
+				pls[i].Name = dl.model.Name
+				pls[i].Type = dl.model.Type
+			}
 			// 写入报告
 			if err := pls.Save(dl.ctx); err != nil {
 				logger.Warnf("pass probe log save err %v", err)
@@ -3,7 +3,9 @@ package detectors
 import (
 	"context"
 	"fmt"
+	"io/ioutil"
 	"os"
+	"path/filepath"
 	"testing"
 	"zonst/qipai/gamehealthysrv/middlewares"
 	"zonst/qipai/gamehealthysrv/models"
@@ -15,6 +17,25 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
+// withLocalStores 模型和探测结果都使用本地文件存储, 测试不依赖Redis和Elastic
+func withLocalStores(t *testing.T) (context.Context, func()) {
+	dir, err := ioutil.TempDir("", "gamehealthy")
+	assert.NoError(t, err)
+	store, err := models.OpenBoltStore(filepath.Join(dir, "store.db"))
+	assert.NoError(t, err)
+	probeStore, err := models.OpenLocalProbeStore(filepath.Join(dir, "probe.db"), 0)
+	assert.NoError(t, err)
+
+	ctx := middlewares.WithLogger(context.Background(), 5, os.Stdout)
+	ctx = models.WithStore(ctx, store)
+	ctx = models.WithProbeStore(ctx, probeStore)
+	return ctx, func() {
+		store.Close()
+		probeStore.Close()
+		os.RemoveAll(dir)
+	}
+}
+
 func init() {
 	registCreator("test", func(ctx context.Context, hp models.Heapster) (detector, error) {
 		return &testDetector{}, nil
@@ -32,7 +53,13 @@ func (td *testDetector) probe(ctx context.Context) models.ProbeLogs {
 	case <-time.After(time.Duration((rand.Int() % 3)) * time.Second):
 		fmt.Println("ok")
 	}
-	return nil
+	return models.ProbeLogs{
+		models.ProbeLog{
+			Heapster: "123456",
+			Target:   "127.0.0.1:5200",
+			Success:  1,
+		},
+	}
 }
 
 func TestDetectLooper(t *testing.T) {
@@ -48,9 +75,9 @@ func TestDetectLooper(t *testing.T) {
 		}
 	)
 
-	ctx := middlewares.WithRedisConn(context.Background(), "localhost:6379", "", 1)
-	ctx = middlewares.WithLogger(ctx, 5, os.Stdout)
-	ctx = middlewares.WithElasticConn(ctx, []string{"http://10.0.10.46:9200"}, "", "")
+	ctx, cleanup := withLocalStores(t)
+	defer cleanup()
+	start := time.Now()
 
 	looper, err := NewDetectLooper(ctx, hp)
 	assert.NoError(t, err)
@@ -58,4 +85,19 @@ func TestDetectLooper(t *testing.T) {
 	looper.Run()
 	time.Sleep(15 * time.Second)
 	looper.Stop()
+
+	// 超时取消的探测没有结果, 至少有一次成功写入
+	rps, err := models.FetchReportsAggs(ctx, string(hp.ID), start)
+	assert.NoError(t, err)
+	if assert.Len(t, rps, 1) {
+		assert.True(t, rps[0].Success > 0)
+	}
+}
+
+// TestCheckTypes 支持的检查类型都有探测器
+func TestCheckTypes(t *testing.T) {
+	for _, typ := range models.CheckTypes() {
+		_, ok := namedDetectors[typ]
+		assert.True(t, ok, "check type %s has no detector", typ)
+	}
 }
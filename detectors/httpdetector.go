@@ -14,7 +14,7 @@ import (
 )
 
 func init() {
-	registCreator(string(models.CheckTypeHTTP), httpDetectorCreator)
+	registCreator(models.CheckTypeHTTP, httpDetectorCreator)
 }
 
 var httpDetectorCreator detectorCreator = func(ctx context.Context, hp models.Heapster) (detector, error) {
@@ -47,10 +47,12 @@ var httpDetectorCreator detectorCreator = func(ctx context.Context, hp models.He
 				req.Host = hp.Host
 			}
 			dtr.reqs = append(dtr.reqs, req)
+			dtr.groups = append(dtr.groups, string(g.ID))
 		}
 	}
 	if len(dtr.reqs) >= 256 {
 		dtr.reqs = dtr.reqs[:255]
+		dtr.groups = dtr.groups[:255]
 		dtr.logger.Warnf("max target 256 reached")
 	}
 	return dtr, nil
@@ -60,6 +62,7 @@ type httpDetector struct {
 	model  models.Heapster
 	logger *logrus.Logger
 	reqs   []*http.Request
+	groups []string
 }
 
 func (dtr *httpDetector) probe(ctx context.Context) models.ProbeLogs {
@@ -67,12 +70,12 @@ func (dtr *httpDetector) probe(ctx context.Context) models.ProbeLogs {
 		probeLogs = make(models.ProbeLogs, 0, len(dtr.reqs))
 		wg        sync.WaitGroup
 	)
-	for _, req := range dtr.reqs {
+	for i, req := range dtr.reqs {
 		// 设置超时上下文
 		timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(dtr.model.Timeout))
 		wg.Add(1)
 		// 启动goroutine
-		go func(req *http.Request, ctx context.Context, cancel func()) {
+		go func(req *http.Request, group string, ctx context.Context, cancel func()) {
 			defer wg.Done()
 			defer cancel()
 			// 准备报告
@@ -80,6 +83,7 @@ func (dtr *httpDetector) probe(ctx context.Context) models.ProbeLogs {
 			probeLog := models.ProbeLog{
 				Heapster:  string(dtr.model.ID),
 				Target:    req.URL.String(),
+				Group:     group,
 				Timestamp: beginAt,
 			}
 			// 测试连接
@@ -97,7 +101,7 @@ func (dtr *httpDetector) probe(ctx context.Context) models.ProbeLogs {
 			}
 			// 添加日志
 			probeLogs = append(probeLogs, probeLog)
-		}(req, timeoutCtx, cancel)
+		}(req, dtr.groups[i], timeoutCtx, cancel)
 	}
 	wg.Wait()
 	return probeLogs
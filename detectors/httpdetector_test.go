@@ -5,9 +5,7 @@ import (
 	"fmt"
 	"net"
 	"net/http"
-	"os"
 	"testing"
-	"zonst/qipai/gamehealthysrv/middlewares"
 	"zonst/qipai/gamehealthysrv/models"
 
 	"time"
@@ -40,9 +38,8 @@ func WithHTTPTarget(ctx context.Context) context.Context {
 }
 
 func TestHTTPPlumb(t *testing.T) {
-	ctx := middlewares.WithRedisConn(context.Background(), "localhost:6379", "", 1)
-	ctx = middlewares.WithLogger(ctx, 5, os.Stdout)
-	ctx = middlewares.WithElasticConn(ctx, []string{"http://10.0.10.46:9200"}, "", "")
+	ctx, cleanup := withLocalStores(t)
+	defer cleanup()
 
 	g1 := models.Group{
 		ID:   "test_local",
@@ -85,9 +82,8 @@ func TestHTTPPlumb(t *testing.T) {
 }
 
 func TestHttpWithHost(t *testing.T) {
-	ctx := middlewares.WithRedisConn(context.Background(), "localhost:6379", "", 1)
-	ctx = middlewares.WithLogger(ctx, 5, os.Stdout)
-	ctx = middlewares.WithElasticConn(ctx, []string{"http://10.0.10.46:9200"}, "", "")
+	ctx, cleanup := withLocalStores(t)
+	defer cleanup()
 
 	g1 := models.Group{
 		ID:   "test_local1",
@@ -14,7 +14,7 @@ import (
 )
 
 func init() {
-	registCreator(string(models.CheckTypeTCP), tcpDetectorCreator)
+	registCreator(models.CheckTypeTCP, tcpDetectorCreator)
 }
 
 var tcpDetectorCreator detectorCreator = func(ctx context.Context, hp models.Heapster) (detector, error) {
@@ -36,10 +36,12 @@ var tcpDetectorCreator detectorCreator = func(ctx context.Context, hp models.Hea
 				continue
 			}
 			dtr.address = append(dtr.address, addr)
+			dtr.groups = append(dtr.groups, string(g.ID))
 		}
 	}
 	if len(dtr.address) >= 256 {
 		dtr.address = dtr.address[:255]
+		dtr.groups = dtr.groups[:255]
 		dtr.logger.Warnf("max target 256 reached")
 	}
 	return dtr, nil
@@ -49,6 +51,7 @@ type tcpDetector struct {
 	model   models.Heapster
 	logger  *logrus.Logger
 	address []*net.TCPAddr
+	groups  []string
 }
 
 func (dtr *tcpDetector) probe(ctx context.Context) models.ProbeLogs {
@@ -56,12 +59,12 @@ func (dtr *tcpDetector) probe(ctx context.Context) models.ProbeLogs {
 		probeLogs = make(models.ProbeLogs, 0, len(dtr.address))
 		wg        sync.WaitGroup
 	)
-	for _, addr := range dtr.address {
+	for i, addr := range dtr.address {
 		// 设置超时上下文
 		timeoutCtx, cancel := context.WithTimeout(ctx, time.Duration(dtr.model.Timeout))
 		wg.Add(1)
 		// 启动goroutine
-		go func(addr *net.TCPAddr, ctx context.Context, cancel func()) {
+		go func(addr *net.TCPAddr, group string, ctx context.Context, cancel func()) {
 			defer wg.Done()
 			defer cancel()
 			// 准备报告
@@ -69,6 +72,7 @@ func (dtr *tcpDetector) probe(ctx context.Context) models.ProbeLogs {
 			probeLog := models.ProbeLog{
 				Heapster:  string(dtr.model.ID),
 				Target:    addr.String(),
+				Group:     group,
 				Timestamp: beginAt,
 			}
 			// 测试连接
@@ -85,7 +89,7 @@ func (dtr *tcpDetector) probe(ctx context.Context) models.ProbeLogs {
 			}
 			// 添加日志
 			probeLogs = append(probeLogs, probeLog)
-		}(addr, timeoutCtx, cancel)
+		}(addr, dtr.groups[i], timeoutCtx, cancel)
 	}
 	wg.Wait()
 	return probeLogs
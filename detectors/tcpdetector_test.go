@@ -4,13 +4,11 @@ import (
 	"context"
 	"fmt"
 	"testing"
-	"zonst/qipai/gamehealthysrv/middlewares"
 	"zonst/qipai/gamehealthysrv/models"
 
 	"github.com/stretchr/testify/assert"
 
 	"net"
-	"os"
 	"time"
 )
 
@@ -45,9 +43,8 @@ func WithTCPTarget(ctx context.Context) context.Context {
 }
 
 func TestTCPPlumb(t *testing.T) {
-	ctx := middlewares.WithRedisConn(context.Background(), "localhost:6379", "", 1)
-	ctx = middlewares.WithLogger(ctx, 5, os.Stdout)
-	ctx = middlewares.WithElasticConn(ctx, []string{"http://10.0.10.46:9200"}, "", "")
+	ctx, cleanup := withLocalStores(t)
+	defer cleanup()
 
 	g1 := models.Group{
 		ID:   "test_group1",
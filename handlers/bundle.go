@@ -0,0 +1,79 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// 导入配置请求体的最大长度
+const maxBundleSize = 10 << 20
+
+// ExportBundleReq 导出配置请求, format是json(默认)或者yaml
+type ExportBundleReq struct {
+	Format string `json:"format,omitempty" http:"format,omitempty"`
+}
+
+// ExportBundleHandler 导出全部模版, 通知器, 升级策略, 组和heapster
+func ExportBundleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*ExportBundleReq)
+
+	bundle, err := models.ExportBundle(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	data, err := bundle.Encode(req.Format)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	if req.Format == models.BundleFormatYAML {
+		w.Header().Set("Content-Type", "application/x-yaml")
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// ImportBundleHandler 导入配置, 请求体是json或者yaml格式的全部配置, 不在配置里的对象会被删除;
+// ?dry_run=true 时只返回差异不修改
+func ImportBundleHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	data, err := ioutil.ReadAll(io.LimitReader(r.Body, maxBundleSize+1))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	if len(data) > maxBundleSize {
+		middlewares.ErrorWrite(w, 200, 1, fmt.Errorf("bundle is larger than %d bytes", maxBundleSize))
+		return
+	}
+	bundle, err := models.DecodeBundle(data)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+
+	diff, err := models.ImportBundle(ctx, bundle, r.URL.Query().Get("dry_run") == "true")
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 2), err)
+		return
+	}
+	data, err = json.Marshal(diff)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
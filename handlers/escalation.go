@@ -0,0 +1,183 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// EscalationLevelReq 升级级别, 延迟单位秒
+type EscalationLevelReq struct {
+	Notifiers []string      `json:"notifiers"`
+	Delay     time.Duration `json:"delay"`
+}
+
+// CreateEscalationReq 创建请求
+type CreateEscalationReq struct {
+	Name   string               `json:"name"`
+	Levels []EscalationLevelReq `json:"levels"`
+}
+
+// UpdateEscalationReq 更新请求, Version是修改前读取的版本, 不为0时检查是否已经被修改
+type UpdateEscalationReq struct {
+	ID      string `json:"id"`
+	Version int    `json:"version,omitempty"`
+
+	CreateEscalationReq
+}
+
+// DeleteEscalationReq 删除请求, 还在被引用时拒绝删除, Force时去掉引用之后删除
+type DeleteEscalationReq struct {
+	ID    string `json:"id" http:"id"`
+	Force bool   `json:"force,omitempty" http:"force,omitempty"`
+}
+
+// FetchEscalationReq 查询请求
+type FetchEscalationReq struct {
+	ID string `json:"id,omitempty" http:"id,omitempty"`
+}
+
+// 转换成模型的级别列表
+func (req *CreateEscalationReq) levels() []models.EscalationLevel {
+	levels := make([]models.EscalationLevel, 0, len(req.Levels))
+	for _, level := range req.Levels {
+		levels = append(levels, models.EscalationLevel{
+			Notifiers: level.Notifiers,
+			Delay:     level.Delay * time.Second,
+		})
+	}
+	return levels
+}
+
+// CreateEscalationHandler 创建
+func CreateEscalationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*CreateEscalationReq)
+
+	model := &models.EscalationPolicy{
+		ID:     models.NewSerialNumber(),
+		Name:   req.Name,
+		Levels: req.levels(),
+	}
+	if err := models.CheckRefs(ctx, model); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if err := model.Fill(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 4, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// UpdateEscalationHandler 更新
+func UpdateEscalationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*UpdateEscalationReq)
+
+	model := &models.EscalationPolicy{
+		ID: models.SerialNumber(req.ID),
+	}
+	if err := model.Fill(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if req.Version != 0 {
+		model.Version = req.Version
+	}
+	model.Name = req.Name
+	model.Levels = req.levels()
+	if err := models.CheckRefs(ctx, model); err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 3), err)
+		return
+	}
+	middlewares.ErrorWriteOK(w)
+}
+
+// DeleteEscalationHandler 删除
+func DeleteEscalationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*DeleteEscalationReq)
+
+	model := &models.EscalationPolicy{
+		ID: models.SerialNumber(req.ID),
+	}
+	if forceDelete(r, req.Force) {
+		err = model.ForceDelete(ctx)
+	} else {
+		err = model.Delete(ctx)
+	}
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, deleteErrno(err, 2), err)
+		return
+	}
+	middlewares.ErrorWriteOK(w)
+}
+
+// FetchEscalationHandler 查询
+func FetchEscalationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchEscalationReq)
+
+	var eps models.EscalationPolicies
+
+	if req.ID == "" {
+		eps, err = models.FetchEscalationPolicies(ctx)
+		if err != nil {
+			middlewares.ErrorWrite(w, 200, 2, err)
+			return
+		}
+	} else {
+		ep := &models.EscalationPolicy{
+			ID: models.SerialNumber(req.ID),
+		}
+		if err = ep.Fill(ctx); err != nil {
+			middlewares.ErrorWrite(w, 200, 2, err)
+			return
+		}
+		eps = models.EscalationPolicies{*ep}
+	}
+	data, err := json.Marshal(eps)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
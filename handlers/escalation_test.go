@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"zonst/qipai-golang-libs/httputil"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var escalationTestID string
+
+func TestCreateEscalation(t *testing.T) {
+	saveTestRefs(t)
+	ctx := httputil.WithHTTPContext(nil)
+	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&CreateEscalationReq{}),
+		CreateEscalationHandler)
+	data := []byte(`
+    {
+        "name": "游戏服务值班",
+        "levels": [
+            {"notifiers": ["testsms1"], "delay": 0},
+            {"notifiers": ["testsms2"], "delay": 900},
+            {"notifiers": ["testwebhook"], "delay": 1800}
+        ]
+    }
+    `)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	req.Header.Add("Content-Type", "json")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	ep := models.EscalationPolicy{}
+	assert.NoError(t, json.Unmarshal(body, &ep))
+	assert.Len(t, ep.Levels, 3)
+	escalationTestID = string(ep.ID)
+	fmt.Println(escalationTestID)
+}
+
+func TestFetchEscalation(t *testing.T) {
+	ctx := httputil.WithHTTPContext(nil)
+	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&FetchEscalationReq{}),
+		FetchEscalationHandler)
+
+	req := httptest.NewRequest("GET", "/", nil)
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println(string(body))
+}
+
+func TestDeleteEscalation(t *testing.T) {
+	ctx := httputil.WithHTTPContext(nil)
+	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&DeleteEscalationReq{}),
+		DeleteEscalationHandler)
+	data := []byte(`
+    {
+        "id": "` + escalationTestID + `"
+    }
+    `)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	req.Header.Add("Content-Type", "json")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	fmt.Println(string(body))
+}
@@ -9,22 +9,26 @@ import (
 
 // CreateGroupReq 创建group请求模型
 type CreateGroupReq struct {
-	Name      string   `json:"name"`
-	Endpoints []string `json:"endpoints"`
-	Excluded  []string `json:"excluded"`
-	Status    string   `json:"status,omitempty"`
+	Name          string                `json:"name"`
+	Endpoints     []string              `json:"endpoints"`
+	Excluded      []string              `json:"excluded"`
+	Status        string                `json:"status,omitempty"`
+	Depends       []string              `json:"depends,omitempty"`
+	DependTargets []models.TargetDepend `json:"depend_targets,omitempty"`
 }
 
-// UpdateGroupReq 修改group请求模型
+// UpdateGroupReq 修改group请求模型, Version是修改前读取的版本, 不为0时检查是否已经被修改
 type UpdateGroupReq struct {
 	CreateGroupReq
 
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Version int    `json:"version,omitempty"`
 }
 
-// DeleteGroupReq 删除请求
+// DeleteGroupReq 删除请求, 还在被引用时拒绝删除, Force时去掉引用之后删除
 type DeleteGroupReq struct {
-	ID string `json:"id" http:"id"`
+	ID    string `json:"id" http:"id"`
+	Force bool   `json:"force,omitempty" http:"force,omitempty"`
 }
 
 // FetchGroupReq 查询请求
@@ -50,16 +54,26 @@ func CreateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		middlewares.ErrorWrite(w, 200, 3, err)
 	}
 	model := models.Group{
-		ID:        models.NewSerialNumber(),
-		Name:      req.Name,
-		Endpoints: eps,
-		Excluded:  excluded,
-		Status:    models.GroupStatusEnable,
+		ID:            models.NewSerialNumber(),
+		Name:          req.Name,
+		Endpoints:     eps,
+		Excluded:      excluded,
+		Status:        models.GroupStatusEnable,
+		Depends:       req.Depends,
+		DependTargets: req.DependTargets,
 	}
 	if err := model.Validate(); err != nil {
 		middlewares.ErrorWrite(w, 200, 4, err)
 		return
 	}
+	if err := models.CheckRefs(ctx, &model); err != nil {
+		middlewares.ErrorWrite(w, 200, 5, err)
+		return
+	}
+	if err := models.CheckDepends(ctx, &model); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
 	if err := model.Save(ctx); err != nil {
 		middlewares.ErrorWrite(w, 200, 5, err)
 		return
@@ -93,8 +107,13 @@ func UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		middlewares.ErrorWrite(w, 200, 2, err)
 		return
 	}
+	if req.Version != 0 {
+		model.Version = req.Version
+	}
 	model.Name = req.Name
 	model.Status = models.GroupStatus(req.Status)
+	model.Depends = req.Depends
+	model.DependTargets = req.DependTargets
 	model.Endpoints, err = models.ParseEndpoints(req.Endpoints, true)
 	if err != nil {
 		middlewares.ErrorWrite(w, 200, 3, err)
@@ -109,10 +128,18 @@ func UpdateGroupHandler(w http.ResponseWriter, r *http.Request) {
 		middlewares.ErrorWrite(w, 200, 5, err)
 		return
 	}
-	if err := model.Save(ctx); err != nil {
+	if err := models.CheckRefs(ctx, model); err != nil {
 		middlewares.ErrorWrite(w, 200, 6, err)
 		return
 	}
+	if err := models.CheckDepends(ctx, model); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 6), err)
+		return
+	}
 	middlewares.ErrorWriteOK(w)
 }
 
@@ -129,8 +156,13 @@ func DeleteGroupHandler(w http.ResponseWriter, r *http.Request) {
 	model := &models.Group{
 		ID: models.SerialNumber(req.ID),
 	}
-	if err = model.Delete(ctx); err != nil {
-		middlewares.ErrorWrite(w, 200, 2, err)
+	if forceDelete(r, req.Force) {
+		err = model.ForceDelete(ctx)
+	} else {
+		err = model.Delete(ctx)
+	}
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, deleteErrno(err, 2), err)
 		return
 	}
 	middlewares.ErrorWriteOK(w)
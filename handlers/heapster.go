@@ -22,6 +22,44 @@ type CreateHeapsterReq struct {
 	AcceptCode []int         `json:"accept_code,omitempty"`
 	Host       string        `json:"host,omitempty"`
 	Location   string        `json:"location,omitempty"`
+
+	Rule          *models.HeapsterRule  `json:"rule,omitempty"`
+	Latency       *LatencyRuleReq       `json:"latency,omitempty"`
+	MinLocations  int                   `json:"min_locations,omitempty"`
+	Depends       []string              `json:"depends,omitempty"`
+	DependTargets []models.TargetDepend `json:"depend_targets,omitempty"`
+	Escalation    string                `json:"escalation,omitempty"`
+}
+
+// LatencyThresholdReq 延迟阈值, 单位毫秒
+type LatencyThresholdReq struct {
+	P50 time.Duration `json:"p50,omitempty"`
+	P95 time.Duration `json:"p95,omitempty"`
+	Max time.Duration `json:"max,omitempty"`
+}
+
+// LatencyRuleReq 延迟规则
+type LatencyRuleReq struct {
+	Yellow LatencyThresholdReq `json:"yellow"`
+	Red    LatencyThresholdReq `json:"red"`
+}
+
+// 转换成模型, 毫秒转换成时间间隔
+func (req *LatencyRuleReq) toModel() *models.LatencyRule {
+	if req == nil {
+		return nil
+	}
+	convert := func(t LatencyThresholdReq) models.LatencyThreshold {
+		return models.LatencyThreshold{
+			P50: t.P50 * time.Millisecond,
+			P95: t.P95 * time.Millisecond,
+			Max: t.Max * time.Millisecond,
+		}
+	}
+	return &models.LatencyRule{
+		Yellow: convert(req.Yellow),
+		Red:    convert(req.Red),
+	}
 }
 
 // MuteHeapsterReq 静音请求
@@ -30,14 +68,16 @@ type MuteHeapsterReq struct {
 	Mute bool   `json:"mute" http:"mute"`
 }
 
-// DeleteHeapsterReq 删除请求
+// DeleteHeapsterReq 删除请求, 还在被引用时拒绝删除, Force时去掉引用之后删除
 type DeleteHeapsterReq struct {
-	ID string `json:"id" http:"id"`
+	ID    string `json:"id" http:"id"`
+	Force bool   `json:"force,omitempty" http:"force,omitempty"`
 }
 
-// UpdateHeapsterReq 更新请求
+// UpdateHeapsterReq 更新请求, Revision是修改前读取的版本, 不为0时检查是否已经被修改
 type UpdateHeapsterReq struct {
-	ID string `json:"id" http:"id"`
+	ID       string `json:"id" http:"id"`
+	Revision int    `json:"revision,omitempty"`
 
 	CreateHeapsterReq
 }
@@ -75,8 +115,27 @@ func CreateHeapsterHandler(w http.ResponseWriter, r *http.Request) {
 		AcceptCode: req.AcceptCode,
 		Host:       req.Host,
 		Location:   req.Location,
+		Rule:       req.Rule,
+		Latency:    req.Latency.toModel(),
+
+		MinLocations:  req.MinLocations,
+		Depends:       req.Depends,
+		DependTargets: req.DependTargets,
+		Escalation:    req.Escalation,
 	}
 
+	if err := model.Validate(); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
+	if err := models.CheckRefs(ctx, model); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if err := models.CheckDepends(ctx, model); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
 	if err := model.Save(ctx); err != nil {
 		middlewares.ErrorWrite(w, 200, 2, err)
 		return
@@ -107,8 +166,13 @@ func DeleteHeapsterHandler(w http.ResponseWriter, r *http.Request) {
 	model := &models.Heapster{
 		ID: models.SerialNumber(req.ID),
 	}
-	if err = model.Delete(ctx); err != nil {
-		middlewares.ErrorWrite(w, 200, 2, err)
+	if forceDelete(r, req.Force) {
+		err = model.ForceDelete(ctx)
+	} else {
+		err = model.Delete(ctx)
+	}
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, deleteErrno(err, 2), err)
 		return
 	}
 	middlewares.ErrorWriteOK(w)
@@ -131,6 +195,9 @@ func UpdateHeapsterHandler(w http.ResponseWriter, r *http.Request) {
 		middlewares.ErrorWrite(w, 200, 2, err)
 		return
 	}
+	if req.Revision != 0 {
+		model.Revision = req.Revision
+	}
 	model.Name = req.Name
 	model.Type = models.CheckType(req.Type)
 	model.Port = req.Port
@@ -142,10 +209,28 @@ func UpdateHeapsterHandler(w http.ResponseWriter, r *http.Request) {
 	model.AcceptCode = req.AcceptCode
 	model.Host = req.Host
 	model.Location = req.Location
-	if err := model.Save(ctx); err != nil {
+	model.Rule = req.Rule
+	model.Latency = req.Latency.toModel()
+	model.MinLocations = req.MinLocations
+	model.Depends = req.Depends
+	model.DependTargets = req.DependTargets
+	model.Escalation = req.Escalation
+	if err := model.Validate(); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
+	if err := models.CheckRefs(ctx, model); err != nil {
 		middlewares.ErrorWrite(w, 200, 3, err)
 		return
 	}
+	if err := models.CheckDepends(ctx, model); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 3), err)
+		return
+	}
 	middlewares.ErrorWriteOK(w)
 }
 
@@ -213,10 +298,14 @@ func FetchHeapsterStatusHandler(w http.ResponseWriter, r *http.Request) {
 		model := &models.Heapster{
 			ID: models.SerialNumber(req.ID),
 		}
-		statusList = append(statusList, models.HeapsterStatusSet{
+		statusSet := models.HeapsterStatusSet{
 			ID:     model.ID,
 			Status: model.GetStatus(ctx),
-		})
+		}
+		if stat, err := model.GetStat(ctx); err == nil {
+			statusSet.Stat = stat
+		}
+		statusList = append(statusList, statusSet)
 	}
 
 	data, err := json.Marshal(statusList)
@@ -248,7 +337,7 @@ func MuteHeapsterHandler(w http.ResponseWriter, r *http.Request) {
 
 	model.Mute = req.Mute
 	if err := model.Save(ctx); err != nil {
-		middlewares.ErrorWrite(w, 200, 3, err)
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 3), err)
 		return
 	}
 	data, err := json.Marshal(model)
@@ -2,6 +2,7 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -18,7 +19,25 @@ import (
 
 var heapsterTestID string
 
+// saveTestRefs 创建时检查引用的对象是否存在, 先准备好
+func saveTestRefs(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	g := &models.Group{ID: "test_group", Name: "测试组"}
+	assert.NoError(t, g.Save(ctx))
+	for _, id := range []string{"test_notifiers", "testsms1", "testsms2", "testwebhook"} {
+		hn := &models.HeapsterNotifier{ID: models.SerialNumber(id), Type: "sms", Config: map[string]interface{}{
+			"type":     "unicom",
+			"sp":       "103905",
+			"username": "test",
+			"password": "test",
+			"targets":  []string{"13800000000"},
+		}}
+		assert.NoError(t, hn.Save(ctx))
+	}
+}
+
 func TestCreateHeapster(t *testing.T) {
+	saveTestRefs(t)
 	ctx := httputil.WithHTTPContext(nil)
 	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
 	handler := httputil.HandleFunc(ctx,
@@ -36,8 +55,7 @@ func TestCreateHeapster(t *testing.T) {
         "location": "/healthz",
         "timeout": 3,
         "interval": 5,
-        "healthy_threshold": 3,
-        "unhealthy_threshold": 3,
+        "threshold": 3,
         "groups": [
             "test_group"
         ],
@@ -58,6 +76,64 @@ func TestCreateHeapster(t *testing.T) {
 	fmt.Println(heapsterTestID)
 }
 
+func TestCreateHeapsterUnknownRefs(t *testing.T) {
+	ctx := httputil.WithHTTPContext(nil)
+	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&CreateHeapsterReq{}),
+		CreateHeapsterHandler)
+	data := []byte(`
+    {
+        "name": "sample_heapster",
+        "type": "tcp",
+        "port": 5050,
+        "timeout": 3,
+        "interval": 5,
+        "threshold": 3,
+        "groups": [
+            "test_group_not_exists"
+        ]
+    }
+    `)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	req.Header.Add("Content-Type", "json")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	apiErr := middlewares.APIReponseError{}
+	assert.NoError(t, json.Unmarshal(body, &apiErr))
+	assert.Equal(t, 2, apiErr.ErrorNo)
+}
+
+func TestCreateHeapsterInvalid(t *testing.T) {
+	ctx := httputil.WithHTTPContext(nil)
+	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&CreateHeapsterReq{}),
+		CreateHeapsterHandler)
+	data := []byte(`
+    {
+        "name": "sample_heapster",
+        "type": "tcp",
+        "port": 5050,
+        "timeout": 10,
+        "interval": 5,
+        "threshold": 3
+    }
+    `)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	req.Header.Add("Content-Type", "json")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	apiErr := ValidationErrorResp{}
+	assert.NoError(t, json.Unmarshal(body, &apiErr))
+	assert.Equal(t, ErrnoValidation, apiErr.ErrorNo)
+	assert.Equal(t, models.ValidationErrors{{Field: "timeout", Message: "must <= interval"}}, apiErr.Errors)
+}
+
 func TestUpdateHeapter(t *testing.T) {
 	ctx := httputil.WithHTTPContext(nil)
 	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
@@ -77,8 +153,7 @@ func TestUpdateHeapter(t *testing.T) {
         "location": "/healthz",
         "timeout": 3,
         "interval": 5,
-        "healthy_threshold": 3,
-        "unhealthy_threshold": 3,
+        "threshold": 3,
         "groups": [
             "test_group"
         ],
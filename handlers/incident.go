@@ -0,0 +1,94 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// FetchIncidentReq 查询事故历史请求
+type FetchIncidentReq struct {
+	HeapsterID string `json:"heapster" http:"heapster"`
+}
+
+// AckIncidentReq 确认事故请求
+type AckIncidentReq struct {
+	HeapsterID string `json:"heapster" http:"heapster"`
+	By         string `json:"by,omitempty" http:"by,omitempty"`
+}
+
+// IncidentResp 事故以及事故期间发出的通知
+type IncidentResp struct {
+	models.Incident
+	Notifications models.Notifications `json:"notifications"`
+}
+
+// FetchIncidentHandler 查询事故历史, 带上每个事故的通知送达状态
+func FetchIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchIncidentReq)
+
+	incs, err := models.FetchIncidents(ctx, models.SerialNumber(req.HeapsterID))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	ns, err := models.FetchNotifications(ctx, models.SerialNumber(req.HeapsterID))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	resps := make([]IncidentResp, 0, len(incs))
+	for _, inc := range incs {
+		resps = append(resps, IncidentResp{
+			Incident:      inc,
+			Notifications: ns.ByIncident(inc.ID),
+		})
+	}
+	data, err := json.Marshal(resps)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// AckIncidentHandler 确认当前事故, 停止升级通知
+func AckIncidentHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*AckIncidentReq)
+
+	inc, err := models.FetchCurrentIncident(ctx, models.SerialNumber(req.HeapsterID))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if inc == nil {
+		middlewares.ErrorWrite(w, 200, 3, fmt.Errorf("no incident"))
+		return
+	}
+	if err := inc.Acknowledge(ctx, req.By); err != nil {
+		middlewares.ErrorWrite(w, 200, 4, err)
+		return
+	}
+	data, err := json.Marshal(inc)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 5, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// FetchNotificationReq 查询通知历史请求, 可以按照事故过滤
+type FetchNotificationReq struct {
+	HeapsterID string `json:"heapster" http:"heapster"`
+	IncidentID string `json:"incident,omitempty" http:"incident,omitempty"`
+}
+
+// FetchNotificationHandler 查询通知历史以及送达状态
+func FetchNotificationHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchNotificationReq)
+
+	ns, err := models.FetchNotifications(ctx, models.SerialNumber(req.HeapsterID))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if req.IncidentID != "" {
+		ns = ns.ByIncident(models.SerialNumber(req.IncidentID))
+	}
+	data, err := json.Marshal(ns)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -5,6 +5,7 @@ import (
 	"net/http"
 	"zonst/qipai/gamehealthysrv/middlewares"
 	"zonst/qipai/gamehealthysrv/models"
+	"zonst/qipai/gamehealthysrv/notifiers"
 )
 
 // CreateNotifierReq 创建请求
@@ -14,14 +15,16 @@ type CreateNotifierReq struct {
 	Config map[string]interface{} `json:"Config"`
 }
 
-// DeleteNotifierReq 删除请求
+// DeleteNotifierReq 删除请求, 还在被引用时拒绝删除, Force时去掉引用之后删除
 type DeleteNotifierReq struct {
-	ID string `json:"id" http:"id"`
+	ID    string `json:"id" http:"id"`
+	Force bool   `json:"force,omitempty" http:"force,omitempty"`
 }
 
-// UpdateNotifierReq 更新请求
+// UpdateNotifierReq 更新请求, Version是修改前读取的版本, 不为0时检查是否已经被修改
 type UpdateNotifierReq struct {
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Version int    `json:"version,omitempty"`
 
 	CreateNotifierReq
 }
@@ -48,6 +51,14 @@ func CreateNotifierHandler(w http.ResponseWriter, r *http.Request) {
 		Config: req.Config,
 	}
 
+	if err := model.Validate(); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
+	if err := models.CheckRefs(ctx, model); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
 	if err := model.Save(ctx); err != nil {
 		middlewares.ErrorWrite(w, 200, 2, err)
 	}
@@ -76,8 +87,13 @@ func DeleteNotifierHandler(w http.ResponseWriter, r *http.Request) {
 	model := &models.HeapsterNotifier{
 		ID: models.SerialNumber(req.ID),
 	}
-	if err = model.Delete(ctx); err != nil {
-		middlewares.ErrorWrite(w, 200, 2, err)
+	if forceDelete(r, req.Force) {
+		err = model.ForceDelete(ctx)
+	} else {
+		err = model.Delete(ctx)
+	}
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, deleteErrno(err, 2), err)
 		return
 	}
 	middlewares.ErrorWriteOK(w)
@@ -100,13 +116,24 @@ func UpdateNotifierHandler(w http.ResponseWriter, r *http.Request) {
 		middlewares.ErrorWrite(w, 200, 2, err)
 		return
 	}
+	if req.Version != 0 {
+		model.Version = req.Version
+	}
 	model.Type = req.Type
 	model.Name = req.Name
 	model.Config = req.Config
-	if err := model.Save(ctx); err != nil {
+	if err := model.Validate(); err != nil {
+		validationErrorWrite(w, err)
+		return
+	}
+	if err := models.CheckRefs(ctx, model); err != nil {
 		middlewares.ErrorWrite(w, 200, 3, err)
 		return
 	}
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 3), err)
+		return
+	}
 	middlewares.ErrorWriteOK(w)
 }
 
@@ -147,3 +174,39 @@ func FetchNotifierHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	w.Write(data)
 }
+
+// TestNotifierReq 测试发送请求
+type TestNotifierReq struct {
+	ID string `json:"id"`
+}
+
+// TestNotifierHandler 使用示例报告测试发送, 用来验证配置是否正确
+func TestNotifierHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*TestNotifierReq)
+
+	model := &models.HeapsterNotifier{
+		ID: models.SerialNumber(req.ID),
+	}
+	if err := model.Fill(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	n, err := notifiers.TestNotifier(ctx, *model)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	data, err := json.Marshal(n)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 4, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
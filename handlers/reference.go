@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// ErrnoInUse 删除时还在被其他对象引用
+const ErrnoInUse = 12
+
+// deleteErrno 还在被引用使用统一的错误码, 其他错误使用各自的
+func deleteErrno(err error, errno int) int {
+	if _, ok := err.(*models.InUseError); ok {
+		return ErrnoInUse
+	}
+	return errno
+}
+
+// forceDelete 删除请求的force参数, json请求体里没有时也支持 ?force=true
+func forceDelete(r *http.Request, force bool) bool {
+	return force || r.URL.Query().Get("force") == "true"
+}
+
+// FetchUsageReq 查询使用情况请求, kind是heapster, group, notifier, escalation或者template
+type FetchUsageReq struct {
+	Kind string `json:"kind" http:"kind"`
+	ID   string `json:"id" http:"id"`
+}
+
+// FetchUsageHandler 查询引用了对象的其他对象
+func FetchUsageHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchUsageReq)
+
+	refs, err := models.FetchReferrers(ctx, req.Kind, models.SerialNumber(req.ID))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if refs == nil {
+		refs = []models.Ref{}
+	}
+	data, err := json.Marshal(refs)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -42,3 +42,41 @@ func FetchReportHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(200)
 	w.Write(data)
 }
+
+// FetchSLAReq 获取长期可用率请求, 默认最近30天
+type FetchSLAReq struct {
+	HeaspterID string `json:"heapster" http:"heapster"`
+	Days       int    `json:"days" http:"days"`
+}
+
+// FetchSLAHandler 根据小时统计获取每个目标的可用率
+func FetchSLAHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchSLAReq)
+	if req.Days <= 0 {
+		req.Days = 30
+	}
+
+	now := time.Now()
+	rps, err := models.FetchSLA(ctx, req.HeaspterID, now.AddDate(0, 0, -req.Days), now)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if len(rps) == 0 {
+		middlewares.ErrorWrite(w, 200, 3, fmt.Errorf("not found"))
+		return
+	}
+	data, err := json.Marshal(rps)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 4, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
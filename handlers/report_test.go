@@ -2,8 +2,10 @@ package handlers
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"net/http/httptest"
 	"os"
 	"testing"
@@ -54,3 +56,38 @@ func TestFetchReports(t *testing.T) {
 	body, _ := ioutil.ReadAll(resp.Body)
 	fmt.Println(string(body))
 }
+
+func TestFetchSLA(t *testing.T) {
+	var search string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		search = r.URL.Path + " " + string(body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"hits":{"total":0,"hits":[]},"aggregations":{"target":{"buckets":[
+			{"key":"10.0.0.1:80","doc_count":24,
+			"success":{"value":99},"faileds":{"value":1},"max_delay":{"value":90000000},"p95_delay":{"value":40000000},
+			"group":{"buckets":[{"key":"g1","doc_count":24}]}}]}}}`))
+	}))
+	defer server.Close()
+
+	ctx := httputil.WithHTTPContext(context.Background())
+	httputil.Use(ctx, middlewares.ElasticConnHandler([]string{server.URL}, "", ""))
+	httputil.Use(ctx, middlewares.LoggerHandler(5, os.Stdout))
+
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&FetchSLAReq{}),
+		FetchSLAHandler)
+
+	req := httptest.NewRequest("GET", "/?heapster=hp1&days=7", nil)
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	assert.Contains(t, search, "/gamehealthysla-*/hourly/_search")
+	assert.Contains(t, search, `"heapster":"hp1"`)
+	rps := models.SLAReports{}
+	assert.NoError(t, json.NewDecoder(resp.Body).Decode(&rps))
+	if assert.Len(t, rps, 1) {
+		assert.Equal(t, "10.0.0.1:80", rps[0].Target)
+		assert.Equal(t, 0.99, rps[0].Availability)
+	}
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// ErrnoConflict 保存时发现已经被其他人修改, 需要重新读取之后再修改
+const ErrnoConflict = 11
+
+// saveErrno 版本冲突和引用的对象已经被删除使用统一的错误码, 其他错误使用各自的
+func saveErrno(err error, errno int) int {
+	switch err {
+	case models.ErrConflict:
+		return ErrnoConflict
+	case models.ErrRefNotFound:
+		return ErrnoValidation
+	}
+	return errno
+}
+
+// FetchRevisionReq 查询历史版本请求, kind是heapster, group, notifier, escalation或者template
+type FetchRevisionReq struct {
+	Kind string `json:"kind" http:"kind"`
+	ID   string `json:"id" http:"id"`
+}
+
+// RollbackRevisionReq 回滚请求, Revision是要恢复的历史版本,
+// Version是当前版本(heapster是revision字段), 不为0时检查是否已经被修改
+type RollbackRevisionReq struct {
+	Kind     string `json:"kind"`
+	ID       string `json:"id"`
+	Revision int    `json:"revision"`
+	Version  int    `json:"version,omitempty"`
+}
+
+// RollbackRevisionResp 回滚结果
+type RollbackRevisionResp struct {
+	Version int `json:"version"`
+}
+
+// FetchRevisionHandler 查询历史版本, 新的在前
+func FetchRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchRevisionReq)
+
+	revisions, err := models.FetchRevisions(ctx, req.Kind, models.SerialNumber(req.ID))
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	data, err := json.Marshal(revisions)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// RollbackRevisionHandler 恢复到历史版本, 保存为新的版本
+func RollbackRevisionHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*RollbackRevisionReq)
+
+	version, err := models.Rollback(ctx, req.Kind, models.SerialNumber(req.ID), req.Revision, req.Version)
+	if _, ok := err.(models.ValidationErrors); ok {
+		validationErrorWrite(w, err)
+		return
+	} else if err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 2), err)
+		return
+	}
+	data, err := json.Marshal(RollbackRevisionResp{Version: version})
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
@@ -0,0 +1,204 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// CreateTemplateReq 创建请求
+type CreateTemplateReq struct {
+	Name string `json:"name"`
+	Text string `json:"text"`
+}
+
+// UpdateTemplateReq 更新请求, Version是修改前读取的版本, 不为0时检查是否已经被修改
+type UpdateTemplateReq struct {
+	ID      string `json:"id"`
+	Version int    `json:"version,omitempty"`
+
+	CreateTemplateReq
+}
+
+// DeleteTemplateReq 删除请求, 还在被引用时拒绝删除, Force时去掉引用之后删除
+type DeleteTemplateReq struct {
+	ID    string `json:"id" http:"id"`
+	Force bool   `json:"force,omitempty" http:"force,omitempty"`
+}
+
+// FetchTemplateReq 查询请求
+type FetchTemplateReq struct {
+	ID string `json:"id,omitempty" http:"id,omitempty"`
+}
+
+// PreviewTemplateReq 预览请求, 可以指定已保存的模版ID或者直接提交模版文本
+type PreviewTemplateReq struct {
+	ID   string `json:"id,omitempty"`
+	Text string `json:"text,omitempty"`
+}
+
+// PreviewTemplateResp 预览结果
+type PreviewTemplateResp struct {
+	Text string              `json:"text"`
+	Data models.TemplateData `json:"data"`
+}
+
+// CreateTemplateHandler 创建
+func CreateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*CreateTemplateReq)
+
+	model := &models.MessageTemplate{
+		ID:   models.NewSerialNumber(),
+		Name: req.Name,
+		Text: req.Text,
+	}
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if err := model.Fill(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	data, err := json.Marshal(model)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 4, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// UpdateTemplateHandler 更新
+func UpdateTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*UpdateTemplateReq)
+
+	model := &models.MessageTemplate{
+		ID: models.SerialNumber(req.ID),
+	}
+	if err := model.Fill(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, 2, err)
+		return
+	}
+	if req.Version != 0 {
+		model.Version = req.Version
+	}
+	model.Name = req.Name
+	model.Text = req.Text
+	if err := model.Save(ctx); err != nil {
+		middlewares.ErrorWrite(w, 200, saveErrno(err, 3), err)
+		return
+	}
+	middlewares.ErrorWriteOK(w)
+}
+
+// DeleteTemplateHandler 删除
+func DeleteTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*DeleteTemplateReq)
+
+	model := &models.MessageTemplate{
+		ID: models.SerialNumber(req.ID),
+	}
+	if forceDelete(r, req.Force) {
+		err = model.ForceDelete(ctx)
+	} else {
+		err = model.Delete(ctx)
+	}
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, deleteErrno(err, 2), err)
+		return
+	}
+	middlewares.ErrorWriteOK(w)
+}
+
+// FetchTemplateHandler 查询
+func FetchTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*FetchTemplateReq)
+
+	var mts models.MessageTemplates
+
+	if req.ID == "" {
+		mts, err = models.FetchMessageTemplates(ctx)
+		if err != nil {
+			middlewares.ErrorWrite(w, 200, 2, err)
+			return
+		}
+	} else {
+		mt := &models.MessageTemplate{
+			ID: models.SerialNumber(req.ID),
+		}
+		if err = mt.Fill(ctx); err != nil {
+			middlewares.ErrorWrite(w, 200, 2, err)
+			return
+		}
+		mts = models.MessageTemplates{*mt}
+	}
+	data, err := json.Marshal(mts)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
+
+// PreviewTemplateHandler 使用示例报告渲染模版
+func PreviewTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	ctx := r.Context()
+	body, err := middlewares.GetBindBody(ctx)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 1, err)
+		return
+	}
+	req := body.(*PreviewTemplateReq)
+
+	mt := &models.MessageTemplate{
+		ID:   models.SerialNumber(req.ID),
+		Text: req.Text,
+	}
+	if req.Text == "" {
+		if err = mt.Fill(ctx); err != nil {
+			middlewares.ErrorWrite(w, 200, 2, err)
+			return
+		}
+	}
+	resp := PreviewTemplateResp{
+		Data: models.SampleTemplateData(),
+	}
+	if resp.Text, err = mt.Render(resp.Data); err != nil {
+		middlewares.ErrorWrite(w, 200, 3, err)
+		return
+	}
+	data, err := json.Marshal(resp)
+	if err != nil {
+		middlewares.ErrorWrite(w, 200, 4, err)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(data)
+}
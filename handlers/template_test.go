@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+	"zonst/qipai-golang-libs/httputil"
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPreviewTemplate(t *testing.T) {
+	ctx := httputil.WithHTTPContext(nil)
+	httputil.Use(ctx, middlewares.RedisConnHandler("0.0.0.0:6379", "", 9))
+	handler := httputil.HandleFunc(ctx,
+		middlewares.BindBody(&PreviewTemplateReq{}),
+		PreviewTemplateHandler)
+	data := []byte(`
+    {
+        "text": "{{.Heapster.Name}}({{.Report.Target}}) {{statustext .Report.Status}}"
+    }
+    `)
+	req := httptest.NewRequest("POST", "/", bytes.NewReader(data))
+	req.Header.Add("Content-Type", "json")
+	resp := httptest.NewRecorder()
+	handler(resp, req)
+	assert.Equal(t, 200, resp.Code)
+	body, _ := ioutil.ReadAll(resp.Body)
+	preview := PreviewTemplateResp{}
+	assert.NoError(t, json.Unmarshal(body, &preview))
+	assert.Equal(t, "示例监控(10.0.10.46:10000) 严重", preview.Text)
+}
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// ErrnoValidation 参数校验失败, errors里是每个字段的错误;
+// 各接口自己的错误码是1到9, 多个接口共用的错误码从10开始
+const ErrnoValidation = 10
+
+// ValidationErrorResp 校验失败的返回, 在通用错误之外带上字段错误
+type ValidationErrorResp struct {
+	middlewares.APIReponseError
+	Errors models.ValidationErrors `json:"errors"`
+}
+
+// validationErrorWrite 写入校验错误, 不是 ValidationErrors 时没有字段错误
+func validationErrorWrite(w http.ResponseWriter, err error) {
+	errs, ok := err.(models.ValidationErrors)
+	if !ok {
+		middlewares.ErrorWrite(w, 200, ErrnoValidation, err)
+		return
+	}
+	raw, err := json.Marshal(ValidationErrorResp{
+		APIReponseError: middlewares.APIReponseError{
+			ErrorNo:  ErrnoValidation,
+			ErrorMsg: errs.Error(),
+		},
+		Errors: errs,
+	})
+	if err != nil {
+		w.WriteHeader(500)
+		return
+	}
+	w.WriteHeader(200)
+	w.Write(raw)
+}
@@ -0,0 +1,64 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// aliyunPercentEncode 阿里云签名要求的编码
+func aliyunPercentEncode(s string) string {
+	s = url.QueryEscape(s)
+	s = strings.Replace(s, "+", "%20", -1)
+	s = strings.Replace(s, "*", "%2A", -1)
+	s = strings.Replace(s, "%7E", "~", -1)
+	return s
+}
+
+// aliyunSign 计算RPC风格接口的签名
+func aliyunSign(method string, params url.Values, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, aliyunPercentEncode(k)+"="+aliyunPercentEncode(params.Get(k)))
+	}
+	stringToSign := method + "&" + aliyunPercentEncode("/") + "&" +
+		aliyunPercentEncode(strings.Join(pairs, "&"))
+	mac := hmac.New(sha1.New, []byte(secret+"&"))
+	mac.Write([]byte(stringToSign))
+	return base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// aliyunRPC 调用阿里云RPC风格的接口, 返回json内容
+func aliyunRPC(ctx context.Context, baseURL string, accessKeyID string, accessKeySecret string, params url.Values) ([]byte, error) {
+	params.Set("AccessKeyId", accessKeyID)
+	params.Set("Format", "JSON")
+	params.Set("SignatureMethod", "HMAC-SHA1")
+	params.Set("SignatureVersion", "1.0")
+	params.Set("SignatureNonce", fmt.Sprintf("%d", time.Now().UnixNano()))
+	params.Set("Timestamp", time.Now().UTC().Format("2006-01-02T15:04:05Z"))
+	params.Set("Signature", aliyunSign("GET", params, accessKeySecret))
+
+	req, err := http.NewRequest("GET", strings.TrimRight(baseURL, "/")+"/?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,23 @@
+package middlewares
+
+import (
+	"context"
+)
+
+type locationContextKey string
+
+// 上下文key
+const (
+	locationContextName locationContextKey = "_probe_location_"
+)
+
+// WithProbeLocation 设置探测点名称
+func WithProbeLocation(parent context.Context, location string) context.Context {
+	return context.WithValue(parent, locationContextName, location)
+}
+
+// GetProbeLocation 获取探测点名称, 没有设置返回空字符串
+func GetProbeLocation(ctx context.Context) string {
+	location, _ := ctx.Value(locationContextName).(string)
+	return location
+}
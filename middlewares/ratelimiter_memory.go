@@ -0,0 +1,89 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// memoryCounter 一个key在周期里的次数
+type memoryCounter struct {
+	count    int
+	expireAt time.Time
+}
+
+// 进程内的频率控制, 本地存储(bolt)单进程部署时使用, 不需要Redis
+type memoryRateLimiter struct {
+	mtx      sync.Mutex
+	counters map[string]*memoryCounter
+	now      func() time.Time
+}
+
+// NewMemoryRateLimiter 新建一个进程内的Ratelimiter, 和Redis一样按照key, 周期和次数计数
+func NewMemoryRateLimiter() RateLimiter {
+	return &memoryRateLimiter{
+		counters: make(map[string]*memoryCounter),
+		now:      time.Now,
+	}
+}
+
+// counter 获取key的计数, 过期的重新开始
+func (limiter *memoryRateLimiter) counter(key string, every time.Duration, times int, now time.Time) *memoryCounter {
+	limitKey := fmt.Sprintf("%s_%d_%d", key, every.Nanoseconds()/1000000, times)
+	c, ok := limiter.counters[limitKey]
+	if !ok || !now.Before(c.expireAt) {
+		c = &memoryCounter{expireAt: now.Add(every)}
+		limiter.counters[limitKey] = c
+	}
+	return c
+}
+
+// gc 删除过期的key
+func (limiter *memoryRateLimiter) gc(now time.Time) {
+	for k, c := range limiter.counters {
+		if !now.Before(c.expireAt) {
+			delete(limiter.counters, k)
+		}
+	}
+}
+
+func (limiter *memoryRateLimiter) TryAccept(keys []string, every time.Duration, times int) bool {
+	limiter.mtx.Lock()
+	defer limiter.mtx.Unlock()
+
+	now := limiter.now()
+	limiter.gc(now)
+	for _, k := range keys {
+		c := limiter.counter(k, every, times, now)
+		if c.count >= times {
+			// Forbidden
+			return true
+		}
+		c.count++
+	}
+	return false
+}
+
+func (limiter *memoryRateLimiter) Accept(keys []string, every time.Duration, times int) {
+	for _, k := range keys {
+		for {
+			limiter.mtx.Lock()
+			now := limiter.now()
+			c := limiter.counter(k, every, times, now)
+			if c.count < times {
+				c.count++
+				limiter.mtx.Unlock()
+				break
+			}
+			wait := c.expireAt.Sub(now)
+			limiter.mtx.Unlock()
+			time.Sleep(wait)
+		}
+	}
+}
+
+// WithMemoryRateLimiter 装箱进程内的Ratelimiter
+func WithMemoryRateLimiter(parent context.Context) context.Context {
+	return context.WithValue(parent, rateLimiterContextName, NewMemoryRateLimiter())
+}
@@ -58,3 +58,21 @@ func TestRateLimiterBare(t *testing.T) {
 	limiter.Accept([]string{"test_limiter"}, 1*time.Second, 3)
 	limiter.Accept([]string{"test_limiter"}, 1*time.Second, 3)
 }
+
+func TestMemoryRateLimiter(t *testing.T) {
+	now := time.Now()
+	limiter := NewMemoryRateLimiter().(*memoryRateLimiter)
+	limiter.now = func() time.Time { return now }
+
+	for i := 0; i < 3; i++ {
+		assert.False(t, limiter.TryAccept([]string{"13879156403"}, time.Second, 3))
+	}
+	assert.True(t, limiter.TryAccept([]string{"13879156403"}, time.Second, 3))
+	// 周期和次数不同的是不同的计数
+	assert.False(t, limiter.TryAccept([]string{"13879156403"}, time.Minute, 1))
+	assert.True(t, limiter.TryAccept([]string{"13879156403"}, time.Minute, 1))
+
+	now = now.Add(time.Second)
+	assert.False(t, limiter.TryAccept([]string{"13879156403"}, time.Second, 3))
+	assert.Len(t, limiter.counters, 2)
+}
@@ -41,13 +41,19 @@ func SMSHelper(name string, config interface{}) http.HandlerFunc {
 	}
 }
 
-// SMSReceipt 短信回执
+// SMSReceipt 短信回执, 各服务者的状态统一为0表示送达, 非0表示失败
 type SMSReceipt struct {
 	SerialNumber int64
 	Number       string
 	Status       int
 }
 
+// 统一的回执状态
+const (
+	SMSReceiptDelivered = 0
+	SMSReceiptFailed    = 1
+)
+
 // SMSResult 短信发送结果
 type SMSResult struct {
 	Result       int
@@ -102,11 +108,13 @@ func RegistSMSProvider(name string, creator interface{}) {
 	providerFactoryMap[name] = creator
 }
 
-// UnicomConfig 联通短信接口配置
+// UnicomConfig 联通短信接口配置, 接口地址为空时使用默认地址
 type UnicomConfig struct {
-	SPCode   string
-	Username string
-	Password string
+	SPCode     string
+	Username   string
+	Password   string
+	BaseURL    string
+	ReceiptURL string
 }
 
 // UnicomProvider 联通短信接口服务者
@@ -150,7 +158,7 @@ func (unicom *UnicomProvider) SendMessage(ctx context.Context, tpl string, numbe
 
 	req, err := http.NewRequest(
 		"POST",
-		"http://gd.ums86.com:8899/sms/Api/Send.do",
+		strings.TrimRight(unicom.config.BaseURL, "/")+"/sms/Api/Send.do",
 		bytes.NewReader([]byte(postData.Encode())))
 	if err != nil {
 		return
@@ -161,6 +169,7 @@ func (unicom *UnicomProvider) SendMessage(ctx context.Context, tpl string, numbe
 	if err != nil {
 		return
 	}
+	defer resp.Body.Close()
 
 	respData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
@@ -192,10 +201,11 @@ func (unicom *UnicomProvider) FetchReceipts() ([]SMSReceipt, error) {
 	postData.Add("SpCode", unicom.config.SPCode)
 	postData.Add("LoginName", unicom.config.Username)
 	postData.Add("Password", unicom.config.Password)
-	resp, err := http.PostForm("http://smsapi.ums86.com:8888/sms/Api/report.do", postData)
+	resp, err := http.PostForm(strings.TrimRight(unicom.config.ReceiptURL, "/")+"/sms/Api/report.do", postData)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 	respData, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
 		return nil, err
@@ -233,6 +243,12 @@ func (unicom *UnicomProvider) FetchReceipts() ([]SMSReceipt, error) {
 
 // 注册到工厂的方法
 func unicomCreator(config UnicomConfig) SMSProvider {
+	if config.BaseURL == "" {
+		config.BaseURL = "http://gd.ums86.com:8899"
+	}
+	if config.ReceiptURL == "" {
+		config.ReceiptURL = "http://smsapi.ums86.com:8888"
+	}
 	return &UnicomProvider{
 		config: config,
 	}
@@ -0,0 +1,194 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegistSMSProvider("aliyun", aliyunSMSCreator)
+}
+
+// AliyunSMSConfig 阿里云短信配置, 短信内容作为模版参数TemplateParam发送
+type AliyunSMSConfig struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	SignName        string
+	TemplateCode    string
+	TemplateParam   string
+	RegionID        string
+	BaseURL         string
+}
+
+// 阿里云短信发送状态
+const (
+	aliyunSendStatusWaiting = 1
+	aliyunSendStatusFailed  = 2
+	aliyunSendStatusSuccess = 3
+)
+
+// 发送记录保留时间, 超过之后不再查询回执
+const aliyunPendingExpire = 24 * time.Hour
+
+// aliyunPending 等待回执的发送记录
+type aliyunPending struct {
+	serialNumber int64
+	bizID        string
+	sendAt       time.Time
+	numbers      []string
+}
+
+// AliyunSMSProvider 阿里云短信服务者
+type AliyunSMSProvider struct {
+	config AliyunSMSConfig
+
+	mtx     sync.Mutex
+	pending []*aliyunPending
+}
+
+// aliyunSMSResponse 接口返回结构
+type aliyunSMSResponse struct {
+	Code              string `json:"Code"`
+	Message           string `json:"Message"`
+	BizID             string `json:"BizId"`
+	SmsSendDetailDTOs struct {
+		SmsSendDetailDTO []struct {
+			PhoneNum   string `json:"PhoneNum"`
+			SendStatus int    `json:"SendStatus"`
+			ErrCode    string `json:"ErrCode"`
+		} `json:"SmsSendDetailDTO"`
+	} `json:"SmsSendDetailDTOs"`
+}
+
+func (aliyun *AliyunSMSProvider) call(ctx context.Context, params url.Values) (*aliyunSMSResponse, error) {
+	params.Set("RegionId", aliyun.config.RegionID)
+	params.Set("Version", "2017-05-25")
+	data, err := aliyunRPC(ctx, aliyun.config.BaseURL,
+		aliyun.config.AccessKeyID, aliyun.config.AccessKeySecret, params)
+	if err != nil {
+		return nil, err
+	}
+	resp := &aliyunSMSResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != "OK" {
+		return resp, fmt.Errorf("aliyun sms %s %s", resp.Code, resp.Message)
+	}
+	return resp, nil
+}
+
+// SendMessage 实现接口
+func (aliyun *AliyunSMSProvider) SendMessage(ctx context.Context, tpl string, numbers []string) (result SMSResult) {
+	result.SerialNumber = time.Now().UnixNano()
+	templateParam, err := json.Marshal(map[string]string{
+		aliyun.config.TemplateParam: tpl,
+	})
+	if err != nil {
+		result.Result = -1
+		result.Desc = err.Error()
+		return
+	}
+	params := make(url.Values)
+	params.Set("Action", "SendSms")
+	params.Set("PhoneNumbers", strings.Join(numbers, ","))
+	params.Set("SignName", aliyun.config.SignName)
+	params.Set("TemplateCode", aliyun.config.TemplateCode)
+	params.Set("TemplateParam", string(templateParam))
+	params.Set("OutId", strconv.FormatInt(result.SerialNumber, 10))
+	resp, err := aliyun.call(ctx, params)
+	if err != nil {
+		result.Result = -1
+		result.Desc = err.Error()
+		return
+	}
+	aliyun.mtx.Lock()
+	aliyun.pending = append(aliyun.pending, &aliyunPending{
+		serialNumber: result.SerialNumber,
+		bizID:        resp.BizID,
+		sendAt:       time.Now(),
+		numbers:      numbers,
+	})
+	aliyun.mtx.Unlock()
+	return
+}
+
+// FetchReceipts 实现接口, 阿里云需要按照号码逐个查询发送详情
+func (aliyun *AliyunSMSProvider) FetchReceipts() ([]SMSReceipt, error) {
+	var (
+		receipts []SMSReceipt
+		lastErr  error
+		kept     []*aliyunPending
+	)
+	aliyun.mtx.Lock()
+	pending := aliyun.pending
+	aliyun.pending = nil
+	aliyun.mtx.Unlock()
+
+	for _, p := range pending {
+		var waiting []string
+		for _, number := range p.numbers {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			params := make(url.Values)
+			params.Set("Action", "QuerySendDetails")
+			params.Set("PhoneNumber", number)
+			params.Set("BizId", p.bizID)
+			params.Set("SendDate", p.sendAt.Format("20060102"))
+			params.Set("PageSize", "10")
+			params.Set("CurrentPage", "1")
+			resp, err := aliyun.call(ctx, params)
+			cancel()
+			if err != nil {
+				lastErr = err
+				waiting = append(waiting, number)
+				continue
+			}
+			status := aliyunSendStatusWaiting
+			for _, detail := range resp.SmsSendDetailDTOs.SmsSendDetailDTO {
+				status = detail.SendStatus
+			}
+			switch status {
+			case aliyunSendStatusSuccess:
+				receipts = append(receipts, SMSReceipt{SerialNumber: p.serialNumber, Number: number, Status: SMSReceiptDelivered})
+			case aliyunSendStatusFailed:
+				receipts = append(receipts, SMSReceipt{SerialNumber: p.serialNumber, Number: number, Status: SMSReceiptFailed})
+			default:
+				waiting = append(waiting, number)
+			}
+		}
+		if len(waiting) > 0 && time.Since(p.sendAt) < aliyunPendingExpire {
+			p.numbers = waiting
+			kept = append(kept, p)
+		}
+	}
+	aliyun.mtx.Lock()
+	aliyun.pending = append(aliyun.pending, kept...)
+	aliyun.mtx.Unlock()
+
+	if len(receipts) == 0 && lastErr != nil {
+		return nil, lastErr
+	}
+	return receipts, nil
+}
+
+// 注册到工厂的方法
+func aliyunSMSCreator(config AliyunSMSConfig) SMSProvider {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://dysmsapi.aliyuncs.com"
+	}
+	if config.RegionID == "" {
+		config.RegionID = "cn-hangzhou"
+	}
+	if config.TemplateParam == "" {
+		config.TemplateParam = "content"
+	}
+	return &AliyunSMSProvider{
+		config: config,
+	}
+}
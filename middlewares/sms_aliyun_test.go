@@ -0,0 +1,66 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAliyunSMS(t *testing.T) {
+	var templateParam map[string]string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		signature := params.Get("Signature")
+		params.Del("Signature")
+		if signature != aliyunSign("GET", params, "secret") {
+			fmt.Fprint(w, `{"Code":"SignatureDoesNotMatch","Message":"bad signature"}`)
+			return
+		}
+		switch params.Get("Action") {
+		case "SendSms":
+			json.Unmarshal([]byte(params.Get("TemplateParam")), &templateParam)
+			fmt.Fprint(w, `{"Code":"OK","BizId":"biz-1"}`)
+		case "QuerySendDetails":
+			status := 3
+			if params.Get("PhoneNumber") == "13800000002" {
+				status = 2
+			}
+			fmt.Fprintf(w, `{"Code":"OK","SmsSendDetailDTOs":{"SmsSendDetailDTO":[{"PhoneNum":"%s","SendStatus":%d}]}}`,
+				params.Get("PhoneNumber"), status)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := CreateSMSProvider("aliyun", AliyunSMSConfig{
+		AccessKeyID:     "key",
+		AccessKeySecret: "secret",
+		SignName:        "监控",
+		TemplateCode:    "SMS_1",
+		BaseURL:         srv.URL,
+	})
+	assert.NoError(t, err)
+	result := p.SendMessage(context.Background(), "测试内容", []string{"13800000001", "13800000002"})
+	assert.Equal(t, 0, result.Result, result.Desc)
+	assert.Equal(t, "测试内容", templateParam["content"])
+
+	receipts, err := p.FetchReceipts()
+	assert.NoError(t, err)
+	assert.Len(t, receipts, 2)
+	for _, receipt := range receipts {
+		assert.Equal(t, result.SerialNumber, receipt.SerialNumber)
+		if receipt.Number == "13800000001" {
+			assert.Equal(t, SMSReceiptDelivered, receipt.Status)
+		} else {
+			assert.Equal(t, SMSReceiptFailed, receipt.Status)
+		}
+	}
+	// 已经取到的回执不会重复查询
+	receipts, err = p.FetchReceipts()
+	assert.NoError(t, err)
+	assert.Len(t, receipts, 0)
+}
@@ -0,0 +1,258 @@
+package middlewares
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+func init() {
+	RegistSMSProvider("tencent", tencentSMSCreator)
+}
+
+// TencentSMSConfig 腾讯云短信配置, 短信内容作为模版的第一个参数发送
+type TencentSMSConfig struct {
+	SecretID   string
+	SecretKey  string
+	SDKAppID   string
+	SignName   string
+	TemplateID string
+	Region     string
+	BaseURL    string
+}
+
+const (
+	tencentSMSService = "sms"
+	tencentSMSVersion = "2021-01-11"
+)
+
+// tencentSerial 腾讯云流水号对应的发送序列号
+type tencentSerial struct {
+	serialNumber int64
+	sendAt       time.Time
+}
+
+// TencentSMSProvider 腾讯云短信服务者
+type TencentSMSProvider struct {
+	config TencentSMSConfig
+
+	mtx     sync.Mutex
+	serials map[string]tencentSerial
+}
+
+// tencentError 接口错误结构
+type tencentError struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+}
+
+// tencentSMSResponse 接口返回结构
+type tencentSMSResponse struct {
+	Response struct {
+		Error         *tencentError `json:"Error"`
+		SendStatusSet []struct {
+			SerialNo    string `json:"SerialNo"`
+			PhoneNumber string `json:"PhoneNumber"`
+			Code        string `json:"Code"`
+			Message     string `json:"Message"`
+		} `json:"SendStatusSet"`
+		PullSmsSendStatusSet []struct {
+			SerialNo         string `json:"SerialNo"`
+			SubscriberNumber string `json:"SubscriberNumber"`
+			ReportStatus     string `json:"ReportStatus"`
+			SessionContext   string `json:"SessionContext"`
+		} `json:"PullSmsSendStatusSet"`
+	} `json:"Response"`
+}
+
+func tencentHMAC(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+func tencentSHA256(msg []byte) string {
+	sum := sha256.Sum256(msg)
+	return hex.EncodeToString(sum[:])
+}
+
+// tencentAuthorization 计算TC3-HMAC-SHA256签名
+func tencentAuthorization(secretID, secretKey, host string, payload []byte, timestamp int64) string {
+	date := time.Unix(timestamp, 0).UTC().Format("2006-01-02")
+	canonicalRequest := strings.Join([]string{
+		"POST",
+		"/",
+		"",
+		"content-type:application/json\nhost:" + host + "\n",
+		"content-type;host",
+		tencentSHA256(payload),
+	}, "\n")
+	scope := date + "/" + tencentSMSService + "/tc3_request"
+	stringToSign := strings.Join([]string{
+		"TC3-HMAC-SHA256",
+		strconv.FormatInt(timestamp, 10),
+		scope,
+		tencentSHA256([]byte(canonicalRequest)),
+	}, "\n")
+	secretDate := tencentHMAC([]byte("TC3"+secretKey), date)
+	secretService := tencentHMAC(secretDate, tencentSMSService)
+	secretSigning := tencentHMAC(secretService, "tc3_request")
+	signature := hex.EncodeToString(tencentHMAC(secretSigning, stringToSign))
+	return fmt.Sprintf("TC3-HMAC-SHA256 Credential=%s/%s, SignedHeaders=content-type;host, Signature=%s",
+		secretID, scope, signature)
+}
+
+func (tencent *TencentSMSProvider) call(ctx context.Context, action string, body interface{}) (*tencentSMSResponse, error) {
+	payload, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(tencent.config.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	timestamp := time.Now().Unix()
+	req, err := http.NewRequest("POST", tencent.config.BaseURL, bytes.NewReader(payload))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Host", u.Host)
+	req.Header.Set("X-TC-Action", action)
+	req.Header.Set("X-TC-Version", tencentSMSVersion)
+	req.Header.Set("X-TC-Region", tencent.config.Region)
+	req.Header.Set("X-TC-Timestamp", strconv.FormatInt(timestamp, 10))
+	req.Header.Set("Authorization", tencentAuthorization(
+		tencent.config.SecretID, tencent.config.SecretKey, u.Host, payload, timestamp))
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	result := &tencentSMSResponse{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, err
+	}
+	if e := result.Response.Error; e != nil {
+		return result, fmt.Errorf("tencent sms %s %s", e.Code, e.Message)
+	}
+	return result, nil
+}
+
+// tencentPhoneNumber 腾讯云要求E.164格式, 没有国家码的默认中国大陆
+func tencentPhoneNumber(number string) string {
+	if strings.HasPrefix(number, "+") {
+		return number
+	}
+	return "+86" + number
+}
+
+// SendMessage 实现接口
+func (tencent *TencentSMSProvider) SendMessage(ctx context.Context, tpl string, numbers []string) (result SMSResult) {
+	result.SerialNumber = time.Now().UnixNano()
+	phones := make([]string, 0, len(numbers))
+	for _, n := range numbers {
+		phones = append(phones, tencentPhoneNumber(n))
+	}
+	resp, err := tencent.call(ctx, "SendSms", map[string]interface{}{
+		"PhoneNumberSet":   phones,
+		"SmsSdkAppId":      tencent.config.SDKAppID,
+		"SignName":         tencent.config.SignName,
+		"TemplateId":       tencent.config.TemplateID,
+		"TemplateParamSet": []string{tpl},
+		"SessionContext":   strconv.FormatInt(result.SerialNumber, 10),
+	})
+	if err != nil {
+		result.Result = -1
+		result.Desc = err.Error()
+		return
+	}
+	var faileds []string
+	tencent.mtx.Lock()
+	for _, status := range resp.Response.SendStatusSet {
+		if status.Code != "Ok" {
+			faileds = append(faileds, fmt.Sprintf("%s %s", status.PhoneNumber, status.Message))
+			continue
+		}
+		tencent.serials[status.SerialNo] = tencentSerial{
+			serialNumber: result.SerialNumber,
+			sendAt:       time.Now(),
+		}
+	}
+	tencent.mtx.Unlock()
+	if len(faileds) > 0 {
+		result.Result = -1
+		result.Desc = strings.Join(faileds, ";")
+	}
+	return
+}
+
+// FetchReceipts 实现接口
+func (tencent *TencentSMSProvider) FetchReceipts() ([]SMSReceipt, error) {
+	var (
+		receipts []SMSReceipt
+	)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	resp, err := tencent.call(ctx, "PullSmsSendStatus", map[string]interface{}{
+		"Limit":       100,
+		"SmsSdkAppId": tencent.config.SDKAppID,
+	})
+	if err != nil {
+		return nil, err
+	}
+	tencent.mtx.Lock()
+	defer tencent.mtx.Unlock()
+	for _, status := range resp.Response.PullSmsSendStatusSet {
+		receipt := SMSReceipt{
+			Number: status.SubscriberNumber,
+			Status: SMSReceiptFailed,
+		}
+		if serial, ok := tencent.serials[status.SerialNo]; ok {
+			receipt.SerialNumber = serial.serialNumber
+			delete(tencent.serials, status.SerialNo)
+		} else if sn, err := strconv.ParseInt(status.SessionContext, 10, 64); err == nil {
+			receipt.SerialNumber = sn
+		}
+		if status.ReportStatus == "SUCCESS" {
+			receipt.Status = SMSReceiptDelivered
+		}
+		receipts = append(receipts, receipt)
+	}
+	// 清理过期的流水号
+	for serialNo, serial := range tencent.serials {
+		if time.Since(serial.sendAt) > 24*time.Hour {
+			delete(tencent.serials, serialNo)
+		}
+	}
+	return receipts, nil
+}
+
+// 注册到工厂的方法
+func tencentSMSCreator(config TencentSMSConfig) SMSProvider {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://sms.tencentcloudapi.com"
+	}
+	if config.Region == "" {
+		config.Region = "ap-guangzhou"
+	}
+	return &TencentSMSProvider{
+		config:  config,
+		serials: make(map[string]tencentSerial),
+	}
+}
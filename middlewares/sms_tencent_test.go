@@ -0,0 +1,65 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTencentSMS(t *testing.T) {
+	var sendBody map[string]interface{}
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload, _ := ioutil.ReadAll(r.Body)
+		timestamp, _ := strconv.ParseInt(r.Header.Get("X-TC-Timestamp"), 10, 64)
+		if r.Header.Get("Authorization") != tencentAuthorization("id", "key", r.Host, payload, timestamp) {
+			fmt.Fprint(w, `{"Response":{"Error":{"Code":"AuthFailure","Message":"bad signature"}}}`)
+			return
+		}
+		switch r.Header.Get("X-TC-Action") {
+		case "SendSms":
+			json.Unmarshal(payload, &sendBody)
+			fmt.Fprint(w, `{"Response":{"SendStatusSet":[
+				{"SerialNo":"s1","PhoneNumber":"+8613800000001","Code":"Ok"},
+				{"SerialNo":"s2","PhoneNumber":"+8613800000002","Code":"Ok"}]}}`)
+		case "PullSmsSendStatus":
+			fmt.Fprint(w, `{"Response":{"PullSmsSendStatusSet":[
+				{"SerialNo":"s1","SubscriberNumber":"13800000001","ReportStatus":"SUCCESS"},
+				{"SerialNo":"s2","SubscriberNumber":"13800000002","ReportStatus":"FAIL"}]}}`)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := CreateSMSProvider("tencent", TencentSMSConfig{
+		SecretID:   "id",
+		SecretKey:  "key",
+		SDKAppID:   "1400000000",
+		SignName:   "监控",
+		TemplateID: "100",
+		BaseURL:    srv.URL,
+	})
+	assert.NoError(t, err)
+	result := p.SendMessage(context.Background(), "测试内容", []string{"13800000001", "+8613800000002"})
+	assert.Equal(t, 0, result.Result, result.Desc)
+	assert.Equal(t, []interface{}{"+8613800000001", "+8613800000002"}, sendBody["PhoneNumberSet"])
+	assert.Equal(t, []interface{}{"测试内容"}, sendBody["TemplateParamSet"])
+
+	receipts, err := p.FetchReceipts()
+	assert.NoError(t, err)
+	assert.Len(t, receipts, 2)
+	for _, receipt := range receipts {
+		assert.Equal(t, result.SerialNumber, receipt.SerialNumber)
+		if strings.HasSuffix(receipt.Number, "1") {
+			assert.Equal(t, SMSReceiptDelivered, receipt.Status)
+		} else {
+			assert.Equal(t, SMSReceiptFailed, receipt.Status)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package middlewares
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"zonst/qipai-golang-libs/httputil"
+)
+
+// VoiceCallStatus 语音呼叫状态
+type VoiceCallStatus int
+
+// 语音呼叫的三种状态
+const (
+	VoiceCallPending VoiceCallStatus = iota
+	VoiceCallAnswered
+	VoiceCallUnanswered
+)
+
+// VoiceProvider 语音通知服务提供者
+type VoiceProvider interface {
+	// 拨打号码并播放文本, 返回呼叫ID
+	Call(ctx context.Context, text string, number string) (string, error)
+	// 查询呼叫状态, callAt是拨打的时间, 服务商按照拨打的日期查询
+	CallStatus(ctx context.Context, callID string, callAt time.Time) (VoiceCallStatus, error)
+}
+
+var (
+	voiceFactoryMap = make(map[string]interface{})
+)
+
+// CreateVoiceProvider 创建语音通知提供者
+func CreateVoiceProvider(name string, config interface{}) (VoiceProvider, error) {
+	creater, ok := voiceFactoryMap[name]
+	if !ok {
+		return nil, fmt.Errorf("voice provider name %s not found", name)
+	}
+	inj := httputil.NewInjector()
+	inj.Map(config)
+	inj.Map(name)
+	v, err := inj.Invoke(creater)
+	if err != nil {
+		return nil, err
+	}
+	if err = httputil.CheckError(v); err != nil {
+		return nil, err
+	}
+	if len(v) == 0 {
+		return nil, fmt.Errorf("no provider created by name %s", name)
+	}
+	return v[0].Interface().(VoiceProvider), nil
+}
+
+// RegistVoiceProvider 注册
+func RegistVoiceProvider(name string, creator interface{}) {
+	if !httputil.IsFunction(creator) {
+		panic("creator must be a function")
+	}
+	voiceFactoryMap[name] = creator
+}
@@ -0,0 +1,130 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+func init() {
+	RegistVoiceProvider("aliyun", aliyunVoiceCreator)
+}
+
+// AliyunVoiceConfig 阿里云语音服务配置, 文本作为TTS模版参数发送
+type AliyunVoiceConfig struct {
+	AccessKeyID     string
+	AccessKeySecret string
+	ShowNumber      string
+	TTSCode         string
+	TTSParam        string
+	RegionID        string
+	BaseURL         string
+}
+
+// 语音通知产品ID, 查询呼叫详情时需要
+const aliyunVoiceProdID = "11000000300006"
+
+// 接通并正常结束的呼叫状态码
+const aliyunVoiceStateAnswered = "200000"
+
+// AliyunVoiceProvider 阿里云语音服务者
+type AliyunVoiceProvider struct {
+	config AliyunVoiceConfig
+}
+
+// aliyunVoiceResponse 接口返回结构
+type aliyunVoiceResponse struct {
+	Code    string `json:"Code"`
+	Message string `json:"Message"`
+	CallID  string `json:"CallId"`
+	Data    string `json:"Data"`
+}
+
+func (aliyun *AliyunVoiceProvider) call(ctx context.Context, params url.Values) (*aliyunVoiceResponse, error) {
+	params.Set("RegionId", aliyun.config.RegionID)
+	params.Set("Version", "2017-05-25")
+	data, err := aliyunRPC(ctx, aliyun.config.BaseURL,
+		aliyun.config.AccessKeyID, aliyun.config.AccessKeySecret, params)
+	if err != nil {
+		return nil, err
+	}
+	resp := &aliyunVoiceResponse{}
+	if err := json.Unmarshal(data, resp); err != nil {
+		return nil, err
+	}
+	if resp.Code != "OK" {
+		return resp, fmt.Errorf("aliyun vms %s %s", resp.Code, resp.Message)
+	}
+	return resp, nil
+}
+
+// Call 实现接口
+func (aliyun *AliyunVoiceProvider) Call(ctx context.Context, text string, number string) (string, error) {
+	ttsParam, err := json.Marshal(map[string]string{
+		aliyun.config.TTSParam: text,
+	})
+	if err != nil {
+		return "", err
+	}
+	params := make(url.Values)
+	params.Set("Action", "SingleCallByTts")
+	params.Set("CalledShowNumber", aliyun.config.ShowNumber)
+	params.Set("CalledNumber", number)
+	params.Set("TtsCode", aliyun.config.TTSCode)
+	params.Set("TtsParam", string(ttsParam))
+	resp, err := aliyun.call(ctx, params)
+	if err != nil {
+		return "", err
+	}
+	return resp.CallID, nil
+}
+
+// CallStatus 实现接口, 没有详情时表示呼叫还在进行
+func (aliyun *AliyunVoiceProvider) CallStatus(ctx context.Context, callID string, callAt time.Time) (VoiceCallStatus, error) {
+	params := make(url.Values)
+	params.Set("Action", "QueryCallDetailByCallId")
+	params.Set("CallId", callID)
+	params.Set("ProdId", aliyunVoiceProdID)
+	// 跨过零点的呼叫用查询时间会查不到
+	params.Set("QueryDate", strconv.FormatInt(callAt.UnixNano()/int64(time.Millisecond), 10))
+	resp, err := aliyun.call(ctx, params)
+	if err != nil {
+		return VoiceCallPending, err
+	}
+	if resp.Data == "" {
+		return VoiceCallPending, nil
+	}
+	detail := struct {
+		State string `json:"state"`
+	}{}
+	if err := json.Unmarshal([]byte(resp.Data), &detail); err != nil {
+		return VoiceCallPending, err
+	}
+	switch detail.State {
+	case "":
+		return VoiceCallPending, nil
+	case aliyunVoiceStateAnswered:
+		return VoiceCallAnswered, nil
+	default:
+		return VoiceCallUnanswered, nil
+	}
+}
+
+// 注册到工厂的方法
+func aliyunVoiceCreator(config AliyunVoiceConfig) VoiceProvider {
+	if config.BaseURL == "" {
+		config.BaseURL = "https://dyvmsapi.aliyuncs.com"
+	}
+	if config.RegionID == "" {
+		config.RegionID = "cn-hangzhou"
+	}
+	if config.TTSParam == "" {
+		config.TTSParam = "content"
+	}
+	return &AliyunVoiceProvider{
+		config: config,
+	}
+}
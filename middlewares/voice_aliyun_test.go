@@ -0,0 +1,87 @@
+package middlewares
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAliyunVoice(t *testing.T) {
+	var (
+		ttsParam  map[string]string
+		queryDate string
+		state     string
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		signature := params.Get("Signature")
+		params.Del("Signature")
+		if signature != aliyunSign("GET", params, "secret") {
+			fmt.Fprint(w, `{"Code":"SignatureDoesNotMatch","Message":"bad signature"}`)
+			return
+		}
+		switch params.Get("Action") {
+		case "SingleCallByTts":
+			if params.Get("CalledNumber") == "13800000009" {
+				fmt.Fprint(w, `{"Code":"isv.MOBILE_NUMBER_ILLEGAL","Message":"invalid number"}`)
+				return
+			}
+			assert.Equal(t, "4001234567", params.Get("CalledShowNumber"))
+			assert.Equal(t, "TTS_1", params.Get("TtsCode"))
+			json.Unmarshal([]byte(params.Get("TtsParam")), &ttsParam)
+			fmt.Fprint(w, `{"Code":"OK","CallId":"call-1"}`)
+		case "QueryCallDetailByCallId":
+			assert.Equal(t, "call-1", params.Get("CallId"))
+			assert.Equal(t, aliyunVoiceProdID, params.Get("ProdId"))
+			queryDate = params.Get("QueryDate")
+			if state == "" {
+				fmt.Fprint(w, `{"Code":"OK","Data":""}`)
+				return
+			}
+			fmt.Fprintf(w, `{"Code":"OK","Data":"{\"state\":\"%s\"}"}`, state)
+		}
+	}))
+	defer srv.Close()
+
+	p, err := CreateVoiceProvider("aliyun", AliyunVoiceConfig{
+		AccessKeyID:     "key",
+		AccessKeySecret: "secret",
+		ShowNumber:      "4001234567",
+		TTSCode:         "TTS_1",
+		BaseURL:         srv.URL,
+	})
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	callID, err := p.Call(ctx, "测试内容", "13800000001")
+	assert.NoError(t, err)
+	assert.Equal(t, "call-1", callID)
+	assert.Equal(t, "测试内容", ttsParam["content"])
+
+	// 服务者拒绝的号码返回错误
+	_, err = p.Call(ctx, "测试内容", "13800000009")
+	assert.EqualError(t, err, "aliyun vms isv.MOBILE_NUMBER_ILLEGAL invalid number")
+
+	// 没有详情时还在呼叫, 查询使用拨打时间
+	callAt := time.Date(2018, 6, 1, 23, 59, 0, 0, time.UTC)
+	status, err := p.CallStatus(ctx, callID, callAt)
+	assert.NoError(t, err)
+	assert.Equal(t, VoiceCallPending, status)
+	assert.Equal(t, fmt.Sprint(callAt.UnixNano()/int64(time.Millisecond)), queryDate)
+
+	state = aliyunVoiceStateAnswered
+	status, err = p.CallStatus(ctx, callID, callAt)
+	assert.NoError(t, err)
+	assert.Equal(t, VoiceCallAnswered, status)
+
+	state = "200005"
+	status, err = p.CallStatus(ctx, callID, callAt)
+	assert.NoError(t, err)
+	assert.Equal(t, VoiceCallUnanswered, status)
+}
@@ -0,0 +1,386 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Bundle 全部配置, 用于导出导入和从目录加载; 不包含版本和运行状态
+type Bundle struct {
+	Templates   MessageTemplates   `json:"templates,omitempty"`
+	Notifiers   HeapsterNotifiers  `json:"notifiers,omitempty"`
+	Escalations EscalationPolicies `json:"escalations,omitempty"`
+	Groups      Groups             `json:"groups,omitempty"`
+	Heapsters   Heapsters          `json:"heapsters,omitempty"`
+}
+
+// 导出格式
+const (
+	BundleFormatJSON = "json"
+	BundleFormatYAML = "yaml"
+)
+
+// BundleDiff 导入时和当前配置的差异, Ref带上名称
+type BundleDiff struct {
+	Created []Ref `json:"created"`
+	Updated []Ref `json:"updated"`
+	Deleted []Ref `json:"deleted"`
+	DryRun  bool  `json:"dry_run"`
+}
+
+// bundleEntity 配置里的一个实体, meta是去掉版本和运行状态之后的json
+type bundleEntity struct {
+	ref   Ref
+	model kindModel
+	meta  []byte
+}
+
+// DecodeBundle 解析json或者yaml格式的配置, json是yaml的子集, 统一按照yaml解析之后转换成json
+func DecodeBundle(data []byte) (*Bundle, error) {
+	var v interface{}
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(yamlToJSON(v))
+	if err != nil {
+		return nil, err
+	}
+	bundle := &Bundle{}
+	if err := json.Unmarshal(data, bundle); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// yamlToJSON yaml解析出来的map的key是interface{}, 转换成json可以编码的map
+func yamlToJSON(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			m[fmt.Sprint(key)] = yamlToJSON(value)
+		}
+		return m
+	case []interface{}:
+		for i, value := range v {
+			v[i] = yamlToJSON(value)
+		}
+	}
+	return v
+}
+
+// Encode 按照格式编码, yaml保持和json一样的字段顺序
+func (b *Bundle) Encode(format string) ([]byte, error) {
+	data, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "", BundleFormatJSON:
+		return data, nil
+	case BundleFormatYAML:
+		var v yaml.MapSlice
+		if err := yaml.Unmarshal(data, &v); err != nil {
+			return nil, err
+		}
+		return yaml.Marshal(v)
+	default:
+		return nil, fmt.Errorf("unknown format %s", format)
+	}
+}
+
+// Merge 合并另一个配置, 从目录加载多个文件时使用
+func (b *Bundle) Merge(other *Bundle) {
+	b.Templates = append(b.Templates, other.Templates...)
+	b.Notifiers = append(b.Notifiers, other.Notifiers...)
+	b.Escalations = append(b.Escalations, other.Escalations...)
+	b.Groups = append(b.Groups, other.Groups...)
+	b.Heapsters = append(b.Heapsters, other.Heapsters...)
+}
+
+// entities 按照被引用的在前的顺序列出实体, 检查ID, 重复和合法性
+func (b *Bundle) entities() ([]bundleEntity, error) {
+	var list []kindModel
+	for i := range b.Templates {
+		list = append(list, &b.Templates[i])
+	}
+	for i := range b.Notifiers {
+		list = append(list, &b.Notifiers[i])
+	}
+	for i := range b.Escalations {
+		list = append(list, &b.Escalations[i])
+	}
+	for i := range b.Groups {
+		list = append(list, &b.Groups[i])
+	}
+	for i := range b.Heapsters {
+		list = append(list, &b.Heapsters[i])
+	}
+
+	entities := make([]bundleEntity, 0, len(list))
+	seen := make(map[Ref]bool, len(list))
+	for _, model := range list {
+		ref := modelRef(model)
+		if err := model.Validate(); err != nil {
+			return nil, fmt.Errorf("%s %s invalid: %v", ref.Kind, ref.ID, err)
+		}
+		if seen[ref] {
+			return nil, fmt.Errorf("%s %s duplicated", ref.Kind, ref.ID)
+		}
+		seen[ref] = true
+		meta, err := bundleMeta(model)
+		if err != nil {
+			return nil, err
+		}
+		ref.Name = modelName(model)
+		entities = append(entities, bundleEntity{ref: ref, model: model, meta: meta})
+	}
+	// 不在配置里的实体会被删除, 引用的只能是配置里的
+	for _, e := range entities {
+		for _, ref := range e.model.refs() {
+			if !seen[ref] {
+				return nil, fmt.Errorf("%s %s references %s %s which is not in bundle", e.ref.Kind, e.ref.ID, ref.Kind, ref.ID)
+			}
+		}
+	}
+	dg := newDependGraph(b.Heapsters, b.Groups)
+	starts := make([]SerialNumber, 0, len(b.Heapsters))
+	for _, hst := range b.Heapsters {
+		starts = append(starts, hst.ID)
+	}
+	if err := dependCycleError(dg.findCycle(starts)); err != nil {
+		return nil, err
+	}
+	return entities, nil
+}
+
+// modelRef 模型的类型和ID
+func modelRef(model kindModel) Ref {
+	switch m := model.(type) {
+	case *Heapster:
+		return Ref{Kind: kindHeapster, ID: m.ID}
+	case *Group:
+		return Ref{Kind: kindGroup, ID: m.ID}
+	case *HeapsterNotifier:
+		return Ref{Kind: kindNotifier, ID: m.ID}
+	case *EscalationPolicy:
+		return Ref{Kind: kindEscalation, ID: m.ID}
+	case *MessageTemplate:
+		return Ref{Kind: kindTemplate, ID: m.ID}
+	}
+	return Ref{}
+}
+
+// modelName 模型的名称
+func modelName(model kindModel) string {
+	switch m := model.(type) {
+	case *Heapster:
+		return m.Name
+	case *Group:
+		return m.Name
+	case *HeapsterNotifier:
+		return m.Name
+	case *EscalationPolicy:
+		return m.Name
+	case *MessageTemplate:
+		return m.Name
+	}
+	return ""
+}
+
+// bundleMeta 去掉版本和运行状态之后编码, 用于比较配置是否修改
+func bundleMeta(model kindModel) ([]byte, error) {
+	switch m := model.(type) {
+	case *Heapster:
+		m.Version = 0
+		m.Revision = 0
+		m.Status = ""
+	case *Group:
+		m.Version = 0
+	case *HeapsterNotifier:
+		m.Version = 0
+	case *EscalationPolicy:
+		m.Version = 0
+	case *MessageTemplate:
+		m.Version = 0
+	}
+	return json.Marshal(model)
+}
+
+// RedactedSecret 导出时替换通知器的密钥, 导入时密钥是这个值的保留当前保存的密钥
+const RedactedSecret = "******"
+
+// notifierSecretKeys 通知器配置里的密码, 密钥和token
+var notifierSecretKeys = []string{"password", "access_key_secret", "secret", "secret_key", "token"}
+
+// redactSecrets 替换通知器配置里的密钥, 复制配置不修改缓存的对象
+func (hn *HeapsterNotifier) redactSecrets() {
+	config := make(map[string]interface{}, len(hn.Config))
+	for k, v := range hn.Config {
+		config[k] = v
+	}
+	for _, k := range notifierSecretKeys {
+		if v, ok := config[k].(string); ok && v != "" {
+			config[k] = RedactedSecret
+		}
+	}
+	hn.Config = config
+}
+
+// restoreSecrets 导入的通知器密钥是 RedactedSecret 时使用当前保存的密钥, 没有保存过返回错误
+func (b *Bundle) restoreSecrets(ctx context.Context) error {
+	for i := range b.Notifiers {
+		hn := &b.Notifiers[i]
+		var current *HeapsterNotifier
+		for _, k := range notifierSecretKeys {
+			if v, ok := hn.Config[k].(string); !ok || v != RedactedSecret {
+				continue
+			}
+			if current == nil {
+				current = &HeapsterNotifier{ID: hn.ID}
+				if err := current.Fill(ctx); err != nil {
+					return fmt.Errorf("notifier %s %s is redacted and can't be restored: %v", hn.ID, k, err)
+				}
+			}
+			v, ok := current.Config[k].(string)
+			if !ok || v == "" {
+				return fmt.Errorf("notifier %s %s is redacted and can't be restored", hn.ID, k)
+			}
+			hn.Config[k] = v
+		}
+	}
+	return nil
+}
+
+// ExportBundle 导出全部配置, 按照ID排序, 通知器的密钥替换为 RedactedSecret
+func ExportBundle(ctx context.Context) (*Bundle, error) {
+	bundle := &Bundle{}
+	var err error
+	if bundle.Templates, err = FetchMessageTemplates(ctx); err != nil {
+		return nil, err
+	}
+	if bundle.Notifiers, err = FetchHeapsterNotifiers(ctx); err != nil {
+		return nil, err
+	}
+	for i := range bundle.Notifiers {
+		bundle.Notifiers[i].redactSecrets()
+	}
+	if bundle.Escalations, err = FetchEscalationPolicies(ctx); err != nil {
+		return nil, err
+	}
+	if bundle.Groups, err = FetchGroups(ctx); err != nil {
+		return nil, err
+	}
+	hset, err := FetchHeapsters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, hst := range hset {
+		bundle.Heapsters = append(bundle.Heapsters, hst)
+	}
+	sort.Slice(bundle.Templates, func(i, j int) bool { return bundle.Templates[i].ID < bundle.Templates[j].ID })
+	sort.Slice(bundle.Notifiers, func(i, j int) bool { return bundle.Notifiers[i].ID < bundle.Notifiers[j].ID })
+	sort.Slice(bundle.Escalations, func(i, j int) bool { return bundle.Escalations[i].ID < bundle.Escalations[j].ID })
+	sort.Slice(bundle.Groups, func(i, j int) bool { return bundle.Groups[i].ID < bundle.Groups[j].ID })
+	sort.Slice(bundle.Heapsters, func(i, j int) bool { return bundle.Heapsters[i].ID < bundle.Heapsters[j].ID })
+	// 清除版本和运行状态
+	if _, err := bundle.entities(); err != nil {
+		return nil, err
+	}
+	return bundle, nil
+}
+
+// ImportBundle 计算配置和存储的差异, 不是dryRun时原子地修改, 配置里没有的实体删除;
+// 计算差异之后存储被修改过返回 ErrConflict
+func ImportBundle(ctx context.Context, bundle *Bundle, dryRun bool) (*BundleDiff, error) {
+	if err := bundle.restoreSecrets(ctx); err != nil {
+		return nil, err
+	}
+	entities, err := bundle.entities()
+	if err != nil {
+		return nil, err
+	}
+	store := GetStore(ctx)
+	diff := &BundleDiff{
+		Created: []Ref{},
+		Updated: []Ref{},
+		Deleted: []Ref{},
+		DryRun:  dryRun,
+	}
+	var ops []StoreOp
+	current := make(map[Ref]StoreEntity)
+	for _, kind := range storeKinds {
+		list, err := store.List(ctx, kind)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range list {
+			current[Ref{Kind: kind, ID: e.ID}] = e
+		}
+	}
+
+	for _, e := range entities {
+		key := Ref{Kind: e.ref.Kind, ID: e.ref.ID}
+		old, ok := current[key]
+		delete(current, key)
+		if ok {
+			model, err := newKindModel(key.Kind)
+			if err != nil {
+				return nil, err
+			}
+			if err := json.Unmarshal(old.Meta, model); err != nil {
+				return nil, err
+			}
+			meta, err := bundleMeta(model)
+			if err != nil {
+				return nil, err
+			}
+			if bytes.Equal(meta, e.meta) {
+				continue
+			}
+			diff.Updated = append(diff.Updated, e.ref)
+		} else {
+			diff.Created = append(diff.Created, e.ref)
+		}
+		ops = append(ops, StoreOp{
+			Kind:    key.Kind,
+			ID:      key.ID,
+			Meta:    e.meta,
+			Version: old.Version,
+			Refs:    e.model.refs(),
+		})
+	}
+
+	for key, old := range current {
+		ref := key
+		var meta struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(old.Meta, &meta) == nil {
+			ref.Name = meta.Name
+		}
+		diff.Deleted = append(diff.Deleted, ref)
+		ops = append(ops, StoreOp{
+			Kind:    key.Kind,
+			ID:      key.ID,
+			Version: old.Version,
+			Delete:  true,
+		})
+	}
+	sort.Slice(diff.Deleted, func(i, j int) bool {
+		return diff.Deleted[i].String() < diff.Deleted[j].String()
+	})
+
+	if dryRun || len(ops) == 0 {
+		return diff, nil
+	}
+	if err := store.Apply(ctx, ops); err != nil {
+		return nil, err
+	}
+	return diff, nil
+}
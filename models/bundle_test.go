@@ -0,0 +1,147 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testBundleYAML = `
+notifiers:
+- id: testbundlenotifier
+  name: 值班短信
+  type: sms
+  Config:
+    type: unicom
+    sp: "103905"
+    username: test
+    password: test
+    targets: ["13800000000"]
+groups:
+- id: testbundlegroup
+  name: 导入测试组
+  endpoints: ["127.0.0.1"]
+heapsters:
+- id: testbundleheapster
+  name: 导入测试
+  type: tcp
+  port: 8080
+  timeout: 1000000000
+  interval: 5000000000
+  threshold: 3
+  groups: [testbundlegroup]
+  notifiers: [testbundlenotifier]
+`
+
+// testBundle 两种存储的导入导出行为一致
+func testBundle(t *testing.T, ctx context.Context) {
+	bundle, err := DecodeBundle([]byte(testBundleYAML))
+	assert.NoError(t, err)
+	assert.Len(t, bundle.Heapsters, 1)
+	assert.Equal(t, []interface{}{"13800000000"}, bundle.Notifiers[0].Config["targets"])
+
+	// 清理之前的数据, 导入空配置删除全部
+	_, err = ImportBundle(ctx, &Bundle{}, false)
+	assert.NoError(t, err)
+	defer ImportBundle(ctx, &Bundle{}, false)
+
+	// dry run 不修改
+	diff, err := ImportBundle(ctx, bundle, true)
+	assert.NoError(t, err)
+	assert.True(t, diff.DryRun)
+	assert.Len(t, diff.Created, 3)
+	hset, err := FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, hset, 0)
+
+	diff, err = ImportBundle(ctx, bundle, false)
+	assert.NoError(t, err)
+	assert.Len(t, diff.Created, 3)
+	hst := &Heapster{ID: "testbundleheapster"}
+	assert.NoError(t, hst.Fill(ctx))
+	assert.Equal(t, "导入测试", hst.Name)
+	refs, err := FetchReferrers(ctx, kindGroup, "testbundlegroup")
+	assert.NoError(t, err)
+	assert.Equal(t, []Ref{{Kind: kindHeapster, ID: hst.ID, Name: hst.Name}}, refs)
+
+	// 导出之后再导入没有修改
+	exported, err := ExportBundle(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, exported.Heapsters[0].Revision)
+	for _, format := range []string{BundleFormatJSON, BundleFormatYAML} {
+		data, err := exported.Encode(format)
+		assert.NoError(t, err)
+		decoded, err := DecodeBundle(data)
+		assert.NoError(t, err)
+		diff, err = ImportBundle(ctx, decoded, false)
+		assert.NoError(t, err)
+		assert.Len(t, diff.Created, 0)
+		assert.Len(t, diff.Updated, 0)
+		assert.Len(t, diff.Deleted, 0)
+	}
+
+	// 导出的密钥是替换过的, 导入时保留保存的密钥; 新的通知器不能使用替换的密钥
+	assert.Equal(t, RedactedSecret, exported.Notifiers[0].Config["password"])
+	hn := &HeapsterNotifier{ID: "testbundlenotifier"}
+	assert.NoError(t, hn.Fill(ctx))
+	assert.Equal(t, "test", hn.Config["password"])
+	redacted := *exported
+	redacted.Notifiers = append(HeapsterNotifiers{}, exported.Notifiers...)
+	redacted.Notifiers[0].ID = "testbundlenotifier2"
+	redacted.Notifiers[0].Config = map[string]interface{}{"password": RedactedSecret}
+	_, err = ImportBundle(ctx, &redacted, true)
+	assert.Error(t, err)
+
+	// 修改和删除
+	exported.Heapsters[0].Name = "修改"
+	exported.Heapsters[0].Notifiers = nil
+	exported.Notifiers = nil
+	diff, err = ImportBundle(ctx, exported, false)
+	assert.NoError(t, err)
+	assert.Equal(t, []Ref{{Kind: kindHeapster, ID: hst.ID, Name: "修改"}}, diff.Updated)
+	assert.Equal(t, []Ref{{Kind: kindNotifier, ID: "testbundlenotifier", Name: "值班短信"}}, diff.Deleted)
+	filled := &Heapster{ID: hst.ID}
+	assert.NoError(t, filled.Fill(ctx))
+	assert.Equal(t, hst.Revision+1, filled.Revision)
+	assert.Len(t, filled.Notifiers, 0)
+
+	// 引用了配置里没有的对象, 不做任何修改
+	exported.Heapsters[0].Name = "不会保存"
+	exported.Heapsters[0].Escalation = "unknown"
+	_, err = ImportBundle(ctx, exported, false)
+	assert.Error(t, err)
+	assert.NoError(t, filled.Fill(ctx))
+	assert.Equal(t, "修改", filled.Name)
+}
+
+func TestBoltStoreBundle(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+	testBundle(t, ctx)
+}
+
+func TestRedisStoreBundle(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	testBundle(t, ctx)
+}
+
+func TestBoltStoreApplyConflict(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+	store := GetStore(ctx)
+
+	g := &Group{ID: "testapplygroup", Name: "事务测试"}
+	assert.NoError(t, g.Save(ctx))
+	// 第二个操作版本不一致, 第一个也不执行
+	err := store.Apply(ctx, []StoreOp{
+		{Kind: kindTemplate, ID: "testapplytemplate", Meta: []byte(`{"id":"testapplytemplate"}`)},
+		{Kind: kindGroup, ID: g.ID, Version: g.Version + 1, Delete: true},
+	})
+	assert.Equal(t, ErrConflict, err)
+	_, err = store.Get(ctx, kindTemplate, "testapplytemplate")
+	assert.Equal(t, ErrNotFound, err)
+	assert.NoError(t, g.Fill(ctx))
+}
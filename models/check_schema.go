@@ -0,0 +1,58 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CheckSchema 检查类型的配置校验, 只校验和类型相关的字段, 通用字段在 Heapster.Validate 里校验
+type CheckSchema func(hst *Heapster) ValidationErrors
+
+// checkSchemas 支持的检查类型和对应的校验, 检查类型只在这里定义, 不在这里的类型不能保存
+var checkSchemas = map[CheckType]CheckSchema{
+	CheckTypeHTTP: validateHTTPCheck,
+	CheckTypeTCP:  validateTCPCheck,
+}
+
+// CheckTypes 支持的检查类型, 按名称排序
+func CheckTypes() []CheckType {
+	types := make([]CheckType, 0, len(checkSchemas))
+	for typ := range checkSchemas {
+		types = append(types, typ)
+	}
+	sort.Slice(types, func(i, j int) bool { return types[i] < types[j] })
+	return types
+}
+
+// validateHTTPCheck http检查可以配置接受的状态码, Host头和请求路径
+func validateHTTPCheck(hst *Heapster) ValidationErrors {
+	var errs ValidationErrors
+	for i, code := range hst.AcceptCode {
+		if code < 100 || code > 599 {
+			errs.Add(fmt.Sprintf("accept_code[%d]", i), "must be http status code")
+		}
+	}
+	if strings.ContainsAny(hst.Host, "/ ") {
+		errs.Add("host", "must be host name without scheme and path")
+	}
+	if hst.Location != "" && !strings.HasPrefix(hst.Location, "/") {
+		errs.Add("location", "must start with /")
+	}
+	return errs
+}
+
+// validateTCPCheck tcp检查只连接端口, 不支持http的配置
+func validateTCPCheck(hst *Heapster) ValidationErrors {
+	var errs ValidationErrors
+	if len(hst.AcceptCode) > 0 {
+		errs.Add("accept_code", "not supported by tcp check")
+	}
+	if hst.Host != "" {
+		errs.Add("host", "not supported by tcp check")
+	}
+	if hst.Location != "" {
+		errs.Add("location", "not supported by tcp check")
+	}
+	return errs
+}
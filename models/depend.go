@@ -0,0 +1,310 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// TargetDepend 依赖其他heapster的一个目标, 只有这个目标红色时抑制
+type TargetDepend struct {
+	Heapster string `json:"heapster"`
+	Target   string `json:"target"`
+}
+
+// validateDependTargets 校验目标依赖, self不为空时不能依赖自己
+func validateDependTargets(self SerialNumber, depends []TargetDepend) ValidationErrors {
+	var errs ValidationErrors
+	for i, d := range depends {
+		field := fmt.Sprintf("depend_targets[%d]", i)
+		if d.Heapster == "" {
+			errs.Add(field+".heapster", "required")
+		} else if self != "" && d.Heapster == string(self) {
+			errs.Add(field+".heapster", "heapster can't depend on itself")
+		}
+		if d.Target == "" {
+			errs.Add(field+".target", "required")
+		}
+	}
+	return errs
+}
+
+// dependTargetIDs 目标依赖引用的heapster
+func dependTargetIDs(depends []TargetDepend) []string {
+	ids := make([]string, 0, len(depends))
+	for _, d := range depends {
+		ids = append(ids, d.Heapster)
+	}
+	return ids
+}
+
+// removeDependTargets 去掉依赖heapster的目标
+func removeDependTargets(depends []TargetDepend, id SerialNumber) []TargetDepend {
+	var ret []TargetDepend
+	for _, d := range depends {
+		if d.Heapster != string(id) {
+			ret = append(ret, d)
+		}
+	}
+	return ret
+}
+
+// dependGraph 依赖关系图, 边是heapster依赖的heapster, 包括所在组声明的和目标依赖
+type dependGraph struct {
+	heapsters map[SerialNumber]*Heapster
+	groups    map[SerialNumber]*Group
+}
+
+func newDependGraph(hs []Heapster, gs []Group) *dependGraph {
+	dg := &dependGraph{
+		heapsters: make(map[SerialNumber]*Heapster, len(hs)),
+		groups:    make(map[SerialNumber]*Group, len(gs)),
+	}
+	for i := range hs {
+		dg.heapsters[hs[i].ID] = &hs[i]
+	}
+	for i := range gs {
+		dg.groups[gs[i].ID] = &gs[i]
+	}
+	return dg
+}
+
+// edges heapster依赖的全部heapster
+func (dg *dependGraph) edges(id SerialNumber) []string {
+	hst, ok := dg.heapsters[id]
+	if !ok {
+		return nil
+	}
+	ids := append(append([]string{}, hst.Depends...), dependTargetIDs(hst.DependTargets)...)
+	for _, gid := range hst.Groups {
+		if g, ok := dg.groups[SerialNumber(gid)]; ok {
+			ids = append(ids, g.Depends...)
+			ids = append(ids, dependTargetIDs(g.DependTargets)...)
+		}
+	}
+	return ids
+}
+
+// findCycle 从starts开始查找依赖环, 返回环上的heapster, 没有返回nil
+func (dg *dependGraph) findCycle(starts []SerialNumber) []string {
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[SerialNumber]int)
+	var (
+		path  []string
+		cycle []string
+		visit func(id SerialNumber) bool
+	)
+	visit = func(id SerialNumber) bool {
+		switch state[id] {
+		case visiting:
+			for i, p := range path {
+				if p == string(id) {
+					cycle = append(append([]string{}, path[i:]...), string(id))
+					break
+				}
+			}
+			return true
+		case done:
+			return false
+		}
+		state[id] = visiting
+		path = append(path, string(id))
+		for _, next := range dg.edges(id) {
+			// 自己依赖自己由Validate检查, 组的依赖里包括组内的heapster自己时忽略
+			if next == string(id) {
+				continue
+			}
+			if visit(SerialNumber(next)) {
+				return true
+			}
+		}
+		path = path[:len(path)-1]
+		state[id] = done
+		return false
+	}
+	for _, id := range starts {
+		if visit(id) {
+			return cycle
+		}
+	}
+	return nil
+}
+
+// CheckDepends 检查保存之后依赖关系没有环, 互相依赖的heapster都是红色时会一直互相抑制;
+// model是将要保存的 *Heapster 或者 *Group
+func CheckDepends(ctx context.Context, model interface{}) error {
+	hset, err := FetchHeapsters(ctx)
+	if err != nil {
+		return err
+	}
+	gs, err := FetchGroups(ctx)
+	if err != nil {
+		return err
+	}
+	hs := make([]Heapster, 0, len(hset)+1)
+	for _, hst := range hset {
+		hs = append(hs, hst)
+	}
+	dg := newDependGraph(hs, gs)
+	var starts []SerialNumber
+	switch m := model.(type) {
+	case *Heapster:
+		dg.heapsters[m.ID] = m
+		starts = append(starts, m.ID)
+	case *Group:
+		dg.groups[m.ID] = m
+		for id, hst := range dg.heapsters {
+			for _, gid := range hst.Groups {
+				if gid == string(m.ID) {
+					starts = append(starts, id)
+					break
+				}
+			}
+		}
+	}
+	return dependCycleError(dg.findCycle(starts))
+}
+
+// dependCycleError 依赖环的校验错误
+func dependCycleError(cycle []string) error {
+	if len(cycle) == 0 {
+		return nil
+	}
+	var errs ValidationErrors
+	errs.Add("depends", "dependency cycle %s", strings.Join(cycle, " -> "))
+	return errs
+}
+
+// GetDepends 获取依赖的heapster列表, 包括所在组声明的依赖
+func (hst *Heapster) GetDepends(ctx context.Context) []string {
+	var (
+		depends []string
+		seen    = make(map[string]bool)
+	)
+	add := func(ids []string) {
+		for _, id := range ids {
+			if id == string(hst.ID) || seen[id] {
+				continue
+			}
+			seen[id] = true
+			depends = append(depends, id)
+		}
+	}
+	add(hst.Depends)
+	if gs, err := hst.GetApplyGroups(ctx); err == nil {
+		for _, g := range gs {
+			add(g.Depends)
+		}
+	}
+	return depends
+}
+
+// GetDependTargets 获取依赖的目标列表, 包括所在组声明的依赖
+func (hst *Heapster) GetDependTargets(ctx context.Context) []TargetDepend {
+	var (
+		depends []TargetDepend
+		seen    = make(map[TargetDepend]bool)
+	)
+	add := func(ds []TargetDepend) {
+		for _, d := range ds {
+			if d.Heapster == string(hst.ID) || seen[d] {
+				continue
+			}
+			seen[d] = true
+			depends = append(depends, d)
+		}
+	}
+	add(hst.DependTargets)
+	if gs, err := hst.GetApplyGroups(ctx); err == nil {
+		for _, g := range gs {
+			add(g.DependTargets)
+		}
+	}
+	return depends
+}
+
+// isRedTarget 依赖的目标在最近一次统计里是红色
+func isRedTarget(ctx context.Context, d TargetDepend) bool {
+	parent := &Heapster{ID: SerialNumber(d.Heapster)}
+	stat, err := parent.GetStat(ctx)
+	if err != nil {
+		return false
+	}
+	for _, target := range stat.RedTargets {
+		if target == d.Target {
+			return true
+		}
+	}
+	return false
+}
+
+// GetRedDepend 返回第一个红色状态的依赖, 依赖单个目标时只看这个目标, 没有返回空
+func (hst *Heapster) GetRedDepend(ctx context.Context) SerialNumber {
+	for _, id := range hst.GetDepends(ctx) {
+		parent := &Heapster{
+			ID: SerialNumber(id),
+		}
+		if parent.GetStatus(ctx) == HealthyStatusRed {
+			return parent.ID
+		}
+	}
+	for _, d := range hst.GetDependTargets(ctx) {
+		if isRedTarget(ctx, d) {
+			return SerialNumber(d.Heapster)
+		}
+	}
+	return ""
+}
+
+// dependsOn 是否依赖parent, redTargets不为nil时目标依赖只算红色的目标
+func (hst *Heapster) dependsOn(ctx context.Context, parent SerialNumber, redTargets map[string]bool) bool {
+	for _, id := range hst.GetDepends(ctx) {
+		if id == string(parent) {
+			return true
+		}
+	}
+	for _, d := range hst.GetDependTargets(ctx) {
+		if d.Heapster == string(parent) && (redTargets == nil || redTargets[d.Target]) {
+			return true
+		}
+	}
+	return false
+}
+
+// FetchDependents 获取依赖于这个heapster的列表
+func (hst *Heapster) FetchDependents(ctx context.Context) (Heapsters, error) {
+	hset, err := FetchHeapsters(ctx)
+	if err != nil {
+		return nil, err
+	}
+	dependents := make(Heapsters, 0, len(hset))
+	for _, child := range hset {
+		if child.dependsOn(ctx, hst.ID, nil) {
+			dependents = append(dependents, child)
+		}
+	}
+	return dependents, nil
+}
+
+// CountSuppressed 统计因为这个heapster红色而被抑制通知的依赖者, 只算红色的,
+// 依赖单个目标的只有目标在redTargets里才算
+func (hst *Heapster) CountSuppressed(ctx context.Context, redTargets []string) (int, error) {
+	hset, err := FetchHeapsters(ctx)
+	if err != nil {
+		return 0, err
+	}
+	reds := make(map[string]bool, len(redTargets))
+	for _, target := range redTargets {
+		reds[target] = true
+	}
+	count := 0
+	for _, child := range hset {
+		if child.dependsOn(ctx, hst.ID, reds) && child.GetStatus(ctx) == HealthyStatusRed {
+			count++
+		}
+	}
+	return count, nil
+}
@@ -0,0 +1,75 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBoltDepends(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+
+	newHeapster := func(id SerialNumber) *Heapster {
+		return &Heapster{
+			ID:        id,
+			Type:      CheckTypeTCP,
+			Port:      10000,
+			Timeout:   time.Second,
+			Interval:  5 * time.Second,
+			Threshold: 3,
+		}
+	}
+	gateway := newHeapster("testdependgateway")
+	assert.NoError(t, gateway.Save(ctx))
+	room := newHeapster("testdependroom")
+	room.Depends = []string{string(gateway.ID)}
+	assert.NoError(t, CheckDepends(ctx, room))
+	assert.NoError(t, room.Save(ctx))
+	lobby := newHeapster("testdependlobby")
+	lobby.DependTargets = []TargetDepend{{Heapster: string(gateway.ID), Target: "10.0.0.1:80"}}
+	assert.NoError(t, lobby.Save(ctx))
+
+	// 不存在的依赖
+	orphan := newHeapster("testdependorphan")
+	orphan.Depends = []string{"unknown"}
+	assert.Error(t, CheckRefs(ctx, orphan))
+
+	// 互相依赖
+	gateway.Depends = []string{string(room.ID)}
+	assert.Equal(t, []string{"depends"}, fields(t, CheckDepends(ctx, gateway)))
+	gateway.Depends = nil
+	// 通过组的依赖形成环
+	g := &Group{ID: "testdependgroup", Name: "依赖测试", DependTargets: []TargetDepend{{Heapster: string(room.ID), Target: "10.0.0.2:80"}}}
+	assert.NoError(t, g.Save(ctx))
+	gateway.Groups = []string{string(g.ID)}
+	assert.Error(t, CheckDepends(ctx, gateway))
+	gateway.Groups = nil
+
+	// 整个heapster红色时抑制依赖它的, 目标依赖只看目标
+	assert.NoError(t, gateway.SetStatusWithStat(ctx, HealthyStatusRed, HeapsterStat{RedTargets: []string{"10.0.0.2:80"}}))
+	assert.Equal(t, gateway.ID, room.GetRedDepend(ctx))
+	assert.Equal(t, SerialNumber(""), lobby.GetRedDepend(ctx))
+	assert.NoError(t, gateway.SetStatusWithStat(ctx, HealthyStatusYellow, HeapsterStat{RedTargets: []string{"10.0.0.1:80"}}))
+	assert.Equal(t, SerialNumber(""), room.GetRedDepend(ctx))
+	assert.Equal(t, gateway.ID, lobby.GetRedDepend(ctx))
+
+	dependents, err := gateway.FetchDependents(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, dependents, 2)
+
+	// 只统计红色的依赖者
+	assert.NoError(t, room.SetStatus(ctx, HealthyStatusRed))
+	assert.NoError(t, lobby.SetStatus(ctx, HealthyStatusRed))
+	count, err := gateway.CountSuppressed(ctx, []string{"10.0.0.2:80"})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+	count, err = gateway.CountSuppressed(ctx, []string{"10.0.0.1:80"})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+	assert.NoError(t, room.SetStatus(ctx, HealthyStatusGreen))
+	count, err = gateway.CountSuppressed(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+}
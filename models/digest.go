@@ -0,0 +1,43 @@
+package models
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DigestItem 合并通知里面的一个heapster
+type DigestItem struct {
+	Heapster Heapster  `json:"heapster"`
+	Report   Report    `json:"report"`
+	Incident *Incident `json:"incident,omitempty"`
+	// heapster里面异常的目标数量
+	Faileds int `json:"faileds"`
+}
+
+// Digest 等待期间合并的多个通知
+type Digest struct {
+	Labels map[string]string `json:"labels,omitempty"`
+	Items  []DigestItem      `json:"items"`
+}
+
+// Targets 异常的目标总数
+func (d Digest) Targets() int {
+	total := 0
+	for _, item := range d.Items {
+		if item.Faileds > 0 {
+			total += item.Faileds
+		} else {
+			total++
+		}
+	}
+	return total
+}
+
+// Summary 合并通知的概要, 例如"3个监控中的12个目标异常(房间服、大厅服、网关)"
+func (d Digest) Summary() string {
+	names := make([]string, 0, len(d.Items))
+	for _, item := range d.Items {
+		names = append(names, item.Heapster.Name)
+	}
+	return fmt.Sprintf("%d个监控中的%d个目标异常(%s)", len(d.Items), d.Targets(), strings.Join(names, "、"))
+}
@@ -0,0 +1,19 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDigestSummary(t *testing.T) {
+	d := Digest{
+		Items: []DigestItem{
+			{Heapster: Heapster{Name: "房间服"}, Faileds: 8},
+			{Heapster: Heapster{Name: "大厅服"}, Faileds: 3},
+			{Heapster: Heapster{Name: "网关"}},
+		},
+	}
+	assert.Equal(t, 12, d.Targets())
+	assert.Equal(t, "3个监控中的12个目标异常(房间服、大厅服、网关)", d.Summary())
+}
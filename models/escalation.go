@@ -0,0 +1,105 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// EscalationLevel 升级级别, 事故开始Delay之后通知Notifiers
+type EscalationLevel struct {
+	Notifiers []string      `json:"notifiers"`
+	Delay     time.Duration `json:"delay"`
+}
+
+// EscalationPolicy 升级策略, 按顺序逐级通知直到事故被确认或者恢复
+type EscalationPolicy struct {
+	ID      SerialNumber      `json:"id"`
+	Name    string            `json:"name"`
+	Levels  []EscalationLevel `json:"levels"`
+	Version int               `json:"version,omitempty"`
+}
+
+// EscalationPolicies 列表
+type EscalationPolicies []EscalationPolicy
+
+// Validate 验证
+func (ep *EscalationPolicy) Validate() error {
+	if ep.ID == "" {
+		return fmt.Errorf("empty id")
+	}
+	if ep.Name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if len(ep.Levels) == 0 {
+		return fmt.Errorf("empty levels")
+	}
+	var lastDelay time.Duration
+	for i, level := range ep.Levels {
+		if len(level.Notifiers) == 0 {
+			return fmt.Errorf("level %d empty notifiers", i)
+		}
+		if level.Delay < lastDelay {
+			return fmt.Errorf("level %d delay must >= previous level", i)
+		}
+		lastDelay = level.Delay
+	}
+	return nil
+}
+
+// Fill 获取升级策略
+func (ep *EscalationPolicy) Fill(ctx context.Context) error {
+	e, err := GetStore(ctx).Get(ctx, kindEscalation, ep.ID)
+	if err != nil {
+		return err
+	}
+	ep.Version, err = decodeEntity(e, ep)
+	return err
+}
+
+// Save 保存升级策略, Version不为0时检查是否已经被修改
+func (ep *EscalationPolicy) Save(ctx context.Context) error {
+	if err := ep.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(ep)
+	if err != nil {
+		return err
+	}
+	version, err := GetStore(ctx).Put(ctx, kindEscalation, ep.ID, data, ep.Version, ep.refs())
+	if err != nil {
+		return err
+	}
+	ep.Version = version
+	return nil
+}
+
+// Delete 删除, 还在被其他对象引用时返回 InUseError
+func (ep *EscalationPolicy) Delete(ctx context.Context) error {
+	return deleteEntity(ctx, kindEscalation, ep.ID, false)
+}
+
+// ForceDelete 删除并去掉其他对象对它的引用
+func (ep *EscalationPolicy) ForceDelete(ctx context.Context) error {
+	return deleteEntity(ctx, kindEscalation, ep.ID, true)
+}
+
+// FetchEscalationPolicies 获取升级策略列表
+func FetchEscalationPolicies(ctx context.Context) (EscalationPolicies, error) {
+	entities, err := GetStore(ctx).List(ctx, kindEscalation)
+	if err != nil {
+		return nil, err
+	}
+	eps := make(EscalationPolicies, 0, len(entities))
+	for _, e := range entities {
+		ep := EscalationPolicy{
+			ID: e.ID,
+		}
+		if ep.Version, err = decodeEntity(e, &ep); err != nil {
+			continue
+		}
+		eps = append(eps, ep)
+	}
+	return eps, nil
+}
@@ -0,0 +1,39 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEscalationPolicy(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+
+	ep := EscalationPolicy{
+		ID:   "testescalation1",
+		Name: "游戏服务值班",
+		Levels: []EscalationLevel{
+			{Notifiers: []string{"testnotifier1"}},
+			{Notifiers: []string{"testnotifier2"}, Delay: 15 * time.Minute},
+		},
+	}
+	assert.NoError(t, ep.Save(ctx))
+
+	ep1 := EscalationPolicy{ID: ep.ID}
+	assert.NoError(t, ep1.Fill(ctx))
+	assert.Len(t, ep1.Levels, 2)
+	assert.Equal(t, 15*time.Minute, ep1.Levels[1].Delay)
+
+	eps, err := FetchEscalationPolicies(ctx)
+	assert.NoError(t, err)
+	assert.True(t, len(eps) > 0)
+
+	ep1.Levels[1].Delay = 0
+	ep1.Levels[0].Delay = time.Minute
+	assert.Error(t, ep1.Validate())
+	assert.NoError(t, ep.Delete(ctx))
+}
@@ -3,13 +3,7 @@ package models
 import (
 	"context"
 	"encoding/json"
-
 	"fmt"
-	"zonst/qipai/gamehealthysrv/middlewares"
-
-	"strings"
-
-	"github.com/garyburd/redigo/redis"
 )
 
 // GroupStatus 监控组状态
@@ -44,6 +38,9 @@ type Group struct {
 	Excluded  Endpoints    `json:"excluded,omitempty"`
 	Status    GroupStatus  `json:"status,omitempty"`
 	Version   int          `json:"version,omitempty"`
+	// 组内所有heapster依赖的heapster和目标
+	Depends       []string       `json:"depends,omitempty"`
+	DependTargets []TargetDepend `json:"depend_targets,omitempty"`
 }
 
 // Groups 组列表
@@ -57,30 +54,21 @@ func (g *Group) Validate() error {
 	if g.Name == "" {
 		return fmt.Errorf("name can't be empty")
 	}
-	return nil
+	return validateDependTargets("", g.DependTargets).Err()
 }
 
 // Fill 根据ID查询 Group 对象
 func (g *Group) Fill(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	storeKey := fmt.Sprintf("gamehealthy_group_%s", g.ID)
-	data, err := redis.Bytes(conn.Do("HGET", storeKey, "meta"))
+	e, err := GetStore(ctx).Get(ctx, kindGroup, g.ID)
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(data, g); err != nil {
-		return err
-	}
-	g.Version, err = redis.Int(conn.Do("HGET", storeKey, "version"))
+	g.Version, err = decodeEntity(e, g)
 	return err
 }
 
-// Save 持久化 Group 对象
+// Save 持久化 Group 对象, Version不为0时检查是否已经被修改
 func (g *Group) Save(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
 	if err := g.Validate(); err != nil {
 		return err
 	}
@@ -88,47 +76,39 @@ func (g *Group) Save(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-
-	storeKey := fmt.Sprintf("gamehealthy_group_%s", g.ID)
-	err = conn.Send("MULTI")
-	err = conn.Send("HSET", storeKey, "meta", data)
-	err = conn.Send("HINCRBY", storeKey, "version", 1)
-	err = conn.Send("EXEC")
-	err = conn.Flush()
-	_, err = conn.Receive()
-	return err
+	version, err := GetStore(ctx).Put(ctx, kindGroup, g.ID, data, g.Version, g.refs())
+	if err != nil {
+		return err
+	}
+	g.Version = version
+	return nil
 }
 
-// Delete 删除
+// Delete 删除, 还在被其他对象引用时返回 InUseError
 func (g *Group) Delete(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	storeKey := fmt.Sprintf("gamehealthy_group_%s", g.ID)
-	_, err := conn.Do("DEL", storeKey)
+	return deleteEntity(ctx, kindGroup, g.ID, false)
+}
 
-	return err
+// ForceDelete 删除并去掉其他对象对它的引用
+func (g *Group) ForceDelete(ctx context.Context) error {
+	return deleteEntity(ctx, kindGroup, g.ID, true)
 }
 
 // FetchGroups 获取group列表
 func FetchGroups(ctx context.Context) (Groups, error) {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	rawKeys, err := redis.ByteSlices(conn.Do("KEYS", "gamehealthy_group_*"))
+	entities, err := GetStore(ctx).List(ctx, kindGroup)
 	if err != nil {
 		return nil, err
 	}
-	gs := make(Groups, 0, 256)
-	for _, rawKey := range rawKeys {
-		key := strings.TrimPrefix(string(rawKey), "gamehealthy_group_")
-		g := &Group{
-			ID: SerialNumber(key),
+	gs := make(Groups, 0, len(entities))
+	for _, e := range entities {
+		g := Group{
+			ID: e.ID,
 		}
-		if g.Fill(ctx) != nil {
+		if g.Version, err = decodeEntity(e, &g); err != nil {
 			continue
 		}
-		gs = append(gs, *g)
+		gs = append(gs, g)
 	}
 	return gs, nil
 }
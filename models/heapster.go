@@ -4,11 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
 	"time"
-	"zonst/qipai/gamehealthysrv/middlewares"
-
-	"github.com/garyburd/redigo/redis"
 )
 
 // HealthyStatus 健康状态
@@ -22,6 +18,20 @@ const (
 	HealthyStatusRed     HealthyStatus = "red"
 )
 
+// Severity 严重程度, 数值越大越严重
+func (hs HealthyStatus) Severity() int {
+	switch hs {
+	case HealthyStatusRed:
+		return 3
+	case HealthyStatusYellow:
+		return 2
+	case HealthyStatusGreen:
+		return 1
+	default:
+		return 0
+	}
+}
+
 // MarshalJSON json编码实现
 func (hs HealthyStatus) MarshalJSON() ([]byte, error) {
 	return json.Marshal(string(hs))
@@ -37,6 +47,20 @@ func (hs *HealthyStatus) UnmarshalJSON(b []byte) error {
 	return nil
 }
 
+// Color 状态对应的颜色, 用于通知消息
+func (hs HealthyStatus) Color() string {
+	switch hs {
+	case HealthyStatusRed:
+		return "#d50200"
+	case HealthyStatusYellow:
+		return "#de9e31"
+	case HealthyStatusGreen:
+		return "#2fa44f"
+	default:
+		return "#9e9e9e"
+	}
+}
+
 // CheckType 健康检查的类型
 type CheckType string
 
@@ -73,8 +97,20 @@ type Heapster struct {
 	Groups    []string      `json:"groups"`
 	Notifiers []string      `json:"notifiers"`
 	Mute      bool          `json:"mute"`
-
+	Rule      *HeapsterRule `json:"rule,omitempty"`
+	Latency   *LatencyRule  `json:"latency,omitempty"`
+	// 至少多少个探测点失败才判定目标失败
+	MinLocations int `json:"min_locations,omitempty"`
+	// 依赖的heapster, 依赖红色的时候不发通知
+	Depends []string `json:"depends,omitempty"`
+	// 依赖的其他heapster的目标, 只有这个目标红色时抑制通知
+	DependTargets []TargetDepend `json:"depend_targets,omitempty"`
+	// 升级策略ID, 设置之后按照策略分级通知
+	Escalation string `json:"escalation,omitempty"`
+
+	// Version包含关联对象的版本, 用于判断是否需要重新加载; Revision是自身保存的版本, 用于检查并发修改
 	Version    int                    `json:"version,omitempty"`
+	Revision   int                    `json:"revision,omitempty"`
 	Status     HealthyStatus          `json:"status,omitempty"`
 	AcceptCode []int                  `json:"accept_code,omitempty"`
 	Host       string                 `json:"host,omitempty"`
@@ -86,6 +122,7 @@ type Heapster struct {
 type HeapsterStatusSet struct {
 	ID     SerialNumber  `json:"id"`
 	Status HealthyStatus `json:"status"`
+	Stat   *HeapsterStat `json:"stat,omitempty"`
 }
 
 // Heapsters 列表
@@ -127,26 +164,87 @@ func (newset HeapsterSet) Diff(oldset HeapsterSet) (added HeapsterSetKeys, modif
 	return
 }
 
-// Validate 验证
+// DefaultHeapsterThreshold 旧版本保存的heapster没有阈值时使用的默认值
+const DefaultHeapsterThreshold = 3
+
+// FillDefaults 补全旧版本保存的配置缺少的字段, 加载时使用; 接口保存时仍然使用 Validate 严格校验
+func (hst *Heapster) FillDefaults() {
+	if hst.Threshold < 1 {
+		hst.Threshold = DefaultHeapsterThreshold
+	}
+	if hst.Timeout <= 0 {
+		hst.Timeout = hst.Interval
+	}
+}
+
+// Runnable 加载时只拒绝无法运行的配置, 间隔为0时创建定时器会panic
+func (hst *Heapster) Runnable() error {
+	if hst.Interval <= 0 {
+		return fmt.Errorf("interval must > 0")
+	}
+	return nil
+}
+
+// Validate 验证, 返回 ValidationErrors, 包含全部字段的错误
 func (hst *Heapster) Validate() error {
+	var errs ValidationErrors
 	if hst.ID == "" {
-		return fmt.Errorf("empty id")
+		errs.Add("id", "required")
+	}
+	if schema, ok := checkSchemas[hst.Type]; !ok {
+		errs.Add("type", "unknown check type %q", hst.Type)
+	} else {
+		errs = append(errs, schema(hst)...)
 	}
 	if hst.Port <= 0 || hst.Port >= 65536 {
-		return fmt.Errorf("port must > 0  and < 65536")
+		errs.Add("port", "must > 0 and < 65536")
 	}
-	return nil
+	// 间隔为0时创建定时器会panic
+	if hst.Interval <= 0 {
+		errs.Add("interval", "must > 0")
+	}
+	if hst.Timeout <= 0 {
+		errs.Add("timeout", "must > 0")
+	} else if hst.Interval > 0 && hst.Timeout > hst.Interval {
+		errs.Add("timeout", "must <= interval")
+	}
+	if hst.Threshold < 1 {
+		errs.Add("threshold", "must >= 1")
+	}
+	for i, id := range hst.Groups {
+		if id == "" {
+			errs.Add(fmt.Sprintf("groups[%d]", i), "empty group id")
+		}
+	}
+	for i, id := range hst.Depends {
+		if id == string(hst.ID) {
+			errs.Add(fmt.Sprintf("depends[%d]", i), "heapster can't depend on itself")
+		}
+	}
+	errs = append(errs, validateDependTargets(hst.ID, hst.DependTargets)...)
+	if hst.MinLocations < 0 {
+		errs.Add("min_locations", "must >= 0")
+	}
+	if hst.Rule != nil {
+		errs.Merge("rule", hst.Rule.Validate(hst.Groups))
+	}
+	if hst.Latency != nil {
+		errs.Merge("latency", hst.Latency.Validate())
+	}
+	return errs.Err()
 }
 
 // GetStatus 获取状态
 func (hst *Heapster) GetStatus(ctx context.Context) HealthyStatus {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	status, err := redis.String(conn.Do("HGET", fmt.Sprintf("gamehealthy_heapster_%s", hst.ID), "status"))
+	s, err := GetStore(ctx).GetStatus(ctx, hst.ID)
 	if err != nil {
 		return HealthyStatusUnknown
 	}
+	return s.Status
+}
+
+// parseHealthyStatus 解析存储的状态, 无法识别的都是未知
+func parseHealthyStatus(status string) HealthyStatus {
 	switch HealthyStatus(status) {
 	case HealthyStatusRed:
 		return HealthyStatusRed
@@ -161,11 +259,32 @@ func (hst *Heapster) GetStatus(ctx context.Context) HealthyStatus {
 
 // SetStatus 设置状态
 func (hst *Heapster) SetStatus(ctx context.Context, status HealthyStatus) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
+	return GetStore(ctx).SetStatus(ctx, hst.ID, status, nil)
+}
 
-	_, err := conn.Do("HSET", fmt.Sprintf("gamehealthy_heapster_%s", hst.ID), "status", status)
-	return err
+// SetStatusWithStat 设置状态并保存统计数据
+func (hst *Heapster) SetStatusWithStat(ctx context.Context, status HealthyStatus, stat HeapsterStat) error {
+	data, err := json.Marshal(stat)
+	if err != nil {
+		return err
+	}
+	return GetStore(ctx).SetStatus(ctx, hst.ID, status, data)
+}
+
+// GetStat 获取最近一次计算状态的统计数据
+func (hst *Heapster) GetStat(ctx context.Context) (*HeapsterStat, error) {
+	s, err := GetStore(ctx).GetStatus(ctx, hst.ID)
+	if err != nil {
+		return nil, err
+	}
+	if len(s.Stat) == 0 {
+		return nil, ErrNotFound
+	}
+	stat := &HeapsterStat{}
+	if err := json.Unmarshal(s.Stat, stat); err != nil {
+		return nil, err
+	}
+	return stat, nil
 }
 
 // GetApplyGroups 从基本信息里面获取Group列表
@@ -186,8 +305,6 @@ func (hst *Heapster) GetApplyGroups(ctx context.Context) (Groups, error) {
 // GetApplyNotifiers 从配置的notifier字段提取出通知器
 func (hst *Heapster) GetApplyNotifiers(ctx context.Context) (HeapsterNotifiers, error) {
 	ret := make(HeapsterNotifiers, 0, len(hst.Notifiers))
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
 	for _, id := range hst.Notifiers {
 		model := HeapsterNotifier{
 			ID: SerialNumber(id),
@@ -202,94 +319,128 @@ func (hst *Heapster) GetApplyNotifiers(ctx context.Context) (HeapsterNotifiers,
 
 // FetchHeapsterStatus 批量获取状态
 func FetchHeapsterStatus(ctx context.Context) ([]HeapsterStatusSet, error) {
-	statusList := make([]HeapsterStatusSet, 0, 256)
-
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	rawKeys, err := redis.Strings(conn.Do("KEYS", "gamehealthy_heapster_*"))
+	list, err := GetStore(ctx).ListStatus(ctx)
 	if err != nil {
 		return nil, err
 	}
-	for _, rawKey := range rawKeys {
-		key := strings.TrimPrefix(string(rawKey), "gamehealthy_heapster_")
-		heapster := &Heapster{
-			ID: SerialNumber(key),
+	statusList := make([]HeapsterStatusSet, 0, len(list))
+	for _, s := range list {
+		statusSet := HeapsterStatusSet{
+			ID:     s.ID,
+			Status: s.Status,
+		}
+		if len(s.Stat) > 0 {
+			stat := &HeapsterStat{}
+			if json.Unmarshal(s.Stat, stat) == nil {
+				statusSet.Stat = stat
+			}
 		}
-		statusList = append(statusList, HeapsterStatusSet{
-			ID:     heapster.ID,
-			Status: heapster.GetStatus(ctx),
-		})
+		statusList = append(statusList, statusSet)
 	}
 	return statusList, nil
 }
 
-// FetchHeapsters 全部加载
+// FetchHeapsters 全部加载, 关联对象的版本一次性读取
 func FetchHeapsters(ctx context.Context) (HeapsterSet, error) {
-	var hset = make(HeapsterSet, 256)
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-	rawKeys, err := redis.Strings(conn.Do("KEYS", "gamehealthy_heapster_*"))
+	store := GetStore(ctx)
+	entities, err := store.List(ctx, kindHeapster)
+	if err != nil {
+		return nil, err
+	}
+	list, err := store.ListStatus(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make(map[SerialNumber]HealthyStatus, len(list))
+	for _, s := range list {
+		status[s.ID] = s.Status
+	}
+	groups, err := store.AllVersions(ctx, kindGroup)
+	if err != nil {
+		return nil, err
+	}
+	notifiers, err := store.AllVersions(ctx, kindNotifier)
+	if err != nil {
+		return nil, err
+	}
+	escalations, err := store.AllVersions(ctx, kindEscalation)
 	if err != nil {
 		return nil, err
 	}
-	for _, rawKey := range rawKeys {
-		key := strings.TrimPrefix(string(rawKey), "gamehealthy_heapster_")
+	var hset = make(HeapsterSet, len(entities))
+	for _, e := range entities {
 		heapster := &Heapster{
-			ID: SerialNumber(key),
+			ID: e.ID,
 		}
-		if err := heapster.Fill(ctx); err != nil {
+		if heapster.Version, err = decodeEntity(e, heapster); err != nil {
 			continue
 		}
+		heapster.Status = status[e.ID]
+		if heapster.Status == "" {
+			heapster.Status = HealthyStatusUnknown
+		}
+		heapster.Revision = heapster.Version
+		heapster.Version += heapster.relatedVersion(groups, notifiers, escalations)
 		hset[HeapsterSetKey(heapster.ID)] = *heapster
 	}
 	return hset, nil
 }
 
+// relatedVersion 关联的组, 通知器和升级策略的版本之和, 关联对象修改之后需要重新加载
+func (hst *Heapster) relatedVersion(groups, notifiers, escalations map[SerialNumber]int) int {
+	version := 0
+	for _, id := range hst.Groups {
+		version += groups[SerialNumber(id)]
+	}
+	for _, id := range hst.Notifiers {
+		version += notifiers[SerialNumber(id)]
+	}
+	if hst.Escalation != "" {
+		version += escalations[SerialNumber(hst.Escalation)]
+	}
+	return version
+}
+
 // Fill 查询基本信息
 func (hst *Heapster) Fill(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-	storeKey := fmt.Sprintf("gamehealthy_heapster_%s", hst.ID)
-	data, err := redis.Bytes(conn.Do("HGET", storeKey, "meta"))
+	store := GetStore(ctx)
+	e, err := store.Get(ctx, kindHeapster, hst.ID)
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(data, hst); err != nil {
+	version, err := decodeEntity(e, hst)
+	if err != nil {
 		return err
 	}
 	// 获取状态
-	status, err := redis.String(conn.Do("HGET", storeKey, "status"))
+	s, err := store.GetStatus(ctx, hst.ID)
 	if err != nil {
-		status = string(HealthyStatusUnknown)
+		return err
 	}
-	hst.Status = HealthyStatus(status)
+	hst.Status = s.Status
 	// 版本计算
-	version, err := redis.Int(conn.Do("HGET", storeKey, "version"))
+	groups, err := store.Versions(ctx, kindGroup, hst.Groups)
 	if err != nil {
 		return err
 	}
-	gs, err := hst.GetApplyGroups(ctx)
-	if err == nil {
-		for _, g := range gs {
-			version += g.Version
-		}
+	notifiers, err := store.Versions(ctx, kindNotifier, hst.Notifiers)
+	if err != nil {
+		return err
 	}
-	ns, err := hst.GetApplyNotifiers(ctx)
-	if err == nil {
-		for _, n := range ns {
-			version += n.Version
+	var escalations map[SerialNumber]int
+	if hst.Escalation != "" {
+		escalations, err = store.Versions(ctx, kindEscalation, []string{hst.Escalation})
+		if err != nil {
+			return err
 		}
 	}
-	hst.Version = version
-
+	hst.Revision = version
+	hst.Version = version + hst.relatedVersion(groups, notifiers, escalations)
 	return nil
 }
 
-// Save 保存基本信息
+// Save 保存基本信息, Revision不为0时检查是否已经被修改
 func (hst *Heapster) Save(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
 	if err := hst.Validate(); err != nil {
 		return err
 	}
@@ -297,25 +448,22 @@ func (hst *Heapster) Save(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-
-	storeKey := fmt.Sprintf("gamehealthy_heapster_%s", hst.ID)
-	err = conn.Send("MULTI")
-	err = conn.Send("HSET", storeKey, "meta", data)
-	err = conn.Send("HINCRBY", storeKey, "version", 1)
-	err = conn.Send("EXEC")
-	err = conn.Flush()
-	_, err = conn.Receive()
-
-	return err
+	version, err := GetStore(ctx).Put(ctx, kindHeapster, hst.ID, data, hst.Revision, hst.refs())
+	if err != nil {
+		return err
+	}
+	hst.Revision = version
+	return nil
 }
 
-// Delete 删除
+// Delete 删除, 还在被其他对象引用时返回 InUseError
 func (hst *Heapster) Delete(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
+	return deleteEntity(ctx, kindHeapster, hst.ID, false)
+}
 
-	_, err := conn.Do("DEL", fmt.Sprintf("gamehealthy_heapster_%s", hst.ID))
-	return err
+// ForceDelete 删除并去掉其他对象对它的引用
+func (hst *Heapster) ForceDelete(ctx context.Context) error {
+	return deleteEntity(ctx, kindHeapster, hst.ID, true)
 }
 
 // HeapsterNotifier 就是自定义的LabelSet
@@ -330,41 +478,34 @@ type HeapsterNotifier struct {
 // HeapsterNotifiers 列表
 type HeapsterNotifiers []HeapsterNotifier
 
-// Validate 验证
+// Validate 验证, Config按照类型的 NotifierSchema 校验, 返回 ValidationErrors
 func (hn *HeapsterNotifier) Validate() error {
+	var errs ValidationErrors
 	if hn.ID == "" {
-		return fmt.Errorf("empty id")
+		errs.Add("id", "required")
 	}
 	if hn.Type == "" {
-		return fmt.Errorf("empty type")
+		errs.Add("type", "required")
+	} else if _, ok := notifierSchemas[hn.Type]; !ok {
+		errs.Add("type", "unknown notifier type %q", hn.Type)
+	} else {
+		errs.Merge("Config", validateNotifierConfig(hn.Type, hn.Config).Err())
 	}
-	return nil
+	return errs.Err()
 }
 
 // Fill 获取notifier模型
 func (hn *HeapsterNotifier) Fill(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	storeKey := fmt.Sprintf("gamehealthy_notifier_%s", hn.ID)
-	data, err := redis.Bytes(conn.Do("HGET", storeKey, "meta"))
+	e, err := GetStore(ctx).Get(ctx, kindNotifier, hn.ID)
 	if err != nil {
 		return err
 	}
-	if err := json.Unmarshal(data, hn); err != nil {
-		return err
-	}
-	hn.Version, err = redis.Int(conn.Do("HGET", storeKey, "version"))
-	if err != nil {
-		return err
-	}
-	return nil
+	hn.Version, err = decodeEntity(e, hn)
+	return err
 }
 
-// Save 保存notifier模型
+// Save 保存notifier模型, Version不为0时检查是否已经被修改
 func (hn *HeapsterNotifier) Save(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
 	if err := hn.Validate(); err != nil {
 		return err
 	}
@@ -372,45 +513,39 @@ func (hn *HeapsterNotifier) Save(ctx context.Context) error {
 	if err != nil {
 		return err
 	}
-
-	storeKey := fmt.Sprintf("gamehealthy_notifier_%s", hn.ID)
-	err = conn.Send("MULTI")
-	err = conn.Send("HSET", storeKey, "meta", data)
-	err = conn.Send("HINCRBY", storeKey, "version", 1)
-	err = conn.Send("EXEC")
-	err = conn.Flush()
-	_, err = conn.Receive()
-	return err
+	version, err := GetStore(ctx).Put(ctx, kindNotifier, hn.ID, data, hn.Version, hn.refs())
+	if err != nil {
+		return err
+	}
+	hn.Version = version
+	return nil
 }
 
-// Delete 删除
+// Delete 删除, 还在被其他对象引用时返回 InUseError
 func (hn *HeapsterNotifier) Delete(ctx context.Context) error {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
+	return deleteEntity(ctx, kindNotifier, hn.ID, false)
+}
 
-	_, err := conn.Do("DEL", fmt.Sprintf("gamehealthy_notifier_%s", hn.ID))
-	return err
+// ForceDelete 删除并去掉其他对象对它的引用
+func (hn *HeapsterNotifier) ForceDelete(ctx context.Context) error {
+	return deleteEntity(ctx, kindNotifier, hn.ID, true)
 }
 
 // FetchHeapsterNotifiers 获取notifier列表
 func FetchHeapsterNotifiers(ctx context.Context) (HeapsterNotifiers, error) {
-	conn := middlewares.GetRedisConn(ctx)
-	defer conn.Close()
-
-	rawKeys, err := redis.ByteSlices(conn.Do("KEYS", "gamehealthy_notifier_*"))
+	entities, err := GetStore(ctx).List(ctx, kindNotifier)
 	if err != nil {
 		return nil, err
 	}
-	var hns = make(HeapsterNotifiers, 0, 256)
-	for _, rawKey := range rawKeys {
-		key := strings.TrimPrefix(string(rawKey), "gamehealthy_notifier_")
-		hn := &HeapsterNotifier{
-			ID: SerialNumber(key),
+	var hns = make(HeapsterNotifiers, 0, len(entities))
+	for _, e := range entities {
+		hn := HeapsterNotifier{
+			ID: e.ID,
 		}
-		if hn.Fill(ctx) != nil {
+		if hn.Version, err = decodeEntity(e, &hn); err != nil {
 			continue
 		}
-		hns = append(hns, *hn)
+		hns = append(hns, hn)
 	}
 	return hns, nil
 }
@@ -65,6 +65,7 @@ func TestHeapsterPersistent(t *testing.T) {
 		Port:      10000,
 		Timeout:   3 * time.Second,
 		Interval:  5 * time.Second,
+		Threshold: 3,
 		Notifiers: []string{"testnotifier1", "testnotifier2"},
 	}
 
@@ -125,3 +126,39 @@ func TestHeapsterDiff(t *testing.T) {
 
 	fmt.Println(hset1.Diff(hset2))
 }
+
+func TestHeapsterDepends(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+
+	gateway := Heapster{
+		ID:        "test_heapster_gateway",
+		Type:      CheckTypeTCP,
+		Port:      10000,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+	}
+	assert.NoError(t, gateway.Save(ctx))
+	room := Heapster{
+		ID:        "test_heapster_room",
+		Type:      CheckTypeTCP,
+		Port:      10001,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+		Depends:   []string{string(gateway.ID)},
+	}
+	assert.NoError(t, room.Save(ctx))
+	room.Depends = []string{string(room.ID)}
+	assert.Error(t, room.Validate())
+	room.Depends = []string{string(gateway.ID)}
+
+	assert.NoError(t, gateway.SetStatus(ctx, HealthyStatusRed))
+	assert.Equal(t, gateway.ID, room.GetRedDepend(ctx))
+	assert.NoError(t, gateway.SetStatus(ctx, HealthyStatusGreen))
+	assert.Equal(t, SerialNumber(""), room.GetRedDepend(ctx))
+
+	dependents, err := gateway.FetchDependents(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, dependents, 1)
+}
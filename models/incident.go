@@ -0,0 +1,145 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// IncidentStatus 事故状态
+type IncidentStatus string
+
+// 事故状态常量
+const (
+	IncidentStatusOpen         IncidentStatus = "open"
+	IncidentStatusAcknowledged IncidentStatus = "acknowledged"
+	IncidentStatusResolved     IncidentStatus = "resolved"
+)
+
+// 每个heapster保留的事故历史数量
+const incidentHistorySize = 100
+
+// Incident 事故, heapster变成红色时开始, 恢复绿色时结束
+type Incident struct {
+	ID             SerialNumber   `json:"id"`
+	Heapster       SerialNumber   `json:"heapster"`
+	Status         IncidentStatus `json:"status"`
+	Level          int            `json:"level"`
+	StartedAt      time.Time      `json:"started_at"`
+	AcknowledgedAt time.Time      `json:"acknowledged_at,omitempty"`
+	AcknowledgedBy string         `json:"acknowledged_by,omitempty"`
+	ResolvedAt     time.Time      `json:"resolved_at,omitempty"`
+	// Version 保存时检查是否已经被修改
+	Version int `json:"version,omitempty"`
+}
+
+// Incidents 列表
+type Incidents []Incident
+
+// OpenIncident 为heapster创建一个新的事故
+func OpenIncident(ctx context.Context, heapster SerialNumber) (*Incident, error) {
+	inc := &Incident{
+		ID:        NewSerialNumber(),
+		Heapster:  heapster,
+		Status:    IncidentStatusOpen,
+		StartedAt: time.Now(),
+		Version:   1,
+	}
+	data, err := json.Marshal(inc)
+	if err != nil {
+		return nil, err
+	}
+	if err := GetStore(ctx).OpenIncident(ctx, heapster, inc.ID, data); err != nil {
+		return nil, err
+	}
+	return inc, nil
+}
+
+// FetchCurrentIncident 获取heapster未结束的事故, 没有返回nil
+func FetchCurrentIncident(ctx context.Context, heapster SerialNumber) (*Incident, error) {
+	id, err := GetStore(ctx).CurrentIncident(ctx, heapster)
+	if err != nil || id == "" {
+		return nil, err
+	}
+	inc := &Incident{
+		ID: id,
+	}
+	if err := inc.Fill(ctx); err != nil {
+		return nil, err
+	}
+	return inc, nil
+}
+
+// FetchIncidents 获取heapster的事故历史, 新的在前
+func FetchIncidents(ctx context.Context, heapster SerialNumber) (Incidents, error) {
+	ids, err := GetStore(ctx).ListIncidents(ctx, heapster)
+	if err != nil {
+		return nil, err
+	}
+	incs := make(Incidents, 0, len(ids))
+	for _, id := range ids {
+		inc := &Incident{
+			ID: id,
+		}
+		if inc.Fill(ctx) != nil {
+			continue
+		}
+		incs = append(incs, *inc)
+	}
+	return incs, nil
+}
+
+// Fill 查询事故
+func (inc *Incident) Fill(ctx context.Context) error {
+	data, version, err := GetStore(ctx).GetIncident(ctx, inc.ID)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(data, inc); err != nil {
+		return err
+	}
+	inc.Version = version
+	return nil
+}
+
+// Save 保存事故, Version不为0时检查是否已经被修改, 被修改过返回 ErrConflict
+func (inc *Incident) Save(ctx context.Context) error {
+	data, err := json.Marshal(inc)
+	if err != nil {
+		return err
+	}
+	version, err := GetStore(ctx).PutIncident(ctx, inc.Heapster, inc.ID, data, inc.Version, false)
+	if err != nil {
+		return err
+	}
+	inc.Version = version
+	return nil
+}
+
+// Acknowledge 确认事故, 停止升级通知
+func (inc *Incident) Acknowledge(ctx context.Context, by string) error {
+	if inc.Status != IncidentStatusOpen {
+		return fmt.Errorf("incident %s is %s", inc.ID, inc.Status)
+	}
+	inc.Status = IncidentStatusAcknowledged
+	inc.AcknowledgedAt = time.Now()
+	inc.AcknowledgedBy = by
+	return inc.Save(ctx)
+}
+
+// Resolve 结束事故, 恢复之后不管事故有没有被修改过都要结束
+func (inc *Incident) Resolve(ctx context.Context) error {
+	inc.Status = IncidentStatusResolved
+	inc.ResolvedAt = time.Now()
+	data, err := json.Marshal(inc)
+	if err != nil {
+		return err
+	}
+	version, err := GetStore(ctx).PutIncident(ctx, inc.Heapster, inc.ID, data, 0, true)
+	if err != nil {
+		return err
+	}
+	inc.Version = version
+	return nil
+}
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testIncident 两种存储的事故行为一致
+func testIncident(t *testing.T, ctx context.Context) {
+	heapster := SerialNumber("test_heapster_incident")
+	inc, err := OpenIncident(ctx, heapster)
+	assert.NoError(t, err)
+
+	cur, err := FetchCurrentIncident(ctx, heapster)
+	assert.NoError(t, err)
+	assert.Equal(t, inc.ID, cur.ID)
+	assert.NoError(t, cur.Acknowledge(ctx, "tester"))
+	assert.Error(t, cur.Acknowledge(ctx, "tester"))
+
+	// 确认之前读取的事故不能覆盖确认
+	inc.Level = 1
+	assert.Equal(t, ErrConflict, inc.Save(ctx))
+	assert.NoError(t, inc.Fill(ctx))
+	assert.Equal(t, IncidentStatusAcknowledged, inc.Status)
+	assert.Equal(t, 0, inc.Level)
+	assert.NoError(t, cur.Resolve(ctx))
+
+	cur, err = FetchCurrentIncident(ctx, heapster)
+	assert.NoError(t, err)
+	assert.Nil(t, cur)
+
+	incs, err := FetchIncidents(ctx, heapster)
+	assert.NoError(t, err)
+	assert.Equal(t, IncidentStatusResolved, incs[0].Status)
+}
+
+func TestIncident(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	testIncident(t, ctx)
+}
+
+func TestBoltIncident(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+	testIncident(t, ctx)
+
+	// 历史只保留最近的, 移出的事故删除
+	heapster := SerialNumber("test_heapster_incident_history")
+	first, err := OpenIncident(ctx, heapster)
+	assert.NoError(t, err)
+	for i := 0; i < incidentHistorySize; i++ {
+		_, err := OpenIncident(ctx, heapster)
+		assert.NoError(t, err)
+	}
+	incs, err := FetchIncidents(ctx, heapster)
+	assert.NoError(t, err)
+	assert.Len(t, incs, incidentHistorySize)
+	assert.Equal(t, ErrNotFound, first.Fill(ctx))
+}
@@ -0,0 +1,183 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+)
+
+// DeliveryStatus 送达状态
+type DeliveryStatus string
+
+// 送达状态常量
+const (
+	DeliveryStatusPending   DeliveryStatus = "pending"
+	DeliveryStatusDelivered DeliveryStatus = "delivered"
+	DeliveryStatusFailed    DeliveryStatus = "failed"
+)
+
+// 每个heapster保留的通知历史数量以及记录保存的时间
+const (
+	notificationHistorySize = 100
+	notificationExpire      = 7 * 24 * time.Hour
+)
+
+// Recipient 每个号码的送达状态
+type Recipient struct {
+	Number    string         `json:"number"`
+	Status    DeliveryStatus `json:"status"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+// Notification 通知记录, 每次发送都会记录, 短信通过回执更新每个号码的送达状态
+type Notification struct {
+	ID           SerialNumber `json:"id"`
+	Heapster     SerialNumber `json:"heapster"`
+	Incident     SerialNumber `json:"incident,omitempty"`
+	Notifier     SerialNumber `json:"notifier"`
+	Type         string       `json:"type"`
+	Report       Report       `json:"report"`
+	Text         string       `json:"text,omitempty"`
+	SerialNumber int64        `json:"serial_number,omitempty"`
+	Recipients   []Recipient  `json:"recipients"`
+	// 发送结果以及流量控制的原因
+	Error     string `json:"error,omitempty"`
+	RateLimit string `json:"rate_limit,omitempty"`
+	// 测试发送的通知
+	Test bool `json:"test,omitempty"`
+	// 送达失败时使用的备用notifier
+	Fallback     SerialNumber `json:"fallback,omitempty"`
+	FallbackSent bool         `json:"fallback_sent,omitempty"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// Notifications 列表
+type Notifications []Notification
+
+// NewNotification 创建通知记录, 所有号码都是等待送达的状态
+func NewNotification(heapster SerialNumber, notifier SerialNumber, typ string, report Report, numbers []string) *Notification {
+	now := time.Now()
+	n := &Notification{
+		ID:        NewSerialNumber(),
+		Heapster:  heapster,
+		Notifier:  notifier,
+		Type:      typ,
+		Report:    report,
+		CreatedAt: now,
+	}
+	for _, number := range numbers {
+		n.Recipients = append(n.Recipients, Recipient{
+			Number:    number,
+			Status:    DeliveryStatusPending,
+			UpdatedAt: now,
+		})
+	}
+	return n
+}
+
+// SetDelivery 更新号码的送达状态, 没有这个号码返回false
+func (n *Notification) SetDelivery(number string, status DeliveryStatus) bool {
+	for i := range n.Recipients {
+		if n.Recipients[i].Number == number {
+			n.Recipients[i].Status = status
+			n.Recipients[i].UpdatedAt = time.Now()
+			return true
+		}
+	}
+	return false
+}
+
+// SetAllDelivery 更新所有号码的送达状态
+func (n *Notification) SetAllDelivery(status DeliveryStatus) {
+	for _, r := range n.Recipients {
+		n.SetDelivery(r.Number, status)
+	}
+}
+
+// NeedFallback 发送失败或者有号码送达失败, 并且备用notifier还没有发送, 流量控制的不需要
+func (n *Notification) NeedFallback() bool {
+	if n.Fallback == "" || n.FallbackSent || n.RateLimit != "" {
+		return false
+	}
+	if n.Error != "" {
+		return true
+	}
+	for _, r := range n.Recipients {
+		if r.Status == DeliveryStatusFailed {
+			return true
+		}
+	}
+	return false
+}
+
+// Create 保存新的通知记录并加入heapster的历史, 短信同时建立序列号的索引
+// 合并发送的短信一个序列号对应多个heapster的通知记录
+func (n *Notification) Create(ctx context.Context) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return GetStore(ctx).CreateNotification(ctx, n.Heapster, n.ID, n.SerialNumber, data)
+}
+
+// Fill 查询通知记录
+func (n *Notification) Fill(ctx context.Context) error {
+	data, err := GetStore(ctx).GetNotification(ctx, n.ID)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, n)
+}
+
+// Save 保存通知记录
+func (n *Notification) Save(ctx context.Context) error {
+	data, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	return GetStore(ctx).PutNotification(ctx, n.ID, data)
+}
+
+// FetchNotificationsBySMS 通过短信序列号查找通知记录
+func FetchNotificationsBySMS(ctx context.Context, serialNumber int64) (Notifications, error) {
+	ids, err := GetStore(ctx).NotificationsBySMS(ctx, serialNumber)
+	if err != nil {
+		return nil, err
+	}
+	return fillNotifications(ctx, ids), nil
+}
+
+// FetchNotifications 获取heapster的通知历史, 新的在前
+func FetchNotifications(ctx context.Context, heapster SerialNumber) (Notifications, error) {
+	ids, err := GetStore(ctx).ListNotifications(ctx, heapster)
+	if err != nil {
+		return nil, err
+	}
+	return fillNotifications(ctx, ids), nil
+}
+
+// fillNotifications 查询通知记录, 过期的跳过
+func fillNotifications(ctx context.Context, ids []SerialNumber) Notifications {
+	ns := make(Notifications, 0, len(ids))
+	for _, id := range ids {
+		n := &Notification{
+			ID: id,
+		}
+		if n.Fill(ctx) != nil {
+			continue
+		}
+		ns = append(ns, *n)
+	}
+	return ns
+}
+
+// ByIncident 过滤出属于事故的通知
+func (ns Notifications) ByIncident(incident SerialNumber) Notifications {
+	var ret Notifications
+	for _, n := range ns {
+		if n.Incident == incident {
+			ret = append(ret, n)
+		}
+	}
+	return ret
+}
@@ -0,0 +1,87 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationDelivery(t *testing.T) {
+	n := NewNotification("test_heapster", "test_notifier", "sms", Report{}, []string{"13800000001", "13800000002"})
+	assert.False(t, n.NeedFallback())
+
+	assert.True(t, n.SetDelivery("13800000001", DeliveryStatusDelivered))
+	assert.False(t, n.SetDelivery("13800000003", DeliveryStatusDelivered))
+	assert.True(t, n.SetDelivery("13800000002", DeliveryStatusFailed))
+	assert.False(t, n.NeedFallback())
+
+	n.Fallback = "test_fallback"
+	assert.True(t, n.NeedFallback())
+	n.FallbackSent = true
+	assert.False(t, n.NeedFallback())
+
+	// 发送失败需要备用, 流量控制的不需要
+	n = NewNotification("test_heapster", "test_notifier", "webhook", Report{}, nil)
+	n.Fallback = "test_fallback"
+	n.Error = "webhook response code 500"
+	assert.True(t, n.NeedFallback())
+	n.RateLimit = "phone"
+	assert.False(t, n.NeedFallback())
+}
+
+// testNotification 两种存储的通知记录行为一致
+func testNotification(t *testing.T, ctx context.Context) {
+	heapster := SerialNumber("test_heapster_notification")
+	n := NewNotification(heapster, "test_notifier", "sms", Report{Target: "10.0.10.46:10000"}, []string{"13800000001"})
+	n.Incident = "test_incident"
+	n.SerialNumber = 1234567890
+	assert.NoError(t, n.Create(ctx))
+
+	found, err := FetchNotificationsBySMS(ctx, n.SerialNumber)
+	assert.NoError(t, err)
+	assert.Len(t, found, 1)
+	assert.Equal(t, n.ID, found[0].ID)
+	found[0].SetDelivery("13800000001", DeliveryStatusDelivered)
+	assert.NoError(t, found[0].Save(ctx))
+
+	found, err = FetchNotificationsBySMS(ctx, 1)
+	assert.NoError(t, err)
+	assert.Len(t, found, 0)
+
+	ns, err := FetchNotifications(ctx, heapster)
+	assert.NoError(t, err)
+	assert.True(t, len(ns) > 0)
+	assert.Equal(t, DeliveryStatusDelivered, ns[0].Recipients[0].Status)
+	assert.Len(t, ns.ByIncident("test_incident"), len(ns))
+}
+
+func TestNotification(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	testNotification(t, ctx)
+}
+
+func TestBoltNotification(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+	testNotification(t, ctx)
+
+	// 历史只保留最近的, 移出的记录和短信索引一起删除
+	heapster := SerialNumber("test_heapster_notification_history")
+	first := NewNotification(heapster, "test_notifier", "sms", Report{}, []string{"13800000001"})
+	first.SerialNumber = 42
+	assert.NoError(t, first.Create(ctx))
+	for i := 0; i < notificationHistorySize; i++ {
+		n := NewNotification(heapster, "test_notifier", "webhook", Report{}, nil)
+		assert.NoError(t, n.Create(ctx))
+	}
+	ns, err := FetchNotifications(ctx, heapster)
+	assert.NoError(t, err)
+	assert.Len(t, ns, notificationHistorySize)
+	assert.Equal(t, ErrNotFound, first.Fill(ctx))
+	ids, err := GetStore(ctx).NotificationsBySMS(ctx, 42)
+	assert.NoError(t, err)
+	assert.Len(t, ids, 0)
+}
@@ -0,0 +1,406 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// NotifierSchema 通知器类型的配置结构, Config按照json标签解码之后校验,
+// 返回的字段错误是相对Config的
+type NotifierSchema interface {
+	Validate() ValidationErrors
+}
+
+// notifierSchemas 通知器类型对应的配置结构, 没有注册的类型不能保存
+var notifierSchemas = map[string]func() NotifierSchema{
+	"sms":      func() NotifierSchema { return &SMSNotifierConfig{} },
+	"voice":    func() NotifierSchema { return &VoiceNotifierConfig{} },
+	"webhook":  func() NotifierSchema { return &WebhookNotifierConfig{} },
+	"email":    func() NotifierSchema { return &EmailNotifierConfig{} },
+	"dingtalk": func() NotifierSchema { return &DingtalkNotifierConfig{} },
+	"slack":    func() NotifierSchema { return &SlackNotifierConfig{} },
+	"wecom":    func() NotifierSchema { return &WecomNotifierConfig{} },
+	"telegram": func() NotifierSchema { return &TelegramNotifierConfig{} },
+}
+
+// RegistNotifierSchema 注册通知器类型的配置结构, 在init里调用
+func RegistNotifierSchema(typ string, schema func() NotifierSchema) {
+	notifierSchemas[typ] = schema
+}
+
+// HasNotifierSchema 通知器类型是否注册了配置结构
+func HasNotifierSchema(typ string) bool {
+	_, ok := notifierSchemas[typ]
+	return ok
+}
+
+// validateNotifierConfig 解码并校验通知器配置
+func validateNotifierConfig(typ string, config map[string]interface{}) ValidationErrors {
+	newSchema, ok := notifierSchemas[typ]
+	if !ok {
+		return nil
+	}
+	schema := newSchema()
+	if errs := decodeConfig(config, schema); len(errs) > 0 {
+		return errs
+	}
+	return schema.Validate()
+}
+
+// DecodeNotifierConfig 解码通知器配置到配置结构, Config里没有的字段保留结构里原来的值,
+// 通知器创建时先设置默认值再解码
+func DecodeNotifierConfig(config map[string]interface{}, schema NotifierSchema) error {
+	return decodeConfig(config, schema).Err()
+}
+
+// NotifierCommonConfig 所有通知器都支持的配置, 消息模版和送达失败时的备用通知器
+type NotifierCommonConfig struct {
+	Template string `json:"template,omitempty"`
+	Fallback string `json:"fallback,omitempty"`
+}
+
+// Validate 引用是否存在在保存时检查
+func (c *NotifierCommonConfig) Validate() ValidationErrors {
+	return nil
+}
+
+// SMSNotifierConfig 短信通知器配置, 不同服务商需要的字段不同
+type SMSNotifierConfig struct {
+	NotifierCommonConfig
+	// 服务商, unicom, aliyun或者tencent
+	Type    string   `json:"type"`
+	Targets []string `json:"targets"`
+	BaseURL string   `json:"base_url,omitempty"`
+
+	// unicom
+	SP         string `json:"sp,omitempty"`
+	Username   string `json:"username,omitempty"`
+	Password   string `json:"password,omitempty"`
+	ReceiptURL string `json:"receipt_url,omitempty"`
+
+	// aliyun
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	AccessKeySecret string `json:"access_key_secret,omitempty"`
+	TemplateCode    string `json:"template_code,omitempty"`
+	TemplateParam   string `json:"template_param,omitempty"`
+	Region          string `json:"region,omitempty"`
+
+	// tencent
+	SecretID   string `json:"secret_id,omitempty"`
+	SecretKey  string `json:"secret_key,omitempty"`
+	SDKAppID   string `json:"sdk_app_id,omitempty"`
+	TemplateID string `json:"template_id,omitempty"`
+
+	// aliyun和tencent
+	SignName string `json:"sign_name,omitempty"`
+}
+
+// Validate 校验
+func (c *SMSNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	switch c.Type {
+	case "unicom":
+		requireFields(&errs, map[string]string{"sp": c.SP, "username": c.Username, "password": c.Password})
+		validateURL(&errs, "receipt_url", c.ReceiptURL)
+	case "aliyun":
+		requireFields(&errs, map[string]string{
+			"access_key_id": c.AccessKeyID, "access_key_secret": c.AccessKeySecret,
+			"sign_name": c.SignName, "template_code": c.TemplateCode,
+		})
+	case "tencent":
+		requireFields(&errs, map[string]string{
+			"secret_id": c.SecretID, "secret_key": c.SecretKey, "sdk_app_id": c.SDKAppID,
+			"sign_name": c.SignName, "template_id": c.TemplateID,
+		})
+	case "":
+		errs.Add("type", "required")
+	default:
+		errs.Add("type", "must be one of unicom, aliyun, tencent")
+	}
+	validatePhones(&errs, "targets", c.Targets)
+	validateURL(&errs, "base_url", c.BaseURL)
+	return errs
+}
+
+// VoiceNotifierConfig 语音通知器配置, 时间单位秒
+type VoiceNotifierConfig struct {
+	NotifierCommonConfig
+	// 服务商, 目前只有aliyun
+	Type          string   `json:"type"`
+	Targets       []string `json:"targets"`
+	Severity      string   `json:"severity,omitempty"`
+	AnswerTimeout float64  `json:"answer_timeout,omitempty"`
+	PollInterval  float64  `json:"poll_interval,omitempty"`
+	CallInterval  float64  `json:"call_interval,omitempty"`
+	BaseURL       string   `json:"base_url,omitempty"`
+
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	AccessKeySecret string `json:"access_key_secret,omitempty"`
+	ShowNumber      string `json:"show_number,omitempty"`
+	TTSCode         string `json:"tts_code,omitempty"`
+	TTSParam        string `json:"tts_param,omitempty"`
+	Region          string `json:"region,omitempty"`
+}
+
+// Validate 校验
+func (c *VoiceNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	switch c.Type {
+	case "aliyun":
+		requireFields(&errs, map[string]string{
+			"access_key_id": c.AccessKeyID, "access_key_secret": c.AccessKeySecret,
+			"show_number": c.ShowNumber, "tts_code": c.TTSCode,
+		})
+	case "":
+		errs.Add("type", "required")
+	default:
+		errs.Add("type", "must be aliyun")
+	}
+	validatePhones(&errs, "targets", c.Targets)
+	switch HealthyStatus(c.Severity) {
+	case "", HealthyStatusYellow, HealthyStatusRed:
+	default:
+		errs.Add("severity", "must be yellow or red")
+	}
+	if c.AnswerTimeout < 0 {
+		errs.Add("answer_timeout", "must >= 0")
+	}
+	if c.PollInterval < 0 {
+		errs.Add("poll_interval", "must >= 0")
+	}
+	if c.CallInterval < 0 {
+		errs.Add("call_interval", "must >= 0")
+	}
+	validateURL(&errs, "base_url", c.BaseURL)
+	return errs
+}
+
+// WebhookNotifierConfig webhook通知器配置, 时间单位秒, body是text/template模版
+type WebhookNotifierConfig struct {
+	NotifierCommonConfig
+	URL             string            `json:"url"`
+	Method          string            `json:"method,omitempty"`
+	Headers         map[string]string `json:"headers,omitempty"`
+	Secret          string            `json:"secret,omitempty"`
+	SignatureHeader string            `json:"signature_header,omitempty"`
+	Retries         int               `json:"retries,omitempty"`
+	Backoff         float64           `json:"backoff,omitempty"`
+	Timeout         float64           `json:"timeout,omitempty"`
+	Body            string            `json:"body,omitempty"`
+}
+
+// Validate 校验
+func (c *WebhookNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	if c.URL == "" {
+		errs.Add("url", "required")
+	} else {
+		validateURL(&errs, "url", c.URL)
+	}
+	switch strings.ToUpper(c.Method) {
+	case "", "GET", "POST", "PUT", "PATCH", "DELETE":
+	default:
+		errs.Add("method", "must be one of GET, POST, PUT, PATCH, DELETE")
+	}
+	if c.Retries < 0 {
+		errs.Add("retries", "must >= 0")
+	}
+	if c.Backoff < 0 {
+		errs.Add("backoff", "must >= 0")
+	}
+	if c.Timeout < 0 {
+		errs.Add("timeout", "must >= 0")
+	}
+	if c.Body != "" {
+		if _, err := template.New("body").Parse(c.Body); err != nil {
+			errs.Add("body", "%v", err)
+		}
+	}
+	return errs
+}
+
+// EmailNotifierConfig 邮件通知器配置, port为0时按照security使用默认端口
+type EmailNotifierConfig struct {
+	NotifierCommonConfig
+	Host     string   `json:"host"`
+	Port     int      `json:"port,omitempty"`
+	Security string   `json:"security,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Validate 校验
+func (c *EmailNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	requireFields(&errs, map[string]string{"host": c.Host, "from": c.From})
+	if c.Port < 0 || c.Port >= 65536 {
+		errs.Add("port", "must >= 0 and < 65536")
+	}
+	switch c.Security {
+	case "", "none", "starttls", "tls":
+	default:
+		errs.Add("security", "must be one of none, starttls, tls")
+	}
+	if len(c.To) == 0 {
+		errs.Add("to", "required")
+	}
+	for i, to := range c.To {
+		if !strings.Contains(to, "@") {
+			errs.Add(fmt.Sprintf("to[%d]", i), "invalid email address")
+		}
+	}
+	return errs
+}
+
+// DingtalkNotifierConfig 钉钉机器人配置
+type DingtalkNotifierConfig struct {
+	NotifierCommonConfig
+	Webhook   string   `json:"webhook"`
+	Secret    string   `json:"secret,omitempty"`
+	AtMobiles []string `json:"at_mobiles,omitempty"`
+	AtAll     bool     `json:"at_all,omitempty"`
+	ReportURL string   `json:"report_url,omitempty"`
+}
+
+// Validate 校验
+func (c *DingtalkNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	validateWebhook(&errs, c.Webhook)
+	validateURL(&errs, "report_url", c.ReportURL)
+	return errs
+}
+
+// SlackNotifierConfig slack机器人配置
+type SlackNotifierConfig struct {
+	NotifierCommonConfig
+	Webhook   string `json:"webhook"`
+	Channel   string `json:"channel,omitempty"`
+	Username  string `json:"username,omitempty"`
+	ReportURL string `json:"report_url,omitempty"`
+}
+
+// Validate 校验
+func (c *SlackNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	validateWebhook(&errs, c.Webhook)
+	validateURL(&errs, "report_url", c.ReportURL)
+	return errs
+}
+
+// WecomNotifierConfig 企业微信机器人配置
+type WecomNotifierConfig struct {
+	NotifierCommonConfig
+	Webhook          string   `json:"webhook"`
+	MentionedMobiles []string `json:"mentioned_mobiles,omitempty"`
+	ReportURL        string   `json:"report_url,omitempty"`
+}
+
+// Validate 校验
+func (c *WecomNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	validateWebhook(&errs, c.Webhook)
+	validateURL(&errs, "report_url", c.ReportURL)
+	return errs
+}
+
+// TelegramNotifierConfig telegram机器人配置
+type TelegramNotifierConfig struct {
+	NotifierCommonConfig
+	BaseURL   string `json:"base_url,omitempty"`
+	Token     string `json:"token"`
+	ChatIDs   IDList `json:"chat_ids"`
+	ReportURL string `json:"report_url,omitempty"`
+}
+
+// Validate 校验
+func (c *TelegramNotifierConfig) Validate() ValidationErrors {
+	var errs ValidationErrors
+	requireFields(&errs, map[string]string{"token": c.Token})
+	if len(c.ChatIDs) == 0 {
+		errs.Add("chat_ids", "required")
+	}
+	validateURL(&errs, "base_url", c.BaseURL)
+	validateURL(&errs, "report_url", c.ReportURL)
+	return errs
+}
+
+// IDList 字符串或者数字的ID列表, 数字格式化为字符串, 比如telegram群组的chat id -1001234
+type IDList []string
+
+// UnmarshalJSON 实现接口
+func (l *IDList) UnmarshalJSON(data []byte) error {
+	var raws []json.RawMessage
+	if err := json.Unmarshal(data, &raws); err != nil {
+		return err
+	}
+	ids := make(IDList, 0, len(raws))
+	for _, raw := range raws {
+		var id string
+		if err := json.Unmarshal(raw, &id); err == nil {
+			ids = append(ids, id)
+			continue
+		}
+		var num json.Number
+		if err := json.Unmarshal(raw, &num); err != nil {
+			return fmt.Errorf("id must be string or number")
+		}
+		ids = append(ids, num.String())
+	}
+	*l = ids
+	return nil
+}
+
+// requireFields 必填的字符串字段, 按照字段名顺序记录错误
+func requireFields(errs *ValidationErrors, fields map[string]string) {
+	var missing []string
+	for name, value := range fields {
+		if value == "" {
+			missing = append(missing, name)
+		}
+	}
+	sort.Strings(missing)
+	for _, name := range missing {
+		errs.Add(name, "required")
+	}
+}
+
+// validateURL 不为空时必须是http或者https地址
+func validateURL(errs *ValidationErrors, field string, value string) {
+	if value == "" {
+		return
+	}
+	u, err := url.Parse(value)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		errs.Add(field, "must be http or https url")
+	}
+}
+
+// validateWebhook 机器人的webhook地址
+func validateWebhook(errs *ValidationErrors, value string) {
+	if value == "" {
+		errs.Add("webhook", "required")
+		return
+	}
+	validateURL(errs, "webhook", value)
+}
+
+var phoneRegexp = regexp.MustCompile(`^\+?[0-9]{5,20}$`)
+
+// validatePhones 电话号码列表, 不能为空
+func validatePhones(errs *ValidationErrors, field string, phones []string) {
+	if len(phones) == 0 {
+		errs.Add(field, "required")
+	}
+	for i, phone := range phones {
+		if !phoneRegexp.MatchString(phone) {
+			errs.Add(fmt.Sprintf("%s[%d]", field, i), "invalid phone number")
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package models
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+const probeSpoolBucket = "spool"
+
+// DefaultProbeSpoolSize 磁盘队列默认最多保存的日志条数
+const DefaultProbeSpoolSize = 100000
+
+// ProbeSpool 有界的磁盘队列, 写入失败的探测日志暂存在这里等待重放, 满了丢弃最旧的
+type ProbeSpool struct {
+	db   *bolt.DB
+	size int
+
+	mtx   sync.Mutex
+	depth int
+}
+
+// OpenProbeSpool 打开队列文件, 不存在时创建, size为0时使用默认值
+func OpenProbeSpool(path string, size int) (*ProbeSpool, error) {
+	if size <= 0 {
+		size = DefaultProbeSpoolSize
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	depth := 0
+	err = db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.CreateBucketIfNotExists([]byte(probeSpoolBucket))
+		if err != nil {
+			return err
+		}
+		depth = b.Stats().KeyN
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &ProbeSpool{
+		db:    db,
+		size:  size,
+		depth: depth,
+	}, nil
+}
+
+// Close 关闭队列文件
+func (sp *ProbeSpool) Close() error {
+	return sp.db.Close()
+}
+
+// Depth 队列里的日志条数
+func (sp *ProbeSpool) Depth() int {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+	return sp.depth
+}
+
+// Push 追加到队尾, 返回因为队列满而丢弃的条数
+func (sp *ProbeSpool) Push(logs ProbeLogs) (int, error) {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+
+	dropped, evicted := 0, 0
+	err := sp.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(probeSpoolBucket))
+		// 超过的部分从队头丢弃, 一次写入比队列还大时只保留最新的
+		if len(logs) > sp.size {
+			dropped += len(logs) - sp.size
+			logs = logs[len(logs)-sp.size:]
+		}
+		if over := sp.depth + len(logs) - sp.size; over > 0 {
+			n, err := spoolDelete(b, over)
+			if err != nil {
+				return err
+			}
+			evicted = n
+		}
+		for _, doc := range logs {
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			key := make([]byte, 8)
+			binary.BigEndian.PutUint64(key, seq)
+			if err := b.Put(key, data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	sp.depth += len(logs) - evicted
+	return dropped + evicted, nil
+}
+
+// Peek 读取队头最多n条和最后一条的序号, 重放成功之后用序号调用Remove删除
+func (sp *ProbeSpool) Peek(n int) (ProbeLogs, uint64, error) {
+	var (
+		logs ProbeLogs
+		last uint64
+	)
+	err := sp.db.View(func(tx *bolt.Tx) error {
+		c := tx.Bucket([]byte(probeSpoolBucket)).Cursor()
+		for k, v := c.First(); k != nil && len(logs) < n; k, v = c.Next() {
+			doc := ProbeLog{}
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return err
+			}
+			logs = append(logs, doc)
+			last = binary.BigEndian.Uint64(k)
+		}
+		return nil
+	})
+	return logs, last, err
+}
+
+// Remove 删除序号不大于last的日志; Peek之后队列满了丢弃过队头,
+// 按序号删除不会删掉之后写入还没有重放的日志
+func (sp *ProbeSpool) Remove(last uint64) error {
+	sp.mtx.Lock()
+	defer sp.mtx.Unlock()
+
+	var keys [][]byte
+	err := sp.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(probeSpoolBucket))
+		c := b.Cursor()
+		for k, _ := c.First(); k != nil && binary.BigEndian.Uint64(k) <= last; k, _ = c.Next() {
+			keys = append(keys, append([]byte(nil), k...))
+		}
+		for _, k := range keys {
+			if err := b.Delete(k); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sp.depth -= len(keys)
+	return nil
+}
+
+// spoolDelete 删除队头n条, 返回实际删除的条数
+func spoolDelete(b *bolt.Bucket, n int) (int, error) {
+	var keys [][]byte
+	c := b.Cursor()
+	for k, _ := c.First(); k != nil && len(keys) < n; k, _ = c.Next() {
+		keys = append(keys, append([]byte(nil), k...))
+	}
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+	return len(keys), nil
+}
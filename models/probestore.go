@@ -0,0 +1,80 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"zonst/qipai-golang-libs/httputil"
+)
+
+// ProbeStore 探测结果存储, 写入探测日志并按照目标聚合统计
+type ProbeStore interface {
+	// Write 写入探测日志, 时间戳由调用方设置
+	Write(ctx context.Context, logs ProbeLogs) error
+	// Aggregate 统计heapster从since开始每个目标的结果, 按照目标排序
+	Aggregate(ctx context.Context, heapster string, since time.Time) (Reports, error)
+}
+
+// 探测结果存储类型
+const (
+	ProbeStoreTypeElastic = "elastic"
+	ProbeStoreTypeLocal   = "local"
+	ProbeStoreTypeInflux  = "influxdb"
+)
+
+// DefaultProbeRetention 探测日志默认保留3天的数据
+const DefaultProbeRetention = 3 * 24 * time.Hour
+
+type probeStoreContext string
+
+const (
+	probeStoreContextLabel probeStoreContext = "_probe_store_"
+)
+
+// NewProbeStore 按照类型创建探测结果存储, 默认使用Elastic
+func NewProbeStore(typ string, path string, retention time.Duration) (ProbeStore, error) {
+	switch typ {
+	case "", ProbeStoreTypeElastic:
+		return elasticProbeStore{}, nil
+	case ProbeStoreTypeInflux:
+		return influxProbeStore{}, nil
+	case ProbeStoreTypeLocal:
+		v, err := openOnce(path, func() (interface{}, error) {
+			return OpenLocalProbeStore(path, retention)
+		})
+		if err != nil {
+			return nil, err
+		}
+		ps, ok := v.(*LocalProbeStore)
+		if !ok {
+			return nil, fmt.Errorf("file %s is opened by another store", path)
+		}
+		return ps, nil
+	default:
+		return nil, fmt.Errorf("probe store type %s not support", typ)
+	}
+}
+
+// WithProbeStore 获取带探测结果存储的上下文
+func WithProbeStore(parent context.Context, store ProbeStore) context.Context {
+	return context.WithValue(parent, probeStoreContextLabel, store)
+}
+
+// GetProbeStore 获取探测结果存储, 没有设置时使用上下文里的Elastic连接
+func GetProbeStore(ctx context.Context) ProbeStore {
+	if store, ok := ctx.Value(probeStoreContextLabel).(ProbeStore); ok {
+		return store
+	}
+	return elasticProbeStore{}
+}
+
+// ProbeStoreHandler 探测结果存储中间件, 全局插件不要单独使用
+func ProbeStoreHandler(store ProbeStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		httputil.WithValue(ctx, probeStoreContextLabel, store)
+		httputil.Next(ctx)
+	}
+}
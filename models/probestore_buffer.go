@@ -0,0 +1,277 @@
+package models
+
+import (
+	"context"
+	"expvar"
+	"sort"
+	"sync"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+)
+
+// 缓冲写入默认配置
+const (
+	DefaultProbeBatchSize     = 500
+	DefaultProbeFlushInterval = 5 * time.Second
+)
+
+// 内存里最多缓存多少批, 超过之后直接写入磁盘队列
+const probeBufferBatches = 10
+
+// probeMetrics 缓冲写入的统计, 通过/debug/vars查看
+var probeMetrics = expvar.NewMap("gamehealthy_probe")
+
+// BufferedProbeConfig 缓冲写入配置
+type BufferedProbeConfig struct {
+	// 满多少条写入一次
+	BatchSize int
+	// 最长多久写入一次
+	FlushInterval time.Duration
+	// 写入失败时暂存的磁盘队列, 为空时失败的日志直接丢弃
+	SpoolPath string
+	SpoolSize int
+}
+
+// BufferedProbeStore 探测日志先缓存在内存, 按照数量或者时间批量写入下层存储,
+// 写入失败的放到磁盘队列, 之后定时重放
+type BufferedProbeStore struct {
+	ctx    context.Context
+	sink   ProbeStore
+	spool  *ProbeSpool
+	config BufferedProbeConfig
+
+	mtx    sync.Mutex
+	buffer ProbeLogs
+
+	flush chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// NewBufferedProbeStore 创建缓冲写入并启动后台写入协程, ctx用于下层存储的连接,
+// 停止时还需要写入剩余的日志, 不要使用可以取消的ctx
+func NewBufferedProbeStore(ctx context.Context, sink ProbeStore, config BufferedProbeConfig) (*BufferedProbeStore, error) {
+	if config.BatchSize <= 0 {
+		config.BatchSize = DefaultProbeBatchSize
+	}
+	if config.FlushInterval <= 0 {
+		config.FlushInterval = DefaultProbeFlushInterval
+	}
+	ps := &BufferedProbeStore{
+		ctx:    ctx,
+		sink:   sink,
+		config: config,
+		flush:  make(chan struct{}, 1),
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+	if config.SpoolPath != "" {
+		spool, err := OpenProbeSpool(config.SpoolPath, config.SpoolSize)
+		if err != nil {
+			return nil, err
+		}
+		ps.spool = spool
+		probeMetrics.Set("spool_depth", expvarInt(int64(spool.Depth())))
+	}
+	go ps.loop()
+	return ps, nil
+}
+
+// Write 放入缓冲区, 满一批时通知后台写入; 下层存储太慢缓冲区堆积时转存到磁盘队列
+func (ps *BufferedProbeStore) Write(ctx context.Context, logs ProbeLogs) error {
+	ps.mtx.Lock()
+	ps.buffer = append(ps.buffer, logs...)
+	size := len(ps.buffer)
+	var overflow ProbeLogs
+	if size > ps.config.BatchSize*probeBufferBatches {
+		overflow = ps.buffer
+		ps.buffer = nil
+	}
+	ps.mtx.Unlock()
+	probeMetrics.Add("buffered", int64(len(logs)))
+
+	if overflow != nil {
+		probeMetrics.Add("buffered", -int64(len(overflow)))
+		ps.spill(overflow)
+		return nil
+	}
+	if size >= ps.config.BatchSize {
+		select {
+		case ps.flush <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Aggregate 下层存储的统计加上缓冲区里还没写入的日志
+func (ps *BufferedProbeStore) Aggregate(ctx context.Context, heapster string, since time.Time) (Reports, error) {
+	reports, err := ps.sink.Aggregate(ctx, heapster, since)
+	if err != nil {
+		return nil, err
+	}
+	pa := newProbeAggregator(heapster)
+	pending := 0
+	ps.mtx.Lock()
+	for _, doc := range ps.buffer {
+		if doc.Heapster == heapster && !doc.Timestamp.Before(since) {
+			pa.add(doc)
+			pending++
+		}
+	}
+	ps.mtx.Unlock()
+	if pending == 0 {
+		return reports, nil
+	}
+	return mergeReports(reports, pa.reports()), nil
+}
+
+// mergeReports 合并同一个目标的统计, 百分位数不能合并, 两边都有时使用base的
+func mergeReports(base Reports, extra Reports) Reports {
+	index := make(map[string]int, len(base))
+	for i, rp := range base {
+		index[rp.Target] = i
+	}
+	for _, rp := range extra {
+		i, ok := index[rp.Target]
+		if !ok {
+			index[rp.Target] = len(base)
+			base = append(base, rp)
+			continue
+		}
+		merged := &base[i]
+		merged.Success += rp.Success
+		merged.Faileds += rp.Faileds
+		if rp.MaxDelay > merged.MaxDelay {
+			merged.MaxDelay = rp.MaxDelay
+		}
+		if merged.Group == "" {
+			merged.Group = rp.Group
+		}
+		for _, lrp := range rp.Locations {
+			found := false
+			for j := range merged.Locations {
+				if merged.Locations[j].Location == lrp.Location {
+					merged.Locations[j].Success += lrp.Success
+					merged.Locations[j].Faileds += lrp.Faileds
+					found = true
+					break
+				}
+			}
+			if !found {
+				merged.Locations = append(merged.Locations, lrp)
+			}
+		}
+	}
+	sort.Slice(base, func(i, j int) bool {
+		return base[i].Target < base[j].Target
+	})
+	return base
+}
+
+// Close 停止后台协程, 写入缓冲区里剩余的日志
+func (ps *BufferedProbeStore) Close() error {
+	close(ps.stop)
+	<-ps.done
+	if ps.spool != nil {
+		return ps.spool.Close()
+	}
+	return nil
+}
+
+func (ps *BufferedProbeStore) loop() {
+	defer close(ps.done)
+	ticker := time.NewTicker(ps.config.FlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ps.stop:
+			ps.flushBuffer(true)
+			return
+		case <-ps.flush:
+			ps.flushBuffer(false)
+		case <-ticker.C:
+			ps.flushBuffer(true)
+			ps.replay()
+		}
+	}
+}
+
+// flushBuffer 按批写入缓冲区, all为false时不满一批的留到下次
+func (ps *BufferedProbeStore) flushBuffer(all bool) {
+	for {
+		ps.mtx.Lock()
+		n := len(ps.buffer)
+		if n == 0 || (!all && n < ps.config.BatchSize) {
+			ps.mtx.Unlock()
+			return
+		}
+		if n > ps.config.BatchSize {
+			n = ps.config.BatchSize
+		}
+		batch := ps.buffer[:n:n]
+		ps.buffer = ps.buffer[n:]
+		ps.mtx.Unlock()
+
+		probeMetrics.Add("buffered", -int64(len(batch)))
+		if err := ps.sink.Write(ps.ctx, batch); err != nil {
+			middlewares.GetLogger(ps.ctx).Warnf("write %d probe logs error %v", len(batch), err)
+			probeMetrics.Add("flush_errors", 1)
+			ps.spill(batch)
+			continue
+		}
+		probeMetrics.Add("written", int64(len(batch)))
+	}
+}
+
+// replay 按批重放磁盘队列直到清空或者写入失败, 成功之后才从队列删除
+func (ps *BufferedProbeStore) replay() {
+	if ps.spool == nil {
+		return
+	}
+	logger := middlewares.GetLogger(ps.ctx)
+	for ps.spool.Depth() > 0 {
+		batch, last, err := ps.spool.Peek(ps.config.BatchSize)
+		if err != nil {
+			logger.Warnf("read probe spool error %v", err)
+			return
+		}
+		if len(batch) == 0 {
+			return
+		}
+		if err := ps.sink.Write(ps.ctx, batch); err != nil {
+			logger.Warnf("replay %d probe logs error %v", len(batch), err)
+			probeMetrics.Add("flush_errors", 1)
+			return
+		}
+		if err := ps.spool.Remove(last); err != nil {
+			logger.Warnf("remove probe spool error %v", err)
+			return
+		}
+		probeMetrics.Add("written", int64(len(batch)))
+		probeMetrics.Set("spool_depth", expvarInt(int64(ps.spool.Depth())))
+	}
+}
+
+// spill 写入失败的日志放到磁盘队列, 没有队列或者队列满了的计入丢弃
+func (ps *BufferedProbeStore) spill(logs ProbeLogs) {
+	if ps.spool == nil {
+		probeMetrics.Add("dropped", int64(len(logs)))
+		return
+	}
+	dropped, err := ps.spool.Push(logs)
+	if err != nil {
+		middlewares.GetLogger(ps.ctx).Warnf("write probe spool error %v", err)
+		dropped = len(logs)
+	}
+	probeMetrics.Add("dropped", int64(dropped))
+	probeMetrics.Set("spool_depth", expvarInt(int64(ps.spool.Depth())))
+}
+
+// expvarInt 队列长度是当前值, 不能用Add累加
+func expvarInt(v int64) *expvar.Int {
+	i := new(expvar.Int)
+	i.Set(v)
+	return i
+}
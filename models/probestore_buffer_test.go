@@ -0,0 +1,235 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeProbeSink 记录写入的日志, failing时返回错误, 查询返回reports
+type fakeProbeSink struct {
+	mtx     sync.Mutex
+	failing bool
+	batches []ProbeLogs
+	reports Reports
+}
+
+func (s *fakeProbeSink) Write(ctx context.Context, logs ProbeLogs) error {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	if s.failing {
+		return errors.New("sink unavailable")
+	}
+	s.batches = append(s.batches, logs)
+	return nil
+}
+
+func (s *fakeProbeSink) Aggregate(ctx context.Context, heapster string, since time.Time) (Reports, error) {
+	return append(Reports{}, s.reports...), nil
+}
+
+func (s *fakeProbeSink) setFailing(failing bool) {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	s.failing = failing
+}
+
+func (s *fakeProbeSink) written() int {
+	s.mtx.Lock()
+	defer s.mtx.Unlock()
+	n := 0
+	for _, batch := range s.batches {
+		n += len(batch)
+	}
+	return n
+}
+
+func newProbeLogs(n int) ProbeLogs {
+	logs := make(ProbeLogs, n)
+	for i := range logs {
+		logs[i] = ProbeLog{Heapster: "hp1", Target: "10.0.0.1:80", Success: 1, Timestamp: time.Now()}
+	}
+	return logs
+}
+
+// waitFor 后台协程异步写入, 最多等待1秒
+func waitFor(cond func() bool) bool {
+	for i := 0; i < 100; i++ {
+		if cond() {
+			return true
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	return cond()
+}
+
+func TestBufferedProbeStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probespool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := middlewares.WithLogger(context.Background(), 0, ioutil.Discard)
+	sink := &fakeProbeSink{}
+	ps, err := NewBufferedProbeStore(ctx, sink, BufferedProbeConfig{
+		BatchSize:     3,
+		FlushInterval: 50 * time.Millisecond,
+		SpoolPath:     filepath.Join(dir, "spool.db"),
+	})
+	assert.NoError(t, err)
+
+	// 满一批立即写入, 不满的等定时写入
+	assert.NoError(t, ps.Write(ctx, newProbeLogs(4)))
+	assert.True(t, waitFor(func() bool { return sink.written() == 4 }))
+	sink.mtx.Lock()
+	assert.Equal(t, 3, len(sink.batches[0]))
+	sink.mtx.Unlock()
+
+	// 写入失败的进入磁盘队列, 恢复之后重放
+	sink.setFailing(true)
+	assert.NoError(t, ps.Write(ctx, newProbeLogs(2)))
+	assert.True(t, waitFor(func() bool { return ps.spool.Depth() == 2 }))
+	sink.setFailing(false)
+	assert.True(t, waitFor(func() bool { return sink.written() == 6 }))
+	assert.Equal(t, 0, ps.spool.Depth())
+
+	// 关闭时写入剩余的
+	assert.NoError(t, ps.Write(ctx, newProbeLogs(1)))
+	assert.NoError(t, ps.Close())
+	assert.Equal(t, 7, sink.written())
+}
+
+func TestBufferedProbeStoreReplay(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probespool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	ctx := middlewares.WithLogger(context.Background(), 0, ioutil.Discard)
+	sink := &fakeProbeSink{}
+	ps, err := NewBufferedProbeStore(ctx, sink, BufferedProbeConfig{
+		BatchSize:     3,
+		FlushInterval: time.Hour,
+		SpoolPath:     filepath.Join(dir, "spool.db"),
+	})
+	assert.NoError(t, err)
+	defer ps.Close()
+
+	// 一次重放按批写完整个队列
+	_, err = ps.spool.Push(newProbeLogs(7))
+	assert.NoError(t, err)
+	ps.replay()
+	assert.Equal(t, 7, sink.written())
+	assert.Len(t, sink.batches, 3)
+	assert.Equal(t, 0, ps.spool.Depth())
+
+	// 写入失败时停止, 剩下的留在队列里
+	_, err = ps.spool.Push(newProbeLogs(4))
+	assert.NoError(t, err)
+	sink.setFailing(true)
+	ps.replay()
+	assert.Equal(t, 4, ps.spool.Depth())
+}
+
+func TestBufferedProbeStoreAggregate(t *testing.T) {
+	ctx := middlewares.WithLogger(context.Background(), 0, ioutil.Discard)
+	sink := &fakeProbeSink{
+		reports: Reports{
+			{
+				Heapster:  "hp1",
+				Target:    "10.0.0.1:80",
+				Success:   2,
+				Faileds:   1,
+				MaxDelay:  time.Second,
+				P95Delay:  time.Second,
+				Locations: []LocationReport{{Location: DefaultProbeLocation, Success: 2, Faileds: 1}},
+			},
+		},
+	}
+	ps, err := NewBufferedProbeStore(ctx, sink, BufferedProbeConfig{
+		BatchSize:     100,
+		FlushInterval: time.Hour,
+	})
+	assert.NoError(t, err)
+	defer ps.Close()
+
+	// 缓冲区里还没写入的日志也要统计, 时间范围之外和其他heapster的不统计
+	now := time.Now()
+	assert.NoError(t, ps.Write(ctx, ProbeLogs{
+		{Heapster: "hp1", Target: "10.0.0.1:80", Failed: 1, Elapsed: 2 * time.Second, Timestamp: now},
+		{Heapster: "hp1", Target: "10.0.0.2:80", Success: 1, Location: "shanghai", Timestamp: now},
+		{Heapster: "hp1", Target: "10.0.0.2:80", Success: 1, Timestamp: now.Add(-time.Hour)},
+		{Heapster: "hp2", Target: "10.0.0.1:80", Success: 1, Timestamp: now},
+	}))
+	reports, err := ps.Aggregate(ctx, "hp1", now.Add(-time.Minute))
+	assert.NoError(t, err)
+	if assert.Len(t, reports, 2) {
+		assert.Equal(t, 2, reports[0].Success)
+		assert.Equal(t, 2, reports[0].Faileds)
+		assert.Equal(t, 2*time.Second, reports[0].MaxDelay)
+		assert.Equal(t, time.Second, reports[0].P95Delay)
+		assert.Equal(t, []LocationReport{{Location: DefaultProbeLocation, Success: 2, Faileds: 2}}, reports[0].Locations)
+		assert.Equal(t, "10.0.0.2:80", reports[1].Target)
+		assert.Equal(t, 1, reports[1].Success)
+		assert.Equal(t, []LocationReport{{Location: "shanghai", Success: 1}}, reports[1].Locations)
+	}
+}
+
+func TestProbeSpool(t *testing.T) {
+	dir, err := ioutil.TempDir("", "probespool")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "spool.db")
+
+	sp, err := OpenProbeSpool(path, 5)
+	assert.NoError(t, err)
+	logs := newProbeLogs(4)
+	for i := range logs {
+		logs[i].Target = string(rune('a' + i))
+	}
+	dropped, err := sp.Push(logs)
+	assert.NoError(t, err)
+	assert.Equal(t, 0, dropped)
+
+	// 满了丢弃最旧的
+	dropped, err = sp.Push(logs[:3])
+	assert.NoError(t, err)
+	assert.Equal(t, 2, dropped)
+	assert.Equal(t, 5, sp.Depth())
+
+	head, last, err := sp.Peek(2)
+	assert.NoError(t, err)
+	assert.Equal(t, "c", head[0].Target)
+	assert.Equal(t, "d", head[1].Target)
+	assert.NoError(t, sp.Remove(last))
+	assert.NoError(t, sp.Close())
+
+	// 重新打开之后数据还在
+	sp, err = OpenProbeSpool(path, 5)
+	assert.NoError(t, err)
+	defer sp.Close()
+	assert.Equal(t, 3, sp.Depth())
+	head, last, err = sp.Peek(10)
+	assert.NoError(t, err)
+	assert.Len(t, head, 3)
+	assert.Equal(t, "a", head[0].Target)
+
+	// 重放期间队列满了丢弃队头, 只删除已经读取的, 之后写入的还在
+	_, err = sp.Push(logs)
+	assert.NoError(t, err)
+	assert.NoError(t, sp.Remove(last))
+	assert.Equal(t, 4, sp.Depth())
+	head, _, err = sp.Peek(10)
+	assert.NoError(t, err)
+	if assert.Len(t, head, 4) {
+		assert.Equal(t, "a", head[0].Target)
+		assert.Equal(t, "d", head[3].Target)
+	}
+}
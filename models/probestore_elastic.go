@@ -0,0 +1,143 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+func init() {
+	elastic.SetTraceLog(log.New(os.Stdout, "****** ", 5))
+}
+
+// elasticProbeStore 探测日志按天写入 gamehealthy-* 索引, 类型probelog
+type elasticProbeStore struct{}
+
+// 索引按照东八区的日期划分
+var elasticIndexZone = time.FixedZone("CST", 8*3600)
+
+// elasticIndex 按照日志时间选择索引, 重放的日志写入原来的日期
+func elasticIndex(t time.Time) string {
+	return elasticProbeIndexPrefix + t.In(elasticIndexZone).Format("2006.01.02")
+}
+
+// elasticDocID 根据内容生成文档ID, 重试写入的时候覆盖而不是重复
+func elasticDocID(doc ProbeLog) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%d", doc.Heapster, doc.Target, doc.Location, doc.Timestamp.UnixNano())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Write 使用bulk接口一次写入, 部分失败也返回错误
+func (elasticProbeStore) Write(ctx context.Context, logs ProbeLogs) error {
+	if len(logs) == 0 {
+		return nil
+	}
+	conn := middlewares.GetElasticConn(ctx)
+	bulk := conn.Bulk()
+	for _, doc := range logs {
+		bulk.Add(elastic.NewBulkIndexRequest().
+			Index(elasticIndex(doc.Timestamp)).
+			Type("probelog").
+			Id(elasticDocID(doc)).
+			Doc(doc))
+	}
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return fmt.Errorf("save report error %v", err)
+	}
+	if failed := resp.Failed(); len(failed) > 0 {
+		reason := ""
+		if failed[0].Error != nil {
+			reason = failed[0].Error.Reason
+		}
+		return fmt.Errorf("save report error %d/%d failed: %s", len(failed), len(logs), reason)
+	}
+	return nil
+}
+
+// Aggregate 使用ES聚合统计
+func (elasticProbeStore) Aggregate(ctx context.Context, heapster string, last time.Time) (Reports, error) {
+	conn := middlewares.GetElasticConn(ctx)
+	// 查询条件
+	queryHeapster := elastic.NewTermQuery("heapster", heapster)
+	queryTimestamp := elastic.NewRangeQuery("timestamp").Gte(last)
+	boolQuery := elastic.NewBoolQuery().Filter(queryHeapster, queryTimestamp)
+	// 聚集
+	aggsSuccess := elastic.NewSumAggregation().Field("success")
+	aggsFaileds := elastic.NewSumAggregation().Field("failed")
+	aggsElapsed := elastic.NewMaxAggregation().Field("elapsed")
+	aggsPercentiles := elastic.NewPercentilesAggregation().Field("elapsed").Percentiles(50, 95)
+	aggsGroup := elastic.NewTermsAggregation().Field("group").Size(1)
+	aggsLocation := elastic.NewTermsAggregation().
+		Field("location").Size(100).Missing(DefaultProbeLocation).
+		SubAggregation("success", aggsSuccess).
+		SubAggregation("faileds", aggsFaileds)
+	aggsTarget := elastic.NewTermsAggregation().
+		Field("target").Size(1000).OrderByTermAsc().
+		SubAggregation("success", aggsSuccess).
+		SubAggregation("faileds", aggsFaileds).
+		SubAggregation("max_delay", aggsElapsed).
+		SubAggregation("delay_percentiles", aggsPercentiles).
+		SubAggregation("group", aggsGroup).
+		SubAggregation("location", aggsLocation)
+
+	// 超过保留时间的索引由 ExpireProbeIndices 删除或者关闭, 更早的数据使用 FetchSLA 查询小时统计
+	result, err := conn.Search(elasticProbeIndexPrefix+"*").
+		Type("probelog").From(0).Size(0).
+		Query(boolQuery).Aggregation("target", aggsTarget).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports := make(Reports, 0, 1024)
+	term, ok := result.Aggregations.Terms("target")
+	if ok {
+		for _, b := range term.Buckets {
+			rp := Report{
+				Heapster: heapster,
+				Target:   b.Key.(string),
+			}
+
+			if success, ok := b.Sum("success"); ok {
+				rp.Success = int(*success.Value)
+			}
+			if faileds, ok := b.Sum("faileds"); ok {
+				rp.Faileds = int(*faileds.Value)
+			}
+			if maxDelay, ok := b.Max("max_delay"); ok && maxDelay.Value != nil {
+				rp.MaxDelay = time.Duration(*maxDelay.Value)
+			}
+			if percentiles, ok := b.Percentiles("delay_percentiles"); ok {
+				rp.P50Delay = time.Duration(percentiles.Values["50.0"])
+				rp.P95Delay = time.Duration(percentiles.Values["95.0"])
+			}
+			if group, ok := b.Terms("group"); ok && len(group.Buckets) > 0 {
+				rp.Group, _ = group.Buckets[0].Key.(string)
+			}
+			if location, ok := b.Terms("location"); ok {
+				for _, lb := range location.Buckets {
+					lrp := LocationReport{}
+					lrp.Location, _ = lb.Key.(string)
+					if success, ok := lb.Sum("success"); ok {
+						lrp.Success = int(*success.Value)
+					}
+					if faileds, ok := lb.Sum("faileds"); ok {
+						lrp.Faileds = int(*faileds.Value)
+					}
+					rp.Locations = append(rp.Locations, lrp)
+				}
+			}
+			reports = append(reports, rp)
+		}
+	}
+	return reports, nil
+}
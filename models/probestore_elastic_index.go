@@ -0,0 +1,442 @@
+package models
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+// 探测日志按天写入 gamehealthy-YYYY.MM.DD, 降采样的小时统计按月写入 gamehealthysla-YYYY.MM
+const (
+	elasticProbeIndexPrefix  = "gamehealthy-"
+	elasticHourlyIndexPrefix = "gamehealthysla-"
+	elasticHourlyType        = "hourly"
+)
+
+// 索引过期的处理方式, close 关闭索引保留数据但是不能检索, 需要时可以手动打开
+const (
+	ElasticRetentionDelete = "delete"
+	ElasticRetentionClose  = "close"
+)
+
+// DefaultSLARetention 小时统计默认保留一年
+const DefaultSLARetention = 365 * 24 * time.Hour
+
+// elasticDownsampleDelay 小时结束之后等待缓冲的日志写入再统计
+const elasticDownsampleDelay = 10 * time.Minute
+
+// DefaultDownsampleWindow 默认重新统计最近24小时, 覆盖暂存重放和慢的探测点晚到的日志
+const DefaultDownsampleWindow = 24 * time.Hour
+
+// elasticTemplateVersion 模版修改时增加版本号, 启动时低于这个版本的模版会被覆盖, 只影响之后新建的索引
+const elasticTemplateVersion = 3
+
+// elasticTemplates 服务管理的索引模版, 字段对应 ProbeLog 和 HourlyReport
+var elasticTemplates = map[string]map[string]interface{}{
+	"gamehealthy": {
+		"template": elasticProbeIndexPrefix + "*",
+		"version":  elasticTemplateVersion,
+		"settings": map[string]interface{}{
+			"number_of_shards": 1,
+		},
+		"mappings": map[string]interface{}{
+			"probelog": map[string]interface{}{
+				"_all": map[string]interface{}{"enabled": false},
+				"properties": map[string]interface{}{
+					"timestamp": map[string]interface{}{"type": "date"},
+					"heapster":  map[string]interface{}{"type": "keyword"},
+					"name":      map[string]interface{}{"type": "keyword"},
+					"target":    map[string]interface{}{"type": "keyword"},
+					"group":     map[string]interface{}{"type": "keyword"},
+					"location":  map[string]interface{}{"type": "keyword"},
+					"type":      map[string]interface{}{"type": "keyword"},
+					"response":  map[string]interface{}{"type": "keyword", "ignore_above": 1024},
+					"elapsed":   map[string]interface{}{"type": "long"},
+					"success":   map[string]interface{}{"type": "integer"},
+					"failed":    map[string]interface{}{"type": "integer"},
+				},
+			},
+		},
+	},
+	"gamehealthysla": {
+		"template": elasticHourlyIndexPrefix + "*",
+		"version":  elasticTemplateVersion,
+		"settings": map[string]interface{}{
+			"number_of_shards": 1,
+		},
+		"mappings": map[string]interface{}{
+			elasticHourlyType: map[string]interface{}{
+				"_all": map[string]interface{}{"enabled": false},
+				"properties": map[string]interface{}{
+					"timestamp": map[string]interface{}{"type": "date"},
+					"heapster":  map[string]interface{}{"type": "keyword"},
+					"target":    map[string]interface{}{"type": "keyword"},
+					"group":     map[string]interface{}{"type": "keyword"},
+					"success":   map[string]interface{}{"type": "long"},
+					"faileds":   map[string]interface{}{"type": "long"},
+					"max_delay": map[string]interface{}{"type": "long"},
+					"p50_delay": map[string]interface{}{"type": "long"},
+					"p95_delay": map[string]interface{}{"type": "long"},
+				},
+			},
+		},
+	},
+}
+
+// HourlyReport 降采样之后的小时统计, Timestamp是小时的开始时间
+type HourlyReport struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Heapster  string        `json:"heapster"`
+	Target    string        `json:"target"`
+	Group     string        `json:"group,omitempty"`
+	Success   int           `json:"success"`
+	Faileds   int           `json:"faileds"`
+	MaxDelay  time.Duration `json:"max_delay"`
+	P50Delay  time.Duration `json:"p50_delay"`
+	P95Delay  time.Duration `json:"p95_delay"`
+}
+
+// SLAReport 根据小时统计计算的长期可用率
+type SLAReport struct {
+	Heapster     string        `json:"heapster"`
+	Target       string        `json:"target"`
+	Group        string        `json:"group,omitempty"`
+	Success      int           `json:"success"`
+	Faileds      int           `json:"faileds"`
+	Availability float64       `json:"availability"`
+	MaxDelay     time.Duration `json:"max_delay"`
+	P95Delay     time.Duration `json:"p95_delay"`
+}
+
+// SLAReports SLA报告列表
+type SLAReports []SLAReport
+
+// elasticHourlyIndex 小时统计按月划分索引
+func elasticHourlyIndex(t time.Time) string {
+	return elasticHourlyIndexPrefix + t.In(elasticIndexZone).Format("2006.01")
+}
+
+// elasticHourlyDocID 同一个小时重复统计的时候覆盖
+func elasticHourlyDocID(rp HourlyReport) string {
+	h := sha1.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%d", rp.Heapster, rp.Target, rp.Timestamp.Unix())
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// InstallElasticTemplates 安装或者升级索引模版, 已经是当前版本的不修改
+func InstallElasticTemplates(ctx context.Context) error {
+	conn := middlewares.GetElasticConn(ctx)
+	names := make([]string, 0, len(elasticTemplates))
+	for name := range elasticTemplates {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		current, err := conn.IndexGetTemplate(name).Do(ctx)
+		if err != nil && !elastic.IsNotFound(err) {
+			return fmt.Errorf("get template %s error %v", name, err)
+		}
+		if tmpl, ok := current[name]; ok && tmpl.Version >= elasticTemplateVersion {
+			continue
+		}
+		if _, err := conn.IndexPutTemplate(name).BodyJson(elasticTemplates[name]).Do(ctx); err != nil {
+			return fmt.Errorf("put template %s error %v", name, err)
+		}
+		middlewares.GetLogger(ctx).Infof("elastic template %s upgraded to version %d", name, elasticTemplateVersion)
+	}
+	return nil
+}
+
+// DownsampleProbeLogs 统计完整结束的小时写入 gamehealthysla-* 索引, 返回已经统计到的时间(不包含);
+// 缓冲和暂存重放的日志可能晚到, 每次都重新统计最近window里的小时, 文档ID固定所以是覆盖;
+// 第一次运行时从最早的日志索引开始, 过期清理之前的日志都已经统计过
+func DownsampleProbeLogs(ctx context.Context, window time.Duration, now time.Time) (time.Time, error) {
+	conn := middlewares.GetElasticConn(ctx)
+	watermark, err := fetchDownsampleWatermark(ctx)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if watermark.IsZero() {
+		names, err := conn.IndexNames()
+		if err != nil {
+			return time.Time{}, fmt.Errorf("list indices error %v", err)
+		}
+		watermark = earliestIndexTime(names, elasticProbeIndexPrefix, "2006.01.02", now)
+	}
+	start := watermark.Truncate(time.Hour)
+	if late := now.Add(-window).Truncate(time.Hour); late.Before(start) {
+		start = late
+	}
+	end := now.Add(-elasticDownsampleDelay).Truncate(time.Hour)
+	for hour := start; hour.Before(end); hour = hour.Add(time.Hour) {
+		if err := downsampleHour(ctx, hour); err != nil {
+			return watermark, err
+		}
+		// 没有日志的小时也推进, 下次不用重新查询
+		if next := hour.Add(time.Hour); next.After(watermark) {
+			if err := saveDownsampleWatermark(ctx, next); err != nil {
+				return watermark, err
+			}
+			watermark = next
+		}
+	}
+	return watermark, nil
+}
+
+// 降采样进度保存在单独的索引里, 不是按月的名字, 过期清理时跳过
+const (
+	elasticWatermarkIndex = elasticHourlyIndexPrefix + "watermark"
+	elasticWatermarkType  = "watermark"
+	elasticWatermarkID    = "hourly"
+)
+
+// downsampleWatermark 降采样进度文档
+type downsampleWatermark struct {
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// fetchDownsampleWatermark 读取降采样进度, 没有统计过返回零值
+func fetchDownsampleWatermark(ctx context.Context) (time.Time, error) {
+	conn := middlewares.GetElasticConn(ctx)
+	result, err := conn.Get().
+		Index(elasticWatermarkIndex).Type(elasticWatermarkType).Id(elasticWatermarkID).
+		Do(ctx)
+	if elastic.IsNotFound(err) {
+		return time.Time{}, nil
+	} else if err != nil {
+		return time.Time{}, fmt.Errorf("fetch downsample watermark error %v", err)
+	}
+	if !result.Found || result.Source == nil {
+		return time.Time{}, nil
+	}
+	wm := downsampleWatermark{}
+	if err := json.Unmarshal(*result.Source, &wm); err != nil {
+		return time.Time{}, err
+	}
+	return wm.Timestamp, nil
+}
+
+// saveDownsampleWatermark 保存降采样进度
+func saveDownsampleWatermark(ctx context.Context, t time.Time) error {
+	conn := middlewares.GetElasticConn(ctx)
+	_, err := conn.Index().
+		Index(elasticWatermarkIndex).Type(elasticWatermarkType).Id(elasticWatermarkID).
+		BodyJson(downsampleWatermark{Timestamp: t}).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("save downsample watermark error %v", err)
+	}
+	return nil
+}
+
+// earliestIndexTime 最早的按日期命名的索引的开始时间, 没有时返回def
+func earliestIndexTime(names []string, prefix, layout string, def time.Time) time.Time {
+	earliest := def
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		t, err := time.ParseInLocation(layout, strings.TrimPrefix(name, prefix), elasticIndexZone)
+		if err == nil && t.Before(earliest) {
+			earliest = t
+		}
+	}
+	return earliest
+}
+
+// downsampleHour 统计一个小时里每个目标的结果
+func downsampleHour(ctx context.Context, hour time.Time) error {
+	conn := middlewares.GetElasticConn(ctx)
+	query := elastic.NewRangeQuery("timestamp").Gte(hour).Lt(hour.Add(time.Hour))
+	aggsTarget := elastic.NewTermsAggregation().
+		Field("target").Size(1000).
+		SubAggregation("success", elastic.NewSumAggregation().Field("success")).
+		SubAggregation("faileds", elastic.NewSumAggregation().Field("failed")).
+		SubAggregation("max_delay", elastic.NewMaxAggregation().Field("elapsed")).
+		SubAggregation("delay_percentiles", elastic.NewPercentilesAggregation().Field("elapsed").Percentiles(50, 95)).
+		SubAggregation("group", elastic.NewTermsAggregation().Field("group").Size(1))
+	aggsHeapster := elastic.NewTermsAggregation().
+		Field("heapster").Size(10000).
+		SubAggregation("target", aggsTarget)
+	result, err := conn.Search(elasticProbeIndexPrefix+"*").
+		Type("probelog").Size(0).
+		Query(query).Aggregation("heapster", aggsHeapster).
+		Do(ctx)
+	if err != nil {
+		return fmt.Errorf("downsample %s error %v", hour.Format(time.RFC3339), err)
+	}
+	heapsters, ok := result.Aggregations.Terms("heapster")
+	if !ok || len(heapsters.Buckets) == 0 {
+		return nil
+	}
+	bulk := conn.Bulk()
+	for _, hb := range heapsters.Buckets {
+		targets, ok := hb.Terms("target")
+		if !ok {
+			continue
+		}
+		for _, b := range targets.Buckets {
+			rp := HourlyReport{Timestamp: hour}
+			rp.Heapster, _ = hb.Key.(string)
+			rp.Target, _ = b.Key.(string)
+			if success, ok := b.Sum("success"); ok && success.Value != nil {
+				rp.Success = int(*success.Value)
+			}
+			if faileds, ok := b.Sum("faileds"); ok && faileds.Value != nil {
+				rp.Faileds = int(*faileds.Value)
+			}
+			if maxDelay, ok := b.Max("max_delay"); ok && maxDelay.Value != nil {
+				rp.MaxDelay = time.Duration(*maxDelay.Value)
+			}
+			if percentiles, ok := b.Percentiles("delay_percentiles"); ok {
+				rp.P50Delay = time.Duration(percentiles.Values["50.0"])
+				rp.P95Delay = time.Duration(percentiles.Values["95.0"])
+			}
+			if group, ok := b.Terms("group"); ok && len(group.Buckets) > 0 {
+				rp.Group, _ = group.Buckets[0].Key.(string)
+			}
+			bulk.Add(elastic.NewBulkIndexRequest().
+				Index(elasticHourlyIndex(hour)).
+				Type(elasticHourlyType).
+				Id(elasticHourlyDocID(rp)).
+				Doc(rp))
+		}
+	}
+	if bulk.NumberOfActions() == 0 {
+		return nil
+	}
+	resp, err := bulk.Do(ctx)
+	if err != nil {
+		return fmt.Errorf("save hourly report error %v", err)
+	}
+	if failed := resp.Failed(); len(failed) > 0 {
+		reason := ""
+		if failed[0].Error != nil {
+			reason = failed[0].Error.Reason
+		}
+		return fmt.Errorf("save hourly report error %d/%d failed: %s", len(failed), len(resp.Items), reason)
+	}
+	return nil
+}
+
+// expiredIndices 过滤出在before之前已经结束的索引, layout是索引名里的日期格式, 结束时间用next计算
+func expiredIndices(names []string, prefix, layout string, next func(time.Time) time.Time, before time.Time) []string {
+	var expired []string
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		t, err := time.ParseInLocation(layout, strings.TrimPrefix(name, prefix), elasticIndexZone)
+		if err != nil {
+			continue
+		}
+		if !next(t).After(before) {
+			expired = append(expired, name)
+		}
+	}
+	sort.Strings(expired)
+	return expired
+}
+
+// ExpireProbeIndices 删除或者关闭 before 之前的探测日志索引, 删除 slaBefore 之前的小时统计索引, 返回处理的索引
+func ExpireProbeIndices(ctx context.Context, action string, before, slaBefore time.Time) ([]string, error) {
+	conn := middlewares.GetElasticConn(ctx)
+	names, err := conn.IndexNames()
+	if err != nil {
+		return nil, fmt.Errorf("list indices error %v", err)
+	}
+	probeIndices := expiredIndices(names, elasticProbeIndexPrefix, "2006.01.02",
+		func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }, before)
+	hourlyIndices := expiredIndices(names, elasticHourlyIndexPrefix, "2006.01",
+		func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }, slaBefore)
+
+	var expired []string
+	switch action {
+	case "", ElasticRetentionDelete:
+		if len(probeIndices) > 0 {
+			if _, err := conn.DeleteIndex(probeIndices...).Do(ctx); err != nil {
+				return expired, fmt.Errorf("delete indices error %v", err)
+			}
+			expired = append(expired, probeIndices...)
+		}
+	case ElasticRetentionClose:
+		// 关闭的索引仍然在列表里, 已经关闭的再关闭没有影响
+		for _, name := range probeIndices {
+			if _, err := conn.CloseIndex(name).Do(ctx); err != nil {
+				return expired, fmt.Errorf("close index %s error %v", name, err)
+			}
+			expired = append(expired, name)
+		}
+	default:
+		return nil, fmt.Errorf("retention action %s not support", action)
+	}
+	if len(hourlyIndices) > 0 {
+		if _, err := conn.DeleteIndex(hourlyIndices...).Do(ctx); err != nil {
+			return expired, fmt.Errorf("delete indices error %v", err)
+		}
+		expired = append(expired, hourlyIndices...)
+	}
+	return expired, nil
+}
+
+// FetchSLA 根据小时统计计算heapster在[since, until)之间每个目标的可用率, 需要使用Elastic存储
+func FetchSLA(ctx context.Context, heapster string, since, until time.Time) (SLAReports, error) {
+	conn := middlewares.GetElasticConn(ctx)
+	query := elastic.NewBoolQuery().Filter(
+		elastic.NewTermQuery("heapster", heapster),
+		elastic.NewRangeQuery("timestamp").Gte(since).Lt(until),
+	)
+	aggsTarget := elastic.NewTermsAggregation().
+		Field("target").Size(1000).OrderByTermAsc().
+		SubAggregation("success", elastic.NewSumAggregation().Field("success")).
+		SubAggregation("faileds", elastic.NewSumAggregation().Field("faileds")).
+		SubAggregation("max_delay", elastic.NewMaxAggregation().Field("max_delay")).
+		SubAggregation("p95_delay", elastic.NewMaxAggregation().Field("p95_delay")).
+		SubAggregation("group", elastic.NewTermsAggregation().Field("group").Size(1))
+	result, err := conn.Search(elasticHourlyIndexPrefix+"*").
+		Type(elasticHourlyType).Size(0).
+		Query(query).Aggregation("target", aggsTarget).
+		Do(ctx)
+	if err != nil {
+		return nil, err
+	}
+	reports := make(SLAReports, 0, 64)
+	term, ok := result.Aggregations.Terms("target")
+	if !ok {
+		return reports, nil
+	}
+	for _, b := range term.Buckets {
+		rp := SLAReport{Heapster: heapster}
+		rp.Target, _ = b.Key.(string)
+		if success, ok := b.Sum("success"); ok && success.Value != nil {
+			rp.Success = int(*success.Value)
+		}
+		if faileds, ok := b.Sum("faileds"); ok && faileds.Value != nil {
+			rp.Faileds = int(*faileds.Value)
+		}
+		if maxDelay, ok := b.Max("max_delay"); ok && maxDelay.Value != nil {
+			rp.MaxDelay = time.Duration(*maxDelay.Value)
+		}
+		// 小时的P95不能合并, 取最差的小时
+		if p95Delay, ok := b.Max("p95_delay"); ok && p95Delay.Value != nil {
+			rp.P95Delay = time.Duration(*p95Delay.Value)
+		}
+		if group, ok := b.Terms("group"); ok && len(group.Buckets) > 0 {
+			rp.Group, _ = group.Buckets[0].Key.(string)
+		}
+		if total := rp.Success + rp.Faileds; total > 0 {
+			rp.Availability = float64(rp.Success) / float64(total)
+		}
+		reports = append(reports, rp)
+	}
+	return reports, nil
+}
@@ -0,0 +1,268 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestExpiredIndices(t *testing.T) {
+	names := []string{
+		"gamehealthy-2019.03.02",
+		"gamehealthy-2019.03.01",
+		"gamehealthy-2019.03.03",
+		"gamehealthysla-2019.01",
+		"gamehealthysla-2019.02",
+		"gamehealthy-bad",
+		".kibana",
+	}
+	day := func(t time.Time) time.Time { return t.AddDate(0, 0, 1) }
+	month := func(t time.Time) time.Time { return t.AddDate(0, 1, 0) }
+	// 东八区3月3日0点, 3月2日的索引刚好结束
+	before := time.Date(2019, 3, 3, 0, 0, 0, 0, elasticIndexZone)
+	assert.Equal(t, []string{"gamehealthy-2019.03.01", "gamehealthy-2019.03.02"},
+		expiredIndices(names, elasticProbeIndexPrefix, "2006.01.02", day, before))
+	assert.Equal(t, []string{"gamehealthy-2019.03.01"},
+		expiredIndices(names, elasticProbeIndexPrefix, "2006.01.02", day, before.Add(-time.Second)))
+	assert.Equal(t, []string{"gamehealthysla-2019.01", "gamehealthysla-2019.02"},
+		expiredIndices(names, elasticHourlyIndexPrefix, "2006.01", month, before))
+	assert.Equal(t, []string{"gamehealthysla-2019.01"},
+		expiredIndices(names, elasticHourlyIndexPrefix, "2006.01", month, before.AddDate(0, 0, -15)))
+}
+
+func TestElasticHourlyIndex(t *testing.T) {
+	// UTC时间按照东八区划分索引
+	hour := time.Date(2019, 2, 28, 16, 0, 0, 0, time.UTC)
+	assert.Equal(t, "gamehealthysla-2019.03", elasticHourlyIndex(hour))
+	rp := HourlyReport{Timestamp: hour, Heapster: "h", Target: "t"}
+	other := rp
+	other.Success = 10
+	assert.Equal(t, elasticHourlyDocID(rp), elasticHourlyDocID(other))
+}
+
+// fakeElastic 模拟管理索引用到的Elastic接口, 记录收到的请求
+type fakeElastic struct {
+	mtx       sync.Mutex
+	templates map[string]int
+	puts      []string
+	watermark string
+	indices   []string
+	deleted   []string
+	closed    []string
+	searches  []string
+	bulk      []string
+	// aggs 按照查询返回聚合结果
+	aggs func(query string) string
+}
+
+func newFakeElastic(indices ...string) *fakeElastic {
+	return &fakeElastic{
+		templates: make(map[string]int),
+		indices:   indices,
+		aggs:      func(string) string { return `{}` },
+	}
+}
+
+func (fe *fakeElastic) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fe.mtx.Lock()
+	defer fe.mtx.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	w.Header().Set("Content-Type", "application/json")
+	path := strings.Trim(r.URL.Path, "/")
+	parts := strings.Split(path, "/")
+	switch {
+	case parts[0] == "_template" && r.Method == "GET":
+		version, ok := fe.templates[parts[1]]
+		if !ok {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{}`))
+			return
+		}
+		fmt.Fprintf(w, `{"%s":{"version":%d}}`, parts[1], version)
+	case parts[0] == "_template" && r.Method == "PUT":
+		tmpl := struct {
+			Version int `json:"version"`
+		}{}
+		json.Unmarshal(body, &tmpl)
+		fe.templates[parts[1]] = tmpl.Version
+		fe.puts = append(fe.puts, parts[1])
+		w.Write([]byte(`{"acknowledged":true}`))
+	case path == elasticWatermarkIndex+"/"+elasticWatermarkType+"/"+elasticWatermarkID:
+		if r.Method == "PUT" {
+			fe.watermark = string(body)
+			w.Write([]byte(`{"_index":"` + elasticWatermarkIndex + `","_id":"hourly","result":"updated"}`))
+			return
+		}
+		if fe.watermark == "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"found":false}`))
+			return
+		}
+		fmt.Fprintf(w, `{"_index":"%s","_id":"hourly","found":true,"_source":%s}`, elasticWatermarkIndex, fe.watermark)
+	case path == "_all/_settings":
+		settings := make(map[string]interface{})
+		for _, name := range fe.indices {
+			settings[name] = map[string]interface{}{"settings": map[string]interface{}{}}
+		}
+		json.NewEncoder(w).Encode(settings)
+	case path == "_bulk":
+		lines := strings.Split(strings.TrimSpace(string(body)), "\n")
+		items := make([]string, 0, len(lines)/2)
+		for i := 0; i+1 < len(lines); i += 2 {
+			fe.bulk = append(fe.bulk, lines[i])
+			items = append(items, `{"index":{"status":201}}`)
+		}
+		fmt.Fprintf(w, `{"errors":false,"items":[%s]}`, strings.Join(items, ","))
+	case len(parts) == 3 && parts[2] == "_search":
+		fe.searches = append(fe.searches, parts[0]+" "+string(body))
+		fmt.Fprintf(w, `{"hits":{"total":0,"hits":[]},"aggregations":%s}`, fe.aggs(string(body)))
+	case len(parts) == 2 && parts[1] == "_close":
+		fe.closed = append(fe.closed, parts[0])
+		w.Write([]byte(`{"acknowledged":true}`))
+	case r.Method == "DELETE":
+		fe.deleted = append(fe.deleted, strings.Split(parts[0], ",")...)
+		w.Write([]byte(`{"acknowledged":true}`))
+	default:
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(`{}`))
+	}
+}
+
+// withFakeElastic 使用模拟的Elastic
+func withFakeElastic(fe *fakeElastic) (context.Context, func()) {
+	server := httptest.NewServer(fe)
+	ctx := middlewares.WithLogger(context.Background(), 0, ioutil.Discard)
+	ctx = middlewares.WithElasticConn(ctx, []string{server.URL}, "", "")
+	return ctx, server.Close
+}
+
+func TestInstallElasticTemplates(t *testing.T) {
+	fe := newFakeElastic()
+	ctx, cleanup := withFakeElastic(fe)
+	defer cleanup()
+
+	// 没有的安装, 已经是当前版本的跳过, 旧版本的升级
+	fe.templates["gamehealthy"] = elasticTemplateVersion
+	assert.NoError(t, InstallElasticTemplates(ctx))
+	assert.Equal(t, []string{"gamehealthysla"}, fe.puts)
+	assert.Equal(t, elasticTemplateVersion, fe.templates["gamehealthysla"])
+
+	fe.templates["gamehealthy"] = elasticTemplateVersion - 1
+	assert.NoError(t, InstallElasticTemplates(ctx))
+	assert.Equal(t, []string{"gamehealthysla", "gamehealthy"}, fe.puts)
+}
+
+const testHourlyAggs = `{"heapster":{"buckets":[{"key":"hp1","doc_count":3,"target":{"buckets":[
+	{"key":"10.0.0.1:80","doc_count":3,
+	"success":{"value":2},"faileds":{"value":1},"max_delay":{"value":40000000},
+	"delay_percentiles":{"values":{"50.0":10000000,"95.0":40000000}},
+	"group":{"buckets":[{"key":"g1","doc_count":3}]}}]}}]}}`
+
+func TestDownsampleProbeLogs(t *testing.T) {
+	fe := newFakeElastic("gamehealthy-2019.03.03", ".kibana")
+	fe.aggs = func(string) string { return testHourlyAggs }
+	ctx, cleanup := withFakeElastic(fe)
+	defer cleanup()
+
+	// 第一次从最早的日志索引开始统计到上一个完整的小时
+	now := time.Date(2019, 3, 3, 3, 5, 0, 0, elasticIndexZone)
+	watermark, err := DownsampleProbeLogs(ctx, time.Hour, now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2019, 3, 3, 2, 0, 0, 0, elasticIndexZone).Equal(watermark), "%v", watermark)
+	assert.Len(t, fe.searches, 2)
+	assert.True(t, strings.HasPrefix(fe.searches[0], "gamehealthy-* "))
+	assert.Len(t, fe.bulk, 2)
+	assert.Contains(t, fe.bulk[0], `"_index":"gamehealthysla-2019.03"`)
+	assert.Contains(t, fe.bulk[0], `"_type":"hourly"`)
+
+	// 之后每次重新统计window里的小时, 晚到的日志也会统计
+	fe.searches = nil
+	now = now.Add(2 * time.Hour)
+	watermark, err = DownsampleProbeLogs(ctx, 4*time.Hour, now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2019, 3, 3, 4, 0, 0, 0, elasticIndexZone).Equal(watermark), "%v", watermark)
+	assert.Len(t, fe.searches, 3)
+
+	// 没有日志的时候进度也推进
+	fe.searches = nil
+	fe.bulk = nil
+	fe.aggs = func(string) string { return `{}` }
+	now = now.Add(5 * time.Hour)
+	watermark, err = DownsampleProbeLogs(ctx, time.Hour, now)
+	assert.NoError(t, err)
+	assert.True(t, time.Date(2019, 3, 3, 9, 0, 0, 0, elasticIndexZone).Equal(watermark), "%v", watermark)
+	assert.Len(t, fe.searches, 5)
+	assert.Len(t, fe.bulk, 0)
+	fe.searches = nil
+	_, err = DownsampleProbeLogs(ctx, 2*time.Hour, now)
+	assert.NoError(t, err)
+	assert.Len(t, fe.searches, 1)
+}
+
+func TestExpireProbeIndices(t *testing.T) {
+	fe := newFakeElastic(
+		"gamehealthy-2019.03.01", "gamehealthy-2019.03.02", "gamehealthy-2019.03.03",
+		"gamehealthysla-2018.01", "gamehealthysla-2019.03", elasticWatermarkIndex,
+	)
+	ctx, cleanup := withFakeElastic(fe)
+	defer cleanup()
+
+	before := time.Date(2019, 3, 3, 0, 0, 0, 0, elasticIndexZone)
+	slaBefore := before.AddDate(-1, 0, 0)
+	expired, err := ExpireProbeIndices(ctx, "", before, slaBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gamehealthy-2019.03.01", "gamehealthy-2019.03.02", "gamehealthysla-2018.01"}, expired)
+	assert.Equal(t, expired, fe.deleted)
+
+	// 关闭日志索引, 小时统计还是删除
+	fe.deleted = nil
+	_, err = ExpireProbeIndices(ctx, ElasticRetentionClose, before, slaBefore)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"gamehealthy-2019.03.01", "gamehealthy-2019.03.02"}, fe.closed)
+	assert.Equal(t, []string{"gamehealthysla-2018.01"}, fe.deleted)
+
+	_, err = ExpireProbeIndices(ctx, "unknown", before, slaBefore)
+	assert.Error(t, err)
+}
+
+const testSLAAggs = `{"target":{"buckets":[
+	{"key":"10.0.0.1:80","doc_count":24,
+	"success":{"value":99},"faileds":{"value":1},"max_delay":{"value":90000000},"p95_delay":{"value":40000000},
+	"group":{"buckets":[{"key":"g1","doc_count":24}]}},
+	{"key":"10.0.0.2:80","doc_count":24,
+	"success":{"value":0},"faileds":{"value":0},"max_delay":{"value":null},"p95_delay":{"value":null},
+	"group":{"buckets":[]}}]}}`
+
+func TestFetchSLA(t *testing.T) {
+	fe := newFakeElastic()
+	fe.aggs = func(string) string { return testSLAAggs }
+	ctx, cleanup := withFakeElastic(fe)
+	defer cleanup()
+
+	now := time.Now()
+	rps, err := FetchSLA(ctx, "hp1", now.AddDate(0, 0, -30), now)
+	assert.NoError(t, err)
+	assert.Len(t, fe.searches, 1)
+	assert.True(t, strings.HasPrefix(fe.searches[0], "gamehealthysla-* "))
+	assert.Contains(t, fe.searches[0], `"heapster":"hp1"`)
+	assert.Equal(t, SLAReports{
+		{
+			Heapster: "hp1", Target: "10.0.0.1:80", Group: "g1",
+			Success: 99, Faileds: 1, Availability: 0.99,
+			MaxDelay: 90 * time.Millisecond, P95Delay: 40 * time.Millisecond,
+		},
+		{Heapster: "hp1", Target: "10.0.0.2:80"},
+	}, rps)
+}
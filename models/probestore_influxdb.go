@@ -0,0 +1,182 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	influxdb "github.com/influxdata/influxdb/client/v2"
+)
+
+// 每种检查类型一个measurement, 查询时用正则匹配全部
+const influxProbeMeasurement = "probe"
+
+// influxProbeStore 探测日志写入InfluxDB, 连接来自上下文
+type influxProbeStore struct{}
+
+// influxMeasurement 按检查类型区分measurement
+func influxMeasurement(typ CheckType) string {
+	if typ == "" {
+		return influxProbeMeasurement
+	}
+	return fmt.Sprintf("%s_%s", influxProbeMeasurement, typ)
+}
+
+// Write 批量写入, heapster/target和heapster的标签name/group/location作为tag, 空值的tag不写
+func (influxProbeStore) Write(ctx context.Context, logs ProbeLogs) error {
+	client := middlewares.GetInfluxDB(ctx)
+	bp, err := influxdb.NewBatchPoints(influxdb.BatchPointsConfig{
+		Database:  middlewares.GetInfluxDBName(ctx),
+		Precision: "ns",
+	})
+	if err != nil {
+		return err
+	}
+	for _, doc := range logs {
+		tags := map[string]string{
+			"heapster": doc.Heapster,
+			"target":   doc.Target,
+		}
+		if doc.Name != "" {
+			tags["name"] = doc.Name
+		}
+		if doc.Group != "" {
+			tags["group"] = doc.Group
+		}
+		if doc.Location != "" {
+			tags["location"] = doc.Location
+		}
+		fields := map[string]interface{}{
+			"elapsed": int64(doc.Elapsed),
+			"success": doc.Success,
+			"failed":  doc.Failed,
+		}
+		pt, err := influxdb.NewPoint(influxMeasurement(doc.Type), tags, fields, doc.Timestamp)
+		if err != nil {
+			return err
+		}
+		bp.AddPoint(pt)
+	}
+	if err := client.Write(bp); err != nil {
+		return fmt.Errorf("save report error %v", err)
+	}
+	return nil
+}
+
+// Aggregate 两个查询, 第一个按目标统计, 第二个按目标和探测点统计成功失败
+func (influxProbeStore) Aggregate(ctx context.Context, heapster string, since time.Time) (Reports, error) {
+	client := middlewares.GetInfluxDB(ctx)
+	where := fmt.Sprintf(`"heapster" = '%s' AND time >= '%s'`,
+		influxEscape(heapster), since.UTC().Format(time.RFC3339Nano))
+	command := strings.Join([]string{
+		fmt.Sprintf(`SELECT count("success"), sum("success"), sum("failed"), max("elapsed"), percentile("elapsed", 50), percentile("elapsed", 95) FROM /^%s/ WHERE %s GROUP BY "target"`,
+			influxProbeMeasurement, where),
+		fmt.Sprintf(`SELECT count("success"), sum("success"), sum("failed") FROM /^%s/ WHERE %s GROUP BY "target", "group", "location"`,
+			influxProbeMeasurement, where),
+	}, "; ")
+	resp, err := client.Query(influxdb.NewQuery(command, middlewares.GetInfluxDBName(ctx), "ns"))
+	if err != nil {
+		return nil, err
+	}
+	if resp.Error() != nil {
+		return nil, resp.Error()
+	}
+	if len(resp.Results) != 2 {
+		return nil, fmt.Errorf("influxdb query return %d results", len(resp.Results))
+	}
+
+	targets := make(map[string]*localProbeAggs)
+	counts := make(map[string]int)
+	for _, row := range resp.Results[0].Series {
+		target := row.Tags["target"]
+		aggs, ok := targets[target]
+		if !ok {
+			aggs = &localProbeAggs{
+				report: Report{
+					Heapster: heapster,
+					Target:   target,
+				},
+				locations: make(map[string]*localLocationAggs),
+			}
+			targets[target] = aggs
+		}
+		for _, values := range row.Values {
+			count := influxInt(values, 1)
+			aggs.report.Success += influxInt(values, 2)
+			aggs.report.Faileds += influxInt(values, 3)
+			if maxDelay := time.Duration(influxInt(values, 4)); maxDelay > aggs.report.MaxDelay {
+				aggs.report.MaxDelay = maxDelay
+			}
+			// 检查类型修改过会有多个measurement, 百分位使用数据多的
+			if count > counts[target] {
+				counts[target] = count
+				aggs.report.P50Delay = time.Duration(influxInt(values, 5))
+				aggs.report.P95Delay = time.Duration(influxInt(values, 6))
+			}
+		}
+	}
+	for _, row := range resp.Results[1].Series {
+		aggs, ok := targets[row.Tags["target"]]
+		if !ok {
+			continue
+		}
+		if aggs.report.Group == "" {
+			aggs.report.Group = row.Tags["group"]
+		}
+		location := row.Tags["location"]
+		if location == "" {
+			location = DefaultProbeLocation
+		}
+		lrp, ok := aggs.locations[location]
+		if !ok {
+			lrp = &localLocationAggs{
+				LocationReport: LocationReport{
+					Location: location,
+				},
+			}
+			aggs.locations[location] = lrp
+		}
+		for _, values := range row.Values {
+			lrp.count += influxInt(values, 1)
+			lrp.Success += influxInt(values, 2)
+			lrp.Faileds += influxInt(values, 3)
+		}
+	}
+
+	reports := make(Reports, 0, len(targets))
+	for _, aggs := range targets {
+		rp := aggs.report
+		rp.Locations = sortLocations(aggs.locations)
+		reports = append(reports, rp)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Target < reports[j].Target
+	})
+	return reports, nil
+}
+
+// influxInt 读取查询结果的数值列, 空值是0
+func influxInt(values []interface{}, i int) int {
+	if i >= len(values) {
+		return 0
+	}
+	switch v := values[i].(type) {
+	case json.Number:
+		f, _ := v.Float64()
+		return int(f)
+	case float64:
+		return int(v)
+	default:
+		return 0
+	}
+}
+
+// influxEscape 转义InfluxQL字符串里的单引号
+func influxEscape(s string) string {
+	return strings.Replace(strings.Replace(s, `\`, `\\`, -1), `'`, `\'`, -1)
+}
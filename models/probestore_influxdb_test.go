@@ -0,0 +1,81 @@
+package models
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const influxTestResponse = `{"results":[
+{"statement_id":0,"series":[
+	{"name":"probe_tcp","tags":{"target":"10.0.0.1:80"},"columns":["time","count","sum","sum_1","max","percentile","percentile_1"],"values":[[0,3,2,1,40000000,20000000,40000000]]},
+	{"name":"probe_tcp","tags":{"target":"10.0.0.2:80"},"columns":["time","count","sum","sum_1","max","percentile","percentile_1"],"values":[[0,1,1,0,10000000,10000000,10000000]]}
+]},
+{"statement_id":1,"series":[
+	{"name":"probe_tcp","tags":{"target":"10.0.0.1:80","group":"g1","location":""},"columns":["time","count","sum","sum_1"],"values":[[0,2,2,0]]},
+	{"name":"probe_tcp","tags":{"target":"10.0.0.1:80","group":"g1","location":"sh"},"columns":["time","count","sum","sum_1"],"values":[[0,1,0,1]]},
+	{"name":"probe_tcp","tags":{"target":"10.0.0.2:80","group":"g1","location":""},"columns":["time","count","sum","sum_1"],"values":[[0,1,1,0]]}
+]}]}`
+
+func TestInfluxProbeStore(t *testing.T) {
+	var written, query string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/write":
+			data, _ := ioutil.ReadAll(r.Body)
+			written = string(data)
+			w.WriteHeader(http.StatusNoContent)
+		case "/query":
+			query = r.FormValue("q")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write([]byte(influxTestResponse))
+		}
+	}))
+	defer server.Close()
+
+	ctx, err := middlewares.WithInfluxDB(context.Background(), server.URL, "", "", "gamehealthy")
+	assert.NoError(t, err)
+	ctx = WithProbeStore(ctx, influxProbeStore{})
+
+	pls := ProbeLogs{
+		{Heapster: "hp1", Name: "room", Target: "10.0.0.1:80", Group: "g1", Type: CheckTypeTCP, Success: 1, Elapsed: 20 * time.Millisecond},
+		{Heapster: "hp1", Target: "10.0.0.1:80", Group: "g1", Type: CheckTypeTCP, Location: "sh", Failed: 1},
+	}
+	assert.NoError(t, pls.Save(ctx))
+	lines := strings.Split(strings.TrimSpace(written), "\n")
+	assert.Len(t, lines, 2)
+	assert.True(t, strings.HasPrefix(lines[0], "probe_tcp,group=g1,heapster=hp1,name=room,target=10.0.0.1:80 "))
+	assert.Contains(t, lines[0], "elapsed=20000000i")
+	assert.True(t, strings.HasPrefix(lines[1], "probe_tcp,group=g1,heapster=hp1,location=sh,target=10.0.0.1:80 "))
+
+	rps, err := FetchReportsAggs(ctx, "hp1", time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Contains(t, query, `"heapster" = 'hp1'`)
+	assert.Len(t, rps, 2)
+
+	rp := rps[0]
+	assert.Equal(t, "10.0.0.1:80", rp.Target)
+	assert.Equal(t, "g1", rp.Group)
+	assert.Equal(t, 2, rp.Success)
+	assert.Equal(t, 1, rp.Faileds)
+	assert.Equal(t, 40*time.Millisecond, rp.MaxDelay)
+	assert.Equal(t, 20*time.Millisecond, rp.P50Delay)
+	assert.Equal(t, 40*time.Millisecond, rp.P95Delay)
+	assert.Equal(t, []LocationReport{
+		{Location: DefaultProbeLocation, Success: 2},
+		{Location: "sh", Faileds: 1},
+	}, rp.Locations)
+	assert.Equal(t, "10.0.0.2:80", rps[1].Target)
+}
+
+func TestInfluxEscape(t *testing.T) {
+	assert.Equal(t, `a\'b\\c`, influxEscape(`a'b\c`))
+}
@@ -0,0 +1,293 @@
+package models
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"math"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 每个heapster一个子bucket, key是8字节时间戳加8字节序号, 按时间有序
+const localProbeBucket = "probelog"
+
+// 过期数据清理间隔
+const localProbePurgeInterval = 10 * time.Minute
+
+// LocalProbeStore 嵌入式时序存储, 数据保存在本地文件, 超过保留时间的自动删除
+type LocalProbeStore struct {
+	db        *bolt.DB
+	retention time.Duration
+
+	mtx       sync.Mutex
+	lastPurge time.Time
+}
+
+// OpenLocalProbeStore 打开存储文件, 不存在时创建, retention为0时使用默认值
+func OpenLocalProbeStore(path string, retention time.Duration) (*LocalProbeStore, error) {
+	if retention <= 0 {
+		retention = DefaultProbeRetention
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(localProbeBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &LocalProbeStore{
+		db:        db,
+		retention: retention,
+		lastPurge: time.Now(),
+	}, nil
+}
+
+// Close 关闭存储文件
+func (ps *LocalProbeStore) Close() error {
+	return ps.db.Close()
+}
+
+// Write 写入探测日志, 同时定期清理过期数据
+func (ps *LocalProbeStore) Write(ctx context.Context, logs ProbeLogs) error {
+	err := ps.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(localProbeBucket))
+		for _, doc := range logs {
+			b, err := root.CreateBucketIfNotExists([]byte(doc.Heapster))
+			if err != nil {
+				return err
+			}
+			seq, err := b.NextSequence()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(doc)
+			if err != nil {
+				return err
+			}
+			if err := b.Put(localProbeKey(doc.Timestamp, seq), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	ps.mtx.Lock()
+	purge := time.Since(ps.lastPurge) >= localProbePurgeInterval
+	if purge {
+		ps.lastPurge = time.Now()
+	}
+	ps.mtx.Unlock()
+	if purge {
+		return ps.Purge(time.Now().Add(-ps.retention))
+	}
+	return nil
+}
+
+// Purge 删除before之前的数据
+func (ps *LocalProbeStore) Purge(before time.Time) error {
+	end := localProbeKey(before, 0)
+	return ps.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket([]byte(localProbeBucket))
+		var empties [][]byte
+		err := root.ForEach(func(name, v []byte) error {
+			b := root.Bucket(name)
+			if b == nil {
+				return nil
+			}
+			// 游标删除之后会跳过下一个, 先收集再删除
+			var keys [][]byte
+			c := b.Cursor()
+			for k, _ := c.First(); k != nil && string(k) < string(end); k, _ = c.Next() {
+				keys = append(keys, append([]byte(nil), k...))
+			}
+			for _, k := range keys {
+				if err := b.Delete(k); err != nil {
+					return err
+				}
+			}
+			if k, _ := c.First(); k == nil {
+				empties = append(empties, append([]byte(nil), name...))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, name := range empties {
+			if err := root.DeleteBucket(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// localProbeAggs 单个目标的聚合中间结果
+type localProbeAggs struct {
+	report    Report
+	elapseds  []time.Duration
+	locations map[string]*localLocationAggs
+}
+
+type localLocationAggs struct {
+	LocationReport
+	count int
+}
+
+// Aggregate 遍历时间范围内的日志, 按照目标聚合, 结果和ES的聚合一致
+func (ps *LocalProbeStore) Aggregate(ctx context.Context, heapster string, since time.Time) (Reports, error) {
+	pa := newProbeAggregator(heapster)
+	err := ps.db.View(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(localProbeBucket)).Bucket([]byte(heapster))
+		if b == nil {
+			return nil
+		}
+		c := b.Cursor()
+		for k, v := c.Seek(localProbeKey(since, 0)); k != nil; k, v = c.Next() {
+			doc := ProbeLog{}
+			if err := json.Unmarshal(v, &doc); err != nil {
+				return err
+			}
+			pa.add(doc)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return pa.reports(), nil
+}
+
+// probeAggregator 在内存里按照目标聚合一个heapster的日志
+type probeAggregator struct {
+	heapster string
+	targets  map[string]*localProbeAggs
+}
+
+func newProbeAggregator(heapster string) *probeAggregator {
+	return &probeAggregator{
+		heapster: heapster,
+		targets:  make(map[string]*localProbeAggs),
+	}
+}
+
+func (pa *probeAggregator) add(doc ProbeLog) {
+	aggs, ok := pa.targets[doc.Target]
+	if !ok {
+		aggs = &localProbeAggs{
+			report: Report{
+				Heapster: pa.heapster,
+				Target:   doc.Target,
+			},
+			locations: make(map[string]*localLocationAggs),
+		}
+		pa.targets[doc.Target] = aggs
+	}
+	aggs.add(doc)
+}
+
+// reports 按照目标排序的聚合结果
+func (pa *probeAggregator) reports() Reports {
+	reports := make(Reports, 0, len(pa.targets))
+	for _, aggs := range pa.targets {
+		reports = append(reports, aggs.result())
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].Target < reports[j].Target
+	})
+	return reports
+}
+
+func (aggs *localProbeAggs) add(doc ProbeLog) {
+	aggs.report.Success += doc.Success
+	aggs.report.Faileds += doc.Failed
+	if doc.Elapsed > aggs.report.MaxDelay {
+		aggs.report.MaxDelay = doc.Elapsed
+	}
+	if aggs.report.Group == "" {
+		aggs.report.Group = doc.Group
+	}
+	aggs.elapseds = append(aggs.elapseds, doc.Elapsed)
+
+	location := doc.Location
+	if location == "" {
+		location = DefaultProbeLocation
+	}
+	lrp, ok := aggs.locations[location]
+	if !ok {
+		lrp = &localLocationAggs{
+			LocationReport: LocationReport{
+				Location: location,
+			},
+		}
+		aggs.locations[location] = lrp
+	}
+	lrp.Success += doc.Success
+	lrp.Faileds += doc.Failed
+	lrp.count++
+}
+
+func (aggs *localProbeAggs) result() Report {
+	rp := aggs.report
+	sort.Slice(aggs.elapseds, func(i, j int) bool {
+		return aggs.elapseds[i] < aggs.elapseds[j]
+	})
+	rp.P50Delay = percentile(aggs.elapseds, 50)
+	rp.P95Delay = percentile(aggs.elapseds, 95)
+	rp.Locations = sortLocations(aggs.locations)
+	return rp
+}
+
+// sortLocations 和ES的terms聚合一样按照文档数量排序
+func sortLocations(aggs map[string]*localLocationAggs) []LocationReport {
+	locations := make([]*localLocationAggs, 0, len(aggs))
+	for _, lrp := range aggs {
+		locations = append(locations, lrp)
+	}
+	sort.Slice(locations, func(i, j int) bool {
+		if locations[i].count != locations[j].count {
+			return locations[i].count > locations[j].count
+		}
+		return locations[i].Location < locations[j].Location
+	})
+	var ret []LocationReport
+	for _, lrp := range locations {
+		ret = append(ret, lrp.LocationReport)
+	}
+	return ret
+}
+
+// percentile 有序数据的百分位数, 相邻两个值之间线性插值
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	if lower == upper {
+		return sorted[lower]
+	}
+	weight := rank - float64(lower)
+	return sorted[lower] + time.Duration(weight*float64(sorted[upper]-sorted[lower]))
+}
+
+// localProbeKey 时间戳在前保证按时间有序, 序号避免同一时间的日志覆盖
+func localProbeKey(t time.Time, seq uint64) []byte {
+	key := make([]byte, 16)
+	binary.BigEndian.PutUint64(key[:8], uint64(t.UnixNano()))
+	binary.BigEndian.PutUint64(key[8:], seq)
+	return key
+}
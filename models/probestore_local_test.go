@@ -0,0 +1,97 @@
+package models
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func withLocalProbeStore(t *testing.T) (context.Context, *LocalProbeStore, func()) {
+	dir, err := ioutil.TempDir("", "gamehealthy")
+	assert.NoError(t, err)
+	store, err := OpenLocalProbeStore(filepath.Join(dir, "probe.db"), 0)
+	assert.NoError(t, err)
+	return WithProbeStore(context.Background(), store), store, func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestLocalProbeStoreAggregate(t *testing.T) {
+	ctx, _, cleanup := withLocalProbeStore(t)
+	defer cleanup()
+
+	pls := ProbeLogs{
+		{Heapster: "hp1", Target: "10.0.0.2:80", Group: "g1", Success: 1, Elapsed: 10 * time.Millisecond},
+		{Heapster: "hp1", Target: "10.0.0.1:80", Group: "g1", Success: 1, Elapsed: 20 * time.Millisecond},
+		{Heapster: "hp1", Target: "10.0.0.1:80", Group: "g1", Failed: 1, Location: "sh"},
+		{Heapster: "hp1", Target: "10.0.0.1:80", Group: "g1", Success: 1, Elapsed: 40 * time.Millisecond},
+		{Heapster: "hp2", Target: "10.0.0.1:80", Success: 1},
+		// 没有目标的不保存
+		{Heapster: "hp1"},
+	}
+	assert.NoError(t, pls.Save(ctx))
+
+	rps, err := FetchReportsAggs(ctx, "hp1", time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, rps, 2)
+
+	rp := rps[0]
+	assert.Equal(t, "10.0.0.1:80", rp.Target)
+	assert.Equal(t, "g1", rp.Group)
+	assert.Equal(t, 2, rp.Success)
+	assert.Equal(t, 1, rp.Faileds)
+	assert.Equal(t, 40*time.Millisecond, rp.MaxDelay)
+	assert.Equal(t, 20*time.Millisecond, rp.P50Delay)
+	assert.Equal(t, []LocationReport{
+		{Location: DefaultProbeLocation, Success: 2},
+		{Location: "sh", Faileds: 1},
+	}, rp.Locations)
+	assert.Equal(t, "10.0.0.2:80", rps[1].Target)
+
+	// 时间范围之外的不统计
+	rps, err = FetchReportsAggs(ctx, "hp1", time.Now().Add(time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, rps, 0)
+
+	rps, err = FetchReportsAggs(ctx, "hp3", time.Now().Add(-time.Minute))
+	assert.NoError(t, err)
+	assert.Len(t, rps, 0)
+}
+
+func TestLocalProbeStorePurge(t *testing.T) {
+	ctx, store, cleanup := withLocalProbeStore(t)
+	defer cleanup()
+
+	old := ProbeLogs{
+		{Heapster: "hp1", Target: "10.0.0.1:80", Success: 1, Timestamp: time.Now().Add(-time.Hour)},
+	}
+	assert.NoError(t, store.Write(ctx, old))
+	assert.NoError(t, ProbeLogs{{Heapster: "hp1", Target: "10.0.0.1:80", Failed: 1}}.Save(ctx))
+
+	assert.NoError(t, store.Purge(time.Now().Add(-time.Minute)))
+	rps, err := FetchReportsAggs(ctx, "hp1", time.Now().Add(-2*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, rps, 1)
+	assert.Equal(t, 0, rps[0].Success)
+	assert.Equal(t, 1, rps[0].Faileds)
+
+	// 全部过期之后删除heapster的bucket
+	assert.NoError(t, store.Purge(time.Now().Add(time.Minute)))
+	rps, err = FetchReportsAggs(ctx, "hp1", time.Now().Add(-2*time.Hour))
+	assert.NoError(t, err)
+	assert.Len(t, rps, 0)
+}
+
+func TestPercentile(t *testing.T) {
+	assert.Equal(t, time.Duration(0), percentile(nil, 50))
+	values := []time.Duration{10, 20, 30, 40}
+	assert.Equal(t, time.Duration(25), percentile(values, 50))
+	assert.Equal(t, time.Duration(38), percentile(values, 95))
+	assert.Equal(t, time.Duration(40), percentile(values, 100))
+}
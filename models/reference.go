@@ -0,0 +1,309 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Ref 对其他实体的引用, 查询使用情况时带上引用方的名称
+type Ref struct {
+	Kind string       `json:"kind"`
+	ID   SerialNumber `json:"id"`
+	Name string       `json:"name,omitempty"`
+}
+
+// InUseError 实体还在被引用, 不能直接删除
+type InUseError struct {
+	Kind      string
+	ID        SerialNumber
+	Referrers []Ref
+}
+
+func (e *InUseError) Error() string {
+	var refs []string
+	for _, ref := range e.Referrers {
+		refs = append(refs, ref.String())
+	}
+	return fmt.Sprintf("%s %s is used by %s", e.Kind, e.ID, strings.Join(refs, ", "))
+}
+
+// String 存储里使用的格式, 实体类型里没有下划线
+func (ref Ref) String() string {
+	return ref.Kind + "_" + string(ref.ID)
+}
+
+// parseRef 解析存储里的引用
+func parseRef(s string) (Ref, bool) {
+	parts := strings.SplitN(s, "_", 2)
+	if len(parts) != 2 {
+		return Ref{}, false
+	}
+	return Ref{Kind: parts[0], ID: SerialNumber(parts[1])}, true
+}
+
+// kindModel 存储的模型, 保存时记录引用, 删除被引用的实体时可以去掉引用
+type kindModel interface {
+	Validate() error
+	// refs 引用的其他实体
+	refs() []Ref
+	// unlink 去掉对ref的引用
+	unlink(ref Ref)
+}
+
+// newKindModel 实体类型对应的模型
+func newKindModel(kind string) (kindModel, error) {
+	switch kind {
+	case kindHeapster:
+		return &Heapster{}, nil
+	case kindGroup:
+		return &Group{}, nil
+	case kindNotifier:
+		return &HeapsterNotifier{}, nil
+	case kindEscalation:
+		return &EscalationPolicy{}, nil
+	case kindTemplate:
+		return &MessageTemplate{}, nil
+	default:
+		return nil, fmt.Errorf("unknown kind %s", kind)
+	}
+}
+
+// appendRefs 添加引用, 去掉空的和重复的
+func appendRefs(refs []Ref, kind string, ids ...string) []Ref {
+	for _, id := range ids {
+		if id == "" {
+			continue
+		}
+		ref := Ref{Kind: kind, ID: SerialNumber(id)}
+		found := false
+		for _, r := range refs {
+			if r == ref {
+				found = true
+				break
+			}
+		}
+		if !found {
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+// removeID 从列表里去掉id
+func removeID(ids []string, id SerialNumber) []string {
+	var ret []string
+	for _, v := range ids {
+		if v != string(id) {
+			ret = append(ret, v)
+		}
+	}
+	return ret
+}
+
+func (hst *Heapster) refs() []Ref {
+	refs := appendRefs(nil, kindGroup, hst.Groups...)
+	refs = appendRefs(refs, kindNotifier, hst.Notifiers...)
+	refs = appendRefs(refs, kindEscalation, hst.Escalation)
+	refs = appendRefs(refs, kindHeapster, hst.Depends...)
+	return appendRefs(refs, kindHeapster, dependTargetIDs(hst.DependTargets)...)
+}
+
+func (hst *Heapster) unlink(ref Ref) {
+	switch ref.Kind {
+	case kindGroup:
+		hst.Groups = removeID(hst.Groups, ref.ID)
+	case kindNotifier:
+		hst.Notifiers = removeID(hst.Notifiers, ref.ID)
+	case kindEscalation:
+		if hst.Escalation == string(ref.ID) {
+			hst.Escalation = ""
+		}
+	case kindHeapster:
+		hst.Depends = removeID(hst.Depends, ref.ID)
+		hst.DependTargets = removeDependTargets(hst.DependTargets, ref.ID)
+	}
+}
+
+func (g *Group) refs() []Ref {
+	refs := appendRefs(nil, kindHeapster, g.Depends...)
+	return appendRefs(refs, kindHeapster, dependTargetIDs(g.DependTargets)...)
+}
+
+func (g *Group) unlink(ref Ref) {
+	if ref.Kind == kindHeapster {
+		g.Depends = removeID(g.Depends, ref.ID)
+		g.DependTargets = removeDependTargets(g.DependTargets, ref.ID)
+	}
+}
+
+// notifierConfigRefs 通知器配置里引用的模版和备用通知器
+var notifierConfigRefs = map[string]string{
+	"template": kindTemplate,
+	"fallback": kindNotifier,
+}
+
+func (hn *HeapsterNotifier) refs() []Ref {
+	var refs []Ref
+	for key, kind := range notifierConfigRefs {
+		if id, ok := hn.Config[key].(string); ok {
+			refs = appendRefs(refs, kind, id)
+		}
+	}
+	return refs
+}
+
+func (hn *HeapsterNotifier) unlink(ref Ref) {
+	for key, kind := range notifierConfigRefs {
+		if id, ok := hn.Config[key].(string); ok && kind == ref.Kind && id == string(ref.ID) {
+			delete(hn.Config, key)
+		}
+	}
+}
+
+func (ep *EscalationPolicy) refs() []Ref {
+	var refs []Ref
+	for _, level := range ep.Levels {
+		refs = appendRefs(refs, kindNotifier, level.Notifiers...)
+	}
+	return refs
+}
+
+// unlink 去掉通知器之后没有通知器的级别也去掉
+func (ep *EscalationPolicy) unlink(ref Ref) {
+	if ref.Kind != kindNotifier {
+		return
+	}
+	var levels []EscalationLevel
+	for _, level := range ep.Levels {
+		level.Notifiers = removeID(level.Notifiers, ref.ID)
+		if len(level.Notifiers) > 0 {
+			levels = append(levels, level)
+		}
+	}
+	ep.Levels = levels
+}
+
+func (mt *MessageTemplate) refs() []Ref {
+	return nil
+}
+
+func (mt *MessageTemplate) unlink(ref Ref) {}
+
+// CheckRefs 检查模型引用的实体都存在
+func CheckRefs(ctx context.Context, model interface {
+	refs() []Ref
+}) error {
+	store := GetStore(ctx)
+	ids := make(map[string][]string)
+	for _, ref := range model.refs() {
+		ids[ref.Kind] = append(ids[ref.Kind], string(ref.ID))
+	}
+	for _, kind := range storeKinds {
+		if len(ids[kind]) == 0 {
+			continue
+		}
+		versions, err := store.Versions(ctx, kind, ids[kind])
+		if err != nil {
+			return err
+		}
+		for _, id := range ids[kind] {
+			if _, ok := versions[SerialNumber(id)]; !ok {
+				return fmt.Errorf("%s %s not found", kind, id)
+			}
+		}
+	}
+	return nil
+}
+
+// FetchReferrers 查询引用了实体的其他实体, 带上名称
+func FetchReferrers(ctx context.Context, kind string, id SerialNumber) ([]Ref, error) {
+	if _, err := newKindModel(kind); err != nil {
+		return nil, err
+	}
+	store := GetStore(ctx)
+	refs, err := store.Referrers(ctx, kind, id)
+	if err != nil {
+		return nil, err
+	}
+	for i, ref := range refs {
+		e, err := store.Get(ctx, ref.Kind, ref.ID)
+		if err != nil {
+			continue
+		}
+		var meta struct {
+			Name string `json:"name"`
+		}
+		if json.Unmarshal(e.Meta, &meta) == nil {
+			refs[i].Name = meta.Name
+		}
+	}
+	return refs, nil
+}
+
+// deleteEntity 删除实体, 还在被引用时返回 InUseError;
+// force时先去掉其他实体对它的引用再删除, 去掉引用的时候又有新的引用时重试
+func deleteEntity(ctx context.Context, kind string, id SerialNumber, force bool) error {
+	store := GetStore(ctx)
+	target := Ref{Kind: kind, ID: id}
+	for i := 0; ; i++ {
+		refs, err := store.Referrers(ctx, kind, id)
+		if err != nil {
+			return err
+		}
+		// 自己依赖自己的数据不会保存, 这里只是防止死循环
+		refs = removeRef(refs, target)
+		if len(refs) > 0 && (!force || i >= maxUnlinkRetries) {
+			return &InUseError{Kind: kind, ID: id, Referrers: refs}
+		}
+		for _, ref := range refs {
+			if err := unlinkEntity(ctx, ref, target); err != nil {
+				return fmt.Errorf("unlink %s error %v", ref, err)
+			}
+		}
+		// 检查和删除之间有新的引用时存储返回 ErrInUse
+		if err := store.Delete(ctx, kind, id); err != ErrInUse {
+			return err
+		}
+	}
+}
+
+// maxUnlinkRetries 强制删除时去掉引用的最多次数
+const maxUnlinkRetries = 3
+
+// removeRef 从列表里去掉ref
+func removeRef(refs []Ref, ref Ref) []Ref {
+	var ret []Ref
+	for _, r := range refs {
+		if r != ref {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// unlinkEntity 去掉ref对target的引用, 作为新版本保存
+func unlinkEntity(ctx context.Context, ref Ref, target Ref) error {
+	store := GetStore(ctx)
+	e, err := store.Get(ctx, ref.Kind, ref.ID)
+	if err == ErrNotFound {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	model, err := newKindModel(ref.Kind)
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(e.Meta, model); err != nil {
+		return err
+	}
+	model.unlink(target)
+	data, err := json.Marshal(model)
+	if err != nil {
+		return err
+	}
+	_, err = store.Put(ctx, ref.Kind, ref.ID, data, e.Version, model.refs())
+	return err
+}
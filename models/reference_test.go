@@ -0,0 +1,96 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testSMSConfig 合法的短信通知器配置
+var testSMSConfig = map[string]interface{}{
+	"type":     "unicom",
+	"sp":       "103905",
+	"username": "test",
+	"password": "test",
+	"targets":  []string{"13800000000"},
+}
+
+// testReferences 两种存储的引用关系行为一致
+func testReferences(t *testing.T, ctx context.Context) {
+	g := &Group{ID: "testrefgroup", Name: "引用测试组"}
+	hn := &HeapsterNotifier{ID: "testrefnotifier", Type: "sms", Config: testSMSConfig}
+	hst := &Heapster{
+		ID:        "testrefheapster",
+		Name:      "引用测试",
+		Type:      CheckTypeTCP,
+		Port:      8080,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+		Groups:    []string{string(g.ID)},
+		Notifiers: []string{string(hn.ID)},
+	}
+	defer hst.Delete(ctx)
+	defer g.ForceDelete(ctx)
+	defer hn.ForceDelete(ctx)
+
+	// 引用的对象不存在
+	assert.Error(t, CheckRefs(ctx, hst))
+	assert.NoError(t, g.Save(ctx))
+	assert.NoError(t, hn.Save(ctx))
+	assert.NoError(t, CheckRefs(ctx, hst))
+	assert.NoError(t, hst.Save(ctx))
+
+	refs, err := FetchReferrers(ctx, kindGroup, g.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Ref{{Kind: kindHeapster, ID: hst.ID, Name: hst.Name}}, refs)
+
+	// 被引用时拒绝删除
+	err = g.Delete(ctx)
+	assert.IsType(t, &InUseError{}, err)
+	assert.NoError(t, g.Fill(ctx))
+	// 检查之后才加上的引用, 存储删除时也会拒绝
+	assert.Equal(t, ErrInUse, GetStore(ctx).Delete(ctx, kindGroup, g.ID))
+
+	// 修改之后不再引用
+	hst.Notifiers = nil
+	assert.NoError(t, hst.Save(ctx))
+	refs, err = FetchReferrers(ctx, kindNotifier, hn.ID)
+	assert.NoError(t, err)
+	assert.Len(t, refs, 0)
+	assert.NoError(t, hn.Delete(ctx))
+
+	// 强制删除时去掉引用
+	assert.NoError(t, g.ForceDelete(ctx))
+	filled := &Heapster{ID: hst.ID}
+	assert.NoError(t, filled.Fill(ctx))
+	assert.Len(t, filled.Groups, 0)
+	assert.Equal(t, hst.Revision+1, filled.Revision)
+
+	// 引用已经删除的对象时保存失败, 回滚到引用了已删除对象的版本也失败
+	_, err = GetStore(ctx).Put(ctx, kindHeapster, hst.ID, []byte(`{}`), 0, []Ref{{Kind: kindGroup, ID: g.ID}})
+	assert.Equal(t, ErrRefNotFound, err)
+	_, err = Rollback(ctx, kindHeapster, hst.ID, hst.Revision, 0)
+	assert.Equal(t, []string{""}, fields(t, err))
+
+	// 删除之后引用关系也删除
+	assert.NoError(t, filled.Delete(ctx))
+	refs, err = FetchReferrers(ctx, kindGroup, g.ID)
+	assert.NoError(t, err)
+	assert.Len(t, refs, 0)
+}
+
+func TestBoltStoreReferences(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+	testReferences(t, ctx)
+}
+
+func TestRedisStoreReferences(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	testReferences(t, ctx)
+}
@@ -3,36 +3,50 @@ package models
 import (
 	"context"
 	"fmt"
-	"log"
-	"os"
 	"time"
+)
 
-	"zonst/qipai/gamehealthysrv/middlewares"
+// DefaultProbeLocation 没有设置探测点时的名称
+const DefaultProbeLocation = "default"
 
-	elastic "gopkg.in/olivere/elastic.v5"
-)
+// LocationReport 单个探测点的检测报告
+type LocationReport struct {
+	Location string `json:"location"`
+	Success  int    `json:"success"`
+	Faileds  int    `json:"faileds"`
+}
 
 // Report 检测报告,用于API接口和Alert模块
 type Report struct {
 	Heapster string        `json:"heapster"`
 	Target   string        `json:"target"`
+	Group    string        `json:"group,omitempty"`
 	Success  int           `json:"success"`
 	Faileds  int           `json:"faileds"`
 	MaxDelay time.Duration `json:"max_delay"`
-}
-
-func init() {
-	elastic.SetTraceLog(log.New(os.Stdout, "****** ", 5))
+	P50Delay time.Duration `json:"p50_delay"`
+	P95Delay time.Duration `json:"p95_delay"`
+	// 每个探测点的结果
+	Locations []LocationReport `json:"locations,omitempty"`
+	// 目标的健康状态以及原因, 由警报器计算
+	Status HealthyStatus `json:"status,omitempty"`
+	Reason string        `json:"reason,omitempty"`
+	// 因为依赖这个heapster而被抑制通知的数量
+	Suppressed int `json:"suppressed,omitempty"`
 }
 
 // Reports 报告列表
 type Reports []Report
 
-// ProbeLog 持久化文档结构，对应Elastic的gamehealthy-*模版
+// ProbeLog 持久化文档结构，对应Elastic的gamehealthy-*模版, 模版由 InstallElasticTemplates 安装
 type ProbeLog struct {
 	Timestamp time.Time     `json:"timestamp"`
 	Heapster  string        `json:"heapster"`
+	Name      string        `json:"name,omitempty"`
 	Target    string        `json:"target"`
+	Group     string        `json:"group,omitempty"`
+	Location  string        `json:"location,omitempty"`
+	Type      CheckType     `json:"type,omitempty"`
 	Response  string        `json:"response"`
 	Elapsed   time.Duration `json:"elapsed"`
 	Success   int           `json:"success"`
@@ -55,69 +69,22 @@ func (pl ProbeLog) Validate() error {
 
 // Save 保存报告
 func (pls ProbeLogs) Save(ctx context.Context) error {
-	conn := middlewares.GetElasticConn(ctx)
+	docs := make(ProbeLogs, 0, len(pls))
+	now := time.Now()
 	for _, doc := range pls {
 		if doc.Validate() != nil {
 			continue
 		}
-		doc.Timestamp = time.Now()
-
-		_, err := conn.Index().
-			Index("<gamehealthy-{now/d{YYYY.MM.dd|+08:00}}>").
-			Type("probelog").
-			BodyJson(doc).Do(ctx)
-		if err != nil {
-			return fmt.Errorf("save report error %v", err)
-		}
+		doc.Timestamp = now
+		docs = append(docs, doc)
 	}
-	return nil
+	if len(docs) == 0 {
+		return nil
+	}
+	return GetProbeStore(ctx).Write(ctx, docs)
 }
 
 // FetchReportsAggs 获取统计报告
 func FetchReportsAggs(ctx context.Context, heapster string, last time.Time) (Reports, error) {
-	conn := middlewares.GetElasticConn(ctx)
-	// 查询条件
-	queryHeapster := elastic.NewTermQuery("heapster", heapster)
-	queryTimestamp := elastic.NewRangeQuery("timestamp").Gte(last)
-	boolQuery := elastic.NewBoolQuery().Filter(queryHeapster, queryTimestamp)
-	// 聚集
-	aggsSuccess := elastic.NewSumAggregation().Field("success")
-	aggsFaileds := elastic.NewSumAggregation().Field("failed")
-	aggsElapsed := elastic.NewMaxAggregation().Field("elapsed")
-	aggsTarget := elastic.NewTermsAggregation().
-		Field("target").Size(1000).OrderByTermAsc().
-		SubAggregation("success", aggsSuccess).
-		SubAggregation("faileds", aggsFaileds).
-		SubAggregation("max_delay", aggsElapsed)
-
-	// 最多检索3天前的数据
-	result, err := conn.Search("gamehealthy-*").
-		Type("probelog").From(0).Size(0).
-		Query(boolQuery).Aggregation("target", aggsTarget).
-		Do(ctx)
-	if err != nil {
-		return nil, err
-	}
-	reports := make(Reports, 0, 1024)
-	term, ok := result.Aggregations.Terms("target")
-	if ok {
-		for _, b := range term.Buckets {
-			rp := Report{
-				Heapster: heapster,
-				Target:   b.Key.(string),
-			}
-
-			if success, ok := b.Sum("success"); ok {
-				rp.Success = int(*success.Value)
-			}
-			if faileds, ok := b.Sum("faileds"); ok {
-				rp.Faileds = int(*faileds.Value)
-			}
-			if maxDelay, ok := b.Sum("max_delay"); ok {
-				rp.MaxDelay = time.Duration(*maxDelay.Value)
-			}
-			reports = append(reports, rp)
-		}
-	}
-	return reports, nil
+	return GetProbeStore(ctx).Aggregate(ctx, heapster, last)
 }
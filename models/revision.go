@@ -0,0 +1,51 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// FetchRevisions 获取实体的历史版本, 新的在前
+func FetchRevisions(ctx context.Context, kind string, id SerialNumber) ([]Revision, error) {
+	if _, err := newKindModel(kind); err != nil {
+		return nil, err
+	}
+	return GetStore(ctx).Revisions(ctx, kind, id)
+}
+
+// Rollback 把实体恢复成历史版本revision的内容, 作为新的版本保存并返回;
+// version是当前版本, 不为0时检查是否已经被修改, heapster使用Revision
+func Rollback(ctx context.Context, kind string, id SerialNumber, revision int, version int) (int, error) {
+	model, err := newKindModel(kind)
+	if err != nil {
+		return 0, err
+	}
+	store := GetStore(ctx)
+	if _, err := store.Get(ctx, kind, id); err != nil {
+		return 0, err
+	}
+	revisions, err := store.Revisions(ctx, kind, id)
+	if err != nil {
+		return 0, err
+	}
+	for _, rev := range revisions {
+		if rev.Version != revision {
+			continue
+		}
+		if err := json.Unmarshal(rev.Meta, model); err != nil {
+			return 0, err
+		}
+		if err := model.Validate(); err != nil {
+			return 0, err
+		}
+		// 历史版本引用的对象可能已经被删除, 和保存一样检查
+		if err := CheckRefs(ctx, model); err != nil {
+			var errs ValidationErrors
+			errs.Add("", "%v", err)
+			return 0, errs
+		}
+		return store.Put(ctx, kind, id, rev.Meta, version, model.refs())
+	}
+	return 0, fmt.Errorf("revision %d of %s %s not found", revision, kind, id)
+}
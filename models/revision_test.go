@@ -0,0 +1,72 @@
+package models
+
+import (
+	"context"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testRevisions 两种存储的并发修改检查和历史版本行为一致
+func testRevisions(t *testing.T, ctx context.Context) {
+	g := &Group{
+		ID:   "testrevisiongroup",
+		Name: "版本1",
+	}
+	defer g.Delete(ctx)
+	assert.NoError(t, g.Save(ctx))
+	assert.Equal(t, 1, g.Version)
+
+	// 两个人同时读取, 后保存的冲突
+	g1 := &Group{ID: g.ID}
+	g2 := &Group{ID: g.ID}
+	assert.NoError(t, g1.Fill(ctx))
+	assert.NoError(t, g2.Fill(ctx))
+	g1.Name = "版本2"
+	assert.NoError(t, g1.Save(ctx))
+	assert.Equal(t, 2, g1.Version)
+	g2.Name = "冲突"
+	assert.Equal(t, ErrConflict, g2.Save(ctx))
+
+	revisions, err := FetchRevisions(ctx, kindGroup, g.ID)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 2)
+	assert.Equal(t, 2, revisions[0].Version)
+	assert.Equal(t, 1, revisions[1].Version)
+
+	// 回滚到版本1, 作为版本3保存
+	_, err = Rollback(ctx, kindGroup, g.ID, 1, 1)
+	assert.Equal(t, ErrConflict, err)
+	version, err := Rollback(ctx, kindGroup, g.ID, 1, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, version)
+	g3 := &Group{ID: g.ID}
+	assert.NoError(t, g3.Fill(ctx))
+	assert.Equal(t, "版本1", g3.Name)
+	assert.Equal(t, 3, g3.Version)
+
+	// 历史版本有上限
+	for i := 0; i < MaxRevisions; i++ {
+		assert.NoError(t, g3.Save(ctx))
+	}
+	revisions, err = FetchRevisions(ctx, kindGroup, g.ID)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, MaxRevisions)
+	assert.Equal(t, g3.Version, revisions[0].Version)
+
+	_, err = FetchRevisions(ctx, "unknown", g.ID)
+	assert.Error(t, err)
+}
+
+func TestBoltStoreRevisions(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+	testRevisions(t, ctx)
+}
+
+func TestRedisStoreRevisions(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	testRevisions(t, ctx)
+}
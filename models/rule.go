@@ -0,0 +1,131 @@
+package models
+
+import (
+	"fmt"
+	"time"
+)
+
+// HeapsterRule 状态聚合规则, 不配置时任意目标失败即判定为红色
+type HeapsterRule struct {
+	// 失败目标百分比达到RedPercent判定为红色
+	RedPercent float64 `json:"red_percent,omitempty"`
+	// 失败目标百分比达到YellowPercent判定为黄色
+	YellowPercent float64 `json:"yellow_percent,omitempty"`
+	// 健康目标少于MinHealthy判定为红色
+	MinHealthy int `json:"min_healthy,omitempty"`
+	// 每个组最少的健康目标数量, key是组ID
+	GroupQuorums map[string]int `json:"group_quorums,omitempty"`
+}
+
+// Validate 验证规则, 返回 ValidationErrors
+func (rule *HeapsterRule) Validate(groups []string) error {
+	var errs ValidationErrors
+	if rule.RedPercent < 0 || rule.RedPercent > 100 {
+		errs.Add("red_percent", "must >= 0 and <= 100")
+	}
+	if rule.YellowPercent < 0 || rule.YellowPercent > 100 {
+		errs.Add("yellow_percent", "must >= 0 and <= 100")
+	}
+	if rule.RedPercent > 0 && rule.YellowPercent > rule.RedPercent {
+		errs.Add("yellow_percent", "must <= red_percent")
+	}
+	if rule.MinHealthy < 0 {
+		errs.Add("min_healthy", "must >= 0")
+	}
+	for gid, quorum := range rule.GroupQuorums {
+		field := "group_quorums." + gid
+		if quorum < 0 {
+			errs.Add(field, "must >= 0")
+		}
+		found := false
+		for _, g := range groups {
+			if g == gid {
+				found = true
+				break
+			}
+		}
+		if !found {
+			errs.Add(field, "group not in heapster groups")
+		}
+	}
+	return errs.Err()
+}
+
+// LatencyThreshold 采样窗口内的延迟阈值, 0表示不检查
+type LatencyThreshold struct {
+	P50 time.Duration `json:"p50,omitempty"`
+	P95 time.Duration `json:"p95,omitempty"`
+	Max time.Duration `json:"max,omitempty"`
+}
+
+// Validate 验证阈值, 返回 ValidationErrors
+func (lt LatencyThreshold) Validate() error {
+	var errs ValidationErrors
+	if lt.P50 < 0 {
+		errs.Add("p50", "must >= 0")
+	}
+	if lt.P95 < 0 {
+		errs.Add("p95", "must >= 0")
+	}
+	if lt.Max < 0 {
+		errs.Add("max", "must >= 0")
+	}
+	return errs.Err()
+}
+
+// Exceeded 返回超过阈值的延迟描述, 没有超过返回空字符串
+func (lt LatencyThreshold) Exceeded(rp Report) string {
+	if lt.Max > 0 && rp.MaxDelay > lt.Max {
+		return fmt.Sprintf("最大延迟%v超过%v", rp.MaxDelay, lt.Max)
+	}
+	if lt.P95 > 0 && rp.P95Delay > lt.P95 {
+		return fmt.Sprintf("p95延迟%v超过%v", rp.P95Delay, lt.P95)
+	}
+	if lt.P50 > 0 && rp.P50Delay > lt.P50 {
+		return fmt.Sprintf("p50延迟%v超过%v", rp.P50Delay, lt.P50)
+	}
+	return ""
+}
+
+// LatencyRule 延迟规则, 目标可以连接但是响应慢的时候变成黄色或者红色
+type LatencyRule struct {
+	Yellow LatencyThreshold `json:"yellow"`
+	Red    LatencyThreshold `json:"red"`
+}
+
+// Validate 验证规则, 返回 ValidationErrors
+func (rule *LatencyRule) Validate() error {
+	var errs ValidationErrors
+	errs.Merge("yellow", rule.Yellow.Validate())
+	errs.Merge("red", rule.Red.Validate())
+	return errs.Err()
+}
+
+// GroupStat 组内目标统计
+type GroupStat struct {
+	Total   int `json:"total"`
+	Healthy int `json:"healthy"`
+}
+
+// LocationStat 探测点统计
+type LocationStat struct {
+	Total   int `json:"total"`
+	Faileds int `json:"faileds"`
+}
+
+// HeapsterStat 计算状态时的统计数据, 和状态一起保存
+type HeapsterStat struct {
+	Total          int                     `json:"total"`
+	Healthy        int                     `json:"healthy"`
+	Unstable       int                     `json:"unstable"`
+	Slow           int                     `json:"slow"`
+	Faileds        int                     `json:"faileds"`
+	HealthyPercent float64                 `json:"healthy_percent"`
+	FailedPercent  float64                 `json:"failed_percent"`
+	Groups         map[string]GroupStat    `json:"groups,omitempty"`
+	Locations      map[string]LocationStat `json:"locations,omitempty"`
+	SuppressedBy   SerialNumber            `json:"suppressed_by,omitempty"`
+	// 红色的目标, 依赖单个目标的heapster根据这个判断是否抑制
+	RedTargets []string  `json:"red_targets,omitempty"`
+	UpdatedAt  time.Time `json:"updated_at"`
+}
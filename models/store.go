@@ -0,0 +1,211 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"zonst/qipai-golang-libs/httputil"
+)
+
+// ErrNotFound 实体不存在
+var ErrNotFound = errors.New("not found")
+
+// ErrConflict 保存时版本和存储里的不一致, 已经被其他人修改
+var ErrConflict = errors.New("version conflict, reload and retry")
+
+// ErrInUse 删除时实体还在被其他实体引用
+var ErrInUse = errors.New("entity in use")
+
+// ErrRefNotFound 保存时引用的实体不存在, 可能刚被删除
+var ErrRefNotFound = errors.New("referenced entity not found")
+
+// MaxRevisions 每个实体最多保留的历史版本数量
+const MaxRevisions = 20
+
+// StoreEntity 存储的实体, meta是json编码的模型
+type StoreEntity struct {
+	ID      SerialNumber
+	Meta    []byte
+	Version int
+}
+
+// StoreStatus 存储的heapster状态和统计数据
+type StoreStatus struct {
+	ID     SerialNumber
+	Status HealthyStatus
+	Stat   []byte
+}
+
+// Revision 实体的历史版本, 每次保存记录一个
+type Revision struct {
+	Version int             `json:"version"`
+	Meta    json.RawMessage `json:"meta"`
+	Time    time.Time       `json:"time"`
+}
+
+// StoreOp 批量修改里的一个操作, Delete为true时删除, 否则保存;
+// Version不为0时和当前版本比较, 和Put一样
+type StoreOp struct {
+	Kind    string
+	ID      SerialNumber
+	Meta    []byte
+	Version int
+	Refs    []Ref
+	Delete  bool
+}
+
+// Store 模型存储接口, kind是实体类型(heapster, group, notifier...)
+type Store interface {
+	// Get 获取单个实体, 不存在返回 ErrNotFound
+	Get(ctx context.Context, kind string, id SerialNumber) (StoreEntity, error)
+	// List 获取某个类型的全部实体
+	List(ctx context.Context, kind string) ([]StoreEntity, error)
+	// Versions 获取指定实体的版本, 不存在的不返回
+	Versions(ctx context.Context, kind string, ids []string) (map[SerialNumber]int, error)
+	// AllVersions 获取某个类型全部实体的版本
+	AllVersions(ctx context.Context, kind string) (map[SerialNumber]int, error)
+	// Put 保存实体, 版本加一并记录历史版本, 返回新的版本; refs是实体引用的其他实体, 同时更新反向引用;
+	// version不为0时和当前版本比较, 不一致返回 ErrConflict; 引用的实体不存在返回 ErrRefNotFound
+	Put(ctx context.Context, kind string, id SerialNumber, meta []byte, version int, refs []Ref) (int, error)
+	// Delete 删除实体, 历史版本和引用关系, heapster同时删除状态; 还在被其他实体引用时返回 ErrInUse,
+	// 和保存在同一个事务里检查, 并发保存不会留下悬空的引用
+	Delete(ctx context.Context, kind string, id SerialNumber) error
+	// Referrers 获取引用了实体的其他实体
+	Referrers(ctx context.Context, kind string, id SerialNumber) ([]Ref, error)
+	// Apply 原子地执行多个保存和删除, 有一个版本不一致时都不执行并返回 ErrConflict
+	Apply(ctx context.Context, ops []StoreOp) error
+	// Revisions 获取实体的历史版本, 新的在前
+	Revisions(ctx context.Context, kind string, id SerialNumber) ([]Revision, error)
+
+	// GetStatus 获取heapster状态, 没有状态时是 HealthyStatusUnknown
+	GetStatus(ctx context.Context, id SerialNumber) (StoreStatus, error)
+	// SetStatus 设置heapster状态, stat为nil时不修改统计数据
+	SetStatus(ctx context.Context, id SerialNumber, status HealthyStatus, stat []byte) error
+	// ListStatus 获取全部heapster的状态
+	ListStatus(ctx context.Context) ([]StoreStatus, error)
+
+	// OpenIncident 保存新的事故, 版本为1, 设置为heapster当前的事故并加入历史, 历史保留最近 incidentHistorySize 个
+	OpenIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte) error
+	// GetIncident 获取事故和版本, 不存在返回 ErrNotFound
+	GetIncident(ctx context.Context, id SerialNumber) ([]byte, int, error)
+	// PutIncident 保存事故并返回新的版本, version不为0时和当前版本比较, 不一致返回 ErrConflict;
+	// resolved为true时同时清除heapster当前的事故
+	PutIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte, version int, resolved bool) (int, error)
+	// CurrentIncident 获取heapster未结束的事故, 没有返回空
+	CurrentIncident(ctx context.Context, heapster SerialNumber) (SerialNumber, error)
+	// ListIncidents 获取heapster的事故历史, 新的在前
+	ListIncidents(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error)
+
+	// CreateNotification 保存新的通知记录并加入heapster的历史, 历史保留最近 notificationHistorySize 个;
+	// serialNumber不为0时建立短信序列号的索引, 记录 notificationExpire 之后过期
+	CreateNotification(ctx context.Context, heapster SerialNumber, id SerialNumber, serialNumber int64, data []byte) error
+	// GetNotification 获取通知记录, 不存在或者过期返回 ErrNotFound
+	GetNotification(ctx context.Context, id SerialNumber) ([]byte, error)
+	// PutNotification 保存通知记录, 重新计算过期时间
+	PutNotification(ctx context.Context, id SerialNumber, data []byte) error
+	// ListNotifications 获取heapster的通知历史, 新的在前
+	ListNotifications(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error)
+	// NotificationsBySMS 获取短信序列号对应的通知记录
+	NotificationsBySMS(ctx context.Context, serialNumber int64) ([]SerialNumber, error)
+}
+
+// 实体类型
+const (
+	kindHeapster   = "heapster"
+	kindGroup      = "group"
+	kindNotifier   = "notifier"
+	kindEscalation = "escalation"
+	kindTemplate   = "template"
+)
+
+var storeKinds = []string{kindHeapster, kindGroup, kindNotifier, kindEscalation, kindTemplate}
+
+// 存储类型
+const (
+	StoreTypeRedis = "redis"
+	StoreTypeBolt  = "bolt"
+)
+
+type storeContext string
+
+const (
+	storeContextLabel storeContext = "_model_store_"
+)
+
+// decodeEntity 解码实体的meta, 返回版本
+func decodeEntity(e StoreEntity, v interface{}) (int, error) {
+	if err := json.Unmarshal(e.Meta, v); err != nil {
+		return 0, err
+	}
+	return e.Version, nil
+}
+
+var (
+	// 文件只能被打开一次, 同一个进程里的服务共用
+	openedFiles    = make(map[string]interface{})
+	openedFilesMtx sync.Mutex
+)
+
+// openOnce 同一个路径只调用一次open, 之后返回第一次打开的对象
+func openOnce(path string, open func() (interface{}, error)) (interface{}, error) {
+	openedFilesMtx.Lock()
+	defer openedFilesMtx.Unlock()
+	if v, ok := openedFiles[path]; ok {
+		return v, nil
+	}
+	v, err := open()
+	if err != nil {
+		return nil, err
+	}
+	openedFiles[path] = v
+	return v, nil
+}
+
+// NewStore 按照类型创建存储, 默认使用Redis
+func NewStore(typ string, path string) (Store, error) {
+	switch typ {
+	case "", StoreTypeRedis:
+		return redisStore{}, nil
+	case StoreTypeBolt:
+		v, err := openOnce(path, func() (interface{}, error) {
+			return OpenBoltStore(path)
+		})
+		if err != nil {
+			return nil, err
+		}
+		bs, ok := v.(*BoltStore)
+		if !ok {
+			return nil, fmt.Errorf("file %s is opened by another store", path)
+		}
+		return bs, nil
+	default:
+		return nil, fmt.Errorf("store type %s not support", typ)
+	}
+}
+
+// WithStore 获取带存储的上下文
+func WithStore(parent context.Context, store Store) context.Context {
+	return context.WithValue(parent, storeContextLabel, store)
+}
+
+// GetStore 获取存储, 没有设置时使用上下文里的Redis连接
+func GetStore(ctx context.Context) Store {
+	if store, ok := ctx.Value(storeContextLabel).(Store); ok {
+		return store
+	}
+	return redisStore{}
+}
+
+// StoreHandler 存储中间件, 全局插件不要单独使用
+func StoreHandler(store Store) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		httputil.WithValue(ctx, storeContextLabel, store)
+		httputil.Next(ctx)
+	}
+}
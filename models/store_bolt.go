@@ -0,0 +1,845 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+// 状态单独一个bucket
+const boltStatusBucket = "status"
+
+// 历史版本一个bucket, 每种实体一个子bucket, 值是版本列表
+const boltRevisionBucket = "revision"
+
+// 反向引用一个bucket, 每种实体一个子bucket, 值是引用了实体的列表
+const boltReferrerBucket = "referrers"
+
+// 事故和通知记录的bucket, 历史和短信索引的值是id列表
+const (
+	boltIncidentBucket            = "incident"
+	boltCurrentIncidentBucket     = "incident_current"
+	boltIncidentHistoryBucket     = "incident_history"
+	boltNotificationBucket        = "notification"
+	boltNotificationHistoryBucket = "notification_history"
+	boltNotificationSMSBucket     = "notification_sms"
+)
+
+var boltRecordBuckets = []string{
+	boltIncidentBucket, boltCurrentIncidentBucket, boltIncidentHistoryBucket,
+	boltNotificationBucket, boltNotificationHistoryBucket, boltNotificationSMSBucket,
+}
+
+// boltRecord 实体记录
+type boltRecord struct {
+	Meta    json.RawMessage `json:"meta"`
+	Version int             `json:"version"`
+	Refs    []Ref           `json:"refs,omitempty"`
+}
+
+// boltStatus 状态记录
+type boltStatus struct {
+	Status HealthyStatus   `json:"status"`
+	Stat   json.RawMessage `json:"stat,omitempty"`
+}
+
+// BoltStore 嵌入式文件存储, 每种实体一个bucket, 不需要外部服务
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBoltStore 打开存储文件, 不存在时创建
+func OpenBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, kind := range storeKinds {
+			if _, err := tx.CreateBucketIfNotExists([]byte(kind)); err != nil {
+				return err
+			}
+		}
+		revisions, err := tx.CreateBucketIfNotExists([]byte(boltRevisionBucket))
+		if err != nil {
+			return err
+		}
+		for _, kind := range storeKinds {
+			if _, err := revisions.CreateBucketIfNotExists([]byte(kind)); err != nil {
+				return err
+			}
+		}
+		// 旧版本的文件没有引用关系, 第一次打开时建立
+		if tx.Bucket([]byte(boltReferrerBucket)) == nil {
+			if err := boltMigrateRefs(tx); err != nil {
+				return err
+			}
+		}
+		for _, name := range boltRecordBuckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(name)); err != nil {
+				return err
+			}
+		}
+		_, err = tx.CreateBucketIfNotExists([]byte(boltStatusBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltStore{db: db}, nil
+}
+
+// Close 关闭存储文件
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
+
+// Get 获取单个实体
+func (bs *BoltStore) Get(ctx context.Context, kind string, id SerialNumber) (StoreEntity, error) {
+	var e StoreEntity
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, kind)
+		if err != nil {
+			return err
+		}
+		data := b.Get([]byte(id))
+		if data == nil {
+			return ErrNotFound
+		}
+		e, err = boltEntity(id, data)
+		return err
+	})
+	return e, err
+}
+
+// List 获取某个类型的全部实体
+func (bs *BoltStore) List(ctx context.Context, kind string) ([]StoreEntity, error) {
+	var entities []StoreEntity
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, kind)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			e, err := boltEntity(SerialNumber(k), v)
+			if err != nil {
+				return err
+			}
+			entities = append(entities, e)
+			return nil
+		})
+	})
+	return entities, err
+}
+
+// Versions 获取指定实体的版本
+func (bs *BoltStore) Versions(ctx context.Context, kind string, ids []string) (map[SerialNumber]int, error) {
+	versions := make(map[SerialNumber]int, len(ids))
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, kind)
+		if err != nil {
+			return err
+		}
+		for _, id := range ids {
+			data := b.Get([]byte(id))
+			if data == nil {
+				continue
+			}
+			e, err := boltEntity(SerialNumber(id), data)
+			if err != nil {
+				return err
+			}
+			versions[e.ID] = e.Version
+		}
+		return nil
+	})
+	return versions, err
+}
+
+// AllVersions 获取某个类型全部实体的版本
+func (bs *BoltStore) AllVersions(ctx context.Context, kind string) (map[SerialNumber]int, error) {
+	entities, err := bs.List(ctx, kind)
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[SerialNumber]int, len(entities))
+	for _, e := range entities {
+		versions[e.ID] = e.Version
+	}
+	return versions, nil
+}
+
+// Put 在同一个事务里比较版本, 保存实体, 记录历史版本并更新引用关系
+func (bs *BoltStore) Put(ctx context.Context, kind string, id SerialNumber, meta []byte, version int, refs []Ref) (int, error) {
+	var newVersion int
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		// 和删除在同一个事务里检查, 不会引用刚删除的实体
+		for _, ref := range refs {
+			b, err := boltBucket(tx, ref.Kind)
+			if err != nil {
+				return err
+			}
+			if b.Get([]byte(ref.ID)) == nil {
+				return ErrRefNotFound
+			}
+		}
+		var err error
+		newVersion, err = boltPut(tx, kind, id, meta, version, refs)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// Revisions 获取历史版本
+func (bs *BoltStore) Revisions(ctx context.Context, kind string, id SerialNumber) ([]Revision, error) {
+	var list []Revision
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		revisions := tx.Bucket([]byte(boltRevisionBucket)).Bucket([]byte(kind))
+		if revisions == nil {
+			return fmt.Errorf("bucket %s not found", kind)
+		}
+		var err error
+		list, err = boltRevisions(revisions, id)
+		return err
+	})
+	return list, err
+}
+
+// Delete 删除实体, 历史版本和引用关系, heapster同时删除状态
+func (bs *BoltStore) Delete(ctx context.Context, kind string, id SerialNumber) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		referrers := tx.Bucket([]byte(boltReferrerBucket)).Bucket([]byte(kind))
+		if referrers == nil {
+			return fmt.Errorf("bucket %s not found", kind)
+		}
+		refs, err := boltRefs(referrers, id)
+		if err != nil {
+			return err
+		}
+		for _, ref := range refs {
+			if ref != (Ref{Kind: kind, ID: id}) {
+				return ErrInUse
+			}
+		}
+		return boltDelete(tx, kind, id, 0)
+	})
+}
+
+// Apply 在同一个事务里执行, 出错时事务回滚
+func (bs *BoltStore) Apply(ctx context.Context, ops []StoreOp) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		for _, op := range ops {
+			var err error
+			if op.Delete {
+				err = boltDelete(tx, op.Kind, op.ID, op.Version)
+			} else {
+				_, err = boltPut(tx, op.Kind, op.ID, op.Meta, op.Version, op.Refs)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// boltPut 比较版本, 保存实体, 记录历史版本并更新引用关系
+func boltPut(tx *bolt.Tx, kind string, id SerialNumber, meta []byte, version int, refs []Ref) (int, error) {
+	b, err := boltBucket(tx, kind)
+	if err != nil {
+		return 0, err
+	}
+	record := boltRecord{}
+	if data := b.Get([]byte(id)); data != nil {
+		if err := json.Unmarshal(data, &record); err != nil {
+			return 0, err
+		}
+	}
+	if version != 0 && version != record.Version {
+		return 0, ErrConflict
+	}
+	if err := boltUpdateReferrers(tx, Ref{Kind: kind, ID: id}, record.Refs, refs); err != nil {
+		return 0, err
+	}
+	record.Meta = meta
+	record.Version++
+	record.Refs = refs
+	data, err := json.Marshal(record)
+	if err != nil {
+		return 0, err
+	}
+	if err := b.Put([]byte(id), data); err != nil {
+		return 0, err
+	}
+
+	revisions := tx.Bucket([]byte(boltRevisionBucket)).Bucket([]byte(kind))
+	list, err := boltRevisions(revisions, id)
+	if err != nil {
+		return 0, err
+	}
+	list = append([]Revision{{
+		Version: record.Version,
+		Meta:    meta,
+		Time:    time.Now(),
+	}}, list...)
+	if len(list) > MaxRevisions {
+		list = list[:MaxRevisions]
+	}
+	data, err = json.Marshal(list)
+	if err != nil {
+		return 0, err
+	}
+	return record.Version, revisions.Put([]byte(id), data)
+}
+
+// boltDelete 删除实体, 历史版本和引用关系, version不为0时先比较版本
+func boltDelete(tx *bolt.Tx, kind string, id SerialNumber, version int) error {
+	b, err := boltBucket(tx, kind)
+	if err != nil {
+		return err
+	}
+	if data := b.Get([]byte(id)); data != nil {
+		record := boltRecord{}
+		if err := json.Unmarshal(data, &record); err != nil {
+			return err
+		}
+		if version != 0 && version != record.Version {
+			return ErrConflict
+		}
+		if err := boltUpdateReferrers(tx, Ref{Kind: kind, ID: id}, record.Refs, nil); err != nil {
+			return err
+		}
+	} else if version != 0 {
+		return ErrConflict
+	}
+	if kind == kindHeapster {
+		if err := tx.Bucket([]byte(boltStatusBucket)).Delete([]byte(id)); err != nil {
+			return err
+		}
+	}
+	if err := b.Delete([]byte(id)); err != nil {
+		return err
+	}
+	if err := tx.Bucket([]byte(boltRevisionBucket)).Bucket([]byte(kind)).Delete([]byte(id)); err != nil {
+		return err
+	}
+	return tx.Bucket([]byte(boltReferrerBucket)).Bucket([]byte(kind)).Delete([]byte(id))
+}
+
+// GetStatus 获取heapster状态
+func (bs *BoltStore) GetStatus(ctx context.Context, id SerialNumber) (StoreStatus, error) {
+	s := StoreStatus{
+		ID:     id,
+		Status: HealthyStatusUnknown,
+	}
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		data := tx.Bucket([]byte(boltStatusBucket)).Get([]byte(id))
+		if data == nil {
+			return nil
+		}
+		var err error
+		s, err = boltStoreStatus(id, data)
+		return err
+	})
+	return s, err
+}
+
+// SetStatus 设置heapster状态
+func (bs *BoltStore) SetStatus(ctx context.Context, id SerialNumber, status HealthyStatus, stat []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket([]byte(boltStatusBucket))
+		record := boltStatus{}
+		if data := b.Get([]byte(id)); data != nil {
+			if err := json.Unmarshal(data, &record); err != nil {
+				return err
+			}
+		}
+		record.Status = status
+		if stat != nil {
+			record.Stat = stat
+		}
+		data, err := json.Marshal(record)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(id), data)
+	})
+}
+
+// ListStatus 获取全部heapster的状态, 和Redis一样没有状态的heapster是未知
+func (bs *BoltStore) ListStatus(ctx context.Context) ([]StoreStatus, error) {
+	var statusList []StoreStatus
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		status := tx.Bucket([]byte(boltStatusBucket))
+		b, err := boltBucket(tx, kindHeapster)
+		if err != nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			s := StoreStatus{
+				ID:     SerialNumber(k),
+				Status: HealthyStatusUnknown,
+			}
+			if data := status.Get(k); data != nil {
+				var err error
+				if s, err = boltStoreStatus(SerialNumber(k), data); err != nil {
+					return err
+				}
+			}
+			statusList = append(statusList, s)
+			return nil
+		})
+	})
+	return statusList, err
+}
+
+// boltBucket 实体类型对应的bucket, 打开的时候已经创建
+func boltBucket(tx *bolt.Tx, kind string) (*bolt.Bucket, error) {
+	b := tx.Bucket([]byte(kind))
+	if b == nil {
+		return nil, fmt.Errorf("bucket %s not found", kind)
+	}
+	return b, nil
+}
+
+// Referrers 获取反向引用
+func (bs *BoltStore) Referrers(ctx context.Context, kind string, id SerialNumber) ([]Ref, error) {
+	var refs []Ref
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		referrers := tx.Bucket([]byte(boltReferrerBucket)).Bucket([]byte(kind))
+		if referrers == nil {
+			return fmt.Errorf("bucket %s not found", kind)
+		}
+		var err error
+		refs, err = boltRefs(referrers, id)
+		return err
+	})
+	return refs, err
+}
+
+// boltUpdateReferrers 从旧的引用对象里去掉self, 加入新的引用对象
+func boltUpdateReferrers(tx *bolt.Tx, self Ref, old []Ref, refs []Ref) error {
+	root := tx.Bucket([]byte(boltReferrerBucket))
+	update := func(ref Ref, add bool) error {
+		b := root.Bucket([]byte(ref.Kind))
+		if b == nil {
+			return fmt.Errorf("bucket %s not found", ref.Kind)
+		}
+		list, err := boltRefs(b, ref.ID)
+		if err != nil {
+			return err
+		}
+		var ret []Ref
+		for _, r := range list {
+			if r != self {
+				ret = append(ret, r)
+			}
+		}
+		if add {
+			ret = append(ret, self)
+		}
+		if len(ret) == 0 {
+			return b.Delete([]byte(ref.ID))
+		}
+		data, err := json.Marshal(ret)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(ref.ID), data)
+	}
+	for _, ref := range old {
+		if err := update(ref, false); err != nil {
+			return err
+		}
+	}
+	for _, ref := range refs {
+		if err := update(ref, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// boltMigrateRefs 创建反向引用bucket, 解析已有实体的引用
+func boltMigrateRefs(tx *bolt.Tx) error {
+	root, err := tx.CreateBucket([]byte(boltReferrerBucket))
+	if err != nil {
+		return err
+	}
+	for _, kind := range storeKinds {
+		if _, err := root.CreateBucket([]byte(kind)); err != nil {
+			return err
+		}
+	}
+	for _, kind := range storeKinds {
+		b := tx.Bucket([]byte(kind))
+		// 遍历的时候不能修改, 先收集
+		records := make(map[string]boltRecord)
+		err := b.ForEach(func(k, v []byte) error {
+			record := boltRecord{}
+			if err := json.Unmarshal(v, &record); err != nil {
+				return err
+			}
+			model, err := newKindModel(kind)
+			if err != nil {
+				return err
+			}
+			if err := json.Unmarshal(record.Meta, model); err != nil {
+				return nil
+			}
+			record.Refs = model.refs()
+			records[string(k)] = record
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for id, record := range records {
+			if err := boltUpdateReferrers(tx, Ref{Kind: kind, ID: SerialNumber(id)}, nil, record.Refs); err != nil {
+				return err
+			}
+			data, err := json.Marshal(record)
+			if err != nil {
+				return err
+			}
+			if err := b.Put([]byte(id), data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// boltRefs 解析引用列表, 返回的数据在事务之外也可以使用
+func boltRefs(b *bolt.Bucket, id SerialNumber) ([]Ref, error) {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+	var refs []Ref
+	if err := json.Unmarshal(data, &refs); err != nil {
+		return nil, err
+	}
+	return refs, nil
+}
+
+// boltRevisions 解析历史版本列表, 返回的数据在事务之外也可以使用
+func boltRevisions(b *bolt.Bucket, id SerialNumber) ([]Revision, error) {
+	data := b.Get([]byte(id))
+	if data == nil {
+		return nil, nil
+	}
+	var list []Revision
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+// boltEntity 解析实体记录, 返回的数据在事务之外也可以使用
+func boltEntity(id SerialNumber, data []byte) (StoreEntity, error) {
+	record := boltRecord{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return StoreEntity{}, err
+	}
+	return StoreEntity{
+		ID:      id,
+		Meta:    record.Meta,
+		Version: record.Version,
+	}, nil
+}
+
+// boltStoreStatus 解析状态记录
+func boltStoreStatus(id SerialNumber, data []byte) (StoreStatus, error) {
+	record := boltStatus{}
+	if err := json.Unmarshal(data, &record); err != nil {
+		return StoreStatus{}, err
+	}
+	return StoreStatus{
+		ID:     id,
+		Status: parseHealthyStatus(string(record.Status)),
+		Stat:   record.Stat,
+	}, nil
+}
+
+// boltNotification 通知记录, 没有TTL, 读取时检查过期时间
+type boltNotification struct {
+	Data         json.RawMessage `json:"data"`
+	SerialNumber int64           `json:"serial_number,omitempty"`
+	ExpireAt     time.Time       `json:"expire_at"`
+}
+
+// OpenIncident 保存事故, 设置当前事故并加入历史, 移出历史的事故删除
+func (bs *BoltStore) OpenIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		incidents := tx.Bucket([]byte(boltIncidentBucket))
+		if err := boltPutIncident(incidents, id, boltRecord{Meta: data, Version: 1}); err != nil {
+			return err
+		}
+		if err := tx.Bucket([]byte(boltCurrentIncidentBucket)).Put([]byte(heapster), []byte(id)); err != nil {
+			return err
+		}
+		removed, err := boltPushID(tx.Bucket([]byte(boltIncidentHistoryBucket)), heapster, id, incidentHistorySize)
+		if err != nil {
+			return err
+		}
+		for _, old := range removed {
+			if err := incidents.Delete([]byte(old)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetIncident 获取事故和版本
+func (bs *BoltStore) GetIncident(ctx context.Context, id SerialNumber) ([]byte, int, error) {
+	var record boltRecord
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		record, err = boltGetIncident(tx.Bucket([]byte(boltIncidentBucket)), id)
+		return err
+	})
+	if err != nil {
+		return nil, 0, err
+	}
+	return record.Meta, record.Version, nil
+}
+
+// PutIncident 比较版本之后保存事故, 结束时删除当前事故
+func (bs *BoltStore) PutIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte, version int, resolved bool) (int, error) {
+	var newVersion int
+	err := bs.db.Update(func(tx *bolt.Tx) error {
+		incidents := tx.Bucket([]byte(boltIncidentBucket))
+		record, err := boltGetIncident(incidents, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		if version != 0 && version != record.Version {
+			return ErrConflict
+		}
+		newVersion = record.Version + 1
+		if err := boltPutIncident(incidents, id, boltRecord{Meta: data, Version: newVersion}); err != nil {
+			return err
+		}
+		if !resolved {
+			return nil
+		}
+		return tx.Bucket([]byte(boltCurrentIncidentBucket)).Delete([]byte(heapster))
+	})
+	if err != nil {
+		return 0, err
+	}
+	return newVersion, nil
+}
+
+// boltGetIncident 解析事故记录, 返回的数据在事务之外也可以使用
+func boltGetIncident(b *bolt.Bucket, id SerialNumber) (boltRecord, error) {
+	record := boltRecord{}
+	data := b.Get([]byte(id))
+	if data == nil {
+		return record, ErrNotFound
+	}
+	err := json.Unmarshal(data, &record)
+	return record, err
+}
+
+func boltPutIncident(b *bolt.Bucket, id SerialNumber, record boltRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(id), data)
+}
+
+// CurrentIncident 获取当前事故
+func (bs *BoltStore) CurrentIncident(ctx context.Context, heapster SerialNumber) (SerialNumber, error) {
+	var id SerialNumber
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		id = SerialNumber(tx.Bucket([]byte(boltCurrentIncidentBucket)).Get([]byte(heapster)))
+		return nil
+	})
+	return id, err
+}
+
+// ListIncidents 获取事故历史
+func (bs *BoltStore) ListIncidents(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error) {
+	return bs.idList(boltIncidentHistoryBucket, string(heapster))
+}
+
+// CreateNotification 保存通知记录并加入历史, 移出历史的记录和短信索引一起删除
+func (bs *BoltStore) CreateNotification(ctx context.Context, heapster SerialNumber, id SerialNumber, serialNumber int64, data []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		var (
+			notifications = tx.Bucket([]byte(boltNotificationBucket))
+			sms           = tx.Bucket([]byte(boltNotificationSMSBucket))
+		)
+		if err := boltPutNotification(notifications, id, boltNotification{
+			Data:         data,
+			SerialNumber: serialNumber,
+			ExpireAt:     time.Now().Add(notificationExpire),
+		}); err != nil {
+			return err
+		}
+		if serialNumber != 0 {
+			key := SerialNumber(fmt.Sprintf("%d", serialNumber))
+			if _, err := boltPushID(sms, key, id, 0); err != nil {
+				return err
+			}
+		}
+		removed, err := boltPushID(tx.Bucket([]byte(boltNotificationHistoryBucket)), heapster, id, notificationHistorySize)
+		if err != nil {
+			return err
+		}
+		for _, old := range removed {
+			record, err := boltGetNotification(notifications, old)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if record.SerialNumber != 0 {
+				key := SerialNumber(fmt.Sprintf("%d", record.SerialNumber))
+				if err := boltRemoveID(sms, key, old); err != nil {
+					return err
+				}
+			}
+			if err := notifications.Delete([]byte(old)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// GetNotification 获取通知记录, 过期的和Redis一样返回不存在
+func (bs *BoltStore) GetNotification(ctx context.Context, id SerialNumber) ([]byte, error) {
+	var data []byte
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		record, err := boltGetNotification(tx.Bucket([]byte(boltNotificationBucket)), id)
+		if err != nil {
+			return err
+		}
+		if time.Now().After(record.ExpireAt) {
+			return ErrNotFound
+		}
+		data = record.Data
+		return nil
+	})
+	return data, err
+}
+
+// PutNotification 保存通知记录, 保留短信序列号
+func (bs *BoltStore) PutNotification(ctx context.Context, id SerialNumber, data []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		notifications := tx.Bucket([]byte(boltNotificationBucket))
+		record, err := boltGetNotification(notifications, id)
+		if err != nil && err != ErrNotFound {
+			return err
+		}
+		record.Data = data
+		record.ExpireAt = time.Now().Add(notificationExpire)
+		return boltPutNotification(notifications, id, record)
+	})
+}
+
+// ListNotifications 获取通知历史
+func (bs *BoltStore) ListNotifications(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error) {
+	return bs.idList(boltNotificationHistoryBucket, string(heapster))
+}
+
+// NotificationsBySMS 读取短信序列号的索引
+func (bs *BoltStore) NotificationsBySMS(ctx context.Context, serialNumber int64) ([]SerialNumber, error) {
+	return bs.idList(boltNotificationSMSBucket, fmt.Sprintf("%d", serialNumber))
+}
+
+// idList 读取bucket里的id列表
+func (bs *BoltStore) idList(bucket string, key string) ([]SerialNumber, error) {
+	var ids []SerialNumber
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		var err error
+		ids, err = boltIDs(tx.Bucket([]byte(bucket)), SerialNumber(key))
+		return err
+	})
+	return ids, err
+}
+
+// boltIDs 解析id列表, 返回的数据在事务之外也可以使用
+func boltIDs(b *bolt.Bucket, key SerialNumber) ([]SerialNumber, error) {
+	data := b.Get([]byte(key))
+	if data == nil {
+		return nil, nil
+	}
+	var ids []SerialNumber
+	if err := json.Unmarshal(data, &ids); err != nil {
+		return nil, err
+	}
+	return ids, nil
+}
+
+// boltPushID 加到id列表的最前面, size大于0时只保留size个, 返回移出的id
+func boltPushID(b *bolt.Bucket, key SerialNumber, id SerialNumber, size int) ([]SerialNumber, error) {
+	ids, err := boltIDs(b, key)
+	if err != nil {
+		return nil, err
+	}
+	ids = append([]SerialNumber{id}, ids...)
+	var removed []SerialNumber
+	if size > 0 && len(ids) > size {
+		removed = ids[size:]
+		ids = ids[:size]
+	}
+	data, err := json.Marshal(ids)
+	if err != nil {
+		return nil, err
+	}
+	return removed, b.Put([]byte(key), data)
+}
+
+// boltRemoveID 从id列表里删除, 空了删除整个列表
+func boltRemoveID(b *bolt.Bucket, key SerialNumber, id SerialNumber) error {
+	ids, err := boltIDs(b, key)
+	if err != nil {
+		return err
+	}
+	kept := ids[:0]
+	for _, v := range ids {
+		if v != id {
+			kept = append(kept, v)
+		}
+	}
+	if len(kept) == 0 {
+		return b.Delete([]byte(key))
+	}
+	data, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), data)
+}
+
+// boltGetNotification 解析通知记录, 返回的数据在事务之外也可以使用
+func boltGetNotification(b *bolt.Bucket, id SerialNumber) (boltNotification, error) {
+	record := boltNotification{}
+	data := b.Get([]byte(id))
+	if data == nil {
+		return record, ErrNotFound
+	}
+	err := json.Unmarshal(data, &record)
+	return record, err
+}
+
+func boltPutNotification(b *bolt.Bucket, id SerialNumber, record boltNotification) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(id), data)
+}
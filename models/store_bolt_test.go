@@ -0,0 +1,166 @@
+package models
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/boltdb/bolt"
+	"github.com/stretchr/testify/assert"
+)
+
+func withBoltStore(t *testing.T) (context.Context, func()) {
+	dir, err := ioutil.TempDir("", "gamehealthy")
+	assert.NoError(t, err)
+	store, err := OpenBoltStore(filepath.Join(dir, "store.db"))
+	assert.NoError(t, err)
+	return WithStore(context.Background(), store), func() {
+		store.Close()
+		os.RemoveAll(dir)
+	}
+}
+
+func TestBoltStoreEntity(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+
+	g := &Group{
+		ID:   "testgroup1",
+		Name: "测试游戏服务器",
+	}
+	assert.NoError(t, g.Save(ctx))
+	assert.NoError(t, g.Save(ctx))
+
+	g2 := Group{ID: g.ID}
+	assert.NoError(t, g2.Fill(ctx))
+	assert.Equal(t, g.Name, g2.Name)
+	assert.Equal(t, 2, g2.Version)
+
+	gs, err := FetchGroups(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, gs, 1)
+
+	assert.NoError(t, g.Delete(ctx))
+	assert.Equal(t, ErrNotFound, g2.Fill(ctx))
+	gs, err = FetchGroups(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, gs, 0)
+}
+
+func TestBoltStoreHeapster(t *testing.T) {
+	ctx, cleanup := withBoltStore(t)
+	defer cleanup()
+
+	g := &Group{
+		ID:   "testgroup1",
+		Name: "测试游戏服务器",
+	}
+	assert.NoError(t, g.Save(ctx))
+	hst := &Heapster{
+		ID:        "testheapster1",
+		Type:      CheckTypeTCP,
+		Port:      8080,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+		Groups:    []string{string(g.ID)},
+	}
+	assert.NoError(t, hst.Save(ctx))
+
+	// 没有状态的时候是未知
+	assert.Equal(t, HealthyStatusUnknown, hst.GetStatus(ctx))
+	_, err := hst.GetStat(ctx)
+	assert.Error(t, err)
+
+	stat := HeapsterStat{}
+	assert.NoError(t, hst.SetStatusWithStat(ctx, HealthyStatusRed, stat))
+	assert.NoError(t, hst.SetStatus(ctx, HealthyStatusYellow))
+	assert.Equal(t, HealthyStatusYellow, hst.GetStatus(ctx))
+	_, err = hst.GetStat(ctx)
+	assert.NoError(t, err)
+
+	filled := &Heapster{ID: hst.ID}
+	assert.NoError(t, filled.Fill(ctx))
+	assert.Equal(t, HealthyStatusYellow, filled.Status)
+	assert.Equal(t, 2, filled.Version)
+
+	hset, err := FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, filled.Version, hset[HeapsterSetKey(hst.ID)].Version)
+	assert.Equal(t, HealthyStatusYellow, hset[HeapsterSetKey(hst.ID)].Status)
+
+	// 组修改之后heapster版本变化
+	assert.NoError(t, g.Save(ctx))
+	hset, err = FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, filled.Version+1, hset[HeapsterSetKey(hst.ID)].Version)
+
+	statusList, err := FetchHeapsterStatus(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, statusList, 1)
+
+	// 删除同时删除状态
+	assert.NoError(t, hst.Delete(ctx))
+	assert.Equal(t, HealthyStatusUnknown, hst.GetStatus(ctx))
+	statusList, err = FetchHeapsterStatus(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, statusList, 0)
+}
+
+func TestBoltStoreMigrateRefs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gamehealthy")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "store.db")
+
+	store, err := OpenBoltStore(path)
+	assert.NoError(t, err)
+	ctx := WithStore(context.Background(), store)
+	g := &Group{ID: "testgroup1", Name: "测试游戏服务器"}
+	assert.NoError(t, g.Save(ctx))
+	hst := &Heapster{ID: "testheapster1", Type: CheckTypeTCP, Port: 8080, Timeout: time.Second, Interval: 5 * time.Second, Threshold: 3, Groups: []string{string(g.ID)}}
+	assert.NoError(t, hst.Save(ctx))
+	// 模拟没有引用关系的旧文件
+	assert.NoError(t, store.db.Update(func(tx *bolt.Tx) error {
+		return tx.DeleteBucket([]byte(boltReferrerBucket))
+	}))
+	assert.NoError(t, store.Close())
+
+	store, err = OpenBoltStore(path)
+	assert.NoError(t, err)
+	defer store.Close()
+	refs, err := store.Referrers(context.Background(), kindGroup, g.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, []Ref{{Kind: kindHeapster, ID: hst.ID}}, refs)
+}
+
+func TestNewStoreOpenOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "openonce")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// 同一个文件共用打开的存储
+	path := filepath.Join(dir, "store.db")
+	s1, err := NewStore(StoreTypeBolt, path)
+	assert.NoError(t, err)
+	s2, err := NewStore(StoreTypeBolt, path)
+	assert.NoError(t, err)
+	assert.True(t, s1 == s2)
+	ps1, err := NewProbeStore(ProbeStoreTypeLocal, filepath.Join(dir, "probes.db"), time.Hour)
+	assert.NoError(t, err)
+	ps2, err := NewProbeStore(ProbeStoreTypeLocal, filepath.Join(dir, "probes.db"), time.Hour)
+	assert.NoError(t, err)
+	assert.True(t, ps1 == ps2)
+
+	// 模型存储和探测结果存储不能使用同一个文件
+	_, err = NewProbeStore(ProbeStoreTypeLocal, path, time.Hour)
+	assert.Error(t, err)
+	_, err = NewStore(StoreTypeBolt, filepath.Join(dir, "probes.db"))
+	assert.Error(t, err)
+
+	s1.(*BoltStore).Close()
+	ps1.(*LocalProbeStore).Close()
+}
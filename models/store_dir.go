@@ -0,0 +1,318 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+)
+
+// ErrReadOnly 配置从目录加载时不能通过接口修改
+var ErrReadOnly = errors.New("config is loaded from directory, read only")
+
+// DirReloadInterval 检查配置目录是否修改的最小间隔
+var DirReloadInterval = 5 * time.Second
+
+// DirStore 从目录里的json或者yaml配置文件加载全部配置, 只读;
+// 文件修改之后自动重新加载, 内容变化的实体版本加一, 这样 HeapsterSet.Diff 可以发现修改;
+// heapster的状态还是保存在backing存储里
+type DirStore struct {
+	dir     string
+	backing Store
+
+	// 同时只有一个重新加载, 版本和上一次加载的比较
+	reloadMtx sync.Mutex
+	mtx       sync.RWMutex
+	checked   time.Time
+	stamp     string
+	entities  map[Ref]StoreEntity
+	refs      map[Ref][]Ref
+	referrers map[Ref][]Ref
+}
+
+// NewDirStore 加载目录里的配置, 第一次加载失败返回错误
+func NewDirStore(dir string, backing Store) (*DirStore, error) {
+	ds := &DirStore{
+		dir:      dir,
+		backing:  backing,
+		entities: make(map[Ref]StoreEntity),
+	}
+	if err := ds.Reload(); err != nil {
+		return nil, err
+	}
+	return ds, nil
+}
+
+// dirFiles 目录里的配置文件, 按照文件名排序, 同时返回文件名, 修改时间和大小作为标记
+func (ds *DirStore) dirFiles() ([]string, string, error) {
+	infos, err := ioutil.ReadDir(ds.dir)
+	if err != nil {
+		return nil, "", err
+	}
+	var (
+		files []string
+		stamp bytes.Buffer
+	)
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		switch strings.ToLower(filepath.Ext(info.Name())) {
+		case ".json", ".yaml", ".yml":
+		default:
+			continue
+		}
+		files = append(files, filepath.Join(ds.dir, info.Name()))
+		fmt.Fprintf(&stamp, "%s:%d:%d;", info.Name(), info.ModTime().UnixNano(), info.Size())
+	}
+	sort.Strings(files)
+	return files, stamp.String(), nil
+}
+
+// Reload 文件有修改时重新加载, 出错时保留之前的配置
+func (ds *DirStore) Reload() error {
+	ds.reloadMtx.Lock()
+	defer ds.reloadMtx.Unlock()
+	files, stamp, err := ds.dirFiles()
+	if err != nil {
+		return err
+	}
+	ds.mtx.RLock()
+	unchanged := stamp == ds.stamp
+	ds.mtx.RUnlock()
+	if unchanged {
+		return nil
+	}
+
+	bundle := &Bundle{}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		b, err := DecodeBundle(data)
+		if err != nil {
+			return fmt.Errorf("decode %s error %v", file, err)
+		}
+		bundle.Merge(b)
+	}
+	list, err := bundle.entities()
+	if err != nil {
+		return err
+	}
+
+	ds.mtx.Lock()
+	defer ds.mtx.Unlock()
+	entities := make(map[Ref]StoreEntity, len(list))
+	refs := make(map[Ref][]Ref, len(list))
+	referrers := make(map[Ref][]Ref)
+	for _, e := range list {
+		key := Ref{Kind: e.ref.Kind, ID: e.ref.ID}
+		old, ok := ds.entities[key]
+		version := old.Version
+		if !ok || !bytes.Equal(old.Meta, e.meta) {
+			version++
+		}
+		entities[key] = StoreEntity{ID: key.ID, Meta: e.meta, Version: version}
+		refs[key] = e.model.refs()
+		for _, ref := range refs[key] {
+			referrers[ref] = append(referrers[ref], key)
+		}
+	}
+	ds.entities = entities
+	ds.refs = refs
+	ds.referrers = referrers
+	ds.stamp = stamp
+	return nil
+}
+
+// refresh 距离上次检查超过间隔时重新加载, 出错时记录日志继续使用之前的配置
+func (ds *DirStore) refresh(ctx context.Context) {
+	ds.mtx.Lock()
+	if time.Since(ds.checked) < DirReloadInterval {
+		ds.mtx.Unlock()
+		return
+	}
+	ds.checked = time.Now()
+	ds.mtx.Unlock()
+	if err := ds.Reload(); err != nil {
+		middlewares.GetLogger(ctx).Warnf("reload config dir %s error %v", ds.dir, err)
+	}
+}
+
+// Get 获取单个实体
+func (ds *DirStore) Get(ctx context.Context, kind string, id SerialNumber) (StoreEntity, error) {
+	ds.refresh(ctx)
+	ds.mtx.RLock()
+	defer ds.mtx.RUnlock()
+	e, ok := ds.entities[Ref{Kind: kind, ID: id}]
+	if !ok {
+		return StoreEntity{}, ErrNotFound
+	}
+	return e, nil
+}
+
+// List 获取某个类型的全部实体
+func (ds *DirStore) List(ctx context.Context, kind string) ([]StoreEntity, error) {
+	ds.refresh(ctx)
+	ds.mtx.RLock()
+	defer ds.mtx.RUnlock()
+	var entities []StoreEntity
+	for key, e := range ds.entities {
+		if key.Kind == kind {
+			entities = append(entities, e)
+		}
+	}
+	return entities, nil
+}
+
+// Versions 获取指定实体的版本
+func (ds *DirStore) Versions(ctx context.Context, kind string, ids []string) (map[SerialNumber]int, error) {
+	ds.refresh(ctx)
+	ds.mtx.RLock()
+	defer ds.mtx.RUnlock()
+	versions := make(map[SerialNumber]int, len(ids))
+	for _, id := range ids {
+		if e, ok := ds.entities[Ref{Kind: kind, ID: SerialNumber(id)}]; ok {
+			versions[e.ID] = e.Version
+		}
+	}
+	return versions, nil
+}
+
+// AllVersions 获取某个类型全部实体的版本
+func (ds *DirStore) AllVersions(ctx context.Context, kind string) (map[SerialNumber]int, error) {
+	ds.refresh(ctx)
+	ds.mtx.RLock()
+	defer ds.mtx.RUnlock()
+	versions := make(map[SerialNumber]int)
+	for key, e := range ds.entities {
+		if key.Kind == kind {
+			versions[e.ID] = e.Version
+		}
+	}
+	return versions, nil
+}
+
+// Put 只读
+func (ds *DirStore) Put(ctx context.Context, kind string, id SerialNumber, meta []byte, version int, refs []Ref) (int, error) {
+	return 0, ErrReadOnly
+}
+
+// Delete 只读
+func (ds *DirStore) Delete(ctx context.Context, kind string, id SerialNumber) error {
+	return ErrReadOnly
+}
+
+// Apply 只读
+func (ds *DirStore) Apply(ctx context.Context, ops []StoreOp) error {
+	return ErrReadOnly
+}
+
+// Referrers 获取引用了实体的其他实体
+func (ds *DirStore) Referrers(ctx context.Context, kind string, id SerialNumber) ([]Ref, error) {
+	ds.refresh(ctx)
+	ds.mtx.RLock()
+	defer ds.mtx.RUnlock()
+	refs := append([]Ref(nil), ds.referrers[Ref{Kind: kind, ID: id}]...)
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].String() < refs[j].String()
+	})
+	return refs, nil
+}
+
+// Revisions 配置文件没有历史版本, 只返回当前版本
+func (ds *DirStore) Revisions(ctx context.Context, kind string, id SerialNumber) ([]Revision, error) {
+	e, err := ds.Get(ctx, kind, id)
+	if err == ErrNotFound {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return []Revision{{Version: e.Version, Meta: e.Meta}}, nil
+}
+
+// GetStatus 获取heapster状态
+func (ds *DirStore) GetStatus(ctx context.Context, id SerialNumber) (StoreStatus, error) {
+	return ds.backing.GetStatus(ctx, id)
+}
+
+// SetStatus 设置heapster状态
+func (ds *DirStore) SetStatus(ctx context.Context, id SerialNumber, status HealthyStatus, stat []byte) error {
+	return ds.backing.SetStatus(ctx, id, status, stat)
+}
+
+// ListStatus 获取目录里全部heapster的状态, backing存储里的heapster可能和目录里的不一样
+func (ds *DirStore) ListStatus(ctx context.Context) ([]StoreStatus, error) {
+	entities, err := ds.List(ctx, kindHeapster)
+	if err != nil {
+		return nil, err
+	}
+	statusList := make([]StoreStatus, 0, len(entities))
+	for _, e := range entities {
+		s, err := ds.backing.GetStatus(ctx, e.ID)
+		if err != nil {
+			return nil, err
+		}
+		statusList = append(statusList, s)
+	}
+	return statusList, nil
+}
+
+// OpenIncident 事故保存在backing存储里
+func (ds *DirStore) OpenIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte) error {
+	return ds.backing.OpenIncident(ctx, heapster, id, data)
+}
+
+// GetIncident 获取事故
+func (ds *DirStore) GetIncident(ctx context.Context, id SerialNumber) ([]byte, int, error) {
+	return ds.backing.GetIncident(ctx, id)
+}
+
+// PutIncident 保存事故
+func (ds *DirStore) PutIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte, version int, resolved bool) (int, error) {
+	return ds.backing.PutIncident(ctx, heapster, id, data, version, resolved)
+}
+
+// CurrentIncident 获取当前事故
+func (ds *DirStore) CurrentIncident(ctx context.Context, heapster SerialNumber) (SerialNumber, error) {
+	return ds.backing.CurrentIncident(ctx, heapster)
+}
+
+// ListIncidents 获取事故历史
+func (ds *DirStore) ListIncidents(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error) {
+	return ds.backing.ListIncidents(ctx, heapster)
+}
+
+// CreateNotification 通知记录保存在backing存储里
+func (ds *DirStore) CreateNotification(ctx context.Context, heapster SerialNumber, id SerialNumber, serialNumber int64, data []byte) error {
+	return ds.backing.CreateNotification(ctx, heapster, id, serialNumber, data)
+}
+
+// GetNotification 获取通知记录
+func (ds *DirStore) GetNotification(ctx context.Context, id SerialNumber) ([]byte, error) {
+	return ds.backing.GetNotification(ctx, id)
+}
+
+// PutNotification 保存通知记录
+func (ds *DirStore) PutNotification(ctx context.Context, id SerialNumber, data []byte) error {
+	return ds.backing.PutNotification(ctx, id, data)
+}
+
+// ListNotifications 获取通知历史
+func (ds *DirStore) ListNotifications(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error) {
+	return ds.backing.ListNotifications(ctx, heapster)
+}
+
+// NotificationsBySMS 读取短信序列号的索引
+func (ds *DirStore) NotificationsBySMS(ctx context.Context, serialNumber int64) ([]SerialNumber, error) {
+	return ds.backing.NotificationsBySMS(ctx, serialNumber)
+}
@@ -0,0 +1,72 @@
+package models
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDirStore(t *testing.T) {
+	backingCtx, cleanup := withBoltStore(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "gamehealthyconfig")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "config.yaml")
+	assert.NoError(t, ioutil.WriteFile(file, []byte(testBundleYAML), 0644))
+	// 其他扩展名的文件不加载
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "README.md"), []byte("groups: 1"), 0644))
+	ds, err := NewDirStore(dir, GetStore(backingCtx))
+	assert.NoError(t, err)
+	ctx := middlewares.WithLogger(WithStore(backingCtx, ds), 0, ioutil.Discard)
+
+	oldSet, err := FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	assert.Len(t, oldSet, 1)
+	assert.Equal(t, HealthyStatusUnknown, oldSet["testbundleheapster"].Status)
+	refs, err := FetchReferrers(ctx, kindNotifier, "testbundlenotifier")
+	assert.NoError(t, err)
+	assert.Len(t, refs, 1)
+
+	// 只读, 状态可以修改
+	g := &Group{ID: "testbundlegroup"}
+	assert.NoError(t, g.Fill(ctx))
+	assert.Equal(t, ErrReadOnly, g.Save(ctx))
+	hst := oldSet["testbundleheapster"]
+	assert.NoError(t, hst.SetStatus(ctx, HealthyStatusRed))
+	assert.Equal(t, HealthyStatusRed, hst.GetStatus(ctx))
+
+	// 修改组之后heapster的版本变化
+	bundle, err := DecodeBundle([]byte(testBundleYAML))
+	assert.NoError(t, err)
+	bundle.Groups[0].Endpoints = nil
+	data, err := bundle.Encode(BundleFormatYAML)
+	assert.NoError(t, err)
+	assert.NoError(t, ioutil.WriteFile(file, data, 0644))
+	later := time.Now().Add(time.Second)
+	assert.NoError(t, os.Chtimes(file, later, later))
+	assert.NoError(t, ds.Reload())
+	newSet, err := FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	added, modified, deleted := newSet.Diff(oldSet)
+	assert.Len(t, added, 0)
+	assert.Equal(t, HeapsterSetKeys{"testbundleheapster"}, modified)
+	assert.Len(t, deleted, 0)
+
+	// 重复的ID加载失败, 保留之前的配置
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "copy.json"), data, 0644))
+	assert.Error(t, ds.Reload())
+	again, err := FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	added, modified, deleted = again.Diff(newSet)
+	assert.Len(t, added, 0)
+	assert.Len(t, modified, 0)
+	assert.Len(t, deleted, 0)
+}
@@ -0,0 +1,756 @@
+package models
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// 迁移完成标记
+const (
+	indexMigratedKey = "gamehealthy_index_migrated"
+	refsMigratedKey  = "gamehealthy_refs_migrated"
+)
+
+// indexKey 索引集合的key
+func indexKey(kind string) string {
+	return fmt.Sprintf("gamehealthy_index_%s", kind)
+}
+
+// entityKeyPrefix 实体hash的key前缀, 加上 Ref.String() 就是实体的key
+const entityKeyPrefix = "gamehealthy"
+
+// entityKey 实体hash的key
+func entityKey(kind string, id SerialNumber) string {
+	return entityKeyPrefix + "_" + Ref{Kind: kind, ID: id}.String()
+}
+
+// revisionKey 历史版本列表的key
+func revisionKey(kind string, id SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_revision_%s_%s", kind, id)
+}
+
+// 引用关系, refs是实体引用的其他实体, referrers是引用了实体的其他实体, 集合成员是Ref.String()
+const (
+	refsKeyPrefix      = "gamehealthy_refs"
+	referrersKeyPrefix = "gamehealthy_referrers"
+)
+
+// refKey 引用关系集合的key
+func refKey(prefix string, kind string, id SerialNumber) string {
+	return prefix + "_" + Ref{Kind: kind, ID: id}.String()
+}
+
+// putScript 比较版本之后保存实体, 加入索引, 记录历史版本并更新引用关系, 版本不一致返回-1, 引用的实体不存在返回-2;
+// meta是json, 直接拼接成历史记录; 被引用实体的key在脚本里拼接
+var putScript = redis.NewScript(4, `
+local current = tonumber(redis.call('HGET', KEYS[1], 'version')) or 0
+local expected = tonumber(ARGV[2])
+if expected ~= 0 and expected ~= current then
+	return -1
+end
+for i = 9, #ARGV do
+	if redis.call('HEXISTS', ARGV[8] .. '_' .. ARGV[i], 'meta') == 0 then
+		return -2
+	end
+end
+local version = current + 1
+redis.call('HSET', KEYS[1], 'meta', ARGV[1])
+redis.call('HSET', KEYS[1], 'version', version)
+redis.call('SADD', KEYS[2], ARGV[4])
+redis.call('LPUSH', KEYS[3], '{"version":' .. version .. ',"meta":' .. ARGV[1] .. ',"time":"' .. ARGV[3] .. '"}')
+redis.call('LTRIM', KEYS[3], 0, tonumber(ARGV[5]) - 1)
+for _, ref in ipairs(redis.call('SMEMBERS', KEYS[4])) do
+	redis.call('SREM', ARGV[7] .. '_' .. ref, ARGV[6])
+end
+redis.call('DEL', KEYS[4])
+for i = 9, #ARGV do
+	redis.call('SADD', KEYS[4], ARGV[i])
+	redis.call('SADD', ARGV[7] .. '_' .. ARGV[i], ARGV[6])
+end
+return version
+`)
+
+// putIncidentScript 比较版本之后保存事故, 版本保存在单独的key里, 版本不一致返回-1; ARGV[3]为1时清除当前事故
+var putIncidentScript = redis.NewScript(3, `
+local current = tonumber(redis.call('GET', KEYS[2])) or 0
+local expected = tonumber(ARGV[2])
+if expected ~= 0 and expected ~= current then
+	return -1
+end
+redis.call('SET', KEYS[1], ARGV[1])
+if ARGV[3] == '1' then
+	redis.call('DEL', KEYS[3])
+end
+return redis.call('INCR', KEYS[2])
+`)
+
+// deleteScript 删除实体, 历史版本和引用关系并移出索引, 还在被其他实体引用时返回-1
+var deleteScript = redis.NewScript(5, `
+for _, ref in ipairs(redis.call('SMEMBERS', KEYS[4])) do
+	if ref ~= ARGV[2] then
+		return -1
+	end
+end
+for _, ref in ipairs(redis.call('SMEMBERS', KEYS[3])) do
+	redis.call('SREM', ARGV[3] .. '_' .. ref, ARGV[2])
+end
+redis.call('DEL', KEYS[1], KEYS[2], KEYS[3], KEYS[4])
+redis.call('SREM', KEYS[5], ARGV[1])
+return 1
+`)
+
+// redisStore 实体保存在hash里, meta和version字段, heapster的状态也在同一个hash
+type redisStore struct{}
+
+// Get 获取单个实体
+func (redisStore) Get(ctx context.Context, kind string, id SerialNumber) (StoreEntity, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("HMGET", entityKey(kind, id), "meta", "version"))
+	if err != nil {
+		return StoreEntity{}, err
+	}
+	return redisEntity(id, values)
+}
+
+// List 通过索引读取全部实体, 使用pipeline只需要两次往返
+func (redisStore) List(ctx context.Context, kind string) ([]StoreEntity, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", indexKey(kind)))
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisFetch(conn, kind, ids, "meta", "version")
+	if err != nil {
+		return nil, err
+	}
+	entities := make([]StoreEntity, 0, len(ids))
+	for i, id := range ids {
+		e, err := redisEntity(SerialNumber(id), values[i])
+		if err != nil {
+			continue
+		}
+		entities = append(entities, e)
+	}
+	return entities, nil
+}
+
+// Versions 批量读取实体版本
+func (redisStore) Versions(ctx context.Context, kind string, ids []string) (map[SerialNumber]int, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	return redisVersions(conn, kind, ids)
+}
+
+// AllVersions 读取索引里全部实体的版本
+func (redisStore) AllVersions(ctx context.Context, kind string) (map[SerialNumber]int, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", indexKey(kind)))
+	if err != nil {
+		return nil, err
+	}
+	return redisVersions(conn, kind, ids)
+}
+
+// Put 使用脚本原子地比较版本并保存
+func (redisStore) Put(ctx context.Context, kind string, id SerialNumber, meta []byte, version int, refs []Ref) (int, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	self := Ref{Kind: kind, ID: id}
+	args := []interface{}{
+		entityKey(kind, id), indexKey(kind), revisionKey(kind, id), refKey(refsKeyPrefix, kind, id),
+		meta, version, time.Now().Format(time.RFC3339Nano), id, MaxRevisions, self.String(), referrersKeyPrefix, entityKeyPrefix,
+	}
+	for _, ref := range refs {
+		args = append(args, ref.String())
+	}
+	newVersion, err := redis.Int(putScript.Do(conn, args...))
+	if err != nil {
+		return 0, err
+	}
+	switch newVersion {
+	case -1:
+		return 0, ErrConflict
+	case -2:
+		return 0, ErrRefNotFound
+	}
+	return newVersion, nil
+}
+
+// Delete 使用脚本原子地删除
+func (redisStore) Delete(ctx context.Context, kind string, id SerialNumber) error {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	self := Ref{Kind: kind, ID: id}
+	ret, err := redis.Int(deleteScript.Do(conn,
+		entityKey(kind, id), revisionKey(kind, id), refKey(refsKeyPrefix, kind, id), refKey(referrersKeyPrefix, kind, id), indexKey(kind),
+		id, self.String(), referrersKeyPrefix))
+	if err != nil {
+		return err
+	}
+	if ret < 0 {
+		return ErrInUse
+	}
+	return nil
+}
+
+// Apply 使用WATCH和MULTI执行, 执行前实体或引用关系被修改时返回 ErrConflict
+func (redisStore) Apply(ctx context.Context, ops []StoreOp) error {
+	if len(ops) == 0 {
+		return nil
+	}
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	// 索引和被删除实体的反向引用也要WATCH, 并发创建实体或者引用被删除的实体时事务不执行
+	var (
+		keys  []interface{}
+		kinds = make(map[string]bool)
+	)
+	for _, op := range ops {
+		keys = append(keys, entityKey(op.Kind, op.ID), refKey(refsKeyPrefix, op.Kind, op.ID))
+		if op.Delete {
+			keys = append(keys, refKey(referrersKeyPrefix, op.Kind, op.ID))
+		}
+		if !kinds[op.Kind] {
+			kinds[op.Kind] = true
+			keys = append(keys, indexKey(op.Kind))
+		}
+	}
+	if _, err := conn.Do("WATCH", keys...); err != nil {
+		return err
+	}
+	// 已经WATCH了, 出错返回之前要取消
+	unwatch := func(err error) error {
+		conn.Do("UNWATCH")
+		return err
+	}
+	for _, op := range ops {
+		conn.Send("HGET", entityKey(op.Kind, op.ID), "version")
+		conn.Send("SMEMBERS", refKey(refsKeyPrefix, op.Kind, op.ID))
+	}
+	if err := conn.Flush(); err != nil {
+		return unwatch(err)
+	}
+	versions := make([]int, len(ops))
+	oldRefs := make([][]string, len(ops))
+	for i := range ops {
+		version, err := redis.Int(conn.Receive())
+		if err != nil && err != redis.ErrNil {
+			return unwatch(err)
+		}
+		versions[i] = version
+		if oldRefs[i], err = redis.Strings(conn.Receive()); err != nil {
+			return unwatch(err)
+		}
+	}
+	for i, op := range ops {
+		if op.Version != 0 && op.Version != versions[i] {
+			return unwatch(ErrConflict)
+		}
+	}
+
+	now := time.Now()
+	conn.Send("MULTI")
+	for i, op := range ops {
+		self := Ref{Kind: op.Kind, ID: op.ID}
+		for _, ref := range oldRefs[i] {
+			conn.Send("SREM", referrersKeyPrefix+"_"+ref, self.String())
+		}
+		conn.Send("DEL", refKey(refsKeyPrefix, op.Kind, op.ID))
+		if op.Delete {
+			conn.Send("DEL", entityKey(op.Kind, op.ID), revisionKey(op.Kind, op.ID), refKey(referrersKeyPrefix, op.Kind, op.ID))
+			conn.Send("SREM", indexKey(op.Kind), op.ID)
+			continue
+		}
+		rev, err := json.Marshal(Revision{
+			Version: versions[i] + 1,
+			Meta:    op.Meta,
+			Time:    now,
+		})
+		if err != nil {
+			conn.Do("DISCARD")
+			return unwatch(err)
+		}
+		conn.Send("HMSET", entityKey(op.Kind, op.ID), "meta", op.Meta, "version", versions[i]+1)
+		conn.Send("SADD", indexKey(op.Kind), op.ID)
+		conn.Send("LPUSH", revisionKey(op.Kind, op.ID), rev)
+		conn.Send("LTRIM", revisionKey(op.Kind, op.ID), 0, MaxRevisions-1)
+		for _, ref := range op.Refs {
+			conn.Send("SADD", refKey(refsKeyPrefix, op.Kind, op.ID), ref.String())
+			conn.Send("SADD", refKey(referrersKeyPrefix, ref.Kind, ref.ID), self.String())
+		}
+	}
+	values, err := conn.Do("EXEC")
+	if err != nil {
+		return err
+	}
+	// WATCH的key被修改过, 事务没有执行
+	if values == nil {
+		return ErrConflict
+	}
+	return nil
+}
+
+// Referrers 读取反向引用集合
+func (redisStore) Referrers(ctx context.Context, kind string, id SerialNumber) ([]Ref, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	members, err := redis.Strings(conn.Do("SMEMBERS", refKey(referrersKeyPrefix, kind, id)))
+	if err != nil {
+		return nil, err
+	}
+	refs := make([]Ref, 0, len(members))
+	for _, member := range members {
+		if ref, ok := parseRef(member); ok {
+			refs = append(refs, ref)
+		}
+	}
+	sort.Slice(refs, func(i, j int) bool {
+		return refs[i].String() < refs[j].String()
+	})
+	return refs, nil
+}
+
+// Revisions 读取历史版本列表
+func (redisStore) Revisions(ctx context.Context, kind string, id SerialNumber) ([]Revision, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	values, err := redis.ByteSlices(conn.Do("LRANGE", revisionKey(kind, id), 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	revisions := make([]Revision, 0, len(values))
+	for _, data := range values {
+		rev := Revision{}
+		if err := json.Unmarshal(data, &rev); err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rev)
+	}
+	return revisions, nil
+}
+
+// GetStatus 获取状态
+func (redisStore) GetStatus(ctx context.Context, id SerialNumber) (StoreStatus, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("HMGET", entityKey(kindHeapster, id), "status", "stat"))
+	if err != nil {
+		return StoreStatus{}, err
+	}
+	return redisStatus(id, values), nil
+}
+
+// SetStatus 设置状态
+func (redisStore) SetStatus(ctx context.Context, id SerialNumber, status HealthyStatus, stat []byte) error {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	var err error
+	if stat == nil {
+		_, err = conn.Do("HSET", entityKey(kindHeapster, id), "status", status)
+	} else {
+		_, err = conn.Do("HMSET", entityKey(kindHeapster, id), "status", status, "stat", stat)
+	}
+	return err
+}
+
+// ListStatus 批量获取状态
+func (redisStore) ListStatus(ctx context.Context) ([]StoreStatus, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", indexKey(kindHeapster)))
+	if err != nil {
+		return nil, err
+	}
+	values, err := redisFetch(conn, kindHeapster, ids, "status", "stat")
+	if err != nil {
+		return nil, err
+	}
+	statusList := make([]StoreStatus, 0, len(ids))
+	for i, id := range ids {
+		statusList = append(statusList, redisStatus(SerialNumber(id), values[i]))
+	}
+	return statusList, nil
+}
+
+// redisFetch 批量读取实体的字段
+func redisFetch(conn redis.Conn, kind string, ids []string, fields ...interface{}) ([][]interface{}, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+	for _, id := range ids {
+		args := append([]interface{}{entityKey(kind, SerialNumber(id))}, fields...)
+		if err := conn.Send("HMGET", args...); err != nil {
+			return nil, err
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return nil, err
+	}
+	ret := make([][]interface{}, 0, len(ids))
+	for range ids {
+		values, err := redis.Values(conn.Receive())
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, values)
+	}
+	return ret, nil
+}
+
+// redisVersions 批量读取实体版本, 不存在的实体不返回
+func redisVersions(conn redis.Conn, kind string, ids []string) (map[SerialNumber]int, error) {
+	values, err := redisFetch(conn, kind, ids, "version")
+	if err != nil {
+		return nil, err
+	}
+	versions := make(map[SerialNumber]int, len(ids))
+	for i, id := range ids {
+		version, err := redis.Int(values[i][0], nil)
+		if err != nil {
+			continue
+		}
+		versions[SerialNumber(id)] = version
+	}
+	return versions, nil
+}
+
+// redisEntity 解析 meta 和 version 字段
+func redisEntity(id SerialNumber, values []interface{}) (StoreEntity, error) {
+	meta, err := redis.Bytes(values[0], nil)
+	if err == redis.ErrNil {
+		return StoreEntity{}, ErrNotFound
+	} else if err != nil {
+		return StoreEntity{}, err
+	}
+	version, err := redis.Int(values[1], nil)
+	if err != nil {
+		return StoreEntity{}, err
+	}
+	return StoreEntity{
+		ID:      id,
+		Meta:    meta,
+		Version: version,
+	}, nil
+}
+
+// redisStatus 解析 status 和 stat 字段
+func redisStatus(id SerialNumber, values []interface{}) StoreStatus {
+	status, _ := redis.String(values[0], nil)
+	stat, _ := redis.Bytes(values[1], nil)
+	return StoreStatus{
+		ID:     id,
+		Status: parseHealthyStatus(status),
+		Stat:   stat,
+	}
+}
+
+// MigrateIndexes 为已有数据建立索引集合和引用关系, 只在第一次启动时执行
+func MigrateIndexes(ctx context.Context) error {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	migrated, err := redis.Bool(conn.Do("EXISTS", indexMigratedKey))
+	if err != nil {
+		return err
+	}
+	if !migrated {
+		for _, kind := range storeKinds {
+			if err := migrateIndex(conn, kind); err != nil {
+				return fmt.Errorf("migrate %s index error %v", kind, err)
+			}
+		}
+		if _, err := conn.Do("SET", indexMigratedKey, 1); err != nil {
+			return err
+		}
+	}
+
+	// 引用关系依赖索引, 在索引之后建立
+	migrated, err = redis.Bool(conn.Do("EXISTS", refsMigratedKey))
+	if err != nil || migrated {
+		return err
+	}
+	for _, kind := range storeKinds {
+		if err := migrateRefs(ctx, conn, kind); err != nil {
+			return fmt.Errorf("migrate %s references error %v", kind, err)
+		}
+	}
+	_, err = conn.Do("SET", refsMigratedKey, 1)
+	return err
+}
+
+// migrateRefs 解析已有实体的引用, 建立引用和反向引用集合
+func migrateRefs(ctx context.Context, conn redis.Conn, kind string) error {
+	entities, err := redisStore{}.List(ctx, kind)
+	if err != nil {
+		return err
+	}
+	count := 0
+	for _, e := range entities {
+		model, err := newKindModel(kind)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(e.Meta, model); err != nil {
+			continue
+		}
+		self := Ref{Kind: kind, ID: e.ID}
+		for _, ref := range model.refs() {
+			conn.Send("SADD", refKey(refsKeyPrefix, kind, e.ID), ref.String())
+			conn.Send("SADD", refKey(referrersKeyPrefix, ref.Kind, ref.ID), self.String())
+			count += 2
+		}
+	}
+	if err := conn.Flush(); err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		if _, err := conn.Receive(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// migrateIndex 使用SCAN遍历实体, 有meta字段的加入索引
+func migrateIndex(conn redis.Conn, kind string) error {
+	prefix := entityKey(kind, "")
+	cursor := 0
+	for {
+		values, err := redis.Values(conn.Do("SCAN", cursor, "MATCH", prefix+"*", "COUNT", 500))
+		if err != nil {
+			return err
+		}
+		var keys []string
+		if _, err := redis.Scan(values, &cursor, &keys); err != nil {
+			return err
+		}
+		for _, key := range keys {
+			conn.Send("HEXISTS", key, "meta")
+		}
+		conn.Flush()
+		args := []interface{}{indexKey(kind)}
+		for _, key := range keys {
+			exists, err := redis.Bool(conn.Receive())
+			// 不是hash的key会返回WRONGTYPE错误, 直接跳过
+			if err != nil || !exists {
+				continue
+			}
+			args = append(args, strings.TrimPrefix(key, prefix))
+		}
+		if len(args) > 1 {
+			if _, err := conn.Do("SADD", args...); err != nil {
+				return err
+			}
+		}
+		if cursor == 0 {
+			return nil
+		}
+	}
+}
+
+// 事故和通知记录的key
+func incidentKey(id SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_incident_%s", id)
+}
+
+func incidentVersionKey(id SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_incident_version_%s", id)
+}
+
+func currentIncidentKey(heapster SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_incident_current_%s", heapster)
+}
+
+func incidentHistoryKey(heapster SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_incidents_%s", heapster)
+}
+
+func notificationKey(id SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_notification_%s", id)
+}
+
+func notificationHistoryKey(heapster SerialNumber) string {
+	return fmt.Sprintf("gamehealthy_notifications_%s", heapster)
+}
+
+func notificationSMSKey(serialNumber int64) string {
+	return fmt.Sprintf("gamehealthy_notification_sms_%d", serialNumber)
+}
+
+// OpenIncident 保存事故, 设置当前事故并加入历史
+func (redisStore) OpenIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte) error {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	historyKey := incidentHistoryKey(heapster)
+	conn.Send("MULTI")
+	conn.Send("SET", incidentKey(id), data)
+	conn.Send("SET", incidentVersionKey(id), 1)
+	conn.Send("SET", currentIncidentKey(heapster), id)
+	conn.Send("LPUSH", historyKey, id)
+	conn.Send("LTRIM", historyKey, 0, incidentHistorySize-1)
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// GetIncident 获取事故和版本, 旧版本保存的事故没有版本, 返回0
+func (redisStore) GetIncident(ctx context.Context, id SerialNumber) ([]byte, int, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	values, err := redis.Values(conn.Do("MGET", incidentKey(id), incidentVersionKey(id)))
+	if err != nil {
+		return nil, 0, err
+	}
+	var (
+		data    []byte
+		version int
+	)
+	if _, err := redis.Scan(values, &data, &version); err != nil {
+		return nil, 0, err
+	}
+	if data == nil {
+		return nil, 0, ErrNotFound
+	}
+	return data, version, nil
+}
+
+// PutIncident 使用脚本比较版本之后保存事故, 结束时删除当前事故
+func (redisStore) PutIncident(ctx context.Context, heapster SerialNumber, id SerialNumber, data []byte, version int, resolved bool) (int, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	resolvedArg := 0
+	if resolved {
+		resolvedArg = 1
+	}
+	newVersion, err := redis.Int(putIncidentScript.Do(conn,
+		incidentKey(id), incidentVersionKey(id), currentIncidentKey(heapster),
+		data, version, resolvedArg))
+	if err != nil {
+		return 0, err
+	}
+	if newVersion < 0 {
+		return 0, ErrConflict
+	}
+	return newVersion, nil
+}
+
+// CurrentIncident 获取当前事故
+func (redisStore) CurrentIncident(ctx context.Context, heapster SerialNumber) (SerialNumber, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	id, err := redis.String(conn.Do("GET", currentIncidentKey(heapster)))
+	if err == redis.ErrNil {
+		return "", nil
+	}
+	return SerialNumber(id), err
+}
+
+// ListIncidents 获取事故历史
+func (redisStore) ListIncidents(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error) {
+	return redisIDList(ctx, incidentHistoryKey(heapster))
+}
+
+// CreateNotification 保存通知记录并加入历史, 短信同时加入序列号的索引
+func (redisStore) CreateNotification(ctx context.Context, heapster SerialNumber, id SerialNumber, serialNumber int64, data []byte) error {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	expire := int(notificationExpire / time.Second)
+	historyKey := notificationHistoryKey(heapster)
+	conn.Send("MULTI")
+	conn.Send("SET", notificationKey(id), data, "EX", expire)
+	conn.Send("LPUSH", historyKey, id)
+	conn.Send("LTRIM", historyKey, 0, notificationHistorySize-1)
+	if serialNumber != 0 {
+		smsKey := notificationSMSKey(serialNumber)
+		conn.Send("SADD", smsKey, id)
+		conn.Send("EXPIRE", smsKey, expire)
+	}
+	_, err := conn.Do("EXEC")
+	return err
+}
+
+// GetNotification 获取通知记录
+func (redisStore) GetNotification(ctx context.Context, id SerialNumber) ([]byte, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	data, err := redis.Bytes(conn.Do("GET", notificationKey(id)))
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// PutNotification 保存通知记录
+func (redisStore) PutNotification(ctx context.Context, id SerialNumber, data []byte) error {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	_, err := conn.Do("SET", notificationKey(id), data, "EX", int(notificationExpire/time.Second))
+	return err
+}
+
+// ListNotifications 获取通知历史
+func (redisStore) ListNotifications(ctx context.Context, heapster SerialNumber) ([]SerialNumber, error) {
+	return redisIDList(ctx, notificationHistoryKey(heapster))
+}
+
+// NotificationsBySMS 读取短信序列号的索引
+func (redisStore) NotificationsBySMS(ctx context.Context, serialNumber int64) ([]SerialNumber, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("SMEMBERS", notificationSMSKey(serialNumber)))
+	if err != nil {
+		return nil, err
+	}
+	return toSerialNumbers(ids), nil
+}
+
+// redisIDList 读取历史列表
+func redisIDList(ctx context.Context, key string) ([]SerialNumber, error) {
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	ids, err := redis.Strings(conn.Do("LRANGE", key, 0, -1))
+	if err != nil {
+		return nil, err
+	}
+	return toSerialNumbers(ids), nil
+}
+
+// toSerialNumbers 转换id列表
+func toSerialNumbers(ids []string) []SerialNumber {
+	ret := make([]SerialNumber, 0, len(ids))
+	for _, id := range ids {
+		ret = append(ret, SerialNumber(id))
+	}
+	return ret
+}
@@ -0,0 +1,100 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/garyburd/redigo/redis"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexSaveDelete(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	g := &Group{
+		ID:   "testindexgroup",
+		Name: "索引测试",
+	}
+	assert.NoError(t, g.Save(ctx))
+	member, err := redis.Bool(conn.Do("SISMEMBER", indexKey(kindGroup), g.ID))
+	assert.NoError(t, err)
+	assert.True(t, member)
+
+	gs, err := FetchGroups(ctx)
+	assert.NoError(t, err)
+	found := false
+	for _, item := range gs {
+		if item.ID == g.ID {
+			found = true
+			assert.Equal(t, g.Name, item.Name)
+			assert.True(t, item.Version > 0)
+		}
+	}
+	assert.True(t, found)
+
+	assert.NoError(t, g.Delete(ctx))
+	member, err = redis.Bool(conn.Do("SISMEMBER", indexKey(kindGroup), g.ID))
+	assert.NoError(t, err)
+	assert.False(t, member)
+}
+
+func TestFetchHeapstersVersion(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+
+	g := &Group{
+		ID:   "testindexgroup2",
+		Name: "索引测试",
+	}
+	assert.NoError(t, g.Save(ctx))
+	defer g.Delete(ctx)
+	hst := &Heapster{
+		ID:        "testindexheapster",
+		Type:      CheckTypeHTTP,
+		Port:      80,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+		Groups:    []string{string(g.ID)},
+	}
+	assert.NoError(t, hst.Save(ctx))
+	defer hst.Delete(ctx)
+
+	filled := &Heapster{ID: hst.ID}
+	assert.NoError(t, filled.Fill(ctx))
+	hset, err := FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, filled.Version, hset[HeapsterSetKey(hst.ID)].Version)
+
+	// 组修改之后版本变化
+	assert.NoError(t, g.Save(ctx))
+	hset, err = FetchHeapsters(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, filled.Version+1, hset[HeapsterSetKey(hst.ID)].Version)
+}
+
+func TestMigrateIndexes(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	conn := middlewares.GetRedisConn(ctx)
+	defer conn.Close()
+
+	// 模拟没有索引的旧数据
+	_, err := conn.Do("HSET", entityKey(kindNotifier, "testmigrate"), "meta", `{"id":"testmigrate","type":"sms"}`)
+	assert.NoError(t, err)
+	_, err = conn.Do("HSET", entityKey(kindNotifier, "testmigrate"), "version", 1)
+	assert.NoError(t, err)
+	_, err = conn.Do("DEL", indexMigratedKey)
+	assert.NoError(t, err)
+
+	assert.NoError(t, MigrateIndexes(ctx))
+	member, err := redis.Bool(conn.Do("SISMEMBER", indexKey(kindNotifier), "testmigrate"))
+	assert.NoError(t, err)
+	assert.True(t, member)
+
+	hn := &HeapsterNotifier{ID: "testmigrate"}
+	assert.NoError(t, hn.Delete(ctx))
+}
@@ -0,0 +1,243 @@
+package models
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// MessageTemplate 通知消息模版, 使用text/template语法渲染TemplateData
+type MessageTemplate struct {
+	ID      SerialNumber `json:"id"`
+	Name    string       `json:"name"`
+	Text    string       `json:"text"`
+	Version int          `json:"version,omitempty"`
+}
+
+// MessageTemplates 列表
+type MessageTemplates []MessageTemplate
+
+// TemplateData 模版数据
+type TemplateData struct {
+	Heapster Heapster          `json:"heapster"`
+	Report   Report            `json:"report"`
+	Incident *Incident         `json:"incident,omitempty"`
+	Labels   map[string]string `json:"labels"`
+}
+
+// DefaultSMSTemplate 短信默认模版
+const DefaultSMSTemplate = `监控提醒：({{.Heapster.Name}})中的({{.Report.Target}})` +
+	`{{if .Report.Reason}}{{.Report.Reason}}{{else}}最近出现{{.Report.Faileds}}次异常{{end}}` +
+	`{{if .Report.Suppressed}}，{{.Report.Suppressed}}个依赖检查已抑制{{end}}需要及时处理请查阅监控报告`
+
+// NewTemplateData 构建模版数据, 标签包含heapster和目标的基本信息
+func NewTemplateData(hp Heapster, report Report, incident *Incident) TemplateData {
+	labels := map[string]string{
+		"heapster": hp.Name,
+		"type":     string(hp.Type),
+		"target":   report.Target,
+		"status":   string(report.Status),
+	}
+	if report.Group != "" {
+		labels["group"] = report.Group
+	}
+	if hp.Location != "" {
+		labels["location"] = hp.Location
+	}
+	return TemplateData{
+		Heapster: hp,
+		Report:   report,
+		Incident: incident,
+		Labels:   labels,
+	}
+}
+
+// SampleTemplateData 预览和验证模版使用的示例数据
+func SampleTemplateData() TemplateData {
+	hp := Heapster{
+		ID:        "sample",
+		Name:      "示例监控",
+		Type:      CheckTypeTCP,
+		Port:      10000,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+		Groups:    []string{"sample"},
+	}
+	report := Report{
+		Heapster: "sample",
+		Target:   "10.0.10.46:10000",
+		Group:    "sample",
+		Success:  2,
+		Faileds:  3,
+		MaxDelay: 900 * time.Millisecond,
+		P50Delay: 120 * time.Millisecond,
+		P95Delay: 800 * time.Millisecond,
+		Status:   HealthyStatusRed,
+	}
+	incident := &Incident{
+		ID:        "sample",
+		Heapster:  "sample",
+		Status:    IncidentStatusOpen,
+		StartedAt: time.Now().Add(-10 * time.Minute),
+	}
+	return NewTemplateData(hp, report, incident)
+}
+
+// templateDuration 可读的时间间隔
+func templateDuration(d time.Duration) string {
+	switch {
+	case d < time.Second:
+		return fmt.Sprintf("%dms", d/time.Millisecond)
+	case d < time.Minute:
+		return fmt.Sprintf("%.1fs", d.Seconds())
+	case d < time.Hour:
+		return fmt.Sprintf("%d分钟", d/time.Minute)
+	default:
+		return fmt.Sprintf("%d小时%d分钟", d/time.Hour, d%time.Hour/time.Minute)
+	}
+}
+
+// templateLabels 按照名称排序输出标签
+func templateLabels(labels map[string]string) string {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+labels[k])
+	}
+	return strings.Join(pairs, ", ")
+}
+
+// templateStatusText 状态的中文描述
+func templateStatusText(hs HealthyStatus) string {
+	switch hs {
+	case HealthyStatusRed:
+		return "严重"
+	case HealthyStatusYellow:
+		return "警告"
+	case HealthyStatusGreen:
+		return "正常"
+	default:
+		return "未知"
+	}
+}
+
+var templateFuncs = template.FuncMap{
+	"duration": templateDuration,
+	"since": func(t time.Time) string {
+		return templateDuration(time.Since(t))
+	},
+	"labels": templateLabels,
+	"label": func(labels map[string]string, name string) string {
+		return labels[name]
+	},
+	"statuscolor": func(hs HealthyStatus) string {
+		return hs.Color()
+	},
+	"statustext": templateStatusText,
+}
+
+// ParseTemplate 解析模版文本
+func ParseTemplate(name string, text string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=zero").Parse(text)
+}
+
+// RenderTemplate 解析并渲染模版文本
+func RenderTemplate(text string, data TemplateData) (string, error) {
+	tpl, err := ParseTemplate("message", text)
+	if err != nil {
+		return "", err
+	}
+	buf := &bytes.Buffer{}
+	if err := tpl.Execute(buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+// Render 渲染模版
+func (mt *MessageTemplate) Render(data TemplateData) (string, error) {
+	return RenderTemplate(mt.Text, data)
+}
+
+// Validate 验证, 模版需要能够用示例数据渲染
+func (mt *MessageTemplate) Validate() error {
+	if mt.ID == "" {
+		return fmt.Errorf("empty id")
+	}
+	if mt.Name == "" {
+		return fmt.Errorf("empty name")
+	}
+	if mt.Text == "" {
+		return fmt.Errorf("empty text")
+	}
+	if _, err := mt.Render(SampleTemplateData()); err != nil {
+		return fmt.Errorf("template error %v", err)
+	}
+	return nil
+}
+
+// Fill 获取模版
+func (mt *MessageTemplate) Fill(ctx context.Context) error {
+	e, err := GetStore(ctx).Get(ctx, kindTemplate, mt.ID)
+	if err != nil {
+		return err
+	}
+	mt.Version, err = decodeEntity(e, mt)
+	return err
+}
+
+// Save 保存模版, Version不为0时检查是否已经被修改
+func (mt *MessageTemplate) Save(ctx context.Context) error {
+	if err := mt.Validate(); err != nil {
+		return err
+	}
+	data, err := json.Marshal(mt)
+	if err != nil {
+		return err
+	}
+	version, err := GetStore(ctx).Put(ctx, kindTemplate, mt.ID, data, mt.Version, mt.refs())
+	if err != nil {
+		return err
+	}
+	mt.Version = version
+	return nil
+}
+
+// Delete 删除, 还在被其他对象引用时返回 InUseError
+func (mt *MessageTemplate) Delete(ctx context.Context) error {
+	return deleteEntity(ctx, kindTemplate, mt.ID, false)
+}
+
+// ForceDelete 删除并去掉其他对象对它的引用
+func (mt *MessageTemplate) ForceDelete(ctx context.Context) error {
+	return deleteEntity(ctx, kindTemplate, mt.ID, true)
+}
+
+// FetchMessageTemplates 获取模版列表
+func FetchMessageTemplates(ctx context.Context) (MessageTemplates, error) {
+	entities, err := GetStore(ctx).List(ctx, kindTemplate)
+	if err != nil {
+		return nil, err
+	}
+	mts := make(MessageTemplates, 0, len(entities))
+	for _, e := range entities {
+		mt := MessageTemplate{
+			ID: e.ID,
+		}
+		if mt.Version, err = decodeEntity(e, &mt); err != nil {
+			continue
+		}
+		mts = append(mts, mt)
+	}
+	return mts, nil
+}
@@ -0,0 +1,70 @@
+package models
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDefaultSMSTemplate(t *testing.T) {
+	hp := Heapster{Name: "房间服"}
+	text, err := RenderTemplate(DefaultSMSTemplate, NewTemplateData(hp, Report{
+		Target:  "10.0.10.46:10000",
+		Faileds: 3,
+	}, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "监控提醒：(房间服)中的(10.0.10.46:10000)最近出现3次异常需要及时处理请查阅监控报告", text)
+
+	text, err = RenderTemplate(DefaultSMSTemplate, NewTemplateData(hp, Report{
+		Target:     "10.0.10.46:10000",
+		Reason:     "p95延迟2s超过1s",
+		Suppressed: 2,
+	}, nil))
+	assert.NoError(t, err)
+	assert.Equal(t, "监控提醒：(房间服)中的(10.0.10.46:10000)p95延迟2s超过1s，2个依赖检查已抑制需要及时处理请查阅监控报告", text)
+}
+
+func TestTemplateFuncs(t *testing.T) {
+	mt := MessageTemplate{
+		ID:   "testtemplate",
+		Name: "funcs",
+		Text: `{{statustext .Report.Status}} {{statuscolor .Report.Status}} {{duration .Report.P95Delay}} {{label .Labels "group"}} {{labels .Labels}}`,
+	}
+	assert.NoError(t, mt.Validate())
+	text, err := mt.Render(SampleTemplateData())
+	assert.NoError(t, err)
+	assert.Equal(t, "严重 #d50200 800ms sample group=sample, heapster=示例监控, status=red, target=10.0.10.46:10000, type=tcp", text)
+
+	assert.Equal(t, "1.5s", templateDuration(1500*time.Millisecond))
+	assert.Equal(t, "1小时5分钟", templateDuration(65*time.Minute))
+
+	mt.Text = "{{.Report.Target"
+	assert.Error(t, mt.Validate())
+	mt.Text = "{{.Report.NotExists}}"
+	assert.Error(t, mt.Validate())
+}
+
+func TestMessageTemplate(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+
+	mt := MessageTemplate{
+		ID:   "testtemplate1",
+		Name: "简短短信",
+		Text: "{{.Heapster.Name}} {{.Report.Target}} {{statustext .Report.Status}}",
+	}
+	assert.NoError(t, mt.Save(ctx))
+
+	mt1 := MessageTemplate{ID: mt.ID}
+	assert.NoError(t, mt1.Fill(ctx))
+	assert.Equal(t, mt.Text, mt1.Text)
+	assert.Equal(t, 1, mt1.Version)
+
+	mts, err := FetchMessageTemplates(ctx)
+	assert.NoError(t, err)
+	assert.True(t, len(mts) > 0)
+	assert.NoError(t, mt.Delete(ctx))
+}
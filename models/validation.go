@@ -0,0 +1,117 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// FieldError 字段校验错误, Field是json字段路径, 例如 Config.targets, rule.red_percent
+type FieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// ValidationErrors 校验错误列表, 模型的Validate一次返回全部字段的错误
+type ValidationErrors []FieldError
+
+func (errs ValidationErrors) Error() string {
+	msgs := make([]string, 0, len(errs))
+	for _, e := range errs {
+		if e.Field == "" {
+			msgs = append(msgs, e.Message)
+		} else {
+			msgs = append(msgs, e.Field+": "+e.Message)
+		}
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Add 添加字段错误
+func (errs *ValidationErrors) Add(field string, format string, args ...interface{}) {
+	*errs = append(*errs, FieldError{Field: field, Message: fmt.Sprintf(format, args...)})
+}
+
+// Merge 合并子结构的校验错误, 字段加上前缀; 不是 ValidationErrors 的作为前缀字段的错误
+func (errs *ValidationErrors) Merge(prefix string, err error) {
+	if err == nil {
+		return
+	}
+	sub, ok := err.(ValidationErrors)
+	if !ok {
+		errs.Add(prefix, "%v", err)
+		return
+	}
+	for _, e := range sub {
+		field := prefix
+		if e.Field != "" {
+			field = prefix + "." + e.Field
+		}
+		*errs = append(*errs, FieldError{Field: field, Message: e.Message})
+	}
+}
+
+// Err 没有错误时返回nil, 避免返回非nil的空列表
+func (errs ValidationErrors) Err() error {
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// decodeConfig 按照json标签逐个字段解码map配置到结构体, 类型不对的字段记录错误, 不认识的字段忽略
+func decodeConfig(config map[string]interface{}, v interface{}) ValidationErrors {
+	var errs ValidationErrors
+	data, err := json.Marshal(config)
+	if err != nil {
+		errs.Add("", "%v", err)
+		return errs
+	}
+	raw := make(map[string]json.RawMessage)
+	if err := json.Unmarshal(data, &raw); err != nil {
+		errs.Add("", "%v", err)
+		return errs
+	}
+	decodeFields(reflect.ValueOf(v).Elem(), raw, &errs)
+	return errs
+}
+
+// decodeFields 解码结构体的字段, 嵌入的结构体展开
+func decodeFields(rv reflect.Value, raw map[string]json.RawMessage, errs *ValidationErrors) {
+	rt := rv.Type()
+	for i := 0; i < rt.NumField(); i++ {
+		field := rt.Field(i)
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			decodeFields(rv.Field(i), raw, errs)
+			continue
+		}
+		name := strings.Split(field.Tag.Get("json"), ",")[0]
+		value, ok := raw[name]
+		if name == "" || name == "-" || !ok || string(value) == "null" {
+			continue
+		}
+		if err := json.Unmarshal(value, rv.Field(i).Addr().Interface()); err != nil {
+			errs.Add(name, "must be %s", configTypeName(field.Type))
+		}
+	}
+}
+
+// configTypeName 配置字段类型的说明
+func configTypeName(t reflect.Type) string {
+	switch t.Kind() {
+	case reflect.String:
+		return "string"
+	case reflect.Bool:
+		return "boolean"
+	case reflect.Int, reflect.Int64:
+		return "integer"
+	case reflect.Float64:
+		return "number"
+	case reflect.Slice:
+		return "list of " + configTypeName(t.Elem())
+	case reflect.Map:
+		return "object of " + configTypeName(t.Elem())
+	}
+	return t.String()
+}
@@ -0,0 +1,135 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fields 校验错误的字段列表
+func fields(t *testing.T, err error) []string {
+	errs, ok := err.(ValidationErrors)
+	if !assert.True(t, ok, "%v", err) {
+		return nil
+	}
+	var ret []string
+	for _, e := range errs {
+		ret = append(ret, e.Field)
+	}
+	return ret
+}
+
+func TestHeapsterValidate(t *testing.T) {
+	hst := &Heapster{
+		ID:        "testvalidate",
+		Type:      CheckTypeHTTP,
+		Port:      80,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+		Location:  "/healthz",
+	}
+	assert.NoError(t, hst.Validate())
+
+	// 间隔为0, 超时大于间隔, 未知类型
+	bad := *hst
+	bad.Interval = 0
+	assert.Equal(t, []string{"interval"}, fields(t, bad.Validate()))
+	bad = *hst
+	bad.Timeout = 10 * time.Second
+	assert.Equal(t, []string{"timeout"}, fields(t, bad.Validate()))
+	bad = *hst
+	bad.Type = "udp"
+	bad.Threshold = 0
+	assert.Equal(t, []string{"type", "threshold"}, fields(t, bad.Validate()))
+
+	// 类型相关的字段
+	bad = *hst
+	bad.AcceptCode = []int{200, 1000}
+	bad.Location = "healthz"
+	assert.Equal(t, []string{"accept_code[1]", "location"}, fields(t, bad.Validate()))
+	bad.Type = CheckTypeTCP
+	assert.Equal(t, []string{"accept_code", "location"}, fields(t, bad.Validate()))
+
+	// 子结构的错误带上前缀
+	bad = *hst
+	bad.Rule = &HeapsterRule{RedPercent: 120}
+	bad.Latency = &LatencyRule{Red: LatencyThreshold{P95: -1}}
+	assert.Equal(t, []string{"rule.red_percent", "latency.red.p95"}, fields(t, bad.Validate()))
+}
+
+func TestHeapsterFillDefaults(t *testing.T) {
+	// 旧版本的数据没有阈值和超时, 补全之后可以运行
+	hst := &Heapster{ID: "testvalidate", Type: CheckTypeTCP, Port: 80, Interval: 5 * time.Second}
+	assert.Error(t, hst.Validate())
+	hst.FillDefaults()
+	assert.NoError(t, hst.Runnable())
+	assert.Equal(t, DefaultHeapsterThreshold, hst.Threshold)
+	assert.Equal(t, hst.Interval, hst.Timeout)
+	assert.NoError(t, hst.Validate())
+
+	// 间隔为0的不能运行
+	hst.Interval = 0
+	hst.FillDefaults()
+	assert.Error(t, hst.Runnable())
+}
+
+func TestNotifierValidate(t *testing.T) {
+	hn := &HeapsterNotifier{
+		ID:   "testvalidate",
+		Type: "sms",
+		Config: map[string]interface{}{
+			"type":     "unicom",
+			"sp":       "103905",
+			"username": "test",
+			"password": "test",
+			"targets":  []interface{}{"13800000000"},
+			"template": "testtemplate",
+		},
+	}
+	assert.NoError(t, hn.Validate())
+
+	hn.Config["targets"] = "13800000000"
+	assert.Equal(t, []string{"Config.targets"}, fields(t, hn.Validate()))
+	hn.Config["targets"] = []interface{}{"phone"}
+	delete(hn.Config, "password")
+	assert.Equal(t, []string{"Config.password", "Config.targets[0]"}, fields(t, hn.Validate()))
+
+	hn.Type = "unknown"
+	assert.Equal(t, []string{"type"}, fields(t, hn.Validate()))
+
+	webhook := &HeapsterNotifier{
+		ID:   "testvalidate",
+		Type: "webhook",
+		Config: map[string]interface{}{
+			"url":     "ftp://example.com",
+			"retries": 1.5,
+			"body":    "{{.Report",
+		},
+	}
+	assert.Equal(t, []string{"Config.retries"}, fields(t, webhook.Validate()))
+	webhook.Config["retries"] = 3
+	assert.Equal(t, []string{"Config.url", "Config.body"}, fields(t, webhook.Validate()))
+
+	// telegram的chat id可以是数字
+	telegram := &HeapsterNotifier{
+		ID:   "testvalidate",
+		Type: "telegram",
+		Config: map[string]interface{}{
+			"token":    "testtoken",
+			"chat_ids": []interface{}{"@ops", float64(-1001234567890)},
+		},
+	}
+	assert.NoError(t, telegram.Validate())
+	config := &TelegramNotifierConfig{}
+	assert.NoError(t, DecodeNotifierConfig(telegram.Config, config))
+	assert.Equal(t, IDList{"@ops", "-1001234567890"}, config.ChatIDs)
+	telegram.Config["chat_ids"] = []interface{}{true}
+	assert.Equal(t, []string{"Config.chat_ids"}, fields(t, telegram.Validate()))
+
+	// 注册新的类型
+	RegistNotifierSchema("testvalidate", func() NotifierSchema { return &NotifierCommonConfig{} })
+	hn.Type = "testvalidate"
+	assert.NoError(t, hn.Validate())
+}
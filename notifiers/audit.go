@@ -0,0 +1,207 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// attempt 一次发送的详细信息, 由具体的notifier通过context填写
+type attempt struct {
+	text         string
+	recipients   []string
+	serialNumber int64
+	// 需要等待回执确认送达
+	pending bool
+	// 需要在后台完成的发送, 结束之后更新送达状态
+	deliver   func(ctx context.Context) error
+	rateLimit string
+	// 不满足发送条件, 不需要记录
+	skipped bool
+}
+
+type attemptKey struct{}
+
+type testHeapsterKey struct{}
+
+func withAttempt(ctx context.Context, at *attempt) context.Context {
+	return context.WithValue(ctx, attemptKey{}, at)
+}
+
+func getAttempt(ctx context.Context) *attempt {
+	at, _ := ctx.Value(attemptKey{}).(*attempt)
+	return at
+}
+
+// recordMessage 记录渲染之后的消息和接收者
+func recordMessage(ctx context.Context, text string, recipients []string) {
+	if at := getAttempt(ctx); at != nil {
+		at.text = text
+		at.recipients = recipients
+	}
+}
+
+// recordSMS 记录短信序列号, 送达状态等待回执
+func recordSMS(ctx context.Context, serialNumber int64) {
+	if at := getAttempt(ctx); at != nil {
+		at.serialNumber = serialNumber
+		at.pending = true
+	}
+}
+
+// recordSkipped 记录没有发送
+func recordSkipped(ctx context.Context) {
+	if at := getAttempt(ctx); at != nil {
+		at.skipped = true
+	}
+}
+
+// recordRateLimit 记录流量控制的原因
+func recordRateLimit(ctx context.Context, by string) {
+	if at := getAttempt(ctx); at != nil {
+		at.rateLimit = by
+	}
+}
+
+// withTestHeapster 测试发送时使用示例heapster, 不从存储读取, 也不做流量控制
+func withTestHeapster(ctx context.Context, hp models.Heapster) context.Context {
+	return context.WithValue(ctx, testHeapsterKey{}, hp)
+}
+
+func isTest(ctx context.Context) bool {
+	_, ok := ctx.Value(testHeapsterKey{}).(models.Heapster)
+	return ok
+}
+
+// fillHeapster 获取报告对应的heapster
+func fillHeapster(ctx context.Context, report models.Report) (models.Heapster, error) {
+	if hp, ok := ctx.Value(testHeapsterKey{}).(models.Heapster); ok {
+		return hp, nil
+	}
+	hp := models.Heapster{
+		ID: models.SerialNumber(report.Heapster),
+	}
+	if err := hp.Fill(ctx); err != nil {
+		return hp, fmt.Errorf("report missing heapster")
+	}
+	return hp, nil
+}
+
+// auditedNotifier 记录每一次发送, 失败时发送备用通知
+type auditedNotifier struct {
+	Notifier
+
+	id       models.SerialNumber
+	typ      string
+	fallback models.SerialNumber
+}
+
+// auditedDigestNotifier 支持合并发送的notifier
+type auditedDigestNotifier struct {
+	*auditedNotifier
+}
+
+func newAuditedNotifier(model models.HeapsterNotifier, notifier Notifier) Notifier {
+	// 通知器创建时已经解码过, 这里不会出错
+	config := models.NotifierCommonConfig{}
+	models.DecodeNotifierConfig(model.Config, &config)
+	an := &auditedNotifier{
+		Notifier: notifier,
+		id:       model.ID,
+		typ:      model.Type,
+		fallback: models.SerialNumber(config.Fallback),
+	}
+	if _, ok := notifier.(DigestNotifier); ok {
+		return &auditedDigestNotifier{an}
+	}
+	return an
+}
+
+// Send 实现接口
+func (an *auditedNotifier) Send(ctx context.Context, report models.Report) error {
+	_, err := an.send(ctx, report)
+	return err
+}
+
+func (an *auditedNotifier) send(ctx context.Context, report models.Report) (models.Notifications, error) {
+	at := &attempt{}
+	err := an.Notifier.Send(withAttempt(ctx, at), report)
+	hp, _ := ctx.Value(testHeapsterKey{}).(models.Heapster)
+	if hp.ID == "" {
+		hp.ID = models.SerialNumber(report.Heapster)
+	}
+	return an.audit(ctx, at, []models.DigestItem{{Heapster: hp, Report: report}}, err), err
+}
+
+// SendDigest 实现接口
+func (an *auditedDigestNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	at := &attempt{}
+	err := an.Notifier.(DigestNotifier).SendDigest(withAttempt(ctx, at), digest)
+	an.audit(ctx, at, digest.Items, err)
+	return err
+}
+
+// audit 为每个heapster保存通知记录, 需要的时候发送备用通知
+func (an *auditedNotifier) audit(ctx context.Context, at *attempt, items []models.DigestItem, sendErr error) models.Notifications {
+	logger := middlewares.GetLogger(ctx)
+
+	ns := make(models.Notifications, 0, len(items))
+	if at.skipped && sendErr == nil {
+		return ns
+	}
+	for _, item := range items {
+		n := models.NewNotification(item.Heapster.ID, an.id, an.typ, item.Report, at.recipients)
+		n.Text = at.text
+		n.SerialNumber = at.serialNumber
+		n.RateLimit = at.rateLimit
+		n.Test = isTest(ctx)
+		if !n.Test && !isFallback(ctx) {
+			n.Fallback = an.fallback
+		}
+		if item.Incident != nil {
+			n.Incident = item.Incident.ID
+		} else if inc, err := models.FetchCurrentIncident(ctx, item.Heapster.ID); err == nil && inc != nil {
+			n.Incident = inc.ID
+		}
+		if sendErr != nil {
+			n.Error = sendErr.Error()
+			n.SetAllDelivery(models.DeliveryStatusFailed)
+		} else if !at.pending {
+			n.SetAllDelivery(models.DeliveryStatusDelivered)
+		}
+		if err := n.Create(ctx); err != nil {
+			logger.Warnf("save notification error %v, notifier %s", err, an.id)
+		}
+		if n.NeedFallback() {
+			sendFallback(ctx, n)
+		}
+		ns = append(ns, *n)
+	}
+	if at.deliver != nil && sendErr == nil {
+		startDelivery(ctx, ns, at.deliver)
+	}
+	return ns
+}
+
+// TestNotifier 使用示例报告测试发送, 返回通知记录
+func TestNotifier(ctx context.Context, model models.HeapsterNotifier) (*models.Notification, error) {
+	notifier, err := NewNotifier(model)
+	if err != nil {
+		return nil, err
+	}
+	var an *auditedNotifier
+	switch v := notifier.(type) {
+	case *auditedNotifier:
+		an = v
+	case *auditedDigestNotifier:
+		an = v.auditedNotifier
+	}
+	sample := models.SampleTemplateData()
+	ns, err := an.send(withTestHeapster(ctx, sample.Heapster), sample.Report)
+	if len(ns) == 0 {
+		return nil, err
+	}
+	return &ns[0], err
+}
@@ -0,0 +1,59 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// auditNotifier 记录消息之后按照配置返回错误
+type auditNotifier struct {
+	err error
+}
+
+func (an *auditNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	recordMessage(ctx, fmt.Sprintf("%s %s", hp.Name, report.Target), []string{"ops"})
+	return an.err
+}
+
+func TestAuditedNotifier(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	ctx = middlewares.WithLogger(ctx, 5, os.Stdout)
+
+	fake := &auditNotifier{err: fmt.Errorf("provider error")}
+	registCreator("testaudit", func(model models.HeapsterNotifier) (Notifier, error) {
+		return fake, nil
+	})
+	model := models.HeapsterNotifier{
+		ID:   "test_audit_notifier",
+		Type: "testaudit",
+	}
+
+	// 测试发送使用示例heapster, 返回真实的错误
+	n, err := TestNotifier(ctx, model)
+	assert.EqualError(t, err, "provider error")
+	assert.True(t, n.Test)
+	assert.Equal(t, "示例监控 10.0.10.46:10000", n.Text)
+	assert.Equal(t, "provider error", n.Error)
+	assert.Equal(t, models.DeliveryStatusFailed, n.Recipients[0].Status)
+
+	fake.err = nil
+	n, err = TestNotifier(ctx, model)
+	assert.NoError(t, err)
+	assert.Equal(t, models.DeliveryStatusDelivered, n.Recipients[0].Status)
+
+	ns, err := models.FetchNotifications(ctx, "sample")
+	assert.NoError(t, err)
+	assert.True(t, len(ns) >= 2)
+	assert.Equal(t, model.ID, ns[0].Notifier)
+}
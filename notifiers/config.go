@@ -0,0 +1,10 @@
+package notifiers
+
+import (
+	"time"
+)
+
+// configSeconds 配置里以秒为单位的时间间隔
+func configSeconds(seconds float64) time.Duration {
+	return time.Duration(seconds * float64(time.Second))
+}
@@ -0,0 +1,108 @@
+package notifiers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// Deliveries 后台等待送达结果的发送, 比如电话要等待接听;
+// 使用服务的context, 不随单个警报停止, 服务停止时等待全部结束
+type Deliveries struct {
+	ctx context.Context
+	wg  sync.WaitGroup
+}
+
+type deliveriesKey struct{}
+
+// NewDeliveries 创建后台发送, 需要通过 WithDeliveries 放入服务的context
+func NewDeliveries() *Deliveries {
+	return &Deliveries{}
+}
+
+// WithDeliveries 获取带后台发送的上下文, 后台发送也使用这个上下文,
+// 服务停止之后还需要更新通知记录, 不要使用可以取消的context
+func WithDeliveries(parent context.Context, d *Deliveries) context.Context {
+	ctx := context.WithValue(parent, deliveriesKey{}, d)
+	d.ctx = ctx
+	return ctx
+}
+
+// Wait 等待全部后台发送结束
+func (d *Deliveries) Wait() {
+	d.wg.Wait()
+}
+
+func getDeliveries(ctx context.Context) *Deliveries {
+	d, _ := ctx.Value(deliveriesKey{}).(*Deliveries)
+	return d
+}
+
+// recordDelivery 记录需要在后台完成的发送, 通知记录保存为等待送达;
+// 合并通知逐个发送时按顺序全部发送; 没有记录发送的时候返回false, 由调用者直接发送
+func recordDelivery(ctx context.Context, deliver func(ctx context.Context) error) bool {
+	at := getAttempt(ctx)
+	if at == nil {
+		return false
+	}
+	if prev := at.deliver; prev != nil {
+		at.deliver = func(ctx context.Context) error {
+			var errs []string
+			for _, d := range []func(ctx context.Context) error{prev, deliver} {
+				if err := d(ctx); err != nil {
+					errs = append(errs, err.Error())
+				}
+			}
+			if len(errs) > 0 {
+				return errors.New(strings.Join(errs, "; "))
+			}
+			return nil
+		}
+	} else {
+		at.deliver = deliver
+	}
+	at.pending = true
+	return true
+}
+
+// startDelivery 后台发送, 结束之后像短信回执一样更新通知记录, 失败时发送备用通知;
+// 上下文里没有后台发送时直接发送
+func startDelivery(ctx context.Context, ns models.Notifications, deliver func(ctx context.Context) error) {
+	d := getDeliveries(ctx)
+	if d == nil {
+		finishDelivery(ctx, ns, deliver(ctx))
+		return
+	}
+	d.wg.Add(1)
+	go func() {
+		defer d.wg.Done()
+		finishDelivery(d.ctx, ns, deliver(d.ctx))
+	}()
+}
+
+// finishDelivery 更新送达状态
+func finishDelivery(ctx context.Context, ns models.Notifications, err error) {
+	logger := middlewares.GetLogger(ctx)
+	status := models.DeliveryStatusDelivered
+	if err != nil {
+		status = models.DeliveryStatusFailed
+	}
+	for i := range ns {
+		n := &ns[i]
+		if err != nil {
+			n.Error = err.Error()
+		}
+		n.SetAllDelivery(status)
+		if err := n.Save(ctx); err != nil {
+			logger.Warnf("save notification %s error %v", n.ID, err)
+			continue
+		}
+		if n.NeedFallback() {
+			sendFallback(ctx, n)
+		}
+	}
+}
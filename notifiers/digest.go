@@ -0,0 +1,42 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// digestTitle 合并通知的标题
+func digestTitle(digest models.Digest) string {
+	return fmt.Sprintf("监控提醒：%s", digest.Summary())
+}
+
+// digestLine 合并通知里一个heapster的概要
+func digestLine(item models.DigestItem) string {
+	parts := []string{
+		item.Heapster.Name,
+		item.Report.Target,
+		string(item.Report.Status),
+		fmt.Sprintf("成功/失败：%d/%d", item.Report.Success, item.Report.Faileds),
+	}
+	if item.Report.Reason != "" {
+		parts = append(parts, item.Report.Reason)
+	}
+	return strings.Join(parts, " ")
+}
+
+// sendEach 引用了存储的模版时模版只能渲染单个报告, 逐个发送
+func sendEach(ctx context.Context, nt Notifier, digest models.Digest) error {
+	var errs []string
+	for _, item := range digest.Items {
+		if err := nt.Send(ctx, item.Report); err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", item.Heapster.ID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("send digest error %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
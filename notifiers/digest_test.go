@@ -0,0 +1,171 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testDigest() models.Digest {
+	return models.Digest{
+		Items: []models.DigestItem{
+			{
+				Heapster: models.Heapster{ID: "hp1", Name: "room", Interval: 5 * time.Second, Threshold: 3},
+				Report:   models.Report{Heapster: "hp1", Target: "10.0.10.46:10000", Status: models.HealthyStatusRed, Faileds: 3},
+				Faileds:  2,
+			},
+			{
+				Heapster: models.Heapster{ID: "hp2", Name: "lobby", Interval: 5 * time.Second, Threshold: 3},
+				Report:   models.Report{Heapster: "hp2", Target: "10.0.10.47:10000", Status: models.HealthyStatusRed, Faileds: 1},
+				Faileds:  1,
+			},
+		},
+	}
+}
+
+// withRecordServer 记录每次请求的内容
+func withRecordServer(response string) (*httptest.Server, *[]string) {
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte(response))
+	}))
+	return server, &bodies
+}
+
+func TestRobotSendDigest(t *testing.T) {
+	server, bodies := withRecordServer(`{"errcode":0,"errmsg":"ok"}`)
+	defer server.Close()
+
+	n, err := dingtalkNotifierCreator(models.HeapsterNotifier{
+		Type: "dingtalk",
+		Config: map[string]interface{}{
+			"webhook":    server.URL,
+			"report_url": "http://localhost:5050",
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.(DigestNotifier).SendDigest(context.Background(), testDigest()))
+	assert.Len(t, *bodies, 1)
+	payload := map[string]interface{}{}
+	json.Unmarshal([]byte((*bodies)[0]), &payload)
+	markdown := payload["markdown"].(map[string]interface{})
+	assert.Equal(t, "监控提醒：2个监控中的3个目标异常(room、lobby)", markdown["title"])
+	text := markdown["text"].(string)
+	assert.Contains(t, text, "room 10.0.10.46:10000")
+	assert.Contains(t, text, "lobby 10.0.10.47:10000")
+	assert.Contains(t, text, "heapster=hp2&last=1")
+
+	// 企业微信配置了手机号再发一条提醒
+	*bodies = nil
+	n, err = wecomNotifierCreator(models.HeapsterNotifier{
+		Type: "wecom",
+		Config: map[string]interface{}{
+			"webhook":           server.URL,
+			"mentioned_mobiles": []interface{}{"13879156403"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.(DigestNotifier).SendDigest(context.Background(), testDigest()))
+	if assert.Len(t, *bodies, 2) {
+		assert.Contains(t, (*bodies)[0], "lobby 10.0.10.47:10000")
+		assert.Contains(t, (*bodies)[1], "2个监控中的3个目标异常")
+	}
+}
+
+func TestSlackSendDigest(t *testing.T) {
+	server, bodies := withRecordServer(`ok`)
+	defer server.Close()
+
+	n, err := slackNotifierCreator(models.HeapsterNotifier{
+		Type:   "slack",
+		Config: map[string]interface{}{"webhook": server.URL},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.(DigestNotifier).SendDigest(context.Background(), testDigest()))
+	assert.Len(t, *bodies, 1)
+	msg := slackMessage{}
+	json.Unmarshal([]byte((*bodies)[0]), &msg)
+	assert.Equal(t, "监控提醒：2个监控中的3个目标异常(room、lobby)", msg.Text)
+	if assert.Len(t, msg.Attachments, 2) {
+		assert.Equal(t, "room 10.0.10.46:10000", msg.Attachments[0].Title)
+		assert.Equal(t, "lobby 10.0.10.47:10000", msg.Attachments[1].Title)
+	}
+}
+
+func TestTelegramSendDigest(t *testing.T) {
+	server, bodies := withRecordServer(`{"ok":true}`)
+	defer server.Close()
+
+	n, err := telegramNotifierCreator(models.HeapsterNotifier{
+		Type: "telegram",
+		Config: map[string]interface{}{
+			"base_url": server.URL,
+			"token":    "testtoken",
+			"chat_ids": []interface{}{"a", "b"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.(DigestNotifier).SendDigest(context.Background(), testDigest()))
+	if assert.Len(t, *bodies, 2) {
+		assert.Contains(t, (*bodies)[0], "room 10.0.10.46:10000")
+		assert.Contains(t, (*bodies)[0], "lobby 10.0.10.47:10000")
+	}
+}
+
+func TestWebhookSendDigest(t *testing.T) {
+	server, bodies := withRecordServer(`ok`)
+	defer server.Close()
+
+	n, err := webhookNotifierCreator(models.HeapsterNotifier{
+		Type:   "webhook",
+		Config: map[string]interface{}{"url": server.URL},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.(DigestNotifier).SendDigest(context.Background(), testDigest()))
+	assert.Len(t, *bodies, 1)
+	payload := struct {
+		Digest models.Digest `json:"digest"`
+	}{}
+	assert.NoError(t, json.Unmarshal([]byte((*bodies)[0]), &payload))
+	assert.Len(t, payload.Digest.Items, 2)
+	assert.Equal(t, "10.0.10.47:10000", payload.Digest.Items[1].Report.Target)
+}
+
+func TestEmailSendDigest(t *testing.T) {
+	addr, received := withFakeSMTP(t)
+	host, rawPort, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(rawPort)
+
+	n, err := emailNotifierCreator(models.HeapsterNotifier{
+		Type: "email",
+		Config: map[string]interface{}{
+			"host": host,
+			"port": float64(port),
+			"from": "monitor@zonst.local",
+			"to":   []interface{}{"ops@zonst.local"},
+		},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.(DigestNotifier).SendDigest(context.Background(), testDigest()))
+
+	msg, err := mail.ReadMessage(strings.NewReader(<-received))
+	assert.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "监控提醒：2个监控中的3个目标异常(room、lobby)", subject)
+}
@@ -0,0 +1,110 @@
+package notifiers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("dingtalk", dingtalkNotifierCreator)
+}
+
+var dingtalkNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.DingtalkNotifierConfig{}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	dt := &dingtalkNotifier{
+		webhook:   config.Webhook,
+		secret:    config.Secret,
+		mobiles:   config.AtMobiles,
+		atAll:     config.AtAll,
+		reportURL: config.ReportURL,
+		template:  configTemplate(config.NotifierCommonConfig, ""),
+	}
+	if dt.webhook == "" {
+		return nil, fmt.Errorf("dingtalk webhook required")
+	}
+	return dt, nil
+}
+
+type dingtalkNotifier struct {
+	webhook   string
+	secret    string
+	mobiles   []string
+	atAll     bool
+	reportURL string
+	template  messageTemplate
+}
+
+// Send 发送markdown消息到钉钉群机器人
+func (dt *dingtalkNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	return dt.send(ctx, hp, report)
+}
+
+func (dt *dingtalkNotifier) send(ctx context.Context, hp models.Heapster, report models.Report) error {
+	title, text := robotMarkdown(hp, report, dt.reportURL)
+	text, err := dt.template.renderOr(ctx, hp, report, text)
+	if err != nil {
+		return fmt.Errorf("render dingtalk template error %v", err)
+	}
+	return dt.post(ctx, title, text)
+}
+
+// SendDigest 合并的多个heapster发送一条markdown消息
+func (dt *dingtalkNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if dt.template.custom() {
+		return sendEach(ctx, dt, digest)
+	}
+	title, text := robotDigestMarkdown(digest, dt.reportURL)
+	return dt.post(ctx, title, text)
+}
+
+func (dt *dingtalkNotifier) post(ctx context.Context, title string, text string) error {
+	// 需要在内容里面@手机号才会提醒
+	if len(dt.mobiles) > 0 {
+		text += "\n\n@" + strings.Join(dt.mobiles, " @")
+	}
+	recordMessage(ctx, text, dt.mobiles)
+	payload := map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"title": title,
+			"text":  text,
+		},
+		"at": map[string]interface{}{
+			"atMobiles": dt.mobiles,
+			"isAtAll":   dt.atAll,
+		},
+	}
+	return postRobot(ctx, dt.signedURL(time.Now()), payload)
+}
+
+// signedURL 加签, 签名内容是毫秒时间戳+"\n"+密钥
+func (dt *dingtalkNotifier) signedURL(now time.Time) string {
+	if dt.secret == "" {
+		return dt.webhook
+	}
+	timestamp := fmt.Sprintf("%d", now.UnixNano()/int64(time.Millisecond))
+	mac := hmac.New(sha256.New, []byte(dt.secret))
+	mac.Write([]byte(timestamp + "\n" + dt.secret))
+	sign := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+
+	sep := "?"
+	if strings.Contains(dt.webhook, "?") {
+		sep = "&"
+	}
+	return fmt.Sprintf("%s%stimestamp=%s&sign=%s", dt.webhook, sep, timestamp, url.QueryEscape(sign))
+}
@@ -0,0 +1,63 @@
+package notifiers
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDingtalkNotifier(t *testing.T) {
+	var payload map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		timestamp := r.URL.Query().Get("timestamp")
+		mac := hmac.New(sha256.New, []byte("testsecret"))
+		mac.Write([]byte(timestamp + "\n" + "testsecret"))
+		assert.Equal(t, base64.StdEncoding.EncodeToString(mac.Sum(nil)), r.URL.Query().Get("sign"))
+		assert.Equal(t, "testtoken", r.URL.Query().Get("access_token"))
+		json.NewDecoder(r.Body).Decode(&payload)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer server.Close()
+
+	n, err := dingtalkNotifierCreator(models.HeapsterNotifier{
+		Type: "dingtalk",
+		Config: map[string]interface{}{
+			"webhook":    server.URL + "/robot/send?access_token=testtoken",
+			"secret":     "testsecret",
+			"at_mobiles": []interface{}{"13879156403"},
+			"report_url": "http://localhost:5050",
+		},
+	})
+	assert.NoError(t, err)
+
+	hp := models.Heapster{
+		ID:        "testdingtalk",
+		Name:      "room",
+		Interval:  5 * time.Second,
+		Threshold: 3,
+	}
+	err = n.(*dingtalkNotifier).send(context.Background(), hp, models.Report{
+		Heapster: "testdingtalk",
+		Target:   "10.0.10.46:10000",
+		Faileds:  3,
+		P95Delay: 300 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "markdown", payload["msgtype"])
+	text := payload["markdown"].(map[string]interface{})["text"].(string)
+	assert.Contains(t, text, "10.0.10.46:10000")
+	assert.Contains(t, text, "@13879156403")
+	assert.Contains(t, text, "heapster=testdingtalk&last=1")
+	at := payload["at"].(map[string]interface{})
+	assert.Equal(t, []interface{}{"13879156403"}, at["atMobiles"])
+}
@@ -0,0 +1,305 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"sort"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("email", emailNotifierCreator)
+}
+
+// 邮件连接的加密方式
+const (
+	emailSecurityNone     = "none"
+	emailSecurityStartTLS = "starttls"
+	emailSecurityTLS      = "tls"
+)
+
+// fetchReports 获取采样窗口的报告, 测试的时候替换
+var fetchReports = models.FetchReportsAggs
+
+var emailNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.EmailNotifierConfig{
+		Security: emailSecurityNone,
+	}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	em := &emailNotifier{
+		host:     config.Host,
+		port:     config.Port,
+		security: config.Security,
+		username: config.Username,
+		password: config.Password,
+		from:     config.From,
+		to:       config.To,
+		template: configTemplate(config.NotifierCommonConfig, ""),
+	}
+	switch em.security {
+	case emailSecurityNone, emailSecurityStartTLS:
+		if em.port == 0 {
+			em.port = 25
+		}
+	case emailSecurityTLS:
+		if em.port == 0 {
+			em.port = 465
+		}
+	default:
+		return nil, fmt.Errorf("email security %s not support", em.security)
+	}
+	if em.host == "" || em.from == "" || len(em.to) == 0 {
+		return nil, fmt.Errorf("email host, from and to required")
+	}
+	return em, nil
+}
+
+type emailNotifier struct {
+	host     string
+	port     int
+	security string
+	username string
+	password string
+	from     string
+	to       []string
+	template messageTemplate
+}
+
+// emailData 邮件模版数据
+type emailData struct {
+	Heapster models.Heapster
+	Report   models.Report
+	Faileds  models.Reports
+	Since    time.Time
+	// 引用模版渲染的正文, 为空时使用内置的正文
+	Text string
+}
+
+// subject 邮件主题
+func (data emailData) subject() string {
+	return fmt.Sprintf("监控提醒：%s (%d个目标异常)", data.Heapster.Name, len(data.Faileds))
+}
+
+var emailTextTemplate = template.Must(template.New("text").Parse(
+	`{{if .Text}}{{.Text}}{{else}}监控提醒：{{.Heapster.Name}} 出现异常
+
+触发目标：{{.Report.Target}} {{if .Report.Reason}}{{.Report.Reason}}{{else}}最近出现{{.Report.Faileds}}次异常{{end}}
+
+{{.Since.Format "2006-01-02 15:04:05"}} 以来失败的目标：
+{{range .Faileds}}- {{.Target}} 成功{{.Success}} 失败{{.Faileds}} 最大延迟{{.MaxDelay}}
+{{end}}{{end}}`))
+
+var emailHTMLTemplate = htmltemplate.Must(htmltemplate.New("html").Parse(
+	`<html><body>
+<h3>监控提醒：{{.Heapster.Name}} 出现异常</h3>
+{{if .Text}}<pre>{{.Text}}</pre>{{else}}<p>触发目标：<b>{{.Report.Target}}</b> {{if .Report.Reason}}{{.Report.Reason}}{{else}}最近出现{{.Report.Faileds}}次异常{{end}}</p>{{end}}
+<p>{{.Since.Format "2006-01-02 15:04:05"}} 以来失败的目标：</p>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>目标</th><th>成功</th><th>失败</th><th>最大延迟</th></tr>
+{{range .Faileds}}<tr><td>{{.Target}}</td><td>{{.Success}}</td><td>{{.Faileds}}</td><td>{{.MaxDelay}}</td></tr>
+{{end}}</table>
+</body></html>`))
+
+// Send 列出采样窗口里面所有失败的目标
+func (em *emailNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	return em.send(ctx, hp, report)
+}
+
+func (em *emailNotifier) send(ctx context.Context, hp models.Heapster, report models.Report) error {
+	since := time.Now().Add(-time.Duration(hp.Threshold+1) * hp.Interval)
+	rps, err := fetchReports(ctx, string(hp.ID), since)
+	if err != nil {
+		return fmt.Errorf("fetch reports error %v", err)
+	}
+	data := emailData{
+		Heapster: hp,
+		Report:   report,
+		Since:    since,
+	}
+	found := false
+	for _, rp := range rps {
+		if rp.Target == report.Target {
+			found = true
+			rp = report
+		}
+		if rp.Faileds > 0 || rp.Reason != "" {
+			data.Faileds = append(data.Faileds, rp)
+		}
+	}
+	if !found {
+		data.Faileds = append(data.Faileds, report)
+	}
+	if data.Text, err = em.template.renderOr(ctx, hp, report, ""); err != nil {
+		return fmt.Errorf("render email template error %v", err)
+	}
+	sort.SliceStable(data.Faileds, func(i, j int) bool {
+		return data.Faileds[i].Faileds > data.Faileds[j].Faileds
+	})
+	recordMessage(ctx, data.subject(), em.to)
+	msg, err := em.message(data.subject(), data, emailTextTemplate, emailHTMLTemplate, time.Now())
+	if err != nil {
+		return err
+	}
+	return em.deliver(ctx, msg)
+}
+
+// emailDigestData 合并邮件模版数据
+type emailDigestData struct {
+	Title string
+	Items []models.DigestItem
+}
+
+var emailDigestTextTemplate = template.Must(template.New("digest_text").Parse(
+	`{{.Title}}
+{{range .Items}}- {{.Heapster.Name}} {{.Report.Target}} {{.Report.Status}} 成功{{.Report.Success}} 失败{{.Report.Faileds}}{{if .Report.Reason}} {{.Report.Reason}}{{end}}
+{{end}}`))
+
+var emailDigestHTMLTemplate = htmltemplate.Must(htmltemplate.New("digest_html").Parse(
+	`<html><body>
+<h3>{{.Title}}</h3>
+<table border="1" cellspacing="0" cellpadding="4">
+<tr><th>监控</th><th>目标</th><th>状态</th><th>成功</th><th>失败</th><th>原因</th></tr>
+{{range .Items}}<tr><td>{{.Heapster.Name}}</td><td>{{.Report.Target}}</td><td>{{.Report.Status}}</td><td>{{.Report.Success}}</td><td>{{.Report.Faileds}}</td><td>{{.Report.Reason}}</td></tr>
+{{end}}</table>
+</body></html>`))
+
+// SendDigest 合并的多个heapster发送一封邮件, 只列出触发的目标
+func (em *emailNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if em.template.custom() {
+		return sendEach(ctx, em, digest)
+	}
+	data := emailDigestData{
+		Title: digestTitle(digest),
+		Items: digest.Items,
+	}
+	recordMessage(ctx, data.Title, em.to)
+	msg, err := em.message(data.Title, data, emailDigestTextTemplate, emailDigestHTMLTemplate, time.Now())
+	if err != nil {
+		return err
+	}
+	return em.deliver(ctx, msg)
+}
+
+// message 构建multipart/alternative邮件
+func (em *emailNotifier) message(subject string, data interface{},
+	textTpl *template.Template, htmlTpl *htmltemplate.Template, now time.Time) ([]byte, error) {
+	var (
+		buf    = &bytes.Buffer{}
+		parts  = multipart.NewWriter(buf)
+		header = textproto.MIMEHeader{}
+	)
+	fmt.Fprintf(buf, "From: %s\r\n", em.from)
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(em.to, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.BEncoding.Encode("UTF-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", now.Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", parts.Boundary())
+
+	header.Set("Content-Transfer-Encoding", "quoted-printable")
+	header.Set("Content-Type", "text/plain; charset=UTF-8")
+	w, err := parts.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	qp := quotedprintable.NewWriter(w)
+	if err := textTpl.Execute(qp, data); err != nil {
+		return nil, err
+	}
+	qp.Close()
+
+	header.Set("Content-Type", "text/html; charset=UTF-8")
+	w, err = parts.CreatePart(header)
+	if err != nil {
+		return nil, err
+	}
+	qp = quotedprintable.NewWriter(w)
+	if err := htmlTpl.Execute(qp, data); err != nil {
+		return nil, err
+	}
+	qp.Close()
+
+	if err := parts.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// deliver 通过SMTP发送
+func (em *emailNotifier) deliver(ctx context.Context, msg []byte) error {
+	var (
+		addr      = net.JoinHostPort(em.host, strconv.Itoa(em.port))
+		tlsConfig = &tls.Config{ServerName: em.host}
+		dialer    = &net.Dialer{Timeout: 10 * time.Second}
+		conn      net.Conn
+		err       error
+	)
+	if em.security == emailSecurityTLS {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsConfig)
+	} else {
+		conn, err = dialer.DialContext(ctx, "tcp", addr)
+	}
+	if err != nil {
+		return err
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(30 * time.Second)
+	}
+	conn.SetDeadline(deadline)
+
+	c, err := smtp.NewClient(conn, em.host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+	if em.security == emailSecurityStartTLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	if em.username != "" {
+		if err := c.Auth(smtp.PlainAuth("", em.username, em.password, em.host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(em.from); err != nil {
+		return err
+	}
+	for _, to := range em.to {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
@@ -0,0 +1,131 @@
+package notifiers
+
+import (
+	"bufio"
+	"context"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// withFakeSMTP 本地的假SMTP服务, 收到的邮件内容写到返回的channel
+func withFakeSMTP(t *testing.T) (string, <-chan string) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	received := make(chan string, 1)
+	go func() {
+		defer l.Close()
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		conn.Write([]byte("220 localhost fake smtp\r\n"))
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+				conn.Write([]byte("250 localhost\r\n"))
+			case cmd == "DATA":
+				conn.Write([]byte("354 go ahead\r\n"))
+				var data []string
+				for {
+					line, err := reader.ReadString('\n')
+					if err != nil || line == ".\r\n" {
+						break
+					}
+					data = append(data, line)
+				}
+				received <- strings.Join(data, "")
+				conn.Write([]byte("250 OK\r\n"))
+			case cmd == "QUIT":
+				conn.Write([]byte("221 bye\r\n"))
+				return
+			default:
+				conn.Write([]byte("250 OK\r\n"))
+			}
+		}
+	}()
+	return l.Addr().String(), received
+}
+
+func TestEmailNotifier(t *testing.T) {
+	addr, received := withFakeSMTP(t)
+	host, rawPort, _ := net.SplitHostPort(addr)
+	port, _ := strconv.Atoi(rawPort)
+
+	fetchReports = func(ctx context.Context, heapster string, last time.Time) (models.Reports, error) {
+		return models.Reports{
+			{Heapster: heapster, Target: "10.0.10.46:10000", Faileds: 3},
+			{Heapster: heapster, Target: "10.0.10.47:10000", Faileds: 1, Success: 2},
+			{Heapster: heapster, Target: "10.0.10.48:10000", Success: 3},
+		}, nil
+	}
+	defer func() { fetchReports = models.FetchReportsAggs }()
+
+	n, err := emailNotifierCreator(models.HeapsterNotifier{
+		Type: "email",
+		Config: map[string]interface{}{
+			"host": host,
+			"port": float64(port),
+			"from": "monitor@zonst.local",
+			"to":   []interface{}{"ops@zonst.local"},
+		},
+	})
+	assert.NoError(t, err)
+	em := n.(*emailNotifier)
+	assert.Equal(t, port, em.port)
+
+	hp := models.Heapster{
+		ID:        "testemail",
+		Name:      "room",
+		Interval:  5 * time.Second,
+		Threshold: 3,
+	}
+	err = em.send(context.Background(), hp, models.Report{
+		Heapster: "testemail",
+		Target:   "10.0.10.46:10000",
+		Faileds:  3,
+	})
+	assert.NoError(t, err)
+
+	raw := <-received
+	msg, err := mail.ReadMessage(strings.NewReader(raw))
+	assert.NoError(t, err)
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	assert.NoError(t, err)
+	assert.Equal(t, "监控提醒：room (2个目标异常)", subject)
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	assert.NoError(t, err)
+	assert.Equal(t, "multipart/alternative", mediaType)
+	reader := multipart.NewReader(msg.Body, params["boundary"])
+	var contentTypes []string
+	for {
+		part, err := reader.NextPart()
+		if err != nil {
+			break
+		}
+		contentTypes = append(contentTypes, part.Header.Get("Content-Type"))
+		body, _ := ioutil.ReadAll(quotedprintable.NewReader(part))
+		assert.Contains(t, string(body), "10.0.10.47:10000")
+		assert.NotContains(t, string(body), "10.0.10.48:10000")
+	}
+	assert.Equal(t, []string{"text/plain; charset=UTF-8", "text/html; charset=UTF-8"}, contentTypes)
+}
@@ -13,9 +13,14 @@ var (
 // notifier工厂方法
 type notifierCreator func(model models.HeapsterNotifier) (Notifier, error)
 
-// 注册工厂
+// 注册工厂, 配置结构在 models 里注册, 没有注册的类型只支持通用配置
 func registCreator(name string, creator notifierCreator) {
 	namedNotifiers[name] = creator
+	if !models.HasNotifierSchema(name) {
+		models.RegistNotifierSchema(name, func() models.NotifierSchema {
+			return &models.NotifierCommonConfig{}
+		})
+	}
 }
 
 // Notifier 健康状态通知者接口
@@ -34,5 +39,11 @@ func NewNotifier(model models.HeapsterNotifier) (Notifier, error) {
 	if err != nil {
 		return nil, err
 	}
-	return notifier, nil
+	return newAuditedNotifier(model, notifier), nil
+}
+
+// DigestNotifier 支持合并通知的通知者, 分发器合并多个通知时优先使用
+type DigestNotifier interface {
+	Notifier
+	SendDigest(ctx context.Context, digest models.Digest) error
 }
@@ -0,0 +1,117 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+type fallbackKey struct{}
+
+// 备用notifier发送的通知不再触发备用, 避免互相引用时循环发送
+func withFallback(ctx context.Context) context.Context {
+	return context.WithValue(ctx, fallbackKey{}, true)
+}
+
+func isFallback(ctx context.Context) bool {
+	fallback, _ := ctx.Value(fallbackKey{}).(bool)
+	return fallback
+}
+
+// sendFallback 送达失败时使用备用notifier重新发送
+func sendFallback(ctx context.Context, n *models.Notification) {
+	logger := middlewares.GetLogger(ctx)
+
+	n.FallbackSent = true
+	if err := n.Save(ctx); err != nil {
+		logger.Warnf("save notification %s error %v", n.ID, err)
+	}
+	model := &models.HeapsterNotifier{
+		ID: n.Fallback,
+	}
+	if err := model.Fill(ctx); err != nil {
+		logger.Warnf("load fallback notifier %s error %v", n.Fallback, err)
+		return
+	}
+	notifier, err := NewNotifier(*model)
+	if err != nil {
+		logger.Warnf("create fallback notifier %s error %v", n.Fallback, err)
+		return
+	}
+	if err := notifier.Send(withFallback(ctx), n.Report); err != nil {
+		logger.Warnf("fallback notifier %s send error %v", n.Fallback, err)
+	}
+}
+
+// deliveryStatus 统一的回执状态转换成送达状态
+func deliveryStatus(receipt middlewares.SMSReceipt) models.DeliveryStatus {
+	if receipt.Status == middlewares.SMSReceiptDelivered {
+		return models.DeliveryStatusDelivered
+	}
+	return models.DeliveryStatusFailed
+}
+
+// handleReceipts 按照序列号更新通知记录, 有号码送达失败时发送备用通知
+func handleReceipts(ctx context.Context, receipts []middlewares.SMSReceipt) error {
+	var (
+		lastErr error
+		updated = make(map[int64]models.Notifications)
+	)
+	for _, receipt := range receipts {
+		ns, ok := updated[receipt.SerialNumber]
+		if !ok {
+			found, err := models.FetchNotificationsBySMS(ctx, receipt.SerialNumber)
+			if err != nil {
+				lastErr = err
+				continue
+			}
+			ns = found
+			updated[receipt.SerialNumber] = ns
+		}
+		// 没有记录的不是警报发出的短信
+		for i := range ns {
+			ns[i].SetDelivery(receipt.Number, deliveryStatus(receipt))
+		}
+	}
+	for _, ns := range updated {
+		for i := range ns {
+			n := &ns[i]
+			if err := n.Save(ctx); err != nil {
+				lastErr = err
+				continue
+			}
+			if n.NeedFallback() {
+				sendFallback(ctx, n)
+			}
+		}
+	}
+	return lastErr
+}
+
+// PollReceipts 拉取所有短信服务者的回执并更新送达状态
+func PollReceipts(ctx context.Context) error {
+	smsProvidersMtx.Lock()
+	providers := make([]middlewares.SMSProvider, 0, len(smsProviders))
+	for _, e := range smsProviders {
+		providers = append(providers, e.provider)
+	}
+	smsProvidersMtx.Unlock()
+
+	var errs []error
+	for _, p := range providers {
+		receipts, err := p.FetchReceipts()
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if err := handleReceipts(ctx, receipts); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("poll receipts error %v", errs)
+	}
+	return nil
+}
@@ -0,0 +1,61 @@
+package notifiers
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeNotifier 记录收到的报告
+type fakeNotifier struct {
+	reports []models.Report
+}
+
+func (fn *fakeNotifier) Send(ctx context.Context, report models.Report) error {
+	fn.reports = append(fn.reports, report)
+	return nil
+}
+
+func TestHandleReceipts(t *testing.T) {
+	ctx := middlewares.WithRedisConn(context.Background(), "0.0.0.0:6379", "", 9)
+	ctx = middlewares.WithLogger(ctx, 5, os.Stdout)
+
+	fake := &fakeNotifier{}
+	registCreator("testfallback", func(model models.HeapsterNotifier) (Notifier, error) {
+		return fake, nil
+	})
+	fallback := &models.HeapsterNotifier{
+		ID:   "test_receipt_fallback",
+		Type: "testfallback",
+	}
+	assert.NoError(t, fallback.Save(ctx))
+
+	n := models.NewNotification("test_heapster_receipt", "test_notifier", "sms",
+		models.Report{Target: "10.0.10.46:10000"}, []string{"13800000001", "13800000002"})
+	n.SerialNumber = 987654321
+	n.Fallback = fallback.ID
+	assert.NoError(t, n.Create(ctx))
+
+	assert.NoError(t, handleReceipts(ctx, []middlewares.SMSReceipt{
+		{SerialNumber: n.SerialNumber, Number: "13800000001", Status: middlewares.SMSReceiptDelivered},
+		{SerialNumber: n.SerialNumber, Number: "13800000002", Status: middlewares.SMSReceiptFailed},
+		{SerialNumber: 1, Number: "13800000003", Status: middlewares.SMSReceiptDelivered},
+	}))
+	assert.NoError(t, n.Fill(ctx))
+	assert.Equal(t, models.DeliveryStatusDelivered, n.Recipients[0].Status)
+	assert.Equal(t, models.DeliveryStatusFailed, n.Recipients[1].Status)
+	assert.True(t, n.FallbackSent)
+	assert.Len(t, fake.reports, 1)
+
+	// 备用通知只发一次
+	assert.NoError(t, handleReceipts(ctx, []middlewares.SMSReceipt{
+		{SerialNumber: n.SerialNumber, Number: "13800000002", Status: middlewares.SMSReceiptFailed},
+	}))
+	assert.Len(t, fake.reports, 1)
+	assert.NoError(t, fallback.Delete(ctx))
+}
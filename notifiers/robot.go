@@ -0,0 +1,96 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// robotMarkdown 聊天机器人的markdown消息, 返回标题和内容
+func robotMarkdown(hp models.Heapster, report models.Report, reportURL string) (string, string) {
+	title := fmt.Sprintf("监控提醒：%s", hp.Name)
+	lines := []string{
+		fmt.Sprintf("### %s", title),
+		fmt.Sprintf("- 目标：%s", report.Target),
+		fmt.Sprintf("- 状态：%s", report.Status),
+		fmt.Sprintf("- 成功/失败：%d/%d", report.Success, report.Faileds),
+		fmt.Sprintf("- 延迟：p95 %v, 最大 %v", report.P95Delay, report.MaxDelay),
+	}
+	if report.Reason != "" {
+		lines = append(lines, fmt.Sprintf("- 原因：%s", report.Reason))
+	}
+	if report.Suppressed > 0 {
+		lines = append(lines, fmt.Sprintf("- 已抑制%d个依赖检查", report.Suppressed))
+	}
+	if reportURL != "" {
+		lines = append(lines, fmt.Sprintf("\n[查看监控报告](%s)", robotReportLink(reportURL, hp)))
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// robotDigestMarkdown 合并通知的markdown消息, 每个heapster一行
+func robotDigestMarkdown(digest models.Digest, reportURL string) (string, string) {
+	title := digestTitle(digest)
+	lines := []string{fmt.Sprintf("### %s", title)}
+	for _, item := range digest.Items {
+		line := "- " + digestLine(item)
+		if reportURL != "" {
+			line += fmt.Sprintf(" [报告](%s)", robotReportLink(reportURL, item.Heapster))
+		}
+		lines = append(lines, line)
+	}
+	return title, strings.Join(lines, "\n")
+}
+
+// robotReportLink 报告接口的链接, 最近一个采样窗口的数据
+func robotReportLink(reportURL string, hp models.Heapster) string {
+	minutes := int((time.Duration(hp.Threshold+1)*hp.Interval + time.Minute - 1) / time.Minute)
+	if minutes < 1 {
+		minutes = 1
+	}
+	return fmt.Sprintf("%s/v1/gamehealthy/report?heapster=%s&last=%d",
+		strings.TrimRight(reportURL, "/"), hp.ID, minutes)
+}
+
+// robotResponse 钉钉和企业微信共用的返回结构
+type robotResponse struct {
+	ErrCode int    `json:"errcode"`
+	ErrMsg  string `json:"errmsg"`
+}
+
+// postRobot 发送json消息到机器人webhook
+func postRobot(ctx context.Context, url string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	sendCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(sendCtx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("robot response code %d", resp.StatusCode)
+	}
+	result := robotResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if result.ErrCode != 0 {
+		return fmt.Errorf("robot error %d %s", result.ErrCode, result.ErrMsg)
+	}
+	return nil
+}
@@ -0,0 +1,176 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("slack", slackNotifierCreator)
+}
+
+var slackNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.SlackNotifierConfig{}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	sn := &slackNotifier{
+		webhook:   config.Webhook,
+		channel:   config.Channel,
+		username:  config.Username,
+		reportURL: config.ReportURL,
+		template:  configTemplate(config.NotifierCommonConfig, ""),
+	}
+	if sn.webhook == "" {
+		return nil, fmt.Errorf("slack webhook required")
+	}
+	return sn, nil
+}
+
+type slackNotifier struct {
+	webhook   string
+	channel   string
+	username  string
+	reportURL string
+	template  messageTemplate
+}
+
+// slackField 附件字段
+type slackField struct {
+	Title string `json:"title"`
+	Value string `json:"value"`
+	Short bool   `json:"short"`
+}
+
+// slackAttachment 消息附件, 颜色按照健康状态
+type slackAttachment struct {
+	Fallback  string       `json:"fallback"`
+	Color     string       `json:"color"`
+	Title     string       `json:"title"`
+	TitleLink string       `json:"title_link,omitempty"`
+	Text      string       `json:"text,omitempty"`
+	Fields    []slackField `json:"fields"`
+	Timestamp int64        `json:"ts"`
+}
+
+// slackMessage incoming webhook消息
+type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	Username    string            `json:"username,omitempty"`
+	Text        string            `json:"text"`
+	Attachments []slackAttachment `json:"attachments"`
+}
+
+// Send 发送到slack兼容的incoming webhook
+func (sn *slackNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	return sn.send(ctx, hp, report)
+}
+
+func (sn *slackNotifier) message(hp models.Heapster, report models.Report) slackMessage {
+	title := fmt.Sprintf("监控提醒：%s", hp.Name)
+	return slackMessage{
+		Channel:     sn.channel,
+		Username:    sn.username,
+		Text:        title,
+		Attachments: []slackAttachment{sn.attachment(title, hp, report)},
+	}
+}
+
+// attachment 一个目标的附件
+func (sn *slackNotifier) attachment(title string, hp models.Heapster, report models.Report) slackAttachment {
+	attachment := slackAttachment{
+		Fallback: fmt.Sprintf("%s %s 最近出现%d次异常", title, report.Target, report.Faileds),
+		Color:    report.Status.Color(),
+		Title:    report.Target,
+		Text:     report.Reason,
+		Fields: []slackField{
+			{Title: "Status", Value: string(report.Status), Short: true},
+			{Title: "Success/Failed", Value: fmt.Sprintf("%d/%d", report.Success, report.Faileds), Short: true},
+			{Title: "P95 Delay", Value: report.P95Delay.String(), Short: true},
+			{Title: "Max Delay", Value: report.MaxDelay.String(), Short: true},
+		},
+		Timestamp: time.Now().Unix(),
+	}
+	if report.Suppressed > 0 {
+		attachment.Fields = append(attachment.Fields, slackField{
+			Title: "Suppressed", Value: fmt.Sprintf("%d", report.Suppressed), Short: true,
+		})
+	}
+	if sn.reportURL != "" {
+		attachment.TitleLink = robotReportLink(sn.reportURL, hp)
+	}
+	return attachment
+}
+
+func (sn *slackNotifier) send(ctx context.Context, hp models.Heapster, report models.Report) error {
+	msg := sn.message(hp, report)
+	// 引用了模版的时候替换附件的正文
+	text, err := sn.template.renderOr(ctx, hp, report, msg.Attachments[0].Text)
+	if err != nil {
+		return fmt.Errorf("render slack template error %v", err)
+	}
+	msg.Attachments[0].Text = text
+	return sn.post(ctx, msg, msg.Attachments[0].Fallback+"\n"+text)
+}
+
+// SendDigest 合并的多个heapster发送一条消息, 每个heapster一个附件
+func (sn *slackNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if sn.template.custom() {
+		return sendEach(ctx, sn, digest)
+	}
+	title := digestTitle(digest)
+	msg := slackMessage{
+		Channel:  sn.channel,
+		Username: sn.username,
+		Text:     title,
+	}
+	lines := []string{title}
+	for _, item := range digest.Items {
+		attachment := sn.attachment(fmt.Sprintf("监控提醒：%s", item.Heapster.Name), item.Heapster, item.Report)
+		attachment.Title = item.Heapster.Name + " " + item.Report.Target
+		msg.Attachments = append(msg.Attachments, attachment)
+		lines = append(lines, digestLine(item))
+	}
+	return sn.post(ctx, msg, strings.Join(lines, "\n"))
+}
+
+func (sn *slackNotifier) post(ctx context.Context, msg slackMessage, text string) error {
+	var channels []string
+	if sn.channel != "" {
+		channels = append(channels, sn.channel)
+	}
+	recordMessage(ctx, text, channels)
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", sn.webhook, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sendCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(sendCtx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("slack response code %d %s", resp.StatusCode, string(body))
+	}
+	return nil
+}
@@ -0,0 +1,43 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlackNotifier(t *testing.T) {
+	var msg slackMessage
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewDecoder(r.Body).Decode(&msg)
+		w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+
+	n, err := slackNotifierCreator(models.HeapsterNotifier{
+		Type: "slack",
+		Config: map[string]interface{}{
+			"webhook": server.URL + "/services/T000/B000/XXXX",
+			"channel": "#ops",
+		},
+	})
+	assert.NoError(t, err)
+
+	err = n.(*slackNotifier).send(context.Background(), models.Heapster{ID: "testslack", Name: "room"}, models.Report{
+		Heapster: "testslack",
+		Target:   "10.0.10.46:10000",
+		Faileds:  3,
+		Status:   models.HealthyStatusRed,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "#ops", msg.Channel)
+	assert.Len(t, msg.Attachments, 1)
+	assert.Equal(t, models.HealthyStatusRed.Color(), msg.Attachments[0].Color)
+	assert.Equal(t, "10.0.10.46:10000", msg.Attachments[0].Title)
+}
@@ -3,6 +3,7 @@ package notifiers
 import (
 	"context"
 	"fmt"
+	"sync"
 	"time"
 
 	"zonst/qipai/gamehealthysrv/middlewares"
@@ -13,90 +14,158 @@ func init() {
 	registCreator("sms", smsNotifierCreator)
 }
 
-var smsNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
-	var (
-		spType     string
-		spID       string
-		spUsername string
-		spPassword string
-		numbers    []string
-	)
+// smsProviderEntry 通知器使用的服务者和创建时通知器的版本
+type smsProviderEntry struct {
+	version  int
+	provider middlewares.SMSProvider
+}
 
-	if val, ok := model.Config["type"].(string); ok {
-		spType = val
+var (
+	// 按照通知器ID缓存服务者, 回执轮询需要遍历所有的服务者
+	smsProviders    = make(map[models.SerialNumber]smsProviderEntry)
+	smsProvidersMtx sync.Mutex
+)
+
+// smsProvider 获取通知器的服务者, 通知器的版本变化时重新创建; 没有保存过的通知器不缓存
+func smsProvider(model models.HeapsterNotifier, spType string, config interface{}) (middlewares.SMSProvider, error) {
+	smsProvidersMtx.Lock()
+	defer smsProvidersMtx.Unlock()
+
+	if e, ok := smsProviders[model.ID]; ok && e.version == model.Version {
+		return e.provider, nil
 	}
-	// 如果使用联通的短信接口
-	if spType == "unicom" {
-		if val, ok := model.Config["sp"].(string); ok {
-			spID = val
-		}
-		if val, ok := model.Config["username"].(string); ok {
-			spUsername = val
-		}
-		if val, ok := model.Config["password"].(string); ok {
-			spPassword = val
-		}
-		if vals, ok := model.Config["targets"].([]interface{}); ok {
-			for _, v := range vals {
-				numbers = append(numbers, v.(string))
-			}
+	p, err := middlewares.CreateSMSProvider(spType, config)
+	if err != nil {
+		return nil, err
+	}
+	if model.ID != "" {
+		smsProviders[model.ID] = smsProviderEntry{version: model.Version, provider: p}
+	}
+	return p, nil
+}
+
+var smsNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.SMSNotifierConfig{}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+
+	// 按照类型构建不同服务者的配置
+	var spConfig interface{}
+	switch config.Type {
+	case "unicom":
+		spConfig = middlewares.UnicomConfig{
+			SPCode:     config.SP,
+			Username:   config.Username,
+			Password:   config.Password,
+			BaseURL:    config.BaseURL,
+			ReceiptURL: config.ReceiptURL,
 		}
-		smsConfig := middlewares.UnicomConfig{
-			SPCode:   spID,
-			Username: spUsername,
-			Password: spPassword,
+	case "aliyun":
+		spConfig = middlewares.AliyunSMSConfig{
+			AccessKeyID:     config.AccessKeyID,
+			AccessKeySecret: config.AccessKeySecret,
+			SignName:        config.SignName,
+			TemplateCode:    config.TemplateCode,
+			TemplateParam:   config.TemplateParam,
+			RegionID:        config.Region,
+			BaseURL:         config.BaseURL,
 		}
-		p, err := middlewares.CreateSMSProvider(string(spType), smsConfig)
-		if err != nil {
-			return nil, err
+	case "tencent":
+		spConfig = middlewares.TencentSMSConfig{
+			SecretID:   config.SecretID,
+			SecretKey:  config.SecretKey,
+			SDKAppID:   config.SDKAppID,
+			SignName:   config.SignName,
+			TemplateID: config.TemplateID,
+			Region:     config.Region,
+			BaseURL:    config.BaseURL,
 		}
-		return &smsNotifier{
-			provider: p,
-			numbers:  numbers,
-		}, nil
+	default:
+		return nil, fmt.Errorf("sms provider type %s not support", config.Type)
 	}
-	// 暂时不支持其它的
-	return nil, fmt.Errorf("sms provider type %s not support", spType)
+	p, err := smsProvider(model, config.Type, spConfig)
+	if err != nil {
+		return nil, err
+	}
+	return &smsNotifier{
+		provider: p,
+		numbers:  config.Targets,
+		template: configTemplate(config.NotifierCommonConfig, models.DefaultSMSTemplate),
+	}, nil
 }
 
 type smsNotifier struct {
 	provider middlewares.SMSProvider
 	numbers  []string
+	template messageTemplate
 }
 
 // Send 短信不能发那么多字, 只能发一个大概的描述
 func (sms *smsNotifier) Send(ctx context.Context, report models.Report) error {
-	var (
-		limiter = middlewares.GetRateLimiter(ctx)
-		logger  = middlewares.GetLogger(ctx)
-	)
-
 	// 获取heapster信息
-	hp := &models.Heapster{
-		ID: models.SerialNumber(report.Heapster),
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	// 构建消息, 默认模版里响应慢的目标带上超出的延迟
+	tpl, err := sms.template.render(ctx, hp, report)
+	if err != nil {
+		return fmt.Errorf("render sms template error %v", err)
+	}
+	recordMessage(ctx, tpl, sms.numbers)
+	// 流量控制, 测试发送的时候不限制
+	if !isTest(ctx) {
+		limiter := middlewares.GetRateLimiter(ctx)
+		if limiter.TryAccept([]string{string(hp.ID)}, 5*time.Minute, 1) {
+			recordRateLimit(ctx, "heapster")
+			return fmt.Errorf("rate controll by heapster")
+		}
+		if limiter.TryAccept(sms.numbers, 5*time.Minute, 1) {
+			recordRateLimit(ctx, "phone")
+			return fmt.Errorf("rate controll by phone")
+		}
 	}
-	if err := hp.Fill(ctx); err != nil {
-		return fmt.Errorf("report missing heapster")
+	return sms.deliver(ctx, tpl)
+}
+
+// SendDigest 多个heapster合并成一条短信, 和Send一样按照heapster和号码流量控制,
+// 5分钟内发过短信的heapster不再合并进来, 全部被限制时不发送; 引用了模版时逐个发送
+func (sms *smsNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if sms.template.custom() {
+		return sendEach(ctx, sms, digest)
 	}
-	// 流量控制
-	if limiter.TryAccept([]string{string(hp.ID)}, 5*time.Minute, 1) {
+	limiter := middlewares.GetRateLimiter(ctx)
+	accepted := make([]models.DigestItem, 0, len(digest.Items))
+	for _, item := range digest.Items {
+		if limiter.TryAccept([]string{string(item.Heapster.ID)}, 5*time.Minute, 1) {
+			recordRateLimit(ctx, "heapster")
+			continue
+		}
+		accepted = append(accepted, item)
+	}
+	if len(accepted) == 0 {
 		return fmt.Errorf("rate controll by heapster")
 	}
+	digest.Items = accepted
+	tpl := fmt.Sprintf("监控提醒：%s需要及时处理请查阅监控报告", digest.Summary())
+	recordMessage(ctx, tpl, sms.numbers)
 	if limiter.TryAccept(sms.numbers, 5*time.Minute, 1) {
+		recordRateLimit(ctx, "phone")
 		return fmt.Errorf("rate controll by phone")
 	}
-	// 构建消息
-	tpl := fmt.Sprintf("%s提醒：%s需要%s请查阅%s",
-		"监控",
-		fmt.Sprintf("(%s)中的(%s)最近出现%d次异常", hp.Name, report.Target, report.Faileds),
-		"及时处理",
-		"监控报告")
+	return sms.deliver(ctx, tpl)
+}
+
+// deliver 发送短信, 回执轮询按照序列号更新送达状态
+func (sms *smsNotifier) deliver(ctx context.Context, tpl string) error {
 	// 发送超时默认5秒
 	sendCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
 	result := sms.provider.SendMessage(sendCtx, tpl, sms.numbers)
 	cancel()
 	if result.Result != 0 {
-		logger.Warnf("send sms error %s", result.Error())
+		return result
 	}
+	recordSMS(ctx, result.SerialNumber)
 	return nil
 }
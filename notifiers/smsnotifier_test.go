@@ -49,3 +49,49 @@ func TestSMSNotifier(t *testing.T) {
 	})
 	assert.NoError(t, err)
 }
+
+func TestSMSProviderCache(t *testing.T) {
+	model := models.HeapsterNotifier{
+		ID:      "test_sms_provider_cache",
+		Type:    "sms",
+		Version: 1,
+		Config: map[string]interface{}{
+			"type":     "unicom",
+			"sp":       "103905",
+			"username": "zz_sj",
+			"password": "www.zonst.org",
+		},
+	}
+	defer func() {
+		smsProvidersMtx.Lock()
+		delete(smsProviders, model.ID)
+		smsProvidersMtx.Unlock()
+	}()
+	config := middlewares.UnicomConfig{SPCode: "103905", Username: "zz_sj", Password: "www.zonst.org"}
+
+	// 相同版本共用服务者
+	p1, err := smsProvider(model, "unicom", config)
+	assert.NoError(t, err)
+	p2, err := smsProvider(model, "unicom", config)
+	assert.NoError(t, err)
+	assert.True(t, p1 == p2)
+
+	// 版本变化替换原来的服务者
+	model.Version = 2
+	config.Password = "changed"
+	p3, err := smsProvider(model, "unicom", config)
+	assert.NoError(t, err)
+	assert.False(t, p1 == p3)
+	smsProvidersMtx.Lock()
+	assert.True(t, smsProviders[model.ID].provider == p3)
+	smsProvidersMtx.Unlock()
+
+	// 没有保存过的通知器不缓存
+	p4, err := smsProvider(models.HeapsterNotifier{Type: "sms"}, "unicom", config)
+	assert.NoError(t, err)
+	assert.NotNil(t, p4)
+	smsProvidersMtx.Lock()
+	_, ok := smsProviders[""]
+	smsProvidersMtx.Unlock()
+	assert.False(t, ok)
+}
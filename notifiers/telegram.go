@@ -0,0 +1,158 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"html"
+	"net/http"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("telegram", telegramNotifierCreator)
+}
+
+var telegramNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.TelegramNotifierConfig{
+		BaseURL: "https://api.telegram.org",
+	}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	tn := &telegramNotifier{
+		baseURL:   strings.TrimRight(config.BaseURL, "/"),
+		token:     config.Token,
+		chatIDs:   config.ChatIDs,
+		reportURL: config.ReportURL,
+		template:  configTemplate(config.NotifierCommonConfig, ""),
+	}
+	if tn.token == "" || len(tn.chatIDs) == 0 {
+		return nil, fmt.Errorf("telegram token and chat_ids required")
+	}
+	return tn, nil
+}
+
+type telegramNotifier struct {
+	baseURL   string
+	token     string
+	chatIDs   []string
+	reportURL string
+	template  messageTemplate
+}
+
+// telegramResponse Bot API返回结构
+type telegramResponse struct {
+	OK          bool   `json:"ok"`
+	Description string `json:"description"`
+}
+
+// Send 通过Bot API发送到所有配置的会话
+func (tn *telegramNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	return tn.send(ctx, hp, report)
+}
+
+// text HTML格式的消息内容
+func (tn *telegramNotifier) text(hp models.Heapster, report models.Report) string {
+	lines := []string{
+		fmt.Sprintf("<b>监控提醒：%s</b>", html.EscapeString(hp.Name)),
+		fmt.Sprintf("目标：<code>%s</code>", html.EscapeString(report.Target)),
+		fmt.Sprintf("状态：%s", report.Status),
+		fmt.Sprintf("成功/失败：%d/%d", report.Success, report.Faileds),
+		fmt.Sprintf("延迟：p95 %v, 最大 %v", report.P95Delay, report.MaxDelay),
+	}
+	if report.Reason != "" {
+		lines = append(lines, fmt.Sprintf("原因：%s", html.EscapeString(report.Reason)))
+	}
+	if report.Suppressed > 0 {
+		lines = append(lines, fmt.Sprintf("已抑制%d个依赖检查", report.Suppressed))
+	}
+	if tn.reportURL != "" {
+		lines = append(lines, fmt.Sprintf(`<a href="%s">查看监控报告</a>`,
+			html.EscapeString(robotReportLink(tn.reportURL, hp))))
+	}
+	return strings.Join(lines, "\n")
+}
+
+func (tn *telegramNotifier) send(ctx context.Context, hp models.Heapster, report models.Report) error {
+	text, err := tn.template.renderOr(ctx, hp, report, tn.text(hp, report))
+	if err != nil {
+		return fmt.Errorf("render telegram template error %v", err)
+	}
+	return tn.broadcast(ctx, text)
+}
+
+// SendDigest 合并的多个heapster发送一条消息
+func (tn *telegramNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if tn.template.custom() {
+		return sendEach(ctx, tn, digest)
+	}
+	lines := []string{fmt.Sprintf("<b>%s</b>", html.EscapeString(digestTitle(digest)))}
+	for _, item := range digest.Items {
+		line := "- " + html.EscapeString(digestLine(item))
+		if tn.reportURL != "" {
+			line += fmt.Sprintf(` <a href="%s">报告</a>`,
+				html.EscapeString(robotReportLink(tn.reportURL, item.Heapster)))
+		}
+		lines = append(lines, line)
+	}
+	return tn.broadcast(ctx, strings.Join(lines, "\n"))
+}
+
+// broadcast 发送到所有配置的会话
+func (tn *telegramNotifier) broadcast(ctx context.Context, text string) error {
+	var (
+		errs   []string
+		apiURL = fmt.Sprintf("%s/bot%s/sendMessage", tn.baseURL, tn.token)
+	)
+	recordMessage(ctx, text, tn.chatIDs)
+	for _, chatID := range tn.chatIDs {
+		if err := tn.sendMessage(ctx, apiURL, chatID, text); err != nil {
+			errs = append(errs, fmt.Sprintf("chat %s: %v", chatID, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("telegram send error %s", strings.Join(errs, "; "))
+	}
+	return nil
+}
+
+func (tn *telegramNotifier) sendMessage(ctx context.Context, apiURL string, chatID string, text string) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"chat_id":                  chatID,
+		"text":                     text,
+		"parse_mode":               "HTML",
+		"disable_web_page_preview": true,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", apiURL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	sendCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(sendCtx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	result := telegramResponse{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return err
+	}
+	if !result.OK {
+		return fmt.Errorf("%s", result.Description)
+	}
+	return nil
+}
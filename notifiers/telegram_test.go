@@ -0,0 +1,49 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTelegramNotifier(t *testing.T) {
+	var chats []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/bottesttoken/sendMessage", r.URL.Path)
+		payload := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		chats = append(chats, payload["chat_id"].(string))
+		assert.Contains(t, payload["text"], "<code>10.0.10.46:10000</code>")
+		if payload["chat_id"] == "-1002" {
+			w.Write([]byte(`{"ok":false,"description":"Bad Request: chat not found"}`))
+			return
+		}
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer server.Close()
+
+	n, err := telegramNotifierCreator(models.HeapsterNotifier{
+		Type: "telegram",
+		Config: map[string]interface{}{
+			"base_url": server.URL,
+			"token":    "testtoken",
+			"chat_ids": []interface{}{"-1001", float64(-1002)},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = n.(*telegramNotifier).send(context.Background(), models.Heapster{ID: "testtelegram", Name: "room"}, models.Report{
+		Heapster: "testtelegram",
+		Target:   "10.0.10.46:10000",
+		Faileds:  3,
+	})
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "chat not found")
+	assert.Equal(t, []string{"-1001", "-1002"}, chats)
+}
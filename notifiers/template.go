@@ -0,0 +1,48 @@
+package notifiers
+
+import (
+	"context"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+// messageTemplate 通过Config["template"]引用的消息模版, 没有引用的时候使用默认模版
+type messageTemplate struct {
+	id  string
+	def string
+}
+
+func configTemplate(config models.NotifierCommonConfig, def string) messageTemplate {
+	return messageTemplate{
+		id:  config.Template,
+		def: def,
+	}
+}
+
+// custom 是否引用了存储的模版
+func (mt messageTemplate) custom() bool {
+	return mt.id != ""
+}
+
+// render 渲染消息, 存储的模版每次发送时读取, 修改之后立即生效
+func (mt messageTemplate) render(ctx context.Context, hp models.Heapster, report models.Report) (string, error) {
+	if !mt.custom() {
+		return models.RenderTemplate(mt.def, models.NewTemplateData(hp, report, nil))
+	}
+	tpl := &models.MessageTemplate{
+		ID: models.SerialNumber(mt.id),
+	}
+	if err := tpl.Fill(ctx); err != nil {
+		return "", err
+	}
+	incident, _ := models.FetchCurrentIncident(ctx, hp.ID)
+	return tpl.Render(models.NewTemplateData(hp, report, incident))
+}
+
+// renderOr 引用了模版的时候渲染模版, 否则使用内置的消息
+func (mt messageTemplate) renderOr(ctx context.Context, hp models.Heapster, report models.Report, builtin string) (string, error) {
+	if !mt.custom() {
+		return builtin, nil
+	}
+	return mt.render(ctx, hp, report)
+}
@@ -0,0 +1,218 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("voice", voiceNotifierCreator)
+}
+
+var voiceNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.VoiceNotifierConfig{
+		Severity:      string(models.HealthyStatusRed),
+		AnswerTimeout: 60,
+		PollInterval:  5,
+		CallInterval:  300,
+	}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	var vpConfig interface{}
+	switch config.Type {
+	case "aliyun":
+		vpConfig = middlewares.AliyunVoiceConfig{
+			AccessKeyID:     config.AccessKeyID,
+			AccessKeySecret: config.AccessKeySecret,
+			ShowNumber:      config.ShowNumber,
+			TTSCode:         config.TTSCode,
+			TTSParam:        config.TTSParam,
+			RegionID:        config.Region,
+			BaseURL:         config.BaseURL,
+		}
+	default:
+		return nil, fmt.Errorf("voice provider type %s not support", config.Type)
+	}
+	p, err := middlewares.CreateVoiceProvider(config.Type, vpConfig)
+	if err != nil {
+		return nil, err
+	}
+	vn := &voiceNotifier{
+		provider:      p,
+		numbers:       config.Targets,
+		severity:      models.HealthyStatus(config.Severity),
+		answerTimeout: configSeconds(config.AnswerTimeout),
+		pollInterval:  configSeconds(config.PollInterval),
+		callInterval:  configSeconds(config.CallInterval),
+		template:      configTemplate(config.NotifierCommonConfig, voiceTemplate),
+	}
+	if len(vn.numbers) == 0 {
+		return nil, fmt.Errorf("voice targets required")
+	}
+	if vn.severity.Severity() == 0 {
+		return nil, fmt.Errorf("voice severity %s not support", vn.severity)
+	}
+	return vn, nil
+}
+
+type voiceNotifier struct {
+	provider      middlewares.VoiceProvider
+	numbers       []string
+	severity      models.HealthyStatus
+	answerTimeout time.Duration
+	pollInterval  time.Duration
+	callInterval  time.Duration
+	template      messageTemplate
+}
+
+// voiceTemplate 语音播报的默认模版, 尽量简短
+const voiceTemplate = `监控{{.Heapster.Name}}中的{{.Report.Target}}` +
+	`{{if .Report.Reason}}{{.Report.Reason}}{{else}}最近出现{{.Report.Faileds}}次异常{{end}}`
+
+// Send 低于配置的严重程度不打电话, 同一个heapster和号码在call_interval里只拨打一次;
+// 接听结果需要轮询, 在后台按顺序拨打直到有人接听, 不阻塞警报和分发器, 结束之后更新通知记录
+func (vn *voiceNotifier) Send(ctx context.Context, report models.Report) error {
+	if report.Status.Severity() < vn.severity.Severity() {
+		recordSkipped(ctx)
+		return nil
+	}
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	text, err := vn.template.render(ctx, hp, report)
+	if err != nil {
+		return fmt.Errorf("render voice template error %v", err)
+	}
+	recordMessage(ctx, text, vn.numbers)
+	// 流量控制, 测试发送的时候不限制; key和短信区分开, 不互相影响
+	if !isTest(ctx) {
+		limiter := middlewares.GetRateLimiter(ctx)
+		if limiter.TryAccept([]string{"voice/" + string(hp.ID)}, vn.callInterval, 1) {
+			recordRateLimit(ctx, "heapster")
+			return fmt.Errorf("rate controll by heapster")
+		}
+		keys := make([]string, 0, len(vn.numbers))
+		for _, number := range vn.numbers {
+			keys = append(keys, "voice/"+number)
+		}
+		if limiter.TryAccept(keys, vn.callInterval, 1) {
+			recordRateLimit(ctx, "phone")
+			return fmt.Errorf("rate controll by phone")
+		}
+	}
+	return vn.deliver(ctx, text)
+}
+
+// deliver 测试发送等待拨打结果, 其他的在后台拨打
+func (vn *voiceNotifier) deliver(ctx context.Context, text string) error {
+	if isTest(ctx) || !recordDelivery(ctx, func(ctx context.Context) error {
+		return vn.dial(ctx, text)
+	}) {
+		return vn.dial(ctx, text)
+	}
+	return nil
+}
+
+// SendDigest 合并的多个heapster只打一个电话, 播报概要;
+// 其中一个heapster在call_interval里打过电话就跳过它, 全部跳过时不打; 引用了模版时逐个拨打
+func (vn *voiceNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if vn.template.custom() {
+		return sendEach(ctx, vn, digest)
+	}
+	var items []models.DigestItem
+	for _, item := range digest.Items {
+		if item.Report.Status.Severity() < vn.severity.Severity() {
+			recordSkipped(ctx)
+			continue
+		}
+		items = append(items, item)
+	}
+	if len(items) == 0 {
+		return nil
+	}
+	limiter := middlewares.GetRateLimiter(ctx)
+	accepted := items[:0]
+	for _, item := range items {
+		if limiter.TryAccept([]string{"voice/" + string(item.Heapster.ID)}, vn.callInterval, 1) {
+			recordRateLimit(ctx, "heapster")
+			continue
+		}
+		accepted = append(accepted, item)
+	}
+	if len(accepted) == 0 {
+		return fmt.Errorf("rate controll by heapster")
+	}
+	digest.Items = accepted
+	text := digestTitle(digest)
+	recordMessage(ctx, text, vn.numbers)
+	keys := make([]string, 0, len(vn.numbers))
+	for _, number := range vn.numbers {
+		keys = append(keys, "voice/"+number)
+	}
+	if limiter.TryAccept(keys, vn.callInterval, 1) {
+		recordRateLimit(ctx, "phone")
+		return fmt.Errorf("rate controll by phone")
+	}
+	return vn.deliver(ctx, text)
+}
+
+// dial 号码按顺序拨打直到有人接听
+func (vn *voiceNotifier) dial(ctx context.Context, text string) error {
+	var (
+		faileds []string
+	)
+	for _, number := range vn.numbers {
+		err := vn.call(ctx, text, number)
+		if err == nil {
+			return nil
+		}
+		faileds = append(faileds, fmt.Sprintf("%s %v", number, err))
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return fmt.Errorf("voice call faileds %s", strings.Join(faileds, ";"))
+}
+
+// call 拨打一个号码并等待接听结果
+func (vn *voiceNotifier) call(ctx context.Context, text string, number string) error {
+	callAt := time.Now()
+	callCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+	callID, err := vn.provider.Call(callCtx, text, number)
+	cancel()
+	if err != nil {
+		return err
+	}
+	deadline := time.NewTimer(vn.answerTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(vn.pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-deadline.C:
+			return fmt.Errorf("call %s no result", callID)
+		case <-ticker.C:
+			queryCtx, cancel := context.WithTimeout(ctx, 5*time.Second)
+			status, err := vn.provider.CallStatus(queryCtx, callID, callAt)
+			cancel()
+			if err != nil {
+				continue
+			}
+			switch status {
+			case middlewares.VoiceCallAnswered:
+				return nil
+			case middlewares.VoiceCallUnanswered:
+				return fmt.Errorf("call %s unanswered", callID)
+			}
+		}
+	}
+}
@@ -0,0 +1,217 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/middlewares"
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestVoiceNotifier(t *testing.T) {
+	var (
+		called    []string
+		queryDate string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		switch params.Get("Action") {
+		case "SingleCallByTts":
+			called = append(called, params.Get("CalledNumber"))
+			fmt.Fprintf(w, `{"Code":"OK","CallId":"call-%s"}`, params.Get("CalledNumber"))
+		case "QueryCallDetailByCallId":
+			queryDate = params.Get("QueryDate")
+			state := "200005"
+			if params.Get("CallId") == "call-13800000002" {
+				state = "200000"
+			}
+			fmt.Fprintf(w, `{"Code":"OK","Data":"{\"state\":\"%s\"}"}`, state)
+		}
+	}))
+	defer server.Close()
+
+	n, err := voiceNotifierCreator(models.HeapsterNotifier{
+		Type: "voice",
+		Config: map[string]interface{}{
+			"type":          "aliyun",
+			"base_url":      server.URL,
+			"tts_code":      "TTS_1",
+			"targets":       []interface{}{"13800000001", "13800000002", "13800000003"},
+			"poll_interval": 0.01,
+		},
+	})
+	assert.NoError(t, err)
+
+	// 黄色低于默认的严重程度, 不打电话
+	err = n.Send(context.Background(), models.Report{
+		Heapster: "testvoice",
+		Status:   models.HealthyStatusYellow,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, called, 0)
+
+	// 第一个号码未接听, 继续拨打下一个; 测试发送等待拨打结果
+	ctx := withTestHeapster(context.Background(), models.Heapster{ID: "testvoice", Name: "room"})
+	err = n.Send(ctx, models.Report{
+		Heapster: "testvoice",
+		Target:   "10.0.10.46:10000",
+		Status:   models.HealthyStatusRed,
+		Faileds:  3,
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"13800000001", "13800000002"}, called)
+	// 查询状态使用拨打的日期
+	assert.NotEmpty(t, queryDate)
+}
+
+func TestVoiceNotifierDelivery(t *testing.T) {
+	dir, err := ioutil.TempDir("", "voicedelivery")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := models.NewStore(models.StoreTypeBolt, filepath.Join(dir, "store.db"))
+	assert.NoError(t, err)
+	ctx := middlewares.WithLogger(context.Background(), 0, ioutil.Discard)
+	ctx = models.WithStore(ctx, store)
+	ctx = middlewares.WithMemoryRateLimiter(ctx)
+	deliveries := NewDeliveries()
+	ctx = WithDeliveries(ctx, deliveries)
+
+	answered := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		switch params.Get("Action") {
+		case "SingleCallByTts":
+			fmt.Fprintf(w, `{"Code":"OK","CallId":"call-%s"}`, params.Get("CalledNumber"))
+		case "QueryCallDetailByCallId":
+			// 等待检查通知记录之后再接听
+			<-answered
+			state := "200005"
+			if params.Get("CallId") == "call-13800000002" {
+				state = "200000"
+			}
+			fmt.Fprintf(w, `{"Code":"OK","Data":"{\"state\":\"%s\"}"}`, state)
+		}
+	}))
+	defer server.Close()
+
+	hp := &models.Heapster{
+		ID:        "testvoicedelivery",
+		Name:      "room",
+		Type:      models.CheckTypeTCP,
+		Port:      10000,
+		Timeout:   time.Second,
+		Interval:  5 * time.Second,
+		Threshold: 3,
+	}
+	assert.NoError(t, hp.Save(ctx))
+
+	newVoice := func(targets ...interface{}) Notifier {
+		n, err := NewNotifier(models.HeapsterNotifier{
+			ID:   models.NewSerialNumber(),
+			Type: "voice",
+			Config: map[string]interface{}{
+				"type":          "aliyun",
+				"base_url":      server.URL,
+				"tts_code":      "TTS_1",
+				"targets":       targets,
+				"poll_interval": 0.01,
+				"call_interval": 0.01,
+			},
+		})
+		assert.NoError(t, err)
+		return n
+	}
+	report := models.Report{Heapster: string(hp.ID), Target: "10.0.10.46:10000", Status: models.HealthyStatusRed}
+
+	// 后台拨打, 结束之前通知记录是等待送达
+	alertCtx, cancel := context.WithCancel(ctx)
+	assert.NoError(t, newVoice("13800000001", "13800000002").Send(alertCtx, report))
+	// 警报停止不影响后台拨打
+	cancel()
+	ns, err := models.FetchNotifications(ctx, hp.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, ns, 1) {
+		assert.Equal(t, models.DeliveryStatusPending, ns[0].Recipients[0].Status)
+	}
+	close(answered)
+	deliveries.Wait()
+	ns, err = models.FetchNotifications(ctx, hp.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, ns, 1) {
+		assert.Empty(t, ns[0].Error)
+		assert.Equal(t, models.DeliveryStatusDelivered, ns[0].Recipients[1].Status)
+	}
+
+	// 没有人接听记录为送达失败
+	time.Sleep(20 * time.Millisecond)
+	assert.NoError(t, newVoice("13800000003").Send(ctx, report))
+	deliveries.Wait()
+	ns, err = models.FetchNotifications(ctx, hp.ID)
+	assert.NoError(t, err)
+	if assert.Len(t, ns, 2) {
+		assert.Contains(t, ns[0].Error, "unanswered")
+		assert.Equal(t, models.DeliveryStatusFailed, ns[0].Recipients[0].Status)
+	}
+}
+
+func TestVoiceSendDigestTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "voicedigest")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+	store, err := models.NewStore(models.StoreTypeBolt, filepath.Join(dir, "store.db"))
+	assert.NoError(t, err)
+	ctx := middlewares.WithLogger(context.Background(), 0, ioutil.Discard)
+	ctx = models.WithStore(ctx, store)
+	ctx = middlewares.WithMemoryRateLimiter(ctx)
+
+	var contents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		params := r.URL.Query()
+		switch params.Get("Action") {
+		case "SingleCallByTts":
+			ttsParam := map[string]string{}
+			json.Unmarshal([]byte(params.Get("TtsParam")), &ttsParam)
+			contents = append(contents, ttsParam["content"])
+			fmt.Fprint(w, `{"Code":"OK","CallId":"call-1"}`)
+		case "QueryCallDetailByCallId":
+			fmt.Fprint(w, `{"Code":"OK","Data":"{\"state\":\"200000\"}"}`)
+		}
+	}))
+	defer server.Close()
+
+	digest := testDigest()
+	digest.Items = digest.Items[:1]
+	hp := digest.Items[0].Heapster
+	hp.Type = models.CheckTypeTCP
+	hp.Port = 10000
+	hp.Timeout = time.Second
+	assert.NoError(t, hp.Save(ctx))
+	tpl := &models.MessageTemplate{ID: "testvoicedigest", Name: "voice", Text: "{{.Heapster.Name}}电话告警"}
+	assert.NoError(t, tpl.Save(ctx))
+
+	n, err := voiceNotifierCreator(models.HeapsterNotifier{
+		Type: "voice",
+		Config: map[string]interface{}{
+			"type":          "aliyun",
+			"base_url":      server.URL,
+			"tts_code":      "TTS_1",
+			"targets":       []interface{}{"13800000001"},
+			"poll_interval": 0.01,
+			"template":      "testvoicedigest",
+		},
+	})
+	assert.NoError(t, err)
+	// 引用了模版时逐个使用模版播报, 不播报合并的概要
+	assert.NoError(t, n.(DigestNotifier).SendDigest(ctx, digest))
+	assert.Equal(t, []string{"room电话告警"}, contents)
+}
@@ -0,0 +1,198 @@
+package notifiers
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("webhook", webhookNotifierCreator)
+}
+
+var webhookNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.WebhookNotifierConfig{
+		Method:          "POST",
+		SignatureHeader: "X-Gamehealthy-Signature",
+		Retries:         3,
+		Backoff:         1,
+		Timeout:         5,
+	}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	wh := &webhookNotifier{
+		url:             config.URL,
+		method:          strings.ToUpper(config.Method),
+		headers:         config.Headers,
+		secret:          config.Secret,
+		signatureHeader: config.SignatureHeader,
+		retries:         config.Retries,
+		backoff:         configSeconds(config.Backoff),
+		timeout:         configSeconds(config.Timeout),
+		template:        configTemplate(config.NotifierCommonConfig, ""),
+	}
+	if wh.url == "" {
+		return nil, fmt.Errorf("webhook url required")
+	}
+	if config.Body != "" {
+		tpl, err := template.New(string(model.ID)).Parse(config.Body)
+		if err != nil {
+			return nil, fmt.Errorf("webhook body template error %v", err)
+		}
+		wh.body = tpl
+	}
+	return wh, nil
+}
+
+// webhookData 模版数据
+type webhookData struct {
+	Heapster models.Heapster  `json:"heapster"`
+	Report   models.Report    `json:"report"`
+	Incident *models.Incident `json:"incident,omitempty"`
+}
+
+type webhookNotifier struct {
+	url             string
+	method          string
+	headers         map[string]string
+	body            *template.Template
+	secret          string
+	signatureHeader string
+	retries         int
+	backoff         time.Duration
+	timeout         time.Duration
+	template        messageTemplate
+}
+
+// Send 渲染模版之后发送, 失败按照指数退避重试
+func (wh *webhookNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	data := webhookData{
+		Heapster: hp,
+		Report:   report,
+	}
+	if inc, err := models.FetchCurrentIncident(ctx, data.Heapster.ID); err == nil {
+		data.Incident = inc
+	}
+	// 引用了存储的模版时使用模版渲染请求内容
+	if wh.template.custom() {
+		body, err := wh.template.render(ctx, data.Heapster, report)
+		if err != nil {
+			return fmt.Errorf("render webhook template error %v", err)
+		}
+		return wh.deliver(ctx, []byte(body))
+	}
+	return wh.post(ctx, data)
+}
+
+// SendDigest 没有配置模版时发送合并的json, 模版只能渲染单个报告, 配置了模版逐个发送
+func (wh *webhookNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if wh.template.custom() || wh.body != nil {
+		return sendEach(ctx, wh, digest)
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"digest": digest,
+	})
+	if err != nil {
+		return fmt.Errorf("render webhook body error %v", err)
+	}
+	return wh.deliver(ctx, payload)
+}
+
+// render 渲染请求内容, 没有模版的时候使用json
+func (wh *webhookNotifier) render(data webhookData) ([]byte, error) {
+	if wh.body == nil {
+		return json.Marshal(data)
+	}
+	buf := &bytes.Buffer{}
+	if err := wh.body.Execute(buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// sign HMAC-SHA256签名
+func (wh *webhookNotifier) sign(payload []byte) string {
+	mac := hmac.New(sha256.New, []byte(wh.secret))
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wh *webhookNotifier) post(ctx context.Context, data webhookData) error {
+	payload, err := wh.render(data)
+	if err != nil {
+		return fmt.Errorf("render webhook body error %v", err)
+	}
+	return wh.deliver(ctx, payload)
+}
+
+// deliver 发送请求内容, 失败按照指数退避重试
+func (wh *webhookNotifier) deliver(ctx context.Context, payload []byte) error {
+	var err error
+	if u, err := url.Parse(wh.url); err == nil {
+		recordMessage(ctx, string(payload), []string{u.Host})
+	}
+	backoff := wh.backoff
+	for i := 0; ; i++ {
+		if err = wh.do(ctx, payload); err == nil {
+			return nil
+		}
+		if i >= wh.retries {
+			break
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+	return fmt.Errorf("webhook failed after %d retries: %v", wh.retries, err)
+}
+
+func (wh *webhookNotifier) do(ctx context.Context, payload []byte) error {
+	var body io.Reader
+	if wh.method != "GET" {
+		body = bytes.NewReader(payload)
+	}
+	req, err := http.NewRequest(wh.method, wh.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for k, v := range wh.headers {
+		req.Header.Set(k, v)
+	}
+	if wh.secret != "" {
+		req.Header.Set(wh.signatureHeader, wh.sign(payload))
+	}
+	timeoutCtx, cancel := context.WithTimeout(ctx, wh.timeout)
+	defer cancel()
+	resp, err := http.DefaultClient.Do(req.WithContext(timeoutCtx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook response code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,87 @@
+package notifiers
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	var (
+		calls     int
+		body      string
+		signature string
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		// 第一次失败测试重试
+		if calls == 1 {
+			w.WriteHeader(502)
+			return
+		}
+		data, _ := ioutil.ReadAll(r.Body)
+		body = string(data)
+		signature = r.Header.Get("X-Gamehealthy-Signature")
+		assert.Equal(t, "ops", r.Header.Get("X-Source"))
+		w.WriteHeader(200)
+	}))
+	defer server.Close()
+
+	n, err := webhookNotifierCreator(models.HeapsterNotifier{
+		Type: "webhook",
+		Config: map[string]interface{}{
+			"url":     server.URL,
+			"headers": map[string]interface{}{"X-Source": "ops"},
+			"body":    `{"text":"{{.Heapster.Name}} {{.Report.Target}} {{.Report.Faileds}}"}`,
+			"secret":  "testsecret",
+			"retries": float64(2),
+			"backoff": 0.01,
+		},
+	})
+	assert.NoError(t, err)
+	wh := n.(*webhookNotifier)
+
+	err = wh.post(context.Background(), webhookData{
+		Heapster: models.Heapster{
+			ID:   "testwebhook",
+			Name: "room",
+		},
+		Report: models.Report{
+			Heapster: "testwebhook",
+			Target:   "10.0.10.46:10000",
+			Faileds:  3,
+			MaxDelay: time.Second,
+		},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 2, calls)
+	assert.Equal(t, `{"text":"room 10.0.10.46:10000 3"}`, body)
+	assert.Equal(t, wh.sign([]byte(body)), signature)
+}
+
+func TestWebhookNotifierConfig(t *testing.T) {
+	// 没有配置的字段使用默认值
+	n, err := webhookNotifierCreator(models.HeapsterNotifier{
+		Type:   "webhook",
+		Config: map[string]interface{}{"url": "http://localhost:8080"},
+	})
+	assert.NoError(t, err)
+	wh := n.(*webhookNotifier)
+	assert.Equal(t, "POST", wh.method)
+	assert.Equal(t, 3, wh.retries)
+	assert.Equal(t, 5*time.Second, wh.timeout)
+
+	// 类型不对的字段按照配置结构返回错误
+	_, err = webhookNotifierCreator(models.HeapsterNotifier{
+		Type:   "webhook",
+		Config: map[string]interface{}{"url": "http://localhost:8080", "retries": "3"},
+	})
+	assert.EqualError(t, err, "retries: must be integer")
+}
@@ -0,0 +1,85 @@
+package notifiers
+
+import (
+	"context"
+	"fmt"
+
+	"zonst/qipai/gamehealthysrv/models"
+)
+
+func init() {
+	registCreator("wecom", wecomNotifierCreator)
+}
+
+var wecomNotifierCreator = func(model models.HeapsterNotifier) (Notifier, error) {
+	config := &models.WecomNotifierConfig{}
+	if err := models.DecodeNotifierConfig(model.Config, config); err != nil {
+		return nil, err
+	}
+	wc := &wecomNotifier{
+		webhook:   config.Webhook,
+		mobiles:   config.MentionedMobiles,
+		reportURL: config.ReportURL,
+		template:  configTemplate(config.NotifierCommonConfig, ""),
+	}
+	if wc.webhook == "" {
+		return nil, fmt.Errorf("wecom webhook required")
+	}
+	return wc, nil
+}
+
+type wecomNotifier struct {
+	webhook   string
+	mobiles   []string
+	reportURL string
+	template  messageTemplate
+}
+
+// Send 发送markdown消息到企业微信群机器人
+func (wc *wecomNotifier) Send(ctx context.Context, report models.Report) error {
+	hp, err := fillHeapster(ctx, report)
+	if err != nil {
+		return err
+	}
+	return wc.send(ctx, hp, report)
+}
+
+func (wc *wecomNotifier) send(ctx context.Context, hp models.Heapster, report models.Report) error {
+	_, text := robotMarkdown(hp, report, wc.reportURL)
+	text, err := wc.template.renderOr(ctx, hp, report, text)
+	if err != nil {
+		return fmt.Errorf("render wecom template error %v", err)
+	}
+	return wc.post(ctx, text, fmt.Sprintf("监控提醒：%s需要及时处理", hp.Name))
+}
+
+// SendDigest 合并的多个heapster发送一条markdown消息
+func (wc *wecomNotifier) SendDigest(ctx context.Context, digest models.Digest) error {
+	if wc.template.custom() {
+		return sendEach(ctx, wc, digest)
+	}
+	_, text := robotDigestMarkdown(digest, wc.reportURL)
+	return wc.post(ctx, text, fmt.Sprintf("%s需要及时处理", digestTitle(digest)))
+}
+
+// post 发送markdown消息, 配置了手机号时再发送提醒
+func (wc *wecomNotifier) post(ctx context.Context, text string, notice string) error {
+	recordMessage(ctx, text, wc.mobiles)
+	err := postRobot(ctx, wc.webhook, map[string]interface{}{
+		"msgtype": "markdown",
+		"markdown": map[string]string{
+			"content": text,
+		},
+	})
+	if err != nil || len(wc.mobiles) == 0 {
+		return err
+	}
+	// markdown消息不支持@手机号, 单独发一条文本消息提醒
+	return postRobot(ctx, wc.webhook, map[string]interface{}{
+		"msgtype": "text",
+		"text": map[string]interface{}{
+			"content":               notice,
+			"mentioned_mobile_list": wc.mobiles,
+		},
+	})
+}
@@ -0,0 +1,44 @@
+package notifiers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"zonst/qipai/gamehealthysrv/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWecomNotifier(t *testing.T) {
+	var payloads []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		payload := map[string]interface{}{}
+		json.NewDecoder(r.Body).Decode(&payload)
+		payloads = append(payloads, payload)
+		w.Write([]byte(`{"errcode":0,"errmsg":"ok"}`))
+	}))
+	defer server.Close()
+
+	n, err := wecomNotifierCreator(models.HeapsterNotifier{
+		Type: "wecom",
+		Config: map[string]interface{}{
+			"webhook":           server.URL + "/cgi-bin/webhook/send?key=testkey",
+			"mentioned_mobiles": []interface{}{"13879156403"},
+		},
+	})
+	assert.NoError(t, err)
+
+	err = n.(*wecomNotifier).send(context.Background(), models.Heapster{ID: "testwecom", Name: "room"}, models.Report{
+		Heapster: "testwecom",
+		Target:   "10.0.10.46:10000",
+		Faileds:  3,
+	})
+	assert.NoError(t, err)
+	assert.Len(t, payloads, 2)
+	assert.Equal(t, "markdown", payloads[0]["msgtype"])
+	assert.Contains(t, payloads[0]["markdown"].(map[string]interface{})["content"], "10.0.10.46:10000")
+	assert.Equal(t, []interface{}{"13879156403"}, payloads[1]["text"].(map[string]interface{})["mentioned_mobile_list"])
+}